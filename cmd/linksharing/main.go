@@ -4,10 +4,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -15,6 +24,7 @@ import (
 	"go.uber.org/zap"
 
 	"storj.io/common/fpath"
+	"storj.io/common/memory"
 	"storj.io/linksharing"
 	"storj.io/linksharing/httpserver"
 	"storj.io/linksharing/sharing"
@@ -27,19 +37,121 @@ type LinkSharing struct {
 	Address               string        `user:"true" help:"public address to listen on" default:":8080"`
 	AddressTLS            string        `user:"true" help:"public tls address to listen on" default:":8443"`
 	LetsEncrypt           bool          `user:"true" help:"use lets-encrypt to handle TLS certificates" default:"false"`
+	AccessLogFormat       string        `user:"true" help:"access log format: structured, combined or both" default:"structured"`
+	AccessLogPath         string        `user:"true" help:"file to append combined format access logs to (defaults to stdout)" default:""`
 	CertFile              string        `user:"true" help:"server certificate file" devDefault:"" releaseDefault:"server.crt.pem"`
 	KeyFile               string        `user:"true" help:"server key file" devDefault:"" releaseDefault:"server.key.pem"`
+	TLSMinVersion         string        `user:"true" help:"oldest TLS version accepted: 1.2 or 1.3" default:"1.2"`
+	TLSCipherSuites       string        `user:"true" help:"comma separated TLS 1.2 cipher suites to allow, by Go name; empty uses Go's defaults" default:""`
 	PublicURL             string        `user:"true" help:"comma separated list of public urls for the server" devDefault:"http://localhost:8080" releaseDefault:""`
 	GeoLocationDB         string        `user:"true" help:"maxmind database file path" devDefault:"" releaseDefault:""`
+	GeoLocationWorkers    int           `user:"true" help:"number of concurrent geolocation lookups per object map" default:"8"`
+	GeoLocationTimeout    time.Duration `user:"true" help:"max time spent geolocating an object's pieces" default:"2s"`
 	TxtRecordTTL          time.Duration `user:"true" help:"max ttl (seconds) for website hosting txt record cache" devDefault:"10s" releaseDefault:"1h"`
+	HostingFallback       string        `user:"true" help:"JSON file mapping hosts to the access and root to use when their TXT records can't be looked up; reloaded on SIGHUP" default:""`
 	AuthServiceBaseURL    string        `user:"true" help:"base url to use for resolving access key ids" default:""`
 	AuthServiceToken      string        `user:"true" help:"auth token for giving access to the auth service" default:""`
-	DNSServer             string        `user:"true" help:"dns server address to use for TXT resolution" default:"1.1.1.1:53"`
+	AuthServiceRetryCodes string        `user:"true" help:"comma separated list of auth service 5xx status codes to retry" default:"502,503,504"`
+	AuthServiceCacheTTL   time.Duration `user:"true" help:"how long to cache access grants resolved by the auth service; 0 disables caching" default:"0"`
+	AuthServiceNegTTL     time.Duration `user:"true" help:"how long to cache rejected and non-public access keys" default:"10s"`
+	AuthServiceCacheSize  int           `user:"true" help:"how many access keys the auth service cache holds" default:"10000"`
+	DNSServer             string        `user:"true" help:"comma separated dns server addresses to use for TXT resolution, tried in order" default:"1.1.1.1:53"`
+	DNSTimeout            time.Duration `user:"true" help:"how long a TXT lookup waits for each dns server" default:"5s"`
+	RequestTimeout        time.Duration `user:"true" help:"how long a request's metadata operations may take before it's answered with 504; downloads may take longer; 0 is unlimited" default:"0"`
 	StaticSourcesPath     string        `user:"true" help:"the path to where web assets are located" default:"./web/static"`
 	Templates             string        `user:"true" help:"the path to where renderable templates are located" default:"./web"`
 	LandingRedirectTarget string        `user:"true" help:"the url to redirect empty requests to" default:"https://www.storj.io/"`
+	HealthPath            string        `user:"true" help:"path health probes are answered at for any host; empty disables it" default:"/healthz"`
+	ReadyPath             string        `user:"true" help:"path readiness probes are answered at for any host; empty disables it" default:"/readyz"`
+	ReadyCheckAuthService bool          `user:"true" help:"have readiness probes check the auth service is reachable" default:"false"`
 	RedirectHTTPS         bool          `user:"true" help:"redirect to HTTPS" devDefault:"false" releaseDefault:"true"`
+	UseRequestScheme      bool          `user:"true" help:"build absolute URLs with the scheme of the request, honoring X-Forwarded-Proto from trusted proxies, instead of the URL base's" default:"false"`
 	UseQosAndCC           bool          `user:"true" help:"use congestion control and QOS settings" default:"true"`
+	ExistsMaxKeys         int           `user:"true" help:"max number of keys in a single existence probe" default:"100"`
+	ExistsConcurrency     int           `user:"true" help:"number of keys an existence probe checks concurrently" default:"10"`
+	TextViewDefault       bool          `user:"true" help:"render text objects in the enhanced text view when viewed" default:"false"`
+	TextViewMaxSize       memory.Size   `user:"true" help:"max object size shown in the enhanced text view" default:"1MiB"`
+	PDFViewDefault        bool          `user:"true" help:"render PDFs in an embedded viewer page when viewed" default:"false"`
+	MarkdownViewDefault   bool          `user:"true" help:"render Markdown objects as HTML when viewed" default:"true"`
+	CORSAllowedOrigins    string        `user:"true" help:"comma separated list of origins allowed to fetch objects cross-origin" default:""`
+	StripQueryParams      bool          `user:"true" help:"drop query parameters not in the allowlist before handling requests" default:"false"`
+	QueryParamAllowlist   string        `user:"true" help:"comma separated list of query parameters to keep when stripping (defaults to all understood parameters)" default:""`
+	SecurityTXTPath       string        `user:"true" help:"path to a security.txt file to serve at /.well-known/security.txt" default:""`
+	MaxKeyLength          int           `user:"true" help:"max length in bytes of a requested object key" default:"4096"`
+	MaxKeyDepth           int           `user:"true" help:"max number of path segments in a requested object key" default:"256"`
+	DownloadConfirmation  bool          `user:"true" help:"show a confirmation page with the object size before large downloads" default:"false"`
+	DownloadConfirmSize   memory.Size   `user:"true" help:"smallest object that needs download confirmation" default:"100MB"`
+	CollapseSlashes       bool          `user:"true" help:"collapse consecutive slashes in request paths before resolving object keys" default:"false"`
+	TrailingSlash         string        `user:"true" help:"how prefixes requested without a trailing slash are redirected: see-other, permanent, temporary, or off to list them in place" default:"see-other"`
+	ForceDownload         string        `user:"true" help:"comma separated extensions and media types always downloaded instead of viewed on shared links" default:"text/html,application/xhtml+xml,image/svg+xml,text/xml,application/xml"`
+	HostingForceDownload  string        `user:"true" help:"comma separated extensions and media types always downloaded instead of viewed on hosted sites" default:""`
+	ListPageSize          int           `user:"true" help:"maximum number of entries in one page of a prefix listing" default:"1000"`
+	MaxListSize           int           `user:"true" help:"maximum number of entries a page of an HTML prefix listing collects" default:"1000"`
+	RelativeListingURLs   bool          `user:"true" help:"link listing breadcrumbs relative to the listing instead of with absolute paths" default:"false"`
+	PresignSecretKey      string        `user:"true" help:"secret key S3 style pre-signed URLs are validated against; disabled when empty" default:""`
+	PasswordProtection    bool          `user:"true" help:"let .password objects protect shared links with HTTP basic auth" default:"false"`
+	TransformSigningKey   string        `user:"true" help:"secret key signing the ?sig of signed transforms" default:""`
+	SignedTransforms      string        `user:"true" help:"comma separated transforms requiring a ?sig: archive, preview, transform" default:""`
+	Transforms            string        `user:"true" help:"comma separated built-in transforms objects can be served through with ?transform: gzip, base64" default:""`
+	HostingRootListing    bool          `user:"true" help:"list the root of hosted sites without an index.html or landing page instead of serving a 404" default:"false"`
+	HostingNoindex        bool          `user:"true" help:"ask search engines not to index the listings hosted sites generate" default:"false"`
+	SPARoutePattern       string        `user:"true" help:"regular expression for request paths single page apps treat as routes despite a file extension" default:""`
+	SPAFallbackDocument   string        `user:"true" help:"document, like /index.html, every hosted site serves for app routes that don't exist instead of a listing or 404" default:""`
+	EgressExport          string        `user:"true" help:"where to export egress totals: empty to disable, log, or an http(s) URL to POST them to" default:""`
+	EgressExportInterval  time.Duration `user:"true" help:"how often to export egress totals" default:"1m"`
+	LogRequests           bool          `user:"true" help:"log one line per request with its request id, path, status, size and duration" default:"false"`
+	MetricsAddress        string        `user:"true" help:"address to serve Prometheus metrics on at /metrics; disabled when empty" default:""`
+	PageViews             string        `user:"true" help:"where to record page views of hosted sites: empty to disable, or log" default:""`
+	PageViewSampleRate    float64       `user:"true" help:"fraction of hosted site requests to record page views for" default:"1"`
+	PageViewClientIPs     bool          `user:"true" help:"include client IPs and full referers in page views, rather than only the client's country and the referer's origin" default:"false"`
+	ChecksumVerification  bool          `user:"true" help:"allow ?verify=sha256:<hex> to hash objects as they are served" default:"false"`
+	BodyCacheSize         memory.Size   `user:"true" help:"size of the in-memory cache for small object bodies; 0 disables it" default:"0"`
+	BodyCacheObjectSize   memory.Size   `user:"true" help:"largest object body to cache" default:"1MiB"`
+	BodyCacheTTL          time.Duration `user:"true" help:"how long to cache object bodies" default:"5m"`
+	StaleIfError          time.Duration `user:"true" help:"how long caches may serve objects stale when linksharing or the satellite fails; 0 disables it" default:"0"`
+	RangeCoalesceWindow   time.Duration `user:"true" help:"how long small range reads are kept for later ranges on the same connection; 0 disables coalescing" default:"0"`
+	RangeCoalesceSize     memory.Size   `user:"true" help:"ranges smaller than this are served from reads of this size when coalescing" default:"256KiB"`
+	DownloadBufferSize    memory.Size   `user:"true" help:"size of the buffer objects are copied to responses with" default:"32KiB"`
+	MaxDownloads          int           `user:"true" help:"how many objects may be downloaded at once; 0 is unlimited" default:"0"`
+	DownloadPriority      string        `user:"true" help:"how downloads over the limit are ordered: size to let small ones go first, or fifo" default:"size"`
+	SmallDownloadSize     memory.Size   `user:"true" help:"largest download prioritized by the size policy" default:"1MiB"`
+	LargeDownloadMaxWait  time.Duration `user:"true" help:"how long larger downloads wait before going first regardless of priority" default:"5s"`
+	BandwidthLimit        memory.Size   `user:"true" help:"bytes per second responses for each share are throttled to; 0 is unlimited" default:"0"`
+	BandwidthLimitScope   string        `user:"true" help:"what shares a bandwidth limit: access for each shared access or hosted site, or request" default:"access"`
+	BandwidthBurst        memory.Size   `user:"true" help:"bytes that may be downloaded at once before throttling starts; defaults to a second's worth" default:"0"`
+	AbortShortReads       bool          `user:"true" help:"abort the connection when an object's download ends before its content length" default:"false"`
+	FullRangeAsOK         bool          `user:"true" help:"answer ranges covering a whole object, like bytes=0-, with 200 instead of 206" default:"false"`
+	ProjectCacheSize      int           `user:"true" help:"how many opened projects to keep for reuse by later requests for the same share; 0 disables it" default:"0"`
+	ProjectCacheTTL       time.Duration `user:"true" help:"how long cached projects are reused before being reopened" default:"5m"`
+	ShortLinks            string        `user:"true" help:"where to store short links for QR codes: empty to disable, or memory" default:""`
+	ShortLinkToken        string        `user:"true" help:"bearer token required to create short links" default:""`
+	ShortLinkTTL          time.Duration `user:"true" help:"how long short links last when created without a ttl; 0 keeps them forever" default:"0"`
+	ShortLinkMaxTTL       time.Duration `user:"true" help:"longest a short link may last; 0 is unlimited" default:"0"`
+	TransformMemoryLimit  memory.Size   `user:"true" help:"most bytes of an object a transformation may buffer per request; 0 is unlimited" default:"0"`
+	TransformRejectLarger bool          `user:"true" help:"reject objects over the transform memory limit with 413 instead of serving them untransformed" default:"false"`
+	HostingTraditional    bool          `user:"true" help:"let hosted domains also serve /s/ and /raw/ links that start with an access grant" default:"false"`
+	TrustedProxies        string        `user:"true" help:"comma separated CIDRs of proxies trusted to set X-Forwarded-For" default:""`
+	ClientCountryHeader   bool          `user:"true" help:"set an X-Client-Country header with the country the client IP geolocates to" default:"false"`
+	DebugHeaders          bool          `user:"true" help:"expose the metadata of served objects in X-Object-* headers" default:"false"`
+	BotUserAgents         string        `user:"true" help:"comma separated User-Agent substrings of bots that skip piece location lookups" default:"bot,crawler,spider,slurp,facebookexternalhit,embedly,whatsapp,skypeuripreview"`
+	ArchiveMaxObjects     int           `user:"true" help:"most objects an ?archive= download may hold; 0 is unlimited" default:"10000"`
+	ArchiveMaxSize        memory.Size   `user:"true" help:"largest total size of the objects in an ?archive= download; 0 is unlimited" default:"10GB"`
+	ArchiveReproducible   bool          `user:"true" help:"make ?archive= downloads of unchanged prefixes byte-identical by sorting entries and normalizing their times; needs --archive-max-objects" default:"false"`
+	CacheKeyHeader        string        `user:"true" help:"response header carrying a cache key for CDNs that key on a header; disabled when empty" default:""`
+	CacheKeyParts         string        `user:"true" help:"comma separated parts the cache key is built from: bucket, key, created and size" default:"bucket,key,created,size"`
+	GzipDecompression     bool          `user:"true" help:"serve objects stored gzip compressed with Content-Encoding: gzip, decompressing them for clients that don't accept gzip" default:"false"`
+	CompressResponses     bool          `user:"true" help:"compress HTML pages, listings and text objects for clients accepting gzip or deflate" default:"false"`
+	ScopeAuditLevel       string        `user:"true" help:"log level to log the buckets and prefixes each request's access is limited to at; disabled when empty" default:"debug"`
+	ErrorCacheControl     string        `user:"true" help:"Cache-Control header of error responses, including hosted 404.html pages; unset when empty" default:"max-age=30"`
+	StrictDisplayFlags    bool          `user:"true" help:"reject requests combining ?view with ?download or ?wrap instead of resolving them by precedence" default:"false"`
+	WatermarkImage        string        `user:"true" help:"path to a PNG drawn over images served with ?preview; disabled when empty" default:""`
+	WatermarkMaxSize      memory.Size   `user:"true" help:"largest image to watermark" default:"20MiB"`
+	AllowedSatellites     string        `user:"true" help:"comma separated satellites accesses may be for, as node URLs, host:port or node ids; any when empty" default:""`
+	ArchiveCacheSize      memory.Size   `user:"true" help:"size of the in-memory cache for generated archives; 0 disables it" default:"0"`
+	ArchiveCacheMaxSize   memory.Size   `user:"true" help:"largest total size of objects in an archive to cache" default:"10MiB"`
+	ArchiveCacheTTL       time.Duration `user:"true" help:"how long to cache generated archives" default:"5m"`
+	MaxArchives           int           `user:"true" help:"how many archives may be generated at once; 0 is unlimited" default:"0"`
+	ArchiveRetryAfter     time.Duration `user:"true" help:"how long clients are told to wait when too many archives are being generated" default:"30s"`
 	ConnectionPool        ConnectionPoolConfig
 }
 
@@ -93,6 +205,102 @@ func cmdRun(cmd *cobra.Command, args []string) (err error) {
 
 	publicURLs := strings.Split(runCfg.PublicURL, ",")
 
+	var accessLog io.Writer
+	if runCfg.AccessLogPath != "" {
+		accessLogFile, err := os.OpenFile(runCfg.AccessLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return errs.New("unable to open access log: %w", err)
+		}
+		defer func() { err = errs.Combine(err, accessLogFile.Close()) }()
+		accessLog = accessLogFile
+	}
+
+	authRetryCodes, err := parseStatusCodes(runCfg.AuthServiceRetryCodes)
+	if err != nil {
+		return err
+	}
+
+	transforms, err := parseTransforms(runCfg.Transforms)
+	if err != nil {
+		return err
+	}
+
+	var authCache *sharing.AuthServiceCache
+	if runCfg.AuthServiceCacheTTL > 0 {
+		authCache = sharing.NewAuthServiceCache(runCfg.AuthServiceCacheSize, runCfg.AuthServiceCacheTTL, runCfg.AuthServiceNegTTL)
+	}
+
+	var securityTXT string
+	if runCfg.SecurityTXTPath != "" {
+		data, err := ioutil.ReadFile(runCfg.SecurityTXTPath)
+		if err != nil {
+			return errs.New("unable to read security.txt: %w", err)
+		}
+		securityTXT = string(data)
+	}
+
+	var egressExporter sharing.EgressExporter
+	switch {
+	case runCfg.EgressExport == "":
+	case runCfg.EgressExport == "log":
+		egressExporter = sharing.LogEgressExporter{Log: log.Named("egress")}
+	case strings.HasPrefix(runCfg.EgressExport, "http://") || strings.HasPrefix(runCfg.EgressExport, "https://"):
+		egressExporter = sharing.HTTPEgressExporter{URL: runCfg.EgressExport}
+	default:
+		return errs.New("invalid egress export %q", runCfg.EgressExport)
+	}
+
+	var pageViewRecorder sharing.PageViewRecorder
+	switch runCfg.PageViews {
+	case "":
+	case "log":
+		pageViewRecorder = sharing.LogPageViewRecorder{Log: log.Named("pageviews")}
+	default:
+		return errs.New("invalid page views destination %q", runCfg.PageViews)
+	}
+
+	var shortLinks sharing.ShortLinkStore
+	switch runCfg.ShortLinks {
+	case "":
+	case "memory":
+		shortLinks = sharing.NewMemoryShortLinkStore()
+	default:
+		return errs.New("invalid short link store %q", runCfg.ShortLinks)
+	}
+
+	var metrics *sharing.Metrics
+	if runCfg.MetricsAddress != "" {
+		metrics = sharing.NewMetrics()
+		go serveMetrics(ctx, log, runCfg.MetricsAddress, metrics)
+	}
+
+	var bodyCache sharing.BodyCache
+	if runCfg.BodyCacheSize > 0 {
+		bodyCache = sharing.NewMemoryBodyCache(runCfg.BodyCacheSize.Int64())
+	}
+
+	var archiveCache sharing.BodyCache
+	if runCfg.ArchiveCacheSize > 0 {
+		archiveCache = sharing.NewMemoryBodyCache(runCfg.ArchiveCacheSize.Int64())
+	}
+
+	var hostingFallback *sharing.HostingFallback
+	if runCfg.HostingFallback != "" {
+		hostingFallback, err = sharing.LoadHostingFallback(runCfg.HostingFallback)
+		if err != nil {
+			return err
+		}
+		go reloadOnHangup(ctx, log, hostingFallback)
+	}
+
+	var watermark image.Image
+	if runCfg.WatermarkImage != "" {
+		watermark, err = sharing.LoadWatermark(runCfg.WatermarkImage)
+		if err != nil {
+			return errs.New("unable to load watermark: %w", err)
+		}
+	}
+
 	peer, err := linksharing.New(log, linksharing.Config{
 		Server: httpserver.Config{
 			Name:       "Link Sharing",
@@ -104,23 +312,163 @@ func cmdRun(cmd *cobra.Command, args []string) (err error) {
 				KeyFile:     runCfg.KeyFile,
 				PublicURLs:  publicURLs,
 				ConfigDir:   confDir,
+
+				MinVersion:   runCfg.TLSMinVersion,
+				CipherSuites: sharing.SplitList(runCfg.TLSCipherSuites),
 			},
 			ShutdownTimeout: -1,
+			AccessLogFormat: runCfg.AccessLogFormat,
+			AccessLog:       accessLog,
 		},
 		Handler: sharing.Config{
 			URLBases:              publicURLs,
 			Templates:             runCfg.Templates,
 			StaticSourcesPath:     runCfg.StaticSourcesPath,
 			RedirectHTTPS:         runCfg.RedirectHTTPS,
+			UseRequestScheme:      runCfg.UseRequestScheme,
 			LandingRedirectTarget: runCfg.LandingRedirectTarget,
+			HealthPath:            runCfg.HealthPath,
+			ReadyPath:             runCfg.ReadyPath,
+			ReadyCheckAuthService: runCfg.ReadyCheckAuthService,
 			TxtRecordTTL:          runCfg.TxtRecordTTL,
+			HostingFallback:       hostingFallback,
 			AuthServiceConfig: sharing.AuthServiceConfig{
 				BaseURL: runCfg.AuthServiceBaseURL,
 				Token:   runCfg.AuthServiceToken,
+
+				RetryStatusCodes: authRetryCodes,
+				Cache:            authCache,
 			},
-			DNSServer:      runCfg.DNSServer,
-			ConnectionPool: sharing.ConnectionPoolConfig(runCfg.ConnectionPool),
-			UseQosAndCC:    runCfg.UseQosAndCC,
+			DNSServers:      sharing.SplitList(runCfg.DNSServer),
+			DNSTimeout:      runCfg.DNSTimeout,
+			RequestTimeout:  runCfg.RequestTimeout,
+			ConnectionPool:  sharing.ConnectionPoolConfig(runCfg.ConnectionPool),
+			UseQosAndCC:     runCfg.UseQosAndCC,
+			LocationWorkers: runCfg.GeoLocationWorkers,
+			LocationTimeout: runCfg.GeoLocationTimeout,
+
+			ExistsMaxKeys:     runCfg.ExistsMaxKeys,
+			ExistsConcurrency: runCfg.ExistsConcurrency,
+
+			TextViewDefault: runCfg.TextViewDefault,
+			TextViewMaxSize: runCfg.TextViewMaxSize.Int64(),
+
+			PDFViewDefault:      runCfg.PDFViewDefault,
+			MarkdownViewDefault: runCfg.MarkdownViewDefault,
+
+			CORSAllowedOrigins: sharing.SplitList(runCfg.CORSAllowedOrigins),
+
+			StripQueryParams:    runCfg.StripQueryParams,
+			QueryParamAllowlist: sharing.SplitList(runCfg.QueryParamAllowlist),
+
+			SecurityTXT: securityTXT,
+
+			MaxKeyLength: runCfg.MaxKeyLength,
+			MaxKeyDepth:  runCfg.MaxKeyDepth,
+
+			DownloadConfirmation:        runCfg.DownloadConfirmation,
+			DownloadConfirmationMinSize: runCfg.DownloadConfirmSize.Int64(),
+
+			CollapseSlashes:       runCfg.CollapseSlashes,
+			TrailingSlashRedirect: runCfg.TrailingSlash,
+
+			ForceDownload:        sharing.SplitList(runCfg.ForceDownload),
+			HostingForceDownload: sharing.SplitList(runCfg.HostingForceDownload),
+
+			ListPageSize:        runCfg.ListPageSize,
+			MaxListSize:         runCfg.MaxListSize,
+			RelativeListingURLs: runCfg.RelativeListingURLs,
+
+			PresignSecretKey: runCfg.PresignSecretKey,
+
+			PasswordProtection: runCfg.PasswordProtection,
+
+			TransformSigningKey: runCfg.TransformSigningKey,
+			SignedTransforms:    sharing.SplitList(runCfg.SignedTransforms),
+			Transforms:          transforms,
+
+			HostingRootListing:     runCfg.HostingRootListing,
+			HostingNoindexListings: runCfg.HostingNoindex,
+			SPARoutePattern:        runCfg.SPARoutePattern,
+			SPAFallbackDocument:    runCfg.SPAFallbackDocument,
+
+			EgressExporter:       egressExporter,
+			EgressExportInterval: runCfg.EgressExportInterval,
+
+			LogRequests: runCfg.LogRequests,
+			Metrics:     metrics,
+
+			PageViewRecorder:   pageViewRecorder,
+			PageViewSampleRate: runCfg.PageViewSampleRate,
+			PageViewClientIPs:  runCfg.PageViewClientIPs,
+
+			ChecksumVerification: runCfg.ChecksumVerification,
+
+			BodyCache:              bodyCache,
+			BodyCacheMaxObjectSize: runCfg.BodyCacheObjectSize.Int64(),
+			BodyCacheTTL:           runCfg.BodyCacheTTL,
+			StaleIfError:           runCfg.StaleIfError,
+
+			RangeCoalesceWindow: runCfg.RangeCoalesceWindow,
+			RangeCoalesceSize:   runCfg.RangeCoalesceSize.Int64(),
+			FullRangeAsOK:       runCfg.FullRangeAsOK,
+			AbortShortReads:     runCfg.AbortShortReads,
+
+			MaxConcurrentDownloads: runCfg.MaxDownloads,
+			DownloadPriorityPolicy: runCfg.DownloadPriority,
+			SmallDownloadSize:      runCfg.SmallDownloadSize.Int64(),
+			LargeDownloadMaxWait:   runCfg.LargeDownloadMaxWait,
+			DownloadBufferSize:     int(runCfg.DownloadBufferSize.Int64()),
+
+			BandwidthLimit:      runCfg.BandwidthLimit.Int64(),
+			BandwidthLimitScope: runCfg.BandwidthLimitScope,
+			BandwidthBurst:      runCfg.BandwidthBurst.Int64(),
+
+			ProjectCacheSize: runCfg.ProjectCacheSize,
+			ProjectCacheTTL:  runCfg.ProjectCacheTTL,
+
+			ShortLinks:      shortLinks,
+			ShortLinkToken:  runCfg.ShortLinkToken,
+			ShortLinkTTL:    runCfg.ShortLinkTTL,
+			ShortLinkMaxTTL: runCfg.ShortLinkMaxTTL,
+
+			TransformMemoryLimit:    runCfg.TransformMemoryLimit.Int64(),
+			TransformRejectOversize: runCfg.TransformRejectLarger,
+
+			HostingTraditionalPaths: runCfg.HostingTraditional,
+
+			TrustedProxies:      sharing.SplitList(runCfg.TrustedProxies),
+			ClientCountryHeader: runCfg.ClientCountryHeader,
+			DebugHeaders:        runCfg.DebugHeaders,
+			BotUserAgents:       sharing.SplitList(runCfg.BotUserAgents),
+
+			ArchiveMaxObjects:   runCfg.ArchiveMaxObjects,
+			ArchiveMaxBytes:     runCfg.ArchiveMaxSize.Int64(),
+			ArchiveReproducible: runCfg.ArchiveReproducible,
+
+			CacheKeyHeader: runCfg.CacheKeyHeader,
+			CacheKeyParts:  sharing.SplitList(runCfg.CacheKeyParts),
+
+			GzipDecompression: runCfg.GzipDecompression,
+			CompressResponses: runCfg.CompressResponses,
+
+			ScopeAuditLevel: runCfg.ScopeAuditLevel,
+
+			ErrorCacheControl: runCfg.ErrorCacheControl,
+
+			StrictDisplayFlags: runCfg.StrictDisplayFlags,
+
+			Watermark:        watermark,
+			WatermarkMaxSize: runCfg.WatermarkMaxSize.Int64(),
+
+			AllowedSatellites: sharing.SplitList(runCfg.AllowedSatellites),
+
+			ArchiveCache:        archiveCache,
+			ArchiveCacheMaxSize: runCfg.ArchiveCacheMaxSize.Int64(),
+			ArchiveCacheTTL:     runCfg.ArchiveCacheTTL,
+
+			MaxConcurrentArchives: runCfg.MaxArchives,
+			ArchiveRetryAfter:     runCfg.ArchiveRetryAfter,
 		},
 		GeoLocationDB: runCfg.GeoLocationDB,
 	})
@@ -153,6 +501,69 @@ func cmdSetup(cmd *cobra.Command, args []string) (err error) {
 	return process.SaveConfig(cmd, filepath.Join(setupDir, "config.yaml"))
 }
 
+// reloadOnHangup reloads the hosting fallback map whenever the process gets
+// a SIGHUP, until ctx is done.
+func reloadOnHangup(ctx context.Context, log *zap.Logger, fallback *sharing.HostingFallback) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			if err := fallback.Reload(); err != nil {
+				log.Error("unable to reload hosting fallback", zap.Error(err))
+				continue
+			}
+			log.Info("reloaded hosting fallback")
+		}
+	}
+}
+
+// serveMetrics serves metrics at /metrics on address until ctx is done.
+func serveMetrics(ctx context.Context, log *zap.Logger, address string, metrics *sharing.Metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	server := &http.Server{Addr: address, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("unable to serve metrics", zap.Error(err))
+	}
+}
+
+// parseStatusCodes parses a comma separated list of HTTP status codes.
+func parseStatusCodes(value string) ([]int, error) {
+	var codes []int
+	for _, entry := range sharing.SplitList(value) {
+		code, err := strconv.Atoi(entry)
+		if err != nil || code < 100 || code > 599 {
+			return nil, errs.New("invalid status code %q", entry)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// parseTransforms returns the named built-in transforms.
+func parseTransforms(value string) (map[string]sharing.Transform, error) {
+	builtin := sharing.BuiltinTransforms()
+	transforms := map[string]sharing.Transform{}
+	for _, name := range sharing.SplitList(value) {
+		transform, ok := builtin[name]
+		if !ok {
+			return nil, errs.New("unknown transform %q", name)
+		}
+		transforms[name] = transform
+	}
+	return transforms, nil
+}
+
 func main() {
 	process.Exec(rootCmd)
 }
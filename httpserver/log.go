@@ -4,7 +4,13 @@
 package httpserver
 
 import (
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -62,3 +68,109 @@ func logResponses(log *zap.Logger, h http.Handler) http.Handler {
 				zap.Duration("duration", time.Since(start)))
 		}))
 }
+
+// combinedLogTimeFormat is the timestamp format of the Common and Combined
+// Log Formats.
+const combinedLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// logCombined writes one line per request to out in the Combined Log Format,
+// as understood by traditional log analyzers.
+func logCombined(out io.Writer, h http.Handler) http.Handler {
+	var mu sync.Mutex
+	return whmon.MonitorResponse(whroute.HandlerFunc(h,
+		func(w http.ResponseWriter, r *http.Request) {
+			rw := w.(whmon.ResponseWriter)
+			start := time.Now()
+
+			h.ServeHTTP(rw, r)
+
+			if !rw.WroteHeader() {
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			line := formatCombined(r, start, rw.StatusCode(), rw.Written())
+
+			mu.Lock()
+			defer mu.Unlock()
+			_, _ = io.WriteString(out, line)
+		}))
+}
+
+// formatCombined formats a Combined Log Format line for the request. The
+// request path and the referer are logged with the access grants and access
+// key ids in them redacted, and without their query strings, which may
+// carry signatures and tokens.
+func formatCombined(r *http.Request, start time.Time, code int, written int64) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	size := "-"
+	if written > 0 {
+		size = fmt.Sprint(written)
+	}
+
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s %q %q\n",
+		dashIfEmpty(client),
+		start.Format(combinedLogTimeFormat),
+		r.Method, redactPath(r.URL.EscapedPath()), r.Proto,
+		code, size,
+		dashIfEmpty(redactReferer(r.Referer())), dashIfEmpty(r.UserAgent()))
+}
+
+// accessRoutes are the first path segments of the routes that take an
+// access grant, access key id or short link id as the second.
+var accessRoutes = map[string]bool{"s": true, "raw": true, "exists": true, "q": true}
+
+// redactedSegment replaces the path segments that are redacted.
+const redactedSegment = "-"
+
+// redactPath returns the escaped path with the segment after /s/, /raw/,
+// /exists/ and /q/ redacted, as well as any other segment that looks like an
+// access grant or access key id, like those of links in the legacy format.
+func redactPath(escapedPath string) string {
+	segments := strings.Split(escapedPath, "/")
+	for i, segment := range segments {
+		if (i == 2 && accessRoutes[segments[1]] && segment != "") || looksLikeAccess(segment) {
+			segments[i] = redactedSegment
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// looksLikeAccess returns whether a path segment could be an access grant or
+// an access key id, which are long and alphanumeric.
+func looksLikeAccess(segment string) bool {
+	if len(segment) < 28 {
+		return false
+	}
+	for _, c := range segment {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// redactReferer returns the referer with its path redacted like request
+// paths are, and without its query string and fragment. Referers that don't
+// parse are left out.
+func redactReferer(referer string) string {
+	if referer == "" {
+		return ""
+	}
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	redacted := url.URL{Scheme: u.Scheme, Host: u.Host}
+	return redacted.String() + redactPath(u.EscapedPath())
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
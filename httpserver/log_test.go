@@ -0,0 +1,58 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogCombined(t *testing.T) {
+	var out bytes.Buffer
+	handler := logCombined(&out, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("not here"))
+	}))
+
+	r := httptest.NewRequest("GET", "http://test.test/s/secretaccess/bucket/key%20name?sig=secretsig", nil)
+	r.RemoteAddr = "192.0.2.1:4242"
+	r.Header.Set("Referer", "http://test.test/s/referreraccess/bucket/?restrict=secrettoken")
+	r.Header.Set("User-Agent", "test agent")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := out.String()
+	require.Regexp(t, regexp.MustCompile(
+		`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /s/-/bucket/key%20name HTTP/1\.1" 404 8 "http://test\.test/s/-/bucket/" "test agent"\n$`),
+		line)
+	for _, secret := range []string{"secretaccess", "secretsig", "referreraccess", "secrettoken"} {
+		require.NotContains(t, line, secret)
+	}
+}
+
+func TestRedactPath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/":                     "/",
+		"/index.html":           "/index.html",
+		"/s/access/bucket/key":  "/s/-/bucket/key",
+		"/raw/access/bucket/":   "/raw/-/bucket/",
+		"/exists/access/bucket": "/exists/-/bucket",
+		"/q/SHORTLINKID23456":   "/q/-",
+		"/q/SHORTLINKID23456/a": "/q/-/a",
+		"/q/":                   "/q/",
+		"/static/s/file.css":    "/static/s/file.css",
+		"/images/s/photo.jpg":   "/images/s/photo.jpg",
+		"/jx7kp2vnw4fjlvnsqknyzarigdra/bucket/key":      "/-/bucket/key",
+		"/photos/a-very-long-file-name-with-dashes.jpg": "/photos/a-very-long-file-name-with-dashes.jpg",
+	} {
+		require.Equal(t, expected, redactPath(path), path)
+	}
+
+	require.Equal(t, "https://site.test/s/-/bucket/", redactReferer("https://site.test/s/access/bucket/?restrict=token#top"))
+	require.Equal(t, "", redactReferer("%zz"))
+}
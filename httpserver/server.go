@@ -7,9 +7,11 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"time"
 
@@ -46,6 +48,17 @@ type Config struct {
 	// 10 seconds if unset. If set to a negative value, the server will be
 	// closed immediately.
 	ShutdownTimeout time.Duration
+
+	// AccessLogFormat selects how requests are logged: "structured" (the
+	// default) logs through the zap logger, "combined" writes the Combined
+	// Log Format to AccessLog, and "both" does both. Combined lines carry the
+	// request path and referer with access grants redacted and without
+	// query strings.
+	AccessLogFormat string
+
+	// AccessLog is where Combined Log Format lines are written. Defaults to
+	// stdout.
+	AccessLog io.Writer
 }
 
 // TLSConfig is a struct to handle the preferred/configured TLS options.
@@ -55,6 +68,14 @@ type TLSConfig struct {
 	KeyFile     string
 	PublicURLs  []string
 	ConfigDir   string
+
+	// MinVersion is the oldest TLS version accepted, "1.2" or "1.3".
+	// Defaults to "1.2".
+	MinVersion string
+	// CipherSuites restricts the TLS 1.2 cipher suites, by their Go names
+	// like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Go's defaults are used
+	// when empty. TLS 1.3 cipher suites aren't configurable.
+	CipherSuites []string
 }
 
 // Server is the HTTP server.
@@ -81,6 +102,11 @@ func New(log *zap.Logger, handler http.Handler, config Config) (*Server, error)
 		return nil, errs.New("server handler is required")
 	}
 
+	wrapLogging, err := accessLogging(log, config)
+	if err != nil {
+		return nil, err
+	}
+
 	tlsConfig, httpHandler, err := configureTLS(config.TLSConfig, handler)
 	if err != nil {
 		return nil, err
@@ -100,8 +126,8 @@ func New(log *zap.Logger, handler http.Handler, config Config) (*Server, error)
 	}
 
 	// logging
-	httpHandler = logResponses(log, logRequests(log, httpHandler))
-	handler = logResponses(log, logRequests(log, handler))
+	httpHandler = wrapLogging(httpHandler)
+	handler = wrapLogging(handler)
 
 	server := &http.Server{
 		Handler:  httpHandler,
@@ -134,6 +160,35 @@ func New(log *zap.Logger, handler http.Handler, config Config) (*Server, error)
 	}, nil
 }
 
+// accessLogging returns the middleware that logs requests in the configured
+// access log format.
+func accessLogging(log *zap.Logger, config Config) (func(http.Handler) http.Handler, error) {
+	out := config.AccessLog
+	if out == nil {
+		out = os.Stdout
+	}
+
+	structured := func(h http.Handler) http.Handler {
+		return logResponses(log, logRequests(log, h))
+	}
+	combined := func(h http.Handler) http.Handler {
+		return logCombined(out, h)
+	}
+
+	switch config.AccessLogFormat {
+	case "", "structured":
+		return structured, nil
+	case "combined":
+		return combined, nil
+	case "both":
+		return func(h http.Handler) http.Handler {
+			return combined(structured(h))
+		}, nil
+	default:
+		return nil, errs.New("unknown access log format %q", config.AccessLogFormat)
+	}
+}
+
 // Run runs the server until it's either closed or it errors.
 func (server *Server) Run(ctx context.Context) (err error) {
 	ctx, cancel := context.WithCancel(ctx)
@@ -240,6 +295,9 @@ func configureTLS(config *TLSConfig, handler http.Handler) (*tls.Config, http.Ha
 
 	tlsConfig := BaseTLSConfig()
 	tlsConfig.Certificates = []tls.Certificate{cert}
+	if err := applyTLSPolicy(tlsConfig, config); err != nil {
+		return nil, nil, err
+	}
 	return tlsConfig, handler, nil
 }
 
@@ -259,9 +317,41 @@ func configureLetsEncrypt(config *TLSConfig, handler http.Handler) (*tls.Config,
 
 	tlsConfig := BaseTLSConfig()
 	tlsConfig.GetCertificate = certManager.GetCertificate
+	if err := applyTLSPolicy(tlsConfig, config); err != nil {
+		return nil, nil, err
+	}
 	return tlsConfig, certManager.HTTPHandler(handler), nil
 }
 
+// applyTLSPolicy sets the configured minimum version and cipher suites on
+// tlsConfig. Insecure cipher suites are refused.
+func applyTLSPolicy(tlsConfig *tls.Config, config *TLSConfig) error {
+	switch config.MinVersion {
+	case "", "1.2":
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return errs.New("unsupported minimum TLS version %q", config.MinVersion)
+	}
+
+	if len(config.CipherSuites) == 0 {
+		return nil
+	}
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	for _, name := range config.CipherSuites {
+		id, ok := secure[name]
+		if !ok {
+			return errs.New("unknown or insecure cipher suite %q", name)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+	}
+	return nil
+}
+
 func shutdownWithTimeout(server *http.Server, timeout time.Duration) error {
 	if timeout < 0 {
 		return server.Close()
@@ -228,3 +228,22 @@ func certPoolFromCert(cert *x509.Certificate) *x509.CertPool {
 	pool.AddCert(cert)
 	return pool
 }
+
+func TestApplyTLSPolicy(t *testing.T) {
+	tlsConfig := BaseTLSConfig()
+	require.NoError(t, applyTLSPolicy(tlsConfig, &TLSConfig{}))
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Empty(t, tlsConfig.CipherSuites)
+
+	tlsConfig = BaseTLSConfig()
+	require.NoError(t, applyTLSPolicy(tlsConfig, &TLSConfig{
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	}))
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+
+	require.Error(t, applyTLSPolicy(BaseTLSConfig(), &TLSConfig{MinVersion: "1.0"}))
+	require.Error(t, applyTLSPolicy(BaseTLSConfig(), &TLSConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}))
+	require.Error(t, applyTLSPolicy(BaseTLSConfig(), &TLSConfig{CipherSuites: []string{"NOT_A_SUITE"}}))
+}
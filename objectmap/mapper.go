@@ -69,6 +69,12 @@ func (mapper *IPDB) Close() (err error) {
 	return nil
 }
 
+// Available returns whether the mapper is able to resolve locations. It is
+// safe to call on a nil mapper.
+func (mapper *IPDB) Available() bool {
+	return mapper != nil && mapper.reader != nil
+}
+
 // GetIPInfos returns the geolocation information from an IP address.
 func (mapper *IPDB) GetIPInfos(ctx context.Context, hostOrIP string) (_ *IPInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
@@ -122,3 +122,10 @@ func TestIPDB_GetIPInfos_Concurrent(t *testing.T) {
 
 	require.Equal(t, 10, len(mapper.cachedIPs))
 }
+
+func TestIPDB_Available(t *testing.T) {
+	var nilMapper *IPDB
+	assert.False(t, nilMapper.Available())
+	assert.False(t, (&IPDB{}).Available())
+	assert.True(t, NewIPDB(&MockReader{}).Available())
+}
@@ -30,9 +30,10 @@ type Config struct {
 //
 // architecture: Peer
 type Peer struct {
-	Log    *zap.Logger
-	Mapper *objectmap.IPDB
-	Server *httpserver.Server
+	Log     *zap.Logger
+	Mapper  *objectmap.IPDB
+	Handler *sharing.Handler
+	Server  *httpserver.Server
 }
 
 // New is a constructor for Linksharing Peer.
@@ -44,17 +45,20 @@ func New(log *zap.Logger, config Config) (_ *Peer, err error) {
 	if config.GeoLocationDB != "" {
 		reader, err := maxminddb.Open(config.GeoLocationDB)
 		if err != nil {
-			return nil, errs.New("unable to open geo location db: %w", err)
+			// the map is a nicety, so we'd rather serve without location data
+			// than refuse to start.
+			log.Warn("unable to open geo location db, location data will be unavailable", zap.Error(err))
+		} else {
+			peer.Mapper = objectmap.NewIPDB(reader)
 		}
-		peer.Mapper = objectmap.NewIPDB(reader)
 	}
 
-	handle, err := sharing.NewHandler(log, peer.Mapper, config.Handler)
+	peer.Handler, err = sharing.NewHandler(log, peer.Mapper, config.Handler)
 	if err != nil {
 		return nil, errs.New("unable to create handler: %w", err)
 	}
 
-	peer.Server, err = httpserver.New(log, handle, config.Server)
+	peer.Server, err = httpserver.New(log, peer.Handler, config.Server)
 	if err != nil {
 		return nil, errs.New("unable to create httpserver: %w", err)
 	}
@@ -71,6 +75,10 @@ func (peer *Peer) Run(ctx context.Context) error {
 		return ignoreCancel(peer.Server.Run(ctx))
 	})
 
+	group.Go(func() error {
+		return ignoreCancel(peer.Handler.RunEgressExport(ctx))
+	})
+
 	return group.Wait()
 }
 
@@ -5,11 +5,14 @@ package sharing
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/btcsuite/btcutil/base58"
 	"github.com/zeebo/errs"
 
+	"storj.io/common/storj"
 	"storj.io/uplink"
 )
 
@@ -39,3 +42,27 @@ func parseAccess(ctx context.Context, access string, cfg AuthServiceConfig) (_ *
 
 	return wrappedParse(authResp.AccessGrant)
 }
+
+// errUntrustedSatellite is returned for accesses to satellites outside of
+// the configured allowlist.
+var errUntrustedSatellite = errors.New("access is for an untrusted satellite")
+
+// checkSatellite returns an error if access is for a satellite that isn't
+// allowed. Allowed satellites are given as node URLs (id@host:port), host
+// and port, or node id. Any satellite is allowed when none are configured.
+func (handler *Handler) checkSatellite(access *uplink.Access) error {
+	if len(handler.allowedSatellites) == 0 {
+		return nil
+	}
+	address := access.SatelliteAddress()
+	nodeURL, err := storj.ParseNodeURL(address)
+	if err != nil {
+		return WithStatus(errs.New("invalid satellite address %q: %v", address, err), http.StatusBadRequest)
+	}
+	for _, allowed := range handler.allowedSatellites {
+		if allowed == address || allowed == nodeURL.Address || (!nodeURL.ID.IsZero() && allowed == nodeURL.ID.String()) {
+			return nil
+		}
+	}
+	return WithStatus(fmt.Errorf("%w: %q", errUntrustedSatellite, nodeURL.Address), http.StatusForbidden)
+}
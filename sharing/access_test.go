@@ -0,0 +1,37 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSatellite(t *testing.T) {
+	access := newTestAccess(t)
+
+	for _, allowed := range [][]string{
+		nil,
+		{"12EayRS2V1kEsWESU9QMRseFhdxYxKicsiFmxrsLZHeLUtdps3S@satellite.test:7777"},
+		{"other.test:7777", "satellite.test:7777"},
+		{"12EayRS2V1kEsWESU9QMRseFhdxYxKicsiFmxrsLZHeLUtdps3S"},
+	} {
+		handler := &Handler{allowedSatellites: allowed}
+		require.NoError(t, handler.checkSatellite(access), allowed)
+	}
+
+	for _, allowed := range [][]string{
+		{"other.test:7777"},
+		{"satellite.test:7778"},
+		{"1wFTAgs9DP5RSnCqKV1eLf6N9wtk4EAtmN5DpSxcs8EjT69tGE"},
+	} {
+		handler := &Handler{allowedSatellites: allowed}
+		err := handler.checkSatellite(access)
+		require.True(t, errors.Is(err, errUntrustedSatellite), allowed)
+		require.Equal(t, http.StatusForbidden, GetStatus(err, 0), allowed)
+	}
+}
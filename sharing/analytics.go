@@ -0,0 +1,124 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PageView is a request to a site on the hosting service. Unless client IPs
+// are enabled, the client is only identified by the country its IP
+// geolocates to, and the referer is cut down to its origin.
+type PageView struct {
+	Time     time.Time
+	Host     string
+	Path     string
+	Status   int
+	Country  string
+	Referer  string
+	ClientIP string
+}
+
+// PageViewRecorder receives page views of hosted sites. It is called as
+// requests finish, so implementations should hand views off rather than
+// block on slow pipelines.
+type PageViewRecorder interface {
+	RecordPageView(ctx context.Context, view PageView)
+}
+
+// LogPageViewRecorder records page views as log lines.
+type LogPageViewRecorder struct {
+	Log *zap.Logger
+}
+
+// RecordPageView implements PageViewRecorder.
+func (recorder LogPageViewRecorder) RecordPageView(ctx context.Context, view PageView) {
+	fields := []zap.Field{
+		zap.Time("time", view.Time),
+		zap.String("host", view.Host),
+		zap.String("path", view.Path),
+		zap.Int("status", view.Status),
+		zap.String("country", view.Country),
+		zap.String("referer", view.Referer),
+	}
+	if view.ClientIP != "" {
+		fields = append(fields, zap.String("client", view.ClientIP))
+	}
+	recorder.Log.Info("page view", fields...)
+}
+
+// pageViewWriter remembers the status of the response.
+type pageViewWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *pageViewWriter) WriteHeader(status int) {
+	// informational responses like 103 Early Hints come before the real one.
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *pageViewWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *pageViewWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// trackPageView wraps w to record a sampled page view when the returned done
+// func is called, if r is for a hosted site. Otherwise w is returned as is.
+func (handler *Handler) trackPageView(ctx context.Context, w http.ResponseWriter, r *http.Request) (_ http.ResponseWriter, done func()) {
+	if handler.pageViewRecorder == nil || rand.Float64() >= handler.pageViewSampleRate {
+		return w, func() {}
+	}
+	if ours, err := isDomainOurs(r.Host, handler.urlBases); err != nil || ours {
+		return w, func() {}
+	}
+
+	start := time.Now()
+	tracked := &pageViewWriter{ResponseWriter: w}
+	return tracked, func() {
+		view := PageView{
+			Time:    start,
+			Host:    r.Host,
+			Path:    r.URL.Path,
+			Status:  tracked.status,
+			Country: handler.clientCountry(ctx, r),
+			Referer: r.Referer(),
+		}
+		if handler.pageViewClientIPs {
+			if ip := handler.clientIP(r); ip != nil {
+				view.ClientIP = ip.String()
+			}
+		} else {
+			view.Referer = refererOrigin(view.Referer)
+		}
+		handler.pageViewRecorder.RecordPageView(ctx, view)
+	}
+}
+
+// refererOrigin cuts a referer down to its scheme and host, dropping paths
+// and queries that may identify the visitor.
+func refererOrigin(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
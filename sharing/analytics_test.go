@@ -0,0 +1,67 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+)
+
+type pageViews []PageView
+
+func (views *pageViews) RecordPageView(ctx context.Context, view PageView) {
+	*views = append(*views, view)
+}
+
+func TestTrackPageView(t *testing.T) {
+	ctx := testcontext.New(t)
+	base, err := url.Parse("http://link.test")
+	require.NoError(t, err)
+
+	var views pageViews
+	handler := &Handler{
+		urlBases:           []*url.URL{base},
+		pageViewRecorder:   &views,
+		pageViewSampleRate: 1,
+	}
+
+	request := func(host string) {
+		r := httptest.NewRequest("GET", "http://"+host+"/docs/page.html?utm=x", nil)
+		r.RemoteAddr = "198.51.100.7:1234"
+		r.Header.Set("Referer", "https://search.test/results?q=secret")
+		w, done := handler.trackPageView(ctx, httptest.NewRecorder(), r)
+		w.WriteHeader(103)
+		w.WriteHeader(http.StatusNotFound)
+		done()
+	}
+
+	// only hosted sites are tracked.
+	request("link.test")
+	require.Empty(t, views)
+
+	request("site.test")
+	require.Len(t, views, 1)
+	require.Equal(t, "site.test", views[0].Host)
+	require.Equal(t, "/docs/page.html", views[0].Path)
+	require.Equal(t, http.StatusNotFound, views[0].Status)
+	require.Equal(t, "https://search.test", views[0].Referer)
+	require.Empty(t, views[0].ClientIP)
+
+	handler.pageViewClientIPs = true
+	request("site.test")
+	require.Len(t, views, 2)
+	require.Equal(t, "https://search.test/results?q=secret", views[1].Referer)
+	require.Equal(t, "198.51.100.7", views[1].ClientIP)
+
+	handler.pageViewSampleRate = 0
+	request("site.test")
+	require.Len(t, views, 2)
+}
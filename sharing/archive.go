@@ -0,0 +1,412 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/uplink"
+)
+
+// archiveFormat returns the requested archive format for a prefix, if any.
+// Besides ?archive=, ?download=zip and ?download=tar ask for one too.
+func archiveFormat(r *http.Request) (format string, err error) {
+	q := r.URL.Query()
+	format = strings.ToLower(q.Get("archive"))
+	if download := strings.ToLower(q.Get("download")); format == "" && (download == "zip" || download == "tar") {
+		format = download
+	}
+	switch format {
+	case "", "zip", "tar":
+		return format, nil
+	default:
+		return "", WithStatus(errs.New("unsupported archive format %q", format), http.StatusBadRequest)
+	}
+}
+
+var archiveContentTypes = map[string]string{
+	"zip": "application/zip",
+	"tar": "application/x-tar",
+}
+
+// archiveWriter is the part of zip.Writer and tar.Writer serveArchive needs.
+type archiveWriter interface {
+	// Next starts the next entry in the archive, returning where its
+	// contents should be written.
+	Next(item *uplink.Object, name string) (io.Writer, error)
+	Close() error
+}
+
+type zipArchive struct {
+	*zip.Writer
+	reproducible bool
+}
+
+func (a zipArchive) Next(item *uplink.Object, name string) (io.Writer, error) {
+	return a.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: archiveModTime(item, a.reproducible),
+	})
+}
+
+type tarArchive struct {
+	*tar.Writer
+	reproducible bool
+}
+
+func (a tarArchive) Next(item *uplink.Object, name string) (io.Writer, error) {
+	err := a.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     item.System.ContentLength,
+		Mode:     0644,
+		ModTime:  archiveModTime(item, a.reproducible),
+	})
+	return a.Writer, err
+}
+
+// archiveModTime returns the modification time of item's archive entry. In
+// reproducible archives it's in UTC and whole seconds, so the entry doesn't
+// depend on the server's time zone or on how precisely a format stores it.
+func archiveModTime(item *uplink.Object, reproducible bool) time.Time {
+	if !reproducible {
+		return item.System.Created
+	}
+	return item.System.Created.UTC().Truncate(time.Second)
+}
+
+// serveArchive streams every object under the prefix as a single archive.
+// The listing is consumed lazily and each object is streamed into the archive
+// as it is encountered, so memory use doesn't depend on the number of
+// objects. Once the first entry is written the response is committed, so
+// later failures are logged and end the response early.
+//
+// Small enough archives are served from the archive cache instead, if there
+// is one.
+func (handler *Handler) serveArchive(ctx context.Context, w http.ResponseWriter, r *http.Request, project *uplink.Project, pr *parsedRequest, format string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	scan, err := handler.scanArchive(ctx, project, pr)
+	if err != nil {
+		return err
+	}
+
+	var objects objectIterator = handler.listObjects(ctx, project, pr.bucket, &uplink.ListObjectsOptions{
+		Prefix:    pr.realKey,
+		Recursive: true,
+		System:    true,
+	})
+	if len(scan.protected) > 0 {
+		objects = &hidingIterator{objectIterator: objects, hidden: scan.protects}
+	}
+
+	// find the first object before committing to a response, so an empty
+	// prefix still gets a proper 404.
+	if !objects.Next() {
+		if err := objects.Err(); err != nil {
+			return WithAction(err, "list objects")
+		}
+		return WithAction(uplink.ErrObjectNotFound, "serve archive - empty")
+	}
+
+	if r.Method != http.MethodHead {
+		release, err := handler.acquireArchive(w)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	name := path.Base(strings.TrimSuffix(pr.realKey, "/"))
+	if pr.realKey == "" {
+		name = pr.bucket
+	}
+	w.Header().Set("Content-Type", archiveContentTypes[format])
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", name+"."+format))
+
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	// archives take as long as their objects take to download.
+	liftRequestTimeout(ctx)
+
+	if handler.archiveCache != nil && scan.size <= handler.archiveCacheMaxSize {
+		return handler.serveCachedArchive(ctx, w, project, pr, format, objects, scan)
+	}
+
+	if err := handler.writeArchive(ctx, w, project, pr, format, objects); err != nil {
+		handler.log.Warn("archive interrupted", zap.String("format", format), zap.Error(err))
+	}
+	return nil
+}
+
+// acquireArchive takes one of the slots for generating archives, failing
+// with 503 and a Retry-After header when they are all taken. The returned
+// func gives the slot back.
+func (handler *Handler) acquireArchive(w http.ResponseWriter) (release func(), err error) {
+	if handler.archiveLimiter == nil {
+		return func() {}, nil
+	}
+	select {
+	case handler.archiveLimiter <- struct{}{}:
+		return func() { <-handler.archiveLimiter }, nil
+	default:
+		mon.Event("archive_limit_exceeded")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(handler.archiveRetryAfter.Seconds()))))
+		return nil, WithStatus(errs.New("too many archives being generated"), http.StatusServiceUnavailable)
+	}
+}
+
+// writeArchive writes the objects left in the listing, which has already
+// been advanced to its first item, to dst as an archive.
+func (handler *Handler) writeArchive(ctx context.Context, dst io.Writer, project *uplink.Project, pr *parsedRequest, format string, objects objectIterator) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var archive archiveWriter
+	switch format {
+	case "tar":
+		archive = tarArchive{tar.NewWriter(dst), handler.archiveReproducible}
+	default:
+		archive = zipArchive{zip.NewWriter(dst), handler.archiveReproducible}
+	}
+
+	err = forEachArchiveItem(objects, handler.archiveReproducible, func(item *uplink.Object) error {
+		return handler.archiveObject(ctx, archive, project, pr, item)
+	})
+	if err != nil {
+		return err
+	}
+
+	return WithAction(archive.Close(), "finish archive")
+}
+
+// forEachArchiveItem calls fn with the objects left in the listing, which
+// has already been advanced to its first item. Listings are in the order of
+// the encrypted keys, so when sorted is set the objects are collected first
+// and passed to fn ordered by key instead.
+func forEachArchiveItem(objects objectIterator, sorted bool, fn func(item *uplink.Object) error) error {
+	var items []*uplink.Object
+	for {
+		if item := objects.Item(); !item.IsPrefix {
+			if sorted {
+				items = append(items, item)
+			} else if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if !objects.Next() {
+			break
+		}
+	}
+	if err := objects.Err(); err != nil {
+		return WithAction(err, "list objects")
+	}
+
+	sort.Slice(items, func(i, k int) bool { return items[i].Key < items[k].Key })
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serveCachedArchive serves the archive from the archive cache, generating
+// and caching it on a miss. Concurrent requests for the same archive wait
+// for the first one to generate it rather than all generating it at once.
+func (handler *Handler) serveCachedArchive(ctx context.Context, w http.ResponseWriter, project *uplink.Project, pr *parsedRequest, format string, objects objectIterator, scan archiveScan) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	key, err := archiveCacheKey(pr, format, scan.etag)
+	if err != nil {
+		return WithAction(err, "archive cache key")
+	}
+
+	unlock := handler.archiveLocks.Lock(key)
+	defer unlock()
+
+	body, ok := handler.archiveCache.Get(ctx, key)
+	if ok {
+		mon.Event("archive_cache_hit")
+	} else {
+		mon.Event("archive_cache_miss")
+
+		var buf bytes.Buffer
+		if err := handler.writeArchive(ctx, &buf, project, pr, format, objects); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+		handler.archiveCache.Set(ctx, key, body, handler.archiveCacheTTL)
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, _ = w.Write(body)
+	return nil
+}
+
+// archiveScan summarizes the objects under a prefix.
+type archiveScan struct {
+	count int64
+	size  int64
+	// etag changes whenever an object under the prefix is added, removed or
+	// overwritten.
+	etag string
+	// protected are the prefixes below the archived one with password
+	// objects. their objects are left out, as the credentials that got the
+	// archive may not be theirs.
+	protected []string
+}
+
+// protects returns whether key is under one of the protected prefixes.
+func (scan archiveScan) protects(key string) bool {
+	for _, prefix := range scan.protected {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanArchive lists the prefix before archiving it, when there are caps to
+// check or an archive cache to key. Prefixes with more objects or bytes than
+// an archive may hold are rejected, listing at most one object past the caps
+// so the cost of the check is bounded too. With password protection, it also
+// finds the prefixes below pr's that have password objects.
+func (handler *Handler) scanArchive(ctx context.Context, project *uplink.Project, pr *parsedRequest) (scan archiveScan, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if handler.archiveMaxObjects <= 0 && handler.archiveMaxBytes <= 0 && handler.archiveCache == nil && !handler.passwordProtection {
+		return scan, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// password objects are listed too, to find them.
+	objects := project.ListObjects(ctx, pr.bucket, &uplink.ListObjectsOptions{
+		Prefix:    pr.realKey,
+		Recursive: true,
+		System:    true,
+	})
+
+	hash := sha256.New()
+	for objects.Next() {
+		item := objects.Item()
+		if item.IsPrefix {
+			continue
+		}
+		_, _ = fmt.Fprintf(hash, "%q %d %d\n", item.Key, item.System.Created.UnixNano(), item.System.ContentLength)
+		if handler.hiddenKey(item.Key) {
+			// the one protecting pr's prefix itself has been checked.
+			if dir := item.Key[:strings.LastIndexByte(item.Key, '/')+1]; dir != pr.realKey {
+				scan.protected = append(scan.protected, dir)
+			}
+			continue
+		}
+		scan.count++
+		scan.size += item.System.ContentLength
+		if err := handler.archiveFits(scan.count, scan.size); err != nil {
+			return scan, err
+		}
+	}
+	if err := objects.Err(); err != nil {
+		return scan, WithAction(err, "list objects")
+	}
+	scan.etag = hex.EncodeToString(hash.Sum(nil))
+	return scan, nil
+}
+
+// archiveCacheKey identifies an archive of the prefix in the given format
+// with the given listing etag. Like body cache keys, it includes the access
+// since bucket names are only unique per project.
+func archiveCacheKey(pr *parsedRequest, format, etag string) (string, error) {
+	serialized, err := pr.access.Serialize()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(serialized))
+	return fmt.Sprintf("archive:%s/%s/%s.%s@%s", hex.EncodeToString(sum[:16]), pr.bucket, pr.realKey, format, etag), nil
+}
+
+// archiveFits returns a 413 error once count objects totalling size bytes
+// are over the archive caps.
+func (handler *Handler) archiveFits(count, size int64) error {
+	if handler.archiveMaxObjects > 0 && count > int64(handler.archiveMaxObjects) {
+		return WithStatus(errs.New("archive has more than %d objects", handler.archiveMaxObjects),
+			http.StatusRequestEntityTooLarge)
+	}
+	if handler.archiveMaxBytes > 0 && size > handler.archiveMaxBytes {
+		return WithStatus(errs.New("archive is larger than %d bytes", handler.archiveMaxBytes),
+			http.StatusRequestEntityTooLarge)
+	}
+	return nil
+}
+
+// archiveObject streams a single object into the archive.
+func (handler *Handler) archiveObject(ctx context.Context, archive archiveWriter, project *uplink.Project, pr *parsedRequest, item *uplink.Object) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	name, ok := archiveEntryName(item.Key, pr.realKey)
+	if !ok {
+		handler.log.Debug("skipping archive entry outside of the archive", zap.String("key", item.Key))
+		return nil
+	}
+
+	download, err := project.DownloadObject(ctx, pr.bucket, item.Key, nil)
+	if err != nil {
+		return WithAction(err, "download object")
+	}
+	defer func() {
+		if closeErr := download.Close(); closeErr != nil {
+			handler.log.With(zap.Error(closeErr)).Warn("unable to close archive download")
+		}
+	}()
+
+	entry, err := archive.Next(item, name)
+	if err != nil {
+		return WithAction(err, "archive entry")
+	}
+
+	_, err = io.Copy(entry, download)
+	return WithAction(err, "archive copy")
+}
+
+// archiveEntryName returns the name of the archive entry for the object key
+// under prefix. Keys are arbitrary, so names are cleaned and made relative,
+// and keys that would still end up outside of the archive's directory, like
+// "a/../../x", are reported as not ok so they can be skipped.
+func archiveEntryName(key, prefix string) (name string, ok bool) {
+	rel := strings.TrimPrefix(key, prefix)
+	name = path.Clean(strings.TrimLeft(rel, "/"))
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	if strings.HasSuffix(rel, "/") {
+		name += "/"
+	}
+	return name, true
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"storj.io/common/encryption"
+	"storj.io/common/grant"
+	"storj.io/common/macaroon"
+	"storj.io/common/paths"
+	"storj.io/common/pb"
+	"storj.io/uplink"
+)
+
+// accessScope returns the bucket/prefix pairs the API key of access allows,
+// and whether its caveats restrict it to any at all. Prefixes are decrypted
+// with the access' encryption keys where they can be, so only unencrypted
+// prefixes are returned, never any of the key material.
+func accessScope(access *uplink.Access) (scope []string, restricted bool, err error) {
+	serialized, err := access.Serialize()
+	if err != nil {
+		return nil, false, err
+	}
+	parsed, err := grant.ParseAccess(serialized)
+	if err != nil {
+		return nil, false, err
+	}
+
+	allowed, restricted, err := allowedPaths(parsed.APIKey)
+	if err != nil || !restricted {
+		return nil, restricted, err
+	}
+	for _, allowedPath := range allowed {
+		bucket := string(allowedPath.Bucket)
+		prefix := strings.TrimSuffix(string(allowedPath.EncryptedPathPrefix), "/")
+		if prefix == "" {
+			scope = append(scope, bucket)
+			continue
+		}
+		// keys the access doesn't have leave the prefix encrypted, which is
+		// as much as anyone holding it could tell too.
+		if unenc, err := encryption.DecryptPathWithStoreCipher(bucket, paths.NewEncrypted(prefix), parsed.EncAccess.Store); err == nil && unenc.Valid() {
+			prefix = unenc.Raw()
+		}
+		scope = append(scope, bucket+"/"+prefix)
+	}
+	sort.Strings(scope)
+	return scope, true, nil
+}
+
+// allowedPaths returns the paths every caveat of apiKey allows, and whether
+// any of its caveats restrict paths at all. A caveat allows only the paths it
+// lists, so each one narrows those of the caveats before it.
+func allowedPaths(apiKey *macaroon.APIKey) (allowed []*macaroon.Caveat_Path, restricted bool, err error) {
+	mac, err := macaroon.ParseMacaroon(apiKey.SerializeRaw())
+	if err != nil {
+		return nil, false, err
+	}
+	for _, data := range mac.Caveats() {
+		var caveat macaroon.Caveat
+		if err := pb.Unmarshal(data, &caveat); err != nil {
+			return nil, false, err
+		}
+		if len(caveat.AllowedPaths) == 0 {
+			continue
+		}
+		if !restricted {
+			allowed, restricted = caveat.AllowedPaths, true
+			continue
+		}
+		var narrowed []*macaroon.Caveat_Path
+		for _, a := range allowed {
+			for _, b := range caveat.AllowedPaths {
+				if !bytes.Equal(a.Bucket, b.Bucket) {
+					continue
+				}
+				switch {
+				case withinPrefix(a.EncryptedPathPrefix, b.EncryptedPathPrefix):
+					narrowed = append(narrowed, a)
+				case withinPrefix(b.EncryptedPathPrefix, a.EncryptedPathPrefix):
+					narrowed = append(narrowed, b)
+				}
+			}
+		}
+		allowed = narrowed
+	}
+	return allowed, restricted, nil
+}
+
+// withinPrefix returns whether the encrypted path prefix p is prefix or
+// below it. Encrypted paths keep the components of their unencrypted ones,
+// so this compares whole components.
+func withinPrefix(p, prefix []byte) bool {
+	prefix = bytes.TrimSuffix(prefix, []byte("/"))
+	if len(prefix) == 0 {
+		return true
+	}
+	return bytes.Equal(bytes.TrimSuffix(p, []byte("/")), prefix) || bytes.HasPrefix(p, append(append([]byte{}, prefix...), '/'))
+}
+
+// auditAccessScope logs the scope of the access serving a request, when
+// scope auditing is enabled at a level the logger logs.
+func (handler *Handler) auditAccessScope(access *uplink.Access, bucket string) {
+	if !handler.scopeAudit {
+		return
+	}
+	entry := handler.log.Check(handler.scopeAuditLevel, "access scope")
+	if entry == nil {
+		return
+	}
+
+	scope, restricted, err := accessScope(access)
+	if err != nil {
+		handler.log.Warn("unable to determine access scope", zap.Error(err))
+		return
+	}
+	entry.Write(
+		zap.String("satellite", access.SatelliteAddress()),
+		zap.String("bucket", bucket),
+		zap.Bool("unrestricted", !restricted),
+		zap.Strings("scope", scope),
+	)
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"storj.io/common/grant"
+	"storj.io/common/macaroon"
+	"storj.io/common/storj"
+	"storj.io/uplink"
+)
+
+func newTestAccess(t *testing.T) *uplink.Access {
+	apiKey, err := macaroon.NewAPIKey([]byte("secret"))
+	require.NoError(t, err)
+
+	serialized, err := (&grant.Access{
+		SatelliteAddress: "12EayRS2V1kEsWESU9QMRseFhdxYxKicsiFmxrsLZHeLUtdps3S@satellite.test:7777",
+		APIKey:           apiKey,
+		EncAccess:        grant.NewEncryptionAccessWithDefaultKey(&storj.Key{1}),
+	}).Serialize()
+	require.NoError(t, err)
+
+	access, err := uplink.ParseAccess(serialized)
+	require.NoError(t, err)
+	return access
+}
+
+func TestAccessScope(t *testing.T) {
+	access := newTestAccess(t)
+
+	scope, restricted, err := accessScope(access)
+	require.NoError(t, err)
+	require.False(t, restricted)
+	require.Empty(t, scope)
+
+	shared, err := access.Share(uplink.ReadOnlyPermission(),
+		uplink.SharePrefix{Bucket: "photos", Prefix: "2021/"},
+		uplink.SharePrefix{Bucket: "docs"})
+	require.NoError(t, err)
+
+	scope, restricted, err = accessScope(shared)
+	require.NoError(t, err)
+	require.True(t, restricted)
+	require.Equal(t, []string{"docs", "photos/2021"}, scope)
+
+	// sharing again narrows the scope to what both caveats allow.
+	narrowed, err := shared.Share(uplink.ReadOnlyPermission(),
+		uplink.SharePrefix{Bucket: "photos", Prefix: "2021/june/"},
+		uplink.SharePrefix{Bucket: "docs", Prefix: "manuals/"})
+	require.NoError(t, err)
+
+	scope, restricted, err = accessScope(narrowed)
+	require.NoError(t, err)
+	require.True(t, restricted)
+	require.Equal(t, []string{"docs/manuals", "photos/2021/june"}, scope)
+}
+
+func TestAccessScopeDefaultKey(t *testing.T) {
+	// a grant whose API key is restricted by caveats, but which holds only
+	// the default encryption key, is still restricted.
+	shared, err := newTestAccess(t).Share(uplink.ReadOnlyPermission(),
+		uplink.SharePrefix{Bucket: "photos", Prefix: "2021/"})
+	require.NoError(t, err)
+	serialized, err := shared.Serialize()
+	require.NoError(t, err)
+	parsed, err := grant.ParseAccess(serialized)
+	require.NoError(t, err)
+
+	serialized, err = (&grant.Access{
+		SatelliteAddress: parsed.SatelliteAddress,
+		APIKey:           parsed.APIKey,
+		EncAccess:        grant.NewEncryptionAccessWithDefaultKey(&storj.Key{1}),
+	}).Serialize()
+	require.NoError(t, err)
+	access, err := uplink.ParseAccess(serialized)
+	require.NoError(t, err)
+
+	scope, restricted, err := accessScope(access)
+	require.NoError(t, err)
+	require.True(t, restricted)
+	require.Equal(t, []string{"photos/2021"}, scope)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	handler := &Handler{log: zap.New(core), scopeAudit: true, scopeAuditLevel: zapcore.DebugLevel}
+	handler.auditAccessScope(access, "photos")
+	require.Equal(t, false, logs.All()[0].ContextMap()["unrestricted"])
+}
+
+func TestAuditAccessScope(t *testing.T) {
+	access, err := newTestAccess(t).Share(uplink.ReadOnlyPermission(),
+		uplink.SharePrefix{Bucket: "photos", Prefix: "2021/"})
+	require.NoError(t, err)
+	serialized, err := access.Serialize()
+	require.NoError(t, err)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	handler := &Handler{log: zap.New(core), scopeAudit: true, scopeAuditLevel: zapcore.DebugLevel}
+	handler.auditAccessScope(access, "photos")
+
+	entries := logs.All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	require.Equal(t, "photos", fields["bucket"])
+	require.Equal(t, false, fields["unrestricted"])
+	require.Equal(t, []interface{}{"photos/2021"}, fields["scope"])
+	for _, value := range fields {
+		s, ok := value.(string)
+		require.False(t, ok && strings.Contains(serialized, s) && len(s) > 20, "access logged")
+	}
+
+	disabled := &Handler{log: zap.New(core)}
+	disabled.auditAccessScope(access, "photos")
+	require.Len(t, logs.All(), 1)
+}
+
+func TestWithinPrefix(t *testing.T) {
+	for _, test := range []struct {
+		p, prefix string
+		within    bool
+	}{
+		{p: "a/b", prefix: "", within: true},
+		{p: "a/b", prefix: "a", within: true},
+		{p: "a/b/", prefix: "a/b", within: true},
+		{p: "a/b", prefix: "a/b/", within: true},
+		{p: "a/bc", prefix: "a/b", within: false},
+		{p: "a", prefix: "a/b", within: false},
+	} {
+		require.Equal(t, test.within, withinPrefix([]byte(test.p), []byte(test.prefix)), test)
+	}
+}
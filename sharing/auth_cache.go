@@ -0,0 +1,95 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// AuthServiceCache caches access key ids resolved by the auth service, so
+// popular shares don't need a lookup for every request. Public access
+// grants are kept for the TTL, while non-public ones and access keys the
+// auth service rejects are kept for the shorter NegativeTTL, so bad keys
+// can't hammer the auth service either. Transient failures, like 5xx
+// responses and timeouts, aren't cached, so the next request tries again.
+// It holds up to size entries, evicting the least recently used.
+type AuthServiceCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	cache       *ttlCache
+	updateLocks MutexGroup
+}
+
+type authCacheEntry struct {
+	resp *AuthServiceResponse
+	err  error
+}
+
+// NewAuthServiceCache returns an AuthServiceCache of up to size entries,
+// keeping resolved access grants for ttl, and non-public or rejected access
+// keys for negativeTTL. size defaults to 10000.
+func NewAuthServiceCache(size int, ttl, negativeTTL time.Duration) *AuthServiceCache {
+	if size <= 0 {
+		size = 10000
+	}
+	return &AuthServiceCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		cache:       newTTLCache(size),
+	}
+}
+
+func (cache *AuthServiceCache) resolve(ctx context.Context, accessKeyID string, resolve func(context.Context, string) (*AuthServiceResponse, error)) (_ *AuthServiceResponse, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if entry, ok := cache.lookup(accessKeyID); ok {
+		mon.Event("auth_cache_hit")
+		return entry.resp, entry.err
+	}
+
+	// concurrent requests for the same key wait for a single lookup.
+	defer cache.updateLocks.Lock(accessKeyID)()
+	if entry, ok := cache.lookup(accessKeyID); ok {
+		mon.Event("auth_cache_hit")
+		return entry.resp, entry.err
+	}
+	mon.Event("auth_cache_miss")
+
+	resp, err := resolve(ctx, accessKeyID)
+
+	ttl := cache.ttl
+	switch {
+	case err != nil && !rejected(err):
+		return resp, err
+	case err != nil || !resp.Public:
+		ttl = cache.negativeTTL
+	}
+	if ttl > 0 {
+		cache.cache.set(accessKeyID, &authCacheEntry{resp: resp, err: err}, ttl)
+	}
+	return resp, err
+}
+
+// rejected returns whether err is the auth service rejecting the access key,
+// rather than failing to look it up.
+func rejected(err error) bool {
+	switch status := GetStatus(err, 0); status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, httpStatusClientClosedRequest:
+		return false
+	default:
+		return status >= 400 && status < 500
+	}
+}
+
+// lookup returns the unexpired entry for accessKeyID.
+func (cache *AuthServiceCache) lookup(accessKeyID string) (*authCacheEntry, bool) {
+	val, ok := cache.cache.get(accessKeyID)
+	if !ok {
+		return nil, false
+	}
+	return val.(*authCacheEntry), true
+}
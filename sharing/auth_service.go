@@ -21,6 +21,14 @@ type AuthServiceConfig struct {
 
 	// Authorization token used for the auth service to resolve access key ids.
 	Token string
+
+	// RetryStatusCodes are the response status codes considered transient,
+	// which are retried with backoff. Only 5xx codes are honored, as 4xx
+	// codes mean the access key itself is bad.
+	RetryStatusCodes []int
+
+	// Cache, when set, caches resolved access key ids.
+	Cache *AuthServiceCache
 }
 
 // AuthServiceResponse is the struct representing the response from the auth service.
@@ -36,6 +44,15 @@ var AuthServiceError = errs.Class("auth service")
 func (a AuthServiceConfig) Resolve(ctx context.Context, accessKeyID string) (_ *AuthServiceResponse, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	if a.Cache != nil {
+		return a.Cache.resolve(ctx, accessKeyID, a.resolve)
+	}
+	return a.resolve(ctx, accessKeyID)
+}
+
+func (a AuthServiceConfig) resolve(ctx context.Context, accessKeyID string) (_ *AuthServiceResponse, err error) {
+	defer mon.Task()(&ctx)(&err)
+
 	reqURL, err := url.Parse(a.BaseURL)
 	if err != nil {
 		return nil, WithStatus(AuthServiceError.Wrap(err),
@@ -73,6 +90,12 @@ func (a AuthServiceConfig) Resolve(ctx context.Context, accessKeyID string) (_ *
 			defer func() { _ = resp.Body.Close() }()
 
 			if resp.StatusCode != http.StatusOK {
+				if a.retryable(resp.StatusCode) && !delay.Maxed() {
+					if err := delay.Wait(ctx); err != nil {
+						return false, nil, WithStatus(AuthServiceError.Wrap(err), httpStatusClientClosedRequest)
+					}
+					return true, nil, nil
+				}
 				return false, nil, WithStatus(
 					AuthServiceError.New("invalid status code: %d", resp.StatusCode),
 					resp.StatusCode)
@@ -100,3 +123,17 @@ func (a AuthServiceConfig) Resolve(ctx context.Context, accessKeyID string) (_ *
 		return authResp, err
 	}
 }
+
+// retryable returns whether a response with the given status code should be
+// retried.
+func (a AuthServiceConfig) retryable(statusCode int) bool {
+	if statusCode < 500 {
+		return false
+	}
+	for _, code := range a.RetryStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+)
+
+func TestResolveRetries(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	for _, test := range []struct {
+		name     string
+		failures []int
+		attempts int32
+		status   int
+	}{
+		{name: "success", attempts: 1},
+		{name: "transient failures", failures: []int{503, 502}, attempts: 3},
+		{name: "not found", failures: []int{404}, attempts: 1, status: http.StatusNotFound},
+		{name: "unauthorized", failures: []int{401}, attempts: 1, status: http.StatusUnauthorized},
+		{name: "unconfigured 5xx", failures: []int{500}, attempts: 1, status: http.StatusInternalServerError},
+	} {
+		var attempts int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempt := atomic.AddInt32(&attempts, 1)
+			if int(attempt) <= len(test.failures) {
+				w.WriteHeader(test.failures[attempt-1])
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_grant": "grant", "public": true}`))
+		}))
+
+		resp, err := AuthServiceConfig{
+			BaseURL:          server.URL,
+			RetryStatusCodes: []int{502, 503, 504},
+		}.Resolve(ctx, "accesskey")
+		server.Close()
+
+		require.Equal(t, test.attempts, attempts, test.name)
+		if test.status != 0 {
+			require.Error(t, err, test.name)
+			require.Equal(t, test.status, GetStatus(err, 0), test.name)
+			continue
+		}
+		require.NoError(t, err, test.name)
+		require.Equal(t, "grant", resp.AccessGrant, test.name)
+	}
+}
+
+func TestResolveCache(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch path.Base(r.URL.Path) {
+		case "public":
+			_, _ = w.Write([]byte(`{"access_grant": "grant", "public": true}`))
+		case "private":
+			_, _ = w.Write([]byte(`{"access_grant": "grant", "public": false}`))
+		case "unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := AuthServiceConfig{
+		BaseURL: server.URL,
+		Cache:   NewAuthServiceCache(100, time.Hour, time.Nanosecond),
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := config.Resolve(ctx, "public")
+		require.NoError(t, err)
+		require.Equal(t, "grant", resp.AccessGrant)
+	}
+	require.EqualValues(t, 1, atomic.LoadInt32(&requests))
+
+	// negative results expire much sooner.
+	for _, key := range []string{"private", "missing"} {
+		atomic.StoreInt32(&requests, 0)
+		_, _ = config.Resolve(ctx, key)
+		time.Sleep(time.Millisecond)
+		_, err := config.Resolve(ctx, key)
+		require.EqualValues(t, 2, atomic.LoadInt32(&requests), key)
+		if key == "missing" {
+			require.Equal(t, http.StatusNotFound, GetStatus(err, 0))
+		}
+	}
+
+	config.Cache = NewAuthServiceCache(100, time.Hour, time.Hour)
+	atomic.StoreInt32(&requests, 0)
+	for i := 0; i < 3; i++ {
+		_, err := config.Resolve(ctx, "missing")
+		require.Equal(t, http.StatusNotFound, GetStatus(err, 0))
+	}
+	require.EqualValues(t, 1, atomic.LoadInt32(&requests))
+
+	// transient failures aren't cached, so later requests retry them.
+	atomic.StoreInt32(&requests, 0)
+	for i := 0; i < 3; i++ {
+		_, err := config.Resolve(ctx, "unavailable")
+		require.Equal(t, http.StatusServiceUnavailable, GetStatus(err, 0))
+	}
+	require.EqualValues(t, 3, atomic.LoadInt32(&requests))
+
+	// bogus keys can't grow the cache past its size.
+	config.Cache = NewAuthServiceCache(2, time.Hour, time.Hour)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		_, _ = config.Resolve(ctx, key)
+	}
+	require.Equal(t, 2, config.Cache.cache.order.Len())
+}
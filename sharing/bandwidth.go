@@ -0,0 +1,215 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bandwidthLimitScopes are what responses share a bandwidth limit: "access"
+// shares one between all responses for the same shared access or hosted
+// site, and "request" gives each request its own.
+var bandwidthLimitScopes = map[string]bool{"access": true, "request": true}
+
+// bandwidthSweepInterval is how often buckets nobody has used in a while
+// are dropped.
+const bandwidthSweepInterval = time.Minute
+
+// bandwidthLimiter throttles responses with a token bucket per share, or
+// per request.
+type bandwidthLimiter struct {
+	rate       float64
+	burst      float64
+	perRequest bool
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func newBandwidthLimiter(rate, burst int64, scope string) *bandwidthLimiter {
+	return &bandwidthLimiter{
+		rate:       float64(rate),
+		burst:      float64(burst),
+		perRequest: scope == "request",
+		buckets:    map[string]*tokenBucket{},
+		swept:      time.Now(),
+	}
+}
+
+// acquire returns the bucket responses for key take their bytes from. The
+// returned func must be called once the response is written.
+func (limiter *bandwidthLimiter) acquire(key string) (_ *tokenBucket, release func()) {
+	now := time.Now()
+	if limiter.perRequest || key == "" {
+		return newTokenBucket(limiter.rate, limiter.burst, now), func() {}
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if now.Sub(limiter.swept) >= bandwidthSweepInterval {
+		limiter.swept = now
+		for key, bucket := range limiter.buckets {
+			// a full bucket is no different from a new one.
+			if bucket.users == 0 && bucket.full(now) {
+				delete(limiter.buckets, key)
+			}
+		}
+	}
+
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(limiter.rate, limiter.burst, now)
+		limiter.buckets[key] = bucket
+	}
+	bucket.users++
+	return bucket, func() {
+		limiter.mu.Lock()
+		bucket.users--
+		limiter.mu.Unlock()
+	}
+}
+
+// tokenBucket lets through rate bytes per second, and up to burst bytes at
+// once after a pause. Taking more than there is puts the bucket in debt,
+// which later takers wait out too.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	// users is guarded by the limiter's mutex.
+	users int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// take takes n bytes at now, and returns how long to wait before sending
+// them.
+func (bucket *tokenBucket) take(n int, now time.Time) time.Duration {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	bucket.refill(now)
+	bucket.tokens -= float64(n)
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+func (bucket *tokenBucket) full(now time.Time) bool {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	bucket.refill(now)
+	return bucket.tokens >= bucket.burst
+}
+
+func (bucket *tokenBucket) refill(now time.Time) {
+	if !now.After(bucket.last) {
+		return
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+	bucket.last = now
+}
+
+// meteredWriter counts the bytes written to the response and, with a
+// bandwidth limit, throttles writing them. Only the pace of writes changes,
+// so range requests work as they would without it.
+type meteredWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	bucket *tokenBucket
+
+	bytes     int64
+	throttled time.Duration
+}
+
+// meterShare wraps w to account for the bytes served for the shared access
+// or hosted site with key, and to apply its bandwidth limit. Every response
+// for a share goes through it, whether it serves an object, an archive, a
+// view or a transformation. The returned func records them in the metrics
+// and must be called once the response is written.
+func (handler *Handler) meterShare(w http.ResponseWriter, r *http.Request, key string) (_ http.ResponseWriter, done func()) {
+	if handler.bandwidthLimiter == nil && handler.metrics == nil {
+		return w, func() {}
+	}
+
+	metered := &meteredWriter{ResponseWriter: w, ctx: r.Context()}
+	release := func() {}
+	if handler.bandwidthLimiter != nil {
+		metered.bucket, release = handler.bandwidthLimiter.acquire(key)
+	}
+	service := handler.serviceLabel(r)
+	return metered, func() {
+		release()
+		if handler.metrics != nil {
+			handler.metrics.observeShare(service, metered.bytes, metered.throttled)
+		}
+	}
+}
+
+func (w *meteredWriter) Write(p []byte) (written int, err error) {
+	if w.bucket == nil {
+		written, err = w.ResponseWriter.Write(p)
+		w.bytes += int64(written)
+		return written, err
+	}
+
+	for len(p) > 0 {
+		// writing no more than a burst at a time keeps the pace even.
+		chunk := p
+		if float64(len(chunk)) > w.bucket.burst {
+			chunk = chunk[:int(w.bucket.burst)]
+		}
+		if err := w.wait(w.bucket.take(len(chunk), time.Now())); err != nil {
+			return written, err
+		}
+
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		w.bytes += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// wait waits for d, or until the request is gone.
+func (w *meteredWriter) wait(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	w.throttled += d
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// Flush implements http.Flusher when the wrapped writer does.
+func (w *meteredWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
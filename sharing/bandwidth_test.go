@@ -0,0 +1,131 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/ranger"
+	"storj.io/common/ranger/httpranger"
+	"storj.io/common/testcontext"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	bucket := newTokenBucket(100, 50, start)
+
+	// a full bucket lets a burst through at once.
+	require.Equal(t, time.Duration(0), bucket.take(50, start))
+	// then bytes go out at the rate.
+	require.Equal(t, 500*time.Millisecond, bucket.take(50, start))
+	// and the debt is waited out by later takers too.
+	require.Equal(t, time.Second, bucket.take(50, start))
+
+	// a pause refills it, up to the burst.
+	require.False(t, bucket.full(start.Add(time.Second)))
+	require.True(t, bucket.full(start.Add(time.Hour)))
+	require.Equal(t, time.Duration(0), bucket.take(50, start.Add(time.Hour)))
+	require.Equal(t, 10*time.Millisecond, bucket.take(1, start.Add(time.Hour)))
+}
+
+func TestBandwidthLimiterScope(t *testing.T) {
+	limiter := newBandwidthLimiter(100, 100, "access")
+	a, releaseA := limiter.acquire("access:a")
+	again, releaseAgain := limiter.acquire("access:a")
+	b, releaseB := limiter.acquire("access:b")
+	require.True(t, a == again)
+	require.False(t, a == b)
+
+	// buckets in use are kept, and idle ones dropped.
+	releaseA()
+	releaseAgain()
+	limiter.swept = time.Now().Add(-bandwidthSweepInterval)
+	_, releaseC := limiter.acquire("access:c")
+	require.NotContains(t, limiter.buckets, "access:a")
+	require.Contains(t, limiter.buckets, "access:b")
+	releaseB()
+	releaseC()
+
+	limiter = newBandwidthLimiter(100, 100, "request")
+	a, _ = limiter.acquire("access:a")
+	again, _ = limiter.acquire("access:a")
+	require.False(t, a == again)
+}
+
+func TestMeterShare(t *testing.T) {
+	ctx := testcontext.New(t)
+	metrics := NewMetrics()
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:       []string{"http://test.test"},
+		Templates:      "../web",
+		Metrics:        metrics,
+		BandwidthLimit: 1000,
+		BandwidthBurst: 100,
+	})
+	require.NoError(t, err)
+
+	data := make([]byte, 300)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	r := httptest.NewRequest("GET", "http://test.test/s/access/bucket/key", nil).WithContext(ctx)
+	r.Header.Set("Range", "bytes=100-299")
+
+	// range requests are served as they are, only paced.
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	w, done := handler.meterShare(rec, r, "access:a")
+	httpranger.ServeContent(ctx, w, r, "key", time.Time{}, ranger.ByteRanger(data))
+	done()
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, "bytes 100-299/300", rec.Header().Get("Content-Range"))
+	require.Equal(t, data[100:], rec.Body.Bytes())
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(90*time.Millisecond))
+
+	require.Equal(t, int64(200), metrics.shareBytes["standard"])
+	require.Greater(t, metrics.throttled["standard"], int64(0))
+
+	// so is anything else written for the share, like archives, and the
+	// limit is shared with the earlier response.
+	start = time.Now()
+	rec = httptest.NewRecorder()
+	w, done = handler.meterShare(rec, r, "access:a")
+	n, err := w.Write(data)
+	done()
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	require.Equal(t, data, rec.Body.Bytes())
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(250*time.Millisecond))
+	require.Equal(t, int64(500), metrics.shareBytes["standard"])
+
+	// throttled responses end with the request.
+	canceled, cancel := context.WithCancel(ctx)
+	w, done = handler.meterShare(httptest.NewRecorder(), r.WithContext(canceled), "access:a")
+	defer done()
+	cancel()
+	_, err = w.Write(data)
+	require.True(t, errors.Is(err, context.Canceled))
+
+	// without a limit or metrics, responses aren't wrapped.
+	rec = httptest.NewRecorder()
+	w, done = (&Handler{}).meterShare(rec, r, "access:a")
+	done()
+	require.Equal(t, rec, w)
+
+	_, err = NewHandler(zap.NewNop(), nil, Config{
+		URLBases:            []string{"http://test.test"},
+		Templates:           "../web",
+		BandwidthLimit:      1000,
+		BandwidthLimitScope: "bucket",
+	})
+	require.Error(t, err)
+}
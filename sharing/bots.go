@@ -0,0 +1,25 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isBot returns whether the request comes from a crawler or link preview
+// bot, by matching its User-Agent against the configured patterns as case
+// insensitive substrings.
+func (handler *Handler) isBot(r *http.Request) bool {
+	userAgent := strings.ToLower(r.UserAgent())
+	if userAgent == "" {
+		return false
+	}
+	for _, pattern := range handler.botUserAgents {
+		if strings.Contains(userAgent, pattern) {
+			return true
+		}
+	}
+	return false
+}
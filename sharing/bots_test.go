@@ -0,0 +1,60 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
+)
+
+const googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+func TestIsBot(t *testing.T) {
+	handler := &Handler{botUserAgents: []string{"bot", "facebookexternalhit"}}
+
+	for userAgent, bot := range map[string]bool{
+		googlebot: true,
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)":    true,
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 Chrome/91.0 Safari/537.36": false,
+		"": false,
+	} {
+		r := httptest.NewRequest("GET", "http://test.test/", nil)
+		r.Header.Set("User-Agent", userAgent)
+		assert.Equal(t, bot, handler.isBot(r), userAgent)
+	}
+}
+
+func TestBotSkipsLocations(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:      []string{"http://test.test"},
+		Templates:     "../web",
+		BotUserAgents: []string{"Googlebot"},
+	})
+	require.NoError(t, err)
+
+	ctx := testcontext.New(t)
+	object := &uplink.Object{Key: "photo.jpg"}
+
+	// a piece lookup would need a real access, so this only succeeds if the
+	// lookup is skipped.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://test.test/?format=json", nil)
+	r.Header.Set("User-Agent", googlebot)
+	require.NoError(t, handler.showObject(ctx, w, r, &parsedRequest{}, &uplink.Project{}, object))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var preview objectPreview
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &preview))
+	require.Empty(t, preview.Locations)
+	require.False(t, preview.LocationsAvailable)
+}
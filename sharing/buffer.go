@@ -0,0 +1,56 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// downloadBuffers pools the buffers downloads are copied to responses with,
+// when their size is configured.
+type downloadBuffers struct {
+	size int
+	pool sync.Pool
+}
+
+func newDownloadBuffers(size int) *downloadBuffers {
+	buffers := &downloadBuffers{size: size}
+	buffers.pool.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return buffers
+}
+
+// wrap returns w copying anything read into it through one of the pooled
+// buffers, rather than the default 32 KiB one.
+func (buffers *downloadBuffers) wrap(w http.ResponseWriter) http.ResponseWriter {
+	if buffers == nil {
+		return w
+	}
+	return &bufferedResponseWriter{ResponseWriter: w, buffers: buffers}
+}
+
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	buffers *downloadBuffers
+}
+
+// ReadFrom implements io.ReaderFrom, which io.Copy prefers.
+func (w *bufferedResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	buf := w.buffers.pool.Get().(*[]byte)
+	defer w.buffers.pool.Put(buf)
+
+	// hide the interfaces io.CopyBuffer would otherwise use over the buffer.
+	return io.CopyBuffer(struct{ io.Writer }{w.ResponseWriter}, struct{ io.Reader }{src}, *buf)
+}
+
+// Flush implements http.Flusher when the wrapped writer does.
+func (w *bufferedResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
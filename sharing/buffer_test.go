@@ -0,0 +1,46 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readSizeRecorder records the largest read asked of it.
+type readSizeRecorder struct {
+	io.Reader
+	largest int
+}
+
+func (r *readSizeRecorder) Read(p []byte) (int, error) {
+	if len(p) > r.largest {
+		r.largest = len(p)
+	}
+	return r.Reader.Read(p)
+}
+
+func TestDownloadBuffers(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 100000)
+
+	for _, size := range []int{4 << 10, 256 << 10} {
+		rec := httptest.NewRecorder()
+		src := &readSizeRecorder{Reader: bytes.NewReader(body)}
+
+		// ServeContent copies with io.CopyN, which hides what src implements.
+		n, err := io.CopyN(newDownloadBuffers(size).wrap(rec), src, int64(len(body)))
+		require.NoError(t, err)
+		require.EqualValues(t, len(body), n)
+		require.Equal(t, body, rec.Body.Bytes())
+		require.Equal(t, size, src.largest)
+	}
+
+	var buffers *downloadBuffers
+	rec := httptest.NewRecorder()
+	require.Same(t, rec, buffers.wrap(rec))
+}
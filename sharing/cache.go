@@ -0,0 +1,183 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"storj.io/common/ranger"
+	"storj.io/uplink"
+)
+
+// BodyCache stores the bodies of small objects so popular ones don't have to
+// be fetched from the network for every request. Implementations must be
+// safe for concurrent use. Entries are immutable: a changed object is
+// stored under a new key.
+type BodyCache interface {
+	// Get returns the body stored under key, if there is one.
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Set stores body under key for at most ttl.
+	Set(ctx context.Context, key string, body []byte, ttl time.Duration)
+}
+
+// MemoryBodyCache is an in-memory, least recently used BodyCache holding up
+// to a maximum number of bytes.
+type MemoryBodyCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key     string
+	body    []byte
+	expires time.Time
+}
+
+// NewMemoryBodyCache returns a MemoryBodyCache holding up to maxBytes.
+func NewMemoryBodyCache(maxBytes int64) *MemoryBodyCache {
+	return &MemoryBodyCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Get implements BodyCache.
+func (cache *MemoryBodyCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	elem, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expires) {
+		cache.remove(elem)
+		return nil, false
+	}
+	cache.order.MoveToFront(elem)
+	return entry.body, true
+}
+
+// Set implements BodyCache.
+func (cache *MemoryBodyCache) Set(ctx context.Context, key string, body []byte, ttl time.Duration) {
+	if int64(len(body)) > cache.maxBytes {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if elem, ok := cache.entries[key]; ok {
+		cache.remove(elem)
+	}
+	for cache.used+int64(len(body)) > cache.maxBytes {
+		cache.remove(cache.order.Back())
+	}
+
+	cache.entries[key] = cache.order.PushFront(&memoryCacheEntry{
+		key:     key,
+		body:    body,
+		expires: time.Now().Add(ttl),
+	})
+	cache.used += int64(len(body))
+}
+
+func (cache *MemoryBodyCache) remove(elem *list.Element) {
+	entry := cache.order.Remove(elem).(*memoryCacheEntry)
+	delete(cache.entries, entry.key)
+	cache.used -= int64(len(entry.body))
+}
+
+// bodyCacheKey identifies the contents of o as seen through access. The
+// access is part of it since bucket names are only unique per project, and
+// the object's creation time changes whenever it is overwritten.
+func bodyCacheKey(access *uplink.Access, bucket string, o *uplink.Object) (string, error) {
+	serialized, err := access.Serialize()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(serialized))
+	return fmt.Sprintf("%s/%s/%s@%d-%d", hex.EncodeToString(sum[:16]), bucket, o.Key,
+		o.System.Created.UnixNano(), o.System.ContentLength), nil
+}
+
+// objectRanger returns the ranger to serve o with from rr, which downloads
+// it, going through the body cache for objects small enough to be cached,
+// and otherwise coalescing the small range requests of a connection when
+// enabled.
+func (handler *Handler) objectRanger(r *http.Request, pr *parsedRequest, o *uplink.Object, rr ranger.Ranger) ranger.Ranger {
+	if o.System.ContentLength <= 0 {
+		return rr
+	}
+	cacheable := handler.bodyCache != nil && o.System.ContentLength <= handler.bodyCacheMaxObjectSize
+	if !cacheable && handler.rangeCoalescer == nil {
+		return rr
+	}
+	key, err := bodyCacheKey(pr.access, pr.bucket, o)
+	if err != nil {
+		return rr
+	}
+	if cacheable {
+		caching := &cachingRanger{handler: handler, key: key, object: rr}
+		if handler.staleIfError > 0 {
+			caching.staleKey, _ = staleBodyCacheKey(pr.access, pr.bucket, o.Key)
+		}
+		return caching
+	}
+	return &coalescingRanger{coalescer: handler.rangeCoalescer, key: handler.clientConnection(r) + " " + key, object: rr}
+}
+
+// cachingRanger serves ranges out of the body cache, filling it with the
+// whole object on a miss. With a staleKey, the body is also kept under it
+// past the TTL, for serving when the object can't be looked up.
+type cachingRanger struct {
+	handler  *Handler
+	key      string
+	staleKey string
+	object   ranger.Ranger
+}
+
+func (rr *cachingRanger) Size() int64 { return rr.object.Size() }
+
+func (rr *cachingRanger) Range(ctx context.Context, offset, length int64) (_ io.ReadCloser, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if body, ok := rr.handler.bodyCache.Get(ctx, rr.key); ok {
+		mon.Event("body_cache_hit")
+		return ranger.ByteRanger(body).Range(ctx, offset, length)
+	}
+	mon.Event("body_cache_miss")
+
+	download, err := rr.object.Range(ctx, 0, rr.object.Size())
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(download)
+	if closeErr := download.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rr.handler.bodyCache.Set(ctx, rr.key, body, rr.handler.bodyCacheTTL)
+	if rr.staleKey != "" {
+		rr.handler.bodyCache.Set(ctx, rr.staleKey, body, rr.handler.bodyCacheTTL+rr.handler.staleIfError)
+	}
+	return ranger.ByteRanger(body).Range(ctx, offset, length)
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/ranger"
+	"storj.io/common/testcontext"
+)
+
+func TestMemoryBodyCache(t *testing.T) {
+	ctx := testcontext.New(t)
+	cache := NewMemoryBodyCache(10)
+
+	cache.Set(ctx, "a", []byte("aaaa"), time.Hour)
+	cache.Set(ctx, "b", []byte("bbbb"), time.Hour)
+
+	body, ok := cache.Get(ctx, "a")
+	require.True(t, ok)
+	require.Equal(t, "aaaa", string(body))
+
+	// b is now the least recently used, so it makes room for c.
+	cache.Set(ctx, "c", []byte("cccc"), time.Hour)
+	_, ok = cache.Get(ctx, "b")
+	require.False(t, ok)
+	_, ok = cache.Get(ctx, "a")
+	require.True(t, ok)
+
+	// too large to ever fit.
+	cache.Set(ctx, "huge", make([]byte, 11), time.Hour)
+	_, ok = cache.Get(ctx, "huge")
+	require.False(t, ok)
+
+	cache.Set(ctx, "expired", []byte("x"), -time.Second)
+	_, ok = cache.Get(ctx, "expired")
+	require.False(t, ok)
+	require.LessOrEqual(t, cache.used, cache.maxBytes)
+}
+
+type countingRanger struct {
+	ranger.Ranger
+	calls int
+}
+
+func (rr *countingRanger) Range(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	rr.calls++
+	return rr.Ranger.Range(ctx, offset, length)
+}
+
+func TestCachingRanger(t *testing.T) {
+	ctx := testcontext.New(t)
+	handler := &Handler{bodyCache: NewMemoryBodyCache(100), bodyCacheTTL: time.Hour}
+	object := &countingRanger{Ranger: ranger.ByteRanger("hello world")}
+	rr := &cachingRanger{handler: handler, key: "key", object: object}
+
+	for i := 0; i < 3; i++ {
+		reader, err := rr.Range(ctx, 6, 5)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, "world", string(data))
+	}
+	require.Equal(t, 1, object.calls)
+}
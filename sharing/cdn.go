@@ -0,0 +1,64 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// defaultCacheKeyParts identify one revision of an object. Overwriting an
+// object changes its creation time, so CDNs keying on them never serve a
+// replaced object's bytes.
+var defaultCacheKeyParts = []string{"bucket", "key", "created", "size"}
+
+// cacheKeyParts return the value of each part a cache key can be built from.
+var cacheKeyParts = map[string]func(pr *parsedRequest, o *uplink.Object) string{
+	"bucket": func(pr *parsedRequest, o *uplink.Object) string { return pr.bucket },
+	"key":    func(pr *parsedRequest, o *uplink.Object) string { return o.Key },
+	"created": func(pr *parsedRequest, o *uplink.Object) string {
+		return strconv.FormatInt(o.System.Created.UnixNano(), 10)
+	},
+	"size": func(pr *parsedRequest, o *uplink.Object) string {
+		return strconv.FormatInt(o.System.ContentLength, 10)
+	},
+}
+
+// parseCacheKeyParts validates the configured cache key parts.
+func parseCacheKeyParts(parts []string) ([]string, error) {
+	if len(parts) == 0 {
+		return defaultCacheKeyParts, nil
+	}
+	parsed := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if _, ok := cacheKeyParts[part]; !ok {
+			return nil, errs.New("unknown cache key part %q", part)
+		}
+		parsed = append(parsed, part)
+	}
+	return parsed, nil
+}
+
+// setCacheKeyHeader sets the configured CDN cache key header for o, if any.
+// The parts are hashed so the value is always a valid header value no matter
+// what the object key contains.
+func (handler *Handler) setCacheKeyHeader(w http.ResponseWriter, pr *parsedRequest, o *uplink.Object) {
+	if handler.cacheKeyHeader == "" {
+		return
+	}
+	h := sha256.New()
+	for _, part := range handler.cacheKeyParts {
+		value := cacheKeyParts[part](pr, o)
+		_, _ = h.Write([]byte(strconv.Itoa(len(value)) + ":" + value))
+	}
+	w.Header().Set(handler.cacheKeyHeader, hex.EncodeToString(h.Sum(nil)[:16]))
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/uplink"
+)
+
+func TestParseCacheKeyParts(t *testing.T) {
+	parts, err := parseCacheKeyParts(nil)
+	require.NoError(t, err)
+	require.Equal(t, defaultCacheKeyParts, parts)
+
+	parts, err = parseCacheKeyParts([]string{" Key", "created"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"key", "created"}, parts)
+
+	_, err = parseCacheKeyParts([]string{"etag"})
+	require.Error(t, err)
+}
+
+func TestSetCacheKeyHeader(t *testing.T) {
+	pr := &parsedRequest{bucket: "bucket"}
+	object := func(created time.Time) *uplink.Object {
+		o := &uplink.Object{Key: "index.html"}
+		o.System.Created = created
+		o.System.ContentLength = 10
+		return o
+	}
+	cacheKey := func(handler *Handler, o *uplink.Object) string {
+		w := httptest.NewRecorder()
+		handler.setCacheKeyHeader(w, pr, o)
+		return w.Header().Get("X-Cache-Key")
+	}
+
+	disabled := &Handler{cacheKeyParts: defaultCacheKeyParts}
+	require.Empty(t, cacheKey(disabled, object(time.Unix(1, 0))))
+
+	handler := &Handler{cacheKeyHeader: "X-Cache-Key", cacheKeyParts: defaultCacheKeyParts}
+	first := cacheKey(handler, object(time.Unix(1, 0)))
+	require.Len(t, first, 32)
+	require.Equal(t, first, cacheKey(handler, object(time.Unix(1, 0))))
+	require.NotEqual(t, first, cacheKey(handler, object(time.Unix(2, 0))))
+
+	keyOnly := &Handler{cacheKeyHeader: "X-Cache-Key", cacheKeyParts: []string{"key"}}
+	require.Equal(t, cacheKey(keyOnly, object(time.Unix(1, 0))), cacheKey(keyOnly, object(time.Unix(2, 0))))
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/zeebo/errs"
+)
+
+// parseCIDRs parses a list of CIDRs. Bare IPs are taken as single hosts.
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, errs.New("invalid CIDR %q: %v", value, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// clientIP returns the IP of the client that made r. Requests from trusted
+// proxies are attributed to the rightmost untrusted address in their
+// X-Forwarded-For chain, since only the entries our proxies appended can be
+// trusted and everything to their left may be spoofed.
+func (handler *Handler) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !handler.trustedProxy(ip) {
+		return ip
+	}
+
+	var chain []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		chain = append(chain, strings.Split(header, ",")...)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(chain[i]))
+		if hop == nil {
+			// a malformed entry can't be walked past.
+			return ip
+		}
+		ip = hop
+		if !handler.trustedProxy(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// clientConnection identifies the connection r came in on. Trusted proxies
+// share their connections between the clients behind them, so behind those
+// it identifies the client on the connection.
+func (handler *Handler) clientConnection(r *http.Request) string {
+	if ip := handler.clientIP(r); ip != nil {
+		return r.RemoteAddr + " " + ip.String()
+	}
+	return r.RemoteAddr
+}
+
+func (handler *Handler) trustedProxy(ip net.IP) bool {
+	for _, ipNet := range handler.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientCountry returns the ISO code of the country the client that made r
+// geolocates to, or "" when it can't be resolved.
+func (handler *Handler) clientCountry(ctx context.Context, r *http.Request) string {
+	if !handler.mapper.Available() {
+		return ""
+	}
+	ip := handler.clientIP(r)
+	if ip == nil {
+		return ""
+	}
+	info, err := handler.mapper.GetIPInfos(ctx, ip.String())
+	if err != nil {
+		return ""
+	}
+	return info.Country.IsoCode
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/linksharing/objectmap"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	require.NoError(t, err)
+	handler := &Handler{trustedProxies: trusted}
+
+	for _, test := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:1234", expected: "203.0.113.7"},
+		{name: "untrusted peer can't forward", remoteAddr: "203.0.113.7:1234", forwarded: []string{"198.51.100.1"}, expected: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:1234", forwarded: []string{"198.51.100.1"}, expected: "198.51.100.1"},
+		{name: "proxy chain", remoteAddr: "10.1.2.3:1234", forwarded: []string{"198.51.100.1, 192.168.1.1, 10.9.9.9"}, expected: "198.51.100.1"},
+		{name: "spoofed entry", remoteAddr: "10.1.2.3:1234", forwarded: []string{"1.1.1.1, 198.51.100.1"}, expected: "198.51.100.1"},
+		{name: "multiple headers", remoteAddr: "10.1.2.3:1234", forwarded: []string{"1.1.1.1", "198.51.100.1, 10.0.0.1"}, expected: "198.51.100.1"},
+		{name: "malformed entry", remoteAddr: "10.1.2.3:1234", forwarded: []string{"198.51.100.1, garbage"}, expected: "10.1.2.3"},
+		{name: "all trusted", remoteAddr: "10.1.2.3:1234", forwarded: []string{"10.0.0.2, 10.0.0.1"}, expected: "10.0.0.2"},
+		{name: "ipv6", remoteAddr: "[fd00::1]:1234", forwarded: []string{"2001:db8::1"}, expected: "2001:db8::1"},
+	} {
+		r := httptest.NewRequest("GET", "http://test.test/", nil)
+		r.RemoteAddr = test.remoteAddr
+		for _, value := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		assert.Equal(t, test.expected, handler.clientIP(r).String(), test.name)
+	}
+
+	_, err = parseCIDRs([]string{"not a cidr"})
+	require.Error(t, err)
+}
+
+func TestClientConnection(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	handler := &Handler{trustedProxies: trusted}
+
+	connection := func(remoteAddr, forwarded string) string {
+		r := httptest.NewRequest("GET", "http://test.test/", nil)
+		r.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return handler.clientConnection(r)
+	}
+
+	// clients sharing a proxy's connection are told apart.
+	require.NotEqual(t, connection("10.1.2.3:1234", "198.51.100.1"), connection("10.1.2.3:1234", "198.51.100.2"))
+	require.Equal(t, connection("10.1.2.3:1234", "198.51.100.1"), connection("10.1.2.3:1234", "198.51.100.1"))
+	// direct clients can't pretend to be others.
+	require.Equal(t, connection("203.0.113.7:1234", "198.51.100.1"), connection("203.0.113.7:1234", "198.51.100.2"))
+	require.NotEqual(t, connection("203.0.113.7:1234", ""), connection("203.0.113.7:1235", ""))
+}
+
+// countryReader geolocates 198.51.100.0/24 to Germany and nothing else.
+type countryReader struct{}
+
+func (countryReader) Lookup(ip net.IP, result interface{}) error {
+	if !(&net.IPNet{IP: net.IPv4(198, 51, 100, 0), Mask: net.CIDRMask(24, 32)}).Contains(ip) {
+		return errors.New("not found")
+	}
+	result.(*objectmap.IPInfo).Country.IsoCode = "DE"
+	return nil
+}
+
+func (countryReader) Close() error { return nil }
+
+func TestClientCountry(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	handler := &Handler{
+		mapper:         objectmap.NewIPDB(countryReader{}),
+		trustedProxies: trusted,
+	}
+
+	for _, test := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{name: "direct", remoteAddr: "198.51.100.7:1234", expected: "DE"},
+		{name: "unknown", remoteAddr: "203.0.113.7:1234", expected: ""},
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:1234", forwarded: "198.51.100.7", expected: "DE"},
+		{name: "untrusted peer can't forward", remoteAddr: "203.0.113.7:1234", forwarded: "198.51.100.7", expected: ""},
+	} {
+		r := httptest.NewRequest("GET", "http://test.test/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		assert.Equal(t, test.expected, handler.clientCountry(ctx, r), test.name)
+	}
+
+	require.Empty(t, (&Handler{}).clientCountry(ctx, httptest.NewRequest("GET", "http://test.test/", nil)))
+}
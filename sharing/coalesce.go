@@ -0,0 +1,124 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"storj.io/common/ranger"
+)
+
+// rangeCoalescer remembers, per connection and object, the last block read
+// to serve a small range request. Clients like media players often request
+// many tiny adjacent ranges in a row, which can then be served from one
+// larger read instead of a download each.
+type rangeCoalescer struct {
+	window time.Duration
+	size   int64
+
+	mu     sync.Mutex
+	blocks map[string]*coalescedBlock
+}
+
+type coalescedBlock struct {
+	offset  int64
+	body    []byte
+	expires time.Time
+}
+
+func newRangeCoalescer(window time.Duration, size int64) *rangeCoalescer {
+	return &rangeCoalescer{
+		window: window,
+		size:   size,
+		blocks: map[string]*coalescedBlock{},
+	}
+}
+
+// get returns the bytes at [offset, offset+length) if a block read within
+// the window holds all of them.
+func (c *rangeCoalescer) get(key string, offset, length int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	block, ok := c.blocks[key]
+	if !ok || time.Now().After(block.expires) {
+		return nil, false
+	}
+	if offset < block.offset || offset+length > block.offset+int64(len(block.body)) {
+		return nil, false
+	}
+	start := offset - block.offset
+	return block.body[start : start+length], true
+}
+
+// put remembers a block read for key, replacing any earlier one, and drops
+// the blocks whose window has passed.
+func (c *rangeCoalescer) put(key string, offset int64, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, block := range c.blocks {
+		if now.After(block.expires) {
+			delete(c.blocks, k)
+		}
+	}
+	c.blocks[key] = &coalescedBlock{offset: offset, body: body, expires: now.Add(c.window)}
+}
+
+// coalescingRanger serves ranges smaller than the coalescing size out of
+// blocks of that size, read once and shared by the following requests on the
+// same connection. Larger ranges are read as requested.
+type coalescingRanger struct {
+	coalescer *rangeCoalescer
+	key       string
+	object    ranger.Ranger
+}
+
+func (rr *coalescingRanger) Size() int64 { return rr.object.Size() }
+
+func (rr *coalescingRanger) Range(ctx context.Context, offset, length int64) (_ io.ReadCloser, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if length >= rr.coalescer.size {
+		return rr.object.Range(ctx, offset, length)
+	}
+	if body, ok := rr.coalescer.get(rr.key, offset, length); ok {
+		mon.Event("range_coalesce_hit")
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	mon.Event("range_coalesce_miss")
+
+	blockLength := rr.coalescer.size
+	if remaining := rr.object.Size() - offset; blockLength > remaining {
+		blockLength = remaining
+	}
+	if blockLength < length {
+		// ranges past the end are the object ranger's to reject.
+		return rr.object.Range(ctx, offset, length)
+	}
+
+	download, err := rr.object.Range(ctx, offset, blockLength)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(download)
+	if closeErr := download.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) < length {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	rr.coalescer.put(rr.key, offset, body)
+	return ioutil.NopCloser(bytes.NewReader(body[:length])), nil
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/ranger"
+	"storj.io/common/testcontext"
+)
+
+func TestCoalescingRanger(t *testing.T) {
+	ctx := testcontext.New(t)
+	object := &countingRanger{Ranger: ranger.ByteRanger("0123456789abcdefghij")}
+	coalescer := newRangeCoalescer(time.Hour, 8)
+	rr := &coalescingRanger{coalescer: coalescer, key: "conn key", object: object}
+
+	read := func(offset, length int64) string {
+		reader, err := rr.Range(ctx, offset, length)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		return string(data)
+	}
+
+	// adjacent small ranges share one read of 8 bytes.
+	require.Equal(t, "01", read(0, 2))
+	require.Equal(t, "234", read(2, 3))
+	require.Equal(t, "567", read(5, 3))
+	require.Equal(t, 1, object.calls)
+
+	// a range past the block needs a new read, clamped to the object.
+	require.Equal(t, "89", read(8, 2))
+	require.Equal(t, 2, object.calls)
+	require.Equal(t, "ij", read(18, 2))
+	require.Equal(t, 3, object.calls)
+
+	// large ranges are read as requested.
+	require.Equal(t, "0123456789", read(0, 10))
+	require.Equal(t, 4, object.calls)
+
+	// other connections don't share blocks.
+	other := &coalescingRanger{coalescer: coalescer, key: "other key", object: object}
+	reader, err := other.Range(ctx, 18, 2)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, 5, object.calls)
+}
+
+func TestRangeCoalescerExpiry(t *testing.T) {
+	coalescer := newRangeCoalescer(-time.Second, 8)
+	coalescer.put("key", 0, []byte("01234567"))
+
+	_, ok := coalescer.get("key", 0, 2)
+	require.False(t, ok)
+
+	coalescer.put("other", 0, []byte("01234567"))
+	require.Len(t, coalescer.blocks, 1)
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinSize is the smallest response worth compressing, when its
+// length is known up front.
+const compressMinSize = 1024
+
+// compressibleTypes are the media types, besides text/*, that responses
+// are compressed for.
+var compressibleTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/xhtml+xml":  true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+}
+
+// compressible returns whether responses of contentType are worth
+// compressing.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// compressor is a gzip or deflate writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressors pool the writers for each coding responses are compressed
+// with, in order of preference. Deflate is the zlib format, as HTTP defines
+// it.
+var compressors = []struct {
+	coding string
+	pool   *sync.Pool
+}{
+	{coding: "gzip", pool: &sync.Pool{
+		New: func() interface{} { return gzip.NewWriter(nil) },
+	}},
+	{coding: "deflate", pool: &sync.Pool{
+		New: func() interface{} { return zlib.NewWriter(nil) },
+	}},
+}
+
+// compressResponse wraps w to compress compressible responses for clients
+// accepting gzip, or failing that, deflate. Whether a response is
+// compressed is decided once its headers are written. Responses that
+// already have a Content-Encoding, like objects stored encoded, and partial
+// responses are left as they are. The returned func finishes the compressed
+// stream and must be called once the response is written.
+func (handler *Handler) compressResponse(w http.ResponseWriter, r *http.Request) (_ http.ResponseWriter, done func()) {
+	if !handler.compressResponses {
+		return w, func() {}
+	}
+	cw := &compressWriter{
+		ResponseWriter: w,
+		r:              r,
+		coding:         -1,
+		head:           r.Method == http.MethodHead,
+	}
+	return cw, cw.close
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	r *http.Request
+	// coding indexes compressors, or is -1 when the client accepts none or
+	// the response isn't compressed.
+	coding int
+	head   bool
+
+	wroteHeader bool
+	cz          compressor
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	// informational responses, like 103 Early Hints, come before the real
+	// response and are passed along as they are.
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if w.eligible(status, h) {
+		// the response differs by Accept-Encoding whether or not this
+		// client gets it compressed.
+		acceptEncoding := requestHeader(w, w.r, "Accept-Encoding")
+		for i, compressor := range compressors {
+			if acceptsCoding(acceptEncoding, compressor.coding) {
+				w.coding = i
+				break
+			}
+		}
+		if w.coding >= 0 {
+			h.Set("Content-Encoding", compressors[w.coding].coding)
+			h.Del("Content-Length")
+			// compressed bytes are a different representation than the
+			// ones a strong ETag describes.
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+			if !w.head {
+				w.cz = compressors[w.coding].pool.Get().(compressor)
+				w.cz.Reset(w.ResponseWriter)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// eligible returns whether a response with status and headers h may be
+// compressed.
+func (w *compressWriter) eligible(status int, h http.Header) bool {
+	switch status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if length, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil && length < compressMinSize {
+		return false
+	}
+	return compressible(h.Get("Content-Type"))
+}
+
+// Write implements http.ResponseWriter.
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.cz != nil {
+		return w.cz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher when the wrapped writer does, sending what
+// has been compressed so far.
+func (w *compressWriter) Flush() {
+	if w.cz != nil {
+		_ = w.cz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) close() {
+	if w.cz == nil {
+		return
+	}
+	_ = w.cz.Close()
+	w.cz.Reset(nil)
+	compressors[w.coding].pool.Put(w.cz)
+	w.cz = nil
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressible(t *testing.T) {
+	for contentType, expected := range map[string]bool{
+		"text/html; charset=utf-8": true,
+		"text/plain":               true,
+		"application/json":         true,
+		"application/x-ndjson":     true,
+		"image/svg+xml":            true,
+		"application/ld+json":      true,
+		"image/png":                false,
+		"application/zip":          false,
+		"application/octet-stream": false,
+		"":                         false,
+	} {
+		assert.Equal(t, expected, compressible(contentType), contentType)
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	handler := &Handler{compressResponses: true}
+	body := strings.Repeat("<p>shared</p>\n", 200)
+
+	serve := func(method, acceptEncoding string, header http.Header, status int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "http://test.test/s/access/bucket/", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+
+		w, done := handler.compressResponse(rec, r)
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		_, err := w.Write([]byte(body))
+		require.NoError(t, err)
+		done()
+		return rec
+	}
+	html := func(extra ...string) http.Header {
+		h := http.Header{"Content-Type": {"text/html; charset=utf-8"}}
+		for i := 0; i+1 < len(extra); i += 2 {
+			h.Set(extra[i], extra[i+1])
+		}
+		return h
+	}
+
+	// clients accepting gzip get compressible responses compressed.
+	rec := serve("GET", "gzip, deflate", html("Content-Length", strconv.Itoa(len(body)), "ETag", `"abc"`), http.StatusOK)
+	require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	require.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+	require.Empty(t, rec.Header().Get("Content-Length"))
+	require.Equal(t, `W/"abc"`, rec.Header().Get("ETag"))
+	reader, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	decompressed, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, body, string(decompressed))
+
+	// clients accepting only deflate get that instead.
+	for _, acceptEncoding := range []string{"deflate", "gzip;q=0, deflate"} {
+		rec = serve("GET", acceptEncoding, html(), http.StatusOK)
+		require.Equal(t, "deflate", rec.Header().Get("Content-Encoding"), acceptEncoding)
+		reader, err := zlib.NewReader(rec.Body)
+		require.NoError(t, err)
+		decompressed, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, body, string(decompressed), acceptEncoding)
+	}
+
+	// others get them as they are, but still varying by Accept-Encoding.
+	for _, acceptEncoding := range []string{"", "identity", "gzip;q=0", "gzip;q=0, deflate;q=0"} {
+		rec = serve("GET", acceptEncoding, html(), http.StatusOK)
+		require.Empty(t, rec.Header().Get("Content-Encoding"), acceptEncoding)
+		require.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"), acceptEncoding)
+		require.Equal(t, body, rec.Body.String(), acceptEncoding)
+	}
+
+	// encoded, partial, small and binary responses are left alone.
+	for _, test := range []struct {
+		header http.Header
+		status int
+	}{
+		{header: html("Content-Encoding", "br"), status: http.StatusOK},
+		{header: html("Content-Range", "bytes 0-9/100"), status: http.StatusPartialContent},
+		{header: html("Content-Length", "100"), status: http.StatusOK},
+		{header: http.Header{"Content-Type": {"image/png"}}, status: http.StatusOK},
+	} {
+		rec = serve("GET", "gzip", test.header, test.status)
+		require.NotEqual(t, "gzip", rec.Header().Get("Content-Encoding"), test.header)
+		require.Empty(t, rec.Header().Get("Vary"), test.header)
+		require.Equal(t, body, rec.Body.String(), test.header)
+	}
+
+	// HEAD responses get the headers a GET would, without a body.
+	rec = serve("HEAD", "gzip", html(), http.StatusOK)
+	require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+
+	// without the option, nothing is wrapped.
+	rec = httptest.NewRecorder()
+	w, done := (&Handler{}).compressResponse(rec, httptest.NewRequest("GET", "http://test.test/", nil))
+	done()
+	require.Equal(t, rec, w)
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"storj.io/uplink"
+)
+
+// setLastModified sets the Last-Modified header for serving o. Objects are
+// immutable once uploaded, so their creation time is when they last changed.
+func setLastModified(w http.ResponseWriter, o *uplink.Object) {
+	if !o.System.Created.IsZero() {
+		w.Header().Set("Last-Modified", o.System.Created.UTC().Format(http.TimeFormat))
+	}
+}
+
+// objectETag returns a strong entity tag for o. Objects are immutable once
+// uploaded, so their creation time and size identify their contents.
+func objectETag(o *uplink.Object) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s %d %d", o.Key, o.System.Created.UnixNano(), o.System.ContentLength)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// setETag sets the ETag header for serving o, which ServeContent also uses
+// for If-Range.
+func setETag(w http.ResponseWriter, o *uplink.Object) {
+	w.Header().Set("ETag", objectETag(o))
+}
+
+// etagMatches returns whether an If-None-Match header value lists etag, using
+// the weak comparison RFC 7232 requires for it.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified returns whether a GET or HEAD request's If-None-Match or
+// If-Modified-Since shows the client already has o, so we can answer without
+// opening the object at all. If-None-Match takes precedence when present.
+func notModified(r *http.Request, o *uplink.Object) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, objectETag(o))
+	}
+	if o.System.Created.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// Last-Modified has no sub-second precision.
+	return !o.System.Created.Truncate(time.Second).After(since)
+}
+
+// writeNotModified responds with 304 Not Modified, dropping the headers that
+// describe a body, as there is none.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	delete(h, "Content-Encoding")
+	w.WriteHeader(http.StatusNotModified)
+}
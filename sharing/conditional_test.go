@@ -0,0 +1,100 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
+)
+
+func TestNotModified(t *testing.T) {
+	created := time.Date(2021, 6, 1, 12, 0, 0, 500, time.UTC)
+	o := &uplink.Object{}
+	o.System.Created = created
+
+	for _, test := range []struct {
+		method      string
+		since       string
+		ifNoneMatch string
+		expected    bool
+	}{
+		{method: "GET", since: "", expected: false},
+		{method: "GET", since: "garbage", expected: false},
+		{method: "GET", since: created.Format(http.TimeFormat), expected: true},
+		{method: "HEAD", since: created.Add(time.Hour).Format(http.TimeFormat), expected: true},
+		{method: "GET", since: created.Add(-time.Second).Format(http.TimeFormat), expected: false},
+		{method: "GET", since: created.Format(http.TimeFormat), ifNoneMatch: `"abc"`, expected: false},
+		{method: "POST", since: created.Format(http.TimeFormat), expected: false},
+	} {
+		r := httptest.NewRequest(test.method, "http://test.test/", nil)
+		if test.since != "" {
+			r.Header.Set("If-Modified-Since", test.since)
+		}
+		if test.ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+		require.Equal(t, test.expected, notModified(r, o), "%s %q", test.method, test.since)
+	}
+}
+
+func TestNotModifiedETag(t *testing.T) {
+	o := &uplink.Object{Key: "photo.jpg"}
+	o.System.Created = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	o.System.ContentLength = 100
+	etag := objectETag(o)
+
+	other := &uplink.Object{Key: "photo.jpg"}
+	other.System.Created = o.System.Created
+	other.System.ContentLength = 101
+	require.NotEqual(t, etag, objectETag(other))
+
+	for _, test := range []struct {
+		ifNoneMatch string
+		expected    bool
+	}{
+		{ifNoneMatch: etag, expected: true},
+		{ifNoneMatch: "W/" + etag, expected: true},
+		{ifNoneMatch: `"abc", ` + etag, expected: true},
+		{ifNoneMatch: "*", expected: true},
+		{ifNoneMatch: objectETag(other), expected: false},
+	} {
+		r := httptest.NewRequest("GET", "http://test.test/", nil)
+		r.Header.Set("If-None-Match", test.ifNoneMatch)
+		// If-None-Match wins over a matching If-Modified-Since.
+		r.Header.Set("If-Modified-Since", o.System.Created.Format(http.TimeFormat))
+		require.Equal(t, test.expected, notModified(r, o), test.ifNoneMatch)
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+
+	ctx := testcontext.New(t)
+	object := &uplink.Object{Key: "photo.jpg"}
+	object.System.Created = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	object.System.ContentLength = 100
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://test.test/", nil)
+	r.Header.Set("If-Modified-Since", "Tue, 01 Jun 2021 12:00:00 GMT")
+	// the project is never used, as nothing needs to be downloaded.
+	require.NoError(t, handler.showObject(ctx, w, r, &parsedRequest{}, &uplink.Project{}, object))
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Equal(t, "Tue, 01 Jun 2021 12:00:00 GMT", w.Header().Get("Last-Modified"))
+	require.Equal(t, objectETag(object), w.Header().Get("ETag"))
+	require.Empty(t, w.Header().Get("Content-Type"))
+	require.Empty(t, w.Body.String())
+}
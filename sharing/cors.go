@@ -0,0 +1,89 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// corsMetadataKey is the custom metadata key an object can set to a comma
+// separated list of origins allowed to fetch it cross-origin. When set, it
+// replaces the globally configured allowed origins for that object.
+const corsMetadataKey = "cors-allowed-origins"
+
+// setCORSHeaders sets the CORS response headers for serving o, if the
+// request's origin is allowed to fetch it.
+func (handler *Handler) setCORSHeaders(w http.ResponseWriter, r *http.Request, o *uplink.Object) {
+	allowed := handler.corsAllowedOrigins
+	if value, ok := o.Custom[corsMetadataKey]; ok {
+		allowed = SplitList(value)
+	}
+	if len(allowed) == 0 {
+		return
+	}
+
+	origin := requestHeader(w, r, "Origin")
+	if origin == "" {
+		return
+	}
+
+	if allowedOrigin := allowOrigin(allowed, origin); allowedOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges")
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if it isn't one of the allowed origins.
+func allowOrigin(allowed []string, origin string) string {
+	for _, candidate := range allowed {
+		if candidate == "*" {
+			return "*"
+		}
+		if strings.EqualFold(candidate, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// allowedMethods returns the value of the Allow header: the methods shared
+// objects can be requested with.
+func (handler *Handler) allowedMethods() string {
+	if len(handler.corsAllowedOrigins) > 0 {
+		return "GET, HEAD, OPTIONS"
+	}
+	return "GET, HEAD"
+}
+
+// methodNotAllowed returns the error for requests with methods that aren't
+// allowed, setting the Allow header its 405 response needs.
+func (handler *Handler) methodNotAllowed(w http.ResponseWriter) error {
+	w.Header().Set("Allow", handler.allowedMethods())
+	return WithStatus(errs.New("method not allowed"), http.StatusMethodNotAllowed)
+}
+
+// servePreflight answers OPTIONS requests, including CORS preflights from
+// allowed origins. Preflights go by the configured allowed origins only:
+// looking the object up for its own would cost every preflight a satellite
+// round trip, so origins allowed only by an object's metadata get no
+// preflight, and can only make simple requests for it.
+func (handler *Handler) servePreflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", handler.allowedMethods())
+
+	origin := requestHeader(w, r, "Origin")
+	if origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+		if allowedOrigin := allowOrigin(handler.corsAllowedOrigins, origin); allowedOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+			w.Header().Set("Access-Control-Allow-Headers", "Range, If-Match, If-None-Match, If-Modified-Since, If-Range")
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
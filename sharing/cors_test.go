@@ -0,0 +1,116 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/uplink"
+)
+
+func TestSetCORSHeaders(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		global   []string
+		custom   uplink.CustomMetadata
+		origin   string
+		expected string
+	}{
+		{name: "no config", origin: "https://a.test"},
+		{name: "global match", global: []string{"https://a.test"}, origin: "https://a.test", expected: "https://a.test"},
+		{name: "global mismatch", global: []string{"https://a.test"}, origin: "https://b.test"},
+		{name: "global wildcard", global: []string{"*"}, origin: "https://b.test", expected: "*"},
+		{name: "no origin", global: []string{"*"}},
+		{
+			name:     "metadata overrides global",
+			global:   []string{"https://a.test"},
+			custom:   uplink.CustomMetadata{corsMetadataKey: "https://b.test, https://c.test"},
+			origin:   "https://c.test",
+			expected: "https://c.test",
+		},
+		{
+			name:   "metadata narrows global",
+			global: []string{"*"},
+			custom: uplink.CustomMetadata{corsMetadataKey: "https://b.test"},
+			origin: "https://a.test",
+		},
+		{
+			name:     "metadata without global",
+			custom:   uplink.CustomMetadata{corsMetadataKey: "*"},
+			origin:   "https://a.test",
+			expected: "*",
+		},
+	} {
+		handler := &Handler{corsAllowedOrigins: test.global}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://test.test/", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+
+		handler.setCORSHeaders(w, r, &uplink.Object{Custom: test.custom})
+		assert.Equal(t, test.expected, w.Header().Get("Access-Control-Allow-Origin"), test.name)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	newHandler := func(origins []string) *Handler {
+		handler, err := NewHandler(zap.NewNop(), nil, Config{
+			URLBases:           []string{"http://test.test"},
+			Templates:          "../web",
+			CORSAllowedOrigins: origins,
+		})
+		require.NoError(t, err)
+		return handler
+	}
+
+	handler := newHandler(nil)
+	for _, test := range []struct{ method, url string }{
+		{method: "PUT", url: "http://test.test/s/access/bucket/key"},
+		{method: "DELETE", url: "http://test.test/s/access/bucket/key"},
+		{method: "POST", url: "http://test.test/s/access/bucket/key"},
+		{method: "POST", url: "http://site.test/index.html"},
+		{method: "OPTIONS", url: "http://test.test/s/access/bucket/key"},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code, test)
+		assert.Equal(t, "GET, HEAD", w.Header().Get("Allow"), test)
+	}
+
+	// with CORS, OPTIONS is allowed and answers preflights.
+	handler = newHandler([]string{"https://a.test"})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "http://test.test/s/access/bucket/key", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("OPTIONS", "http://test.test/s/access/bucket/key", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w = preflight("https://a.test")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+	assert.Equal(t, "https://a.test", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, HEAD", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Range")
+
+	w = preflight("https://b.test")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+
+	"storj.io/uplink"
+)
+
+// debugMetadataPrefix prefixes the headers custom metadata is exposed in.
+const debugMetadataPrefix = "X-Object-Meta-"
+
+// setDebugHeaders exposes o's metadata from its stat in X-Object-* headers,
+// when configured to. Objects can't be modified once uploaded, so their
+// modification time is their creation time. Custom metadata whose key or
+// value can't be sent in a header is left out.
+func (handler *Handler) setDebugHeaders(w http.ResponseWriter, o *uplink.Object) {
+	if !handler.debugHeaders {
+		return
+	}
+
+	if !o.System.Created.IsZero() {
+		created := o.System.Created.UTC().Format(time.RFC3339Nano)
+		w.Header().Set("X-Object-Created", created)
+		w.Header().Set("X-Object-Modified", created)
+	}
+	if !o.System.Expires.IsZero() {
+		w.Header().Set("X-Object-Expires", o.System.Expires.UTC().Format(time.RFC3339Nano))
+	}
+
+	keys := make([]string, 0, len(o.Custom))
+	for key := range o.Custom {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := o.Custom[key]
+		if httpguts.ValidHeaderFieldName(key) && httpguts.ValidHeaderFieldValue(value) {
+			w.Header().Add(debugMetadataPrefix+key, value)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/uplink"
+)
+
+func TestDebugHeaders(t *testing.T) {
+	o := &uplink.Object{
+		Key:    "report.pdf",
+		Custom: uplink.CustomMetadata{"owner": "alice", "bad key": "x", "newline": "a\nb"},
+	}
+	o.System.Created = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	o.System.Expires = time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// off by default.
+	w := httptest.NewRecorder()
+	(&Handler{}).setDebugHeaders(w, o)
+	require.Empty(t, w.Header())
+
+	w = httptest.NewRecorder()
+	(&Handler{debugHeaders: true}).setDebugHeaders(w, o)
+	require.Equal(t, http.Header{
+		"X-Object-Created":    {"2021-06-01T12:00:00Z"},
+		"X-Object-Modified":   {"2021-06-01T12:00:00Z"},
+		"X-Object-Expires":    {"2022-06-01T12:00:00Z"},
+		"X-Object-Meta-Owner": {"alice"},
+	}, w.Header())
+}
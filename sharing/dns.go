@@ -5,6 +5,8 @@ package sharing
 
 import (
 	"context"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
@@ -15,29 +17,108 @@ var (
 	errDNS = errs.Class("dns error")
 )
 
+// defaultDNSTimeout is how long a lookup waits for a single DNS server when
+// no timeout is given.
+const defaultDNSTimeout = 5 * time.Second
+
 // DNSClient is a wrapper utility around github.com/miekg/dns to make it
 // a bit more palatable and client user friendly.
 type DNSClient struct {
-	c         *dns.Client
-	dnsServer string
+	c          *dns.Client
+	dnsServers []string
+	timeout    time.Duration
 }
 
-// NewDNSClient creates a DNS Client that uses the given
-// dnsServerAddr. Currently requires that the DNS Server speaks TCP.
-func NewDNSClient(dnsServerAddr string) (*DNSClient, error) {
+// NewDNSClient creates a DNS Client that uses the given dnsServerAddrs,
+// trying them in order until one answers. Addresses without a port use port
+// 53, and IPv6 addresses may be given with or without brackets. Each server
+// is given at most timeout to answer. Currently requires that the DNS
+// Servers speak TCP.
+func NewDNSClient(dnsServerAddrs []string, timeout time.Duration) (*DNSClient, error) {
+	servers := make([]string, 0, len(dnsServerAddrs))
+	for _, addr := range dnsServerAddrs {
+		server, err := dnsServerAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	if timeout <= 0 {
+		timeout = defaultDNSTimeout
+	}
 	return &DNSClient{
-		c:         &dns.Client{Net: "tcp"},
-		dnsServer: dnsServerAddr,
+		c:          &dns.Client{Net: "tcp"},
+		dnsServers: servers,
+		timeout:    timeout,
 	}, nil
 }
 
+// dnsServerAddress returns addr as a host and port, adding the default DNS
+// port when it has none.
+func dnsServerAddress(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return "", errDNS.New("empty dns server address")
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if host == "" || port == "" {
+			return "", errDNS.New("invalid dns server address %q", addr)
+		}
+		return net.JoinHostPort(host, port), nil
+	}
+	// an address without a port: a host name, an IPv4 address or an IPv6
+	// address, bracketed or not.
+	host := addr
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	if strings.ContainsAny(host, "[]") || (strings.Contains(host, ":") && net.ParseIP(host) == nil) {
+		return "", errDNS.New("invalid dns server address %q", addr)
+	}
+	return net.JoinHostPort(host, "53"), nil
+}
+
 // Lookup is a helper method that never returns truncated DNS messages.
 // The current implementation does this by doing all lookups over TCP.
+// Servers that fail, time out or answer with a server failure are skipped
+// for the next one.
 func (cli *DNSClient) Lookup(ctx context.Context, host string, recordType uint16) (*dns.Msg, error) {
 	m := dns.Msg{}
 	m.SetQuestion(dns.Fqdn(host), recordType)
-	r, _, err := cli.c.ExchangeContext(ctx, &m, cli.dnsServer)
-	return r, errDNS.Wrap(err)
+
+	if len(cli.dnsServers) == 0 {
+		return nil, errDNS.New("no dns server address")
+	}
+
+	var failed *dns.Msg
+	var group errs.Group
+	for _, server := range cli.dnsServers {
+		r, err := cli.exchange(ctx, &m, server)
+		if err != nil {
+			group.Add(err)
+		} else if r.Rcode == dns.RcodeServerFailure || r.Rcode == dns.RcodeRefused {
+			failed = r
+		} else {
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if failed != nil {
+		// a server did answer, if unhelpfully.
+		return failed, nil
+	}
+	return nil, errDNS.Wrap(group.Err())
+}
+
+// exchange sends m to server, waiting at most the client's timeout.
+func (cli *DNSClient) exchange(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, cli.timeout)
+	defer cancel()
+
+	r, _, err := cli.c.ExchangeContext(ctx, m, server)
+	return r, err
 }
 
 // ResponseToTXTRecordSet returns a TXTRecordSet from a dns Lookup response.
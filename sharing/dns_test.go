@@ -0,0 +1,103 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+)
+
+func TestDNSServerAddress(t *testing.T) {
+	for _, test := range []struct {
+		addr, server string
+	}{
+		{addr: "1.1.1.1:53", server: "1.1.1.1:53"},
+		{addr: "1.1.1.1", server: "1.1.1.1:53"},
+		{addr: " dns.example.com ", server: "dns.example.com:53"},
+		{addr: "[2606:4700:4700::1111]:5353", server: "[2606:4700:4700::1111]:5353"},
+		{addr: "[2606:4700:4700::1111]", server: "[2606:4700:4700::1111]:53"},
+		{addr: "2606:4700:4700::1111", server: "[2606:4700:4700::1111]:53"},
+		{addr: ""},
+		{addr: ":53"},
+		{addr: "[::1"},
+		{addr: "not:an:address"},
+	} {
+		server, err := dnsServerAddress(test.addr)
+		if test.server == "" {
+			require.Error(t, err, test.addr)
+			continue
+		}
+		require.NoError(t, err, test.addr)
+		require.Equal(t, test.server, server, test.addr)
+	}
+}
+
+// serveDNS serves TXT lookups over TCP, answering with rcode and a single
+// record, and returns its address.
+func serveDNS(t *testing.T, ctx *testcontext.Context, rcode int, txt string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &dns.Server{Listener: listener, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		if rcode == dns.RcodeSuccess {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{txt},
+			})
+		}
+		_ = w.WriteMsg(m)
+	})}
+	ctx.Go(server.ActivateAndServe)
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return listener.Addr().String()
+}
+
+func TestDNSClientFailover(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	// a closed port, so lookups fail right away.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+
+	// a server that accepts connections but never answers.
+	hung, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = hung.Close() }()
+
+	failing := serveDNS(t, ctx, dns.RcodeServerFailure, "")
+	working := serveDNS(t, ctx, dns.RcodeSuccess, "storj-root:bucket")
+
+	client, err := NewDNSClient([]string{closed.Addr().String(), hung.Addr().String(), failing, working}, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	r, err := client.Lookup(ctx, "txt-www.example.com", dns.TypeTXT)
+	require.NoError(t, err)
+	require.Equal(t, "bucket", ResponseToTXTRecordSet(r).Lookup("storj-root"))
+
+	// without a working server, the failure answer is all there is.
+	client, err = NewDNSClient([]string{closed.Addr().String(), failing}, 100*time.Millisecond)
+	require.NoError(t, err)
+	r, err = client.Lookup(ctx, "txt-www.example.com", dns.TypeTXT)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, r.Rcode)
+
+	client, err = NewDNSClient([]string{closed.Addr().String(), hung.Addr().String()}, 100*time.Millisecond)
+	require.NoError(t, err)
+	_, err = client.Lookup(ctx, "txt-www.example.com", dns.TypeTXT)
+	require.Error(t, err)
+
+	client, err = NewDNSClient(nil, time.Second)
+	require.NoError(t, err)
+	_, err = client.Lookup(ctx, "txt-www.example.com", dns.TypeTXT)
+	require.Error(t, err)
+}
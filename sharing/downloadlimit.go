@@ -0,0 +1,181 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// download priorities, highest first.
+const (
+	downloadPriorityHigh = iota
+	downloadPriorityLow
+)
+
+// downloadPriorityPolicies are how waiting downloads are ordered: "size"
+// lets small downloads skip ahead of large ones, and "fifo" serves them in
+// order of arrival.
+var downloadPriorityPolicies = map[string]bool{"size": true, "fifo": true}
+
+// downloadLimiter limits how many objects are downloaded at once. Downloads
+// over the limit wait in a queue per priority, and each freed slot goes to
+// the longest waiting download of the highest priority. So that a steady
+// stream of small downloads can't starve large ones, a low priority
+// download waiting for maxWait or longer goes first.
+type downloadLimiter struct {
+	maxWait time.Duration
+
+	mu     sync.Mutex
+	free   int
+	queues [2]*list.List
+}
+
+type downloadWaiter struct {
+	ready    chan struct{}
+	enqueued time.Time
+}
+
+func newDownloadLimiter(limit int, maxWait time.Duration) *downloadLimiter {
+	return &downloadLimiter{
+		maxWait: maxWait,
+		free:    limit,
+		queues:  [2]*list.List{list.New(), list.New()},
+	}
+}
+
+// acquire waits for a download slot, for at most as long as ctx. The
+// returned func gives the slot back.
+func (limiter *downloadLimiter) acquire(ctx context.Context, priority int) (release func(), err error) {
+	limiter.mu.Lock()
+	if limiter.free > 0 && limiter.queues[0].Len() == 0 && limiter.queues[1].Len() == 0 {
+		limiter.free--
+		limiter.mu.Unlock()
+		return limiter.releaser(), nil
+	}
+	waiter := &downloadWaiter{ready: make(chan struct{}), enqueued: time.Now()}
+	elem := limiter.queues[priority].PushBack(waiter)
+	limiter.mu.Unlock()
+
+	mon.Event("download_queued")
+	select {
+	case <-waiter.ready:
+		return limiter.releaser(), nil
+	case <-ctx.Done():
+	}
+
+	limiter.mu.Lock()
+	select {
+	case <-waiter.ready:
+		// the slot was handed over just now, so pass it on.
+		limiter.mu.Unlock()
+		limiter.release()
+	default:
+		limiter.queues[priority].Remove(elem)
+		limiter.mu.Unlock()
+	}
+	return nil, ctx.Err()
+}
+
+func (limiter *downloadLimiter) releaser() func() {
+	var once sync.Once
+	return func() { once.Do(limiter.release) }
+}
+
+// release hands the slot to the next waiting download, if any.
+func (limiter *downloadLimiter) release() {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	high, low := limiter.queues[downloadPriorityHigh], limiter.queues[downloadPriorityLow]
+	queue := high
+	if front := low.Front(); front != nil {
+		if high.Len() == 0 || time.Since(front.Value.(*downloadWaiter).enqueued) >= limiter.maxWait {
+			queue = low
+		}
+	}
+	if queue.Len() == 0 {
+		limiter.free++
+		return
+	}
+	close(queue.Remove(queue.Front()).(*downloadWaiter).ready)
+}
+
+// downloadPriority returns the priority of serving o for r. With the size
+// policy, downloads of up to smallDownloadSize bytes, either whole objects
+// or a single range of a larger one, like a media player seeking, have high
+// priority and everything else low.
+func (handler *Handler) downloadPriority(r *http.Request, o *uplink.Object) int {
+	if handler.downloadPriorityPolicy != "size" {
+		return downloadPriorityHigh
+	}
+	size := o.System.ContentLength
+	if length, ok := singleRangeLength(r.Header.Get("Range"), size); ok {
+		size = length
+	}
+	if size <= handler.smallDownloadSize {
+		return downloadPriorityHigh
+	}
+	return downloadPriorityLow
+}
+
+// singleRangeLength returns how many bytes a Range header, already
+// normalized, asks for when it is a single range.
+func singleRangeLength(header string, size int64) (int64, bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, false
+	}
+	spec := header[len("bytes="):]
+	dash := strings.IndexByte(spec, '-')
+	if dash < 0 {
+		return 0, false
+	}
+	first, last := spec[:dash], spec[dash+1:]
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return suffix, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil {
+			return 0, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return end - start + 1, true
+}
+
+// acquireDownload waits for a slot to download o for r in, when downloads
+// are limited. The returned func gives the slot back.
+func (handler *Handler) acquireDownload(ctx context.Context, r *http.Request, o *uplink.Object) (release func(), err error) {
+	if handler.downloadLimiter == nil || r.Method == http.MethodHead {
+		return func() {}, nil
+	}
+	release, err = handler.downloadLimiter.acquire(ctx, handler.downloadPriority(r, o))
+	if err != nil {
+		return nil, WithStatus(errs.New("waiting for a download slot: %v", err), httpStatusClientClosedRequest)
+	}
+	return release, nil
+}
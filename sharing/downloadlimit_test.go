@@ -0,0 +1,147 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
+)
+
+// freeSlots returns how many download slots limiter has free.
+func freeSlots(limiter *downloadLimiter) int {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return limiter.free
+}
+
+// queued waits until limiter has n downloads waiting at priority.
+func queued(t *testing.T, limiter *downloadLimiter, priority, n int) {
+	require.Eventually(t, func() bool {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return limiter.queues[priority].Len() == n
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestDownloadLimiterPriority(t *testing.T) {
+	ctx := testcontext.New(t)
+	limiter := newDownloadLimiter(1, time.Hour)
+
+	release, err := limiter.acquire(ctx, downloadPriorityLow)
+	require.NoError(t, err)
+
+	order := make(chan string, 2)
+	acquire := func(name string, priority int) {
+		release, err := limiter.acquire(ctx, priority)
+		if err != nil {
+			order <- err.Error()
+			return
+		}
+		order <- name
+		release()
+	}
+
+	// a large download waits first, but a small one arriving later still
+	// goes before it.
+	go acquire("large", downloadPriorityLow)
+	queued(t, limiter, downloadPriorityLow, 1)
+	go acquire("small", downloadPriorityHigh)
+	queued(t, limiter, downloadPriorityHigh, 1)
+
+	release()
+	require.Equal(t, "small", <-order)
+	require.Equal(t, "large", <-order)
+
+	// everything is released again.
+	require.Eventually(t, func() bool { return freeSlots(limiter) == 1 }, 5*time.Second, time.Millisecond)
+}
+
+func TestDownloadLimiterStarvation(t *testing.T) {
+	ctx := testcontext.New(t)
+	limiter := newDownloadLimiter(1, 20*time.Millisecond)
+
+	release, err := limiter.acquire(ctx, downloadPriorityHigh)
+	require.NoError(t, err)
+
+	order := make(chan string, 2)
+	acquire := func(name string, priority int) {
+		release, err := limiter.acquire(ctx, priority)
+		if err != nil {
+			order <- err.Error()
+			return
+		}
+		order <- name
+		release()
+	}
+
+	// the large download has waited too long to be skipped again.
+	go acquire("large", downloadPriorityLow)
+	queued(t, limiter, downloadPriorityLow, 1)
+	time.Sleep(30 * time.Millisecond)
+	go acquire("small", downloadPriorityHigh)
+	queued(t, limiter, downloadPriorityHigh, 1)
+
+	release()
+	require.Equal(t, "large", <-order)
+	require.Equal(t, "small", <-order)
+}
+
+func TestDownloadLimiterCancel(t *testing.T) {
+	ctx := testcontext.New(t)
+	limiter := newDownloadLimiter(1, time.Hour)
+
+	release, err := limiter.acquire(ctx, downloadPriorityHigh)
+	require.NoError(t, err)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = limiter.acquire(waitCtx, downloadPriorityLow)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	queued(t, limiter, downloadPriorityLow, 0)
+
+	release()
+	release()
+	require.Equal(t, 1, freeSlots(limiter))
+}
+
+func TestDownloadPriority(t *testing.T) {
+	handler := &Handler{downloadPriorityPolicy: "size", smallDownloadSize: 1000}
+	object := func(size int64) *uplink.Object {
+		o := &uplink.Object{Key: "video.mp4"}
+		o.System.ContentLength = size
+		return o
+	}
+
+	for _, test := range []struct {
+		size     int64
+		header   string
+		priority int
+	}{
+		{size: 1000, priority: downloadPriorityHigh},
+		{size: 1001, priority: downloadPriorityLow},
+		{size: 1 << 30, header: "bytes=5000-5999", priority: downloadPriorityHigh},
+		{size: 1 << 30, header: "bytes=-500", priority: downloadPriorityHigh},
+		{size: 1 << 30, header: "bytes=0-", priority: downloadPriorityLow},
+		{size: 1 << 30, header: "bytes=0-10,20-30", priority: downloadPriorityLow},
+		{size: 1500, header: "bytes=1000-", priority: downloadPriorityHigh},
+	} {
+		r := httptest.NewRequest("GET", "http://test.test/", nil)
+		if test.header != "" {
+			r.Header.Set("Range", test.header)
+		}
+		require.Equal(t, test.priority, handler.downloadPriority(r, object(test.size)), test.header)
+	}
+
+	handler.downloadPriorityPolicy = "fifo"
+	r := httptest.NewRequest("GET", "http://test.test/", nil)
+	require.Equal(t, downloadPriorityHigh, handler.downloadPriority(r, object(1<<30)))
+}
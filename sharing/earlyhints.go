@@ -0,0 +1,64 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// preloadDestinations maps asset extensions to their preload "as" value.
+var preloadDestinations = map[string]string{
+	".css":   "style",
+	".js":    "script",
+	".mjs":   "script",
+	".woff":  "font",
+	".woff2": "font",
+	".ttf":   "font",
+	".otf":   "font",
+	".png":   "image",
+	".jpg":   "image",
+	".jpeg":  "image",
+	".gif":   "image",
+	".svg":   "image",
+	".webp":  "image",
+	".avif":  "image",
+}
+
+// preloadLinks turns a comma separated list of site paths into Link header
+// values preloading them. Paths with unknown extensions are skipped, as
+// preloads need a destination.
+func preloadLinks(value string) []string {
+	var links []string
+	for _, asset := range SplitList(value) {
+		if !strings.HasPrefix(asset, "/") || strings.ContainsAny(asset, "<>,; ") {
+			continue
+		}
+		as, ok := preloadDestinations[strings.ToLower(path.Ext(asset))]
+		if !ok {
+			continue
+		}
+		link := "<" + asset + ">; rel=preload; as=" + as
+		if as == "font" {
+			// fonts are always fetched in cors mode.
+			link += "; crossorigin"
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// writeEarlyHints sends the preload links as a 103 Early Hints response, if
+// the server supports informational responses. The links are kept on the
+// final response too, for clients and proxies that ignore 103.
+func writeEarlyHints(w http.ResponseWriter, r *http.Request, links []string) {
+	if len(links) == 0 || r.Method != http.MethodGet || !r.ProtoAtLeast(1, 1) {
+		return
+	}
+	for _, link := range links {
+		w.Header().Add("Link", link)
+	}
+	sendEarlyHints(w)
+}
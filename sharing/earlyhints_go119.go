@@ -0,0 +1,15 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+//go:build go1.19
+// +build go1.19
+
+package sharing
+
+import "net/http"
+
+// sendEarlyHints writes a 103 response with the current headers. Since Go
+// 1.19, 1xx statuses don't end the response.
+func sendEarlyHints(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusEarlyHints)
+}
@@ -0,0 +1,13 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+//go:build !go1.19
+// +build !go1.19
+
+package sharing
+
+import "net/http"
+
+// sendEarlyHints does nothing, as before Go 1.19 writing a 1xx status would
+// end the response. The Link headers on the final response remain.
+func sendEarlyHints(w http.ResponseWriter) {}
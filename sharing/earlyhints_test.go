@@ -0,0 +1,20 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreloadLinks(t *testing.T) {
+	require.Equal(t, []string{
+		"</css/site.css>; rel=preload; as=style",
+		"</js/app.js>; rel=preload; as=script",
+		"</fonts/body.woff2>; rel=preload; as=font; crossorigin",
+	}, preloadLinks("/css/site.css, /js/app.js,/fonts/body.woff2,/data.bin,relative.css,/bad>.css"))
+
+	require.Empty(t, preloadLinks(""))
+}
@@ -0,0 +1,175 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+)
+
+// EgressExporter receives egress totals, in bytes, accumulated since the
+// previous export. Totals are keyed by "host:<domain>" for the hosting
+// service and "access:<id>" for shared links, where the id is a truncated
+// SHA-256 of the access grant or access key in the URL.
+type EgressExporter interface {
+	ExportEgress(ctx context.Context, start, end time.Time, totals map[string]int64) error
+}
+
+// LogEgressExporter exports egress totals as log lines.
+type LogEgressExporter struct {
+	Log *zap.Logger
+}
+
+// ExportEgress implements EgressExporter.
+func (exporter LogEgressExporter) ExportEgress(ctx context.Context, start, end time.Time, totals map[string]int64) error {
+	for key, bytes := range totals {
+		exporter.Log.Info("egress",
+			zap.String("key", key),
+			zap.Int64("bytes", bytes),
+			zap.Time("start", start),
+			zap.Time("end", end))
+	}
+	return nil
+}
+
+// HTTPEgressExporter exports egress totals by POSTing them as JSON to URL.
+type HTTPEgressExporter struct {
+	URL    string
+	Client *http.Client
+}
+
+// ExportEgress implements EgressExporter.
+func (exporter HTTPEgressExporter) ExportEgress(ctx context.Context, start, end time.Time, totals map[string]int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	body, err := json.Marshal(struct {
+		Start  time.Time        `json:"start"`
+		End    time.Time        `json:"end"`
+		Egress map[string]int64 `json:"egress"`
+	}{Start: start, End: end, Egress: totals})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, exporter.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := exporter.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+
+	if resp.StatusCode/100 != 2 {
+		return errs.New("egress export failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// egressTally accumulates egress totals between exports.
+type egressTally struct {
+	mu     sync.Mutex
+	start  time.Time
+	totals map[string]int64
+}
+
+func newEgressTally(now time.Time) *egressTally {
+	return &egressTally{start: now, totals: map[string]int64{}}
+}
+
+func (tally *egressTally) add(key string, n int64) {
+	if n <= 0 {
+		return
+	}
+	tally.mu.Lock()
+	tally.totals[key] += n
+	tally.mu.Unlock()
+}
+
+// flush returns the accumulated totals and the time they started at, and
+// starts a new tally at now.
+func (tally *egressTally) flush(now time.Time) (start time.Time, totals map[string]int64) {
+	tally.mu.Lock()
+	defer tally.mu.Unlock()
+	start, totals = tally.start, tally.totals
+	tally.start, tally.totals = now, map[string]int64{}
+	return start, totals
+}
+
+// egressAccessKey returns the tally key for a shared access.
+func egressAccessKey(serializedAccess string) string {
+	sum := sha256.Sum256([]byte(serializedAccess))
+	return "access:" + hex.EncodeToString(sum[:8])
+}
+
+// countingWriter counts the bytes of the response body.
+type countingWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// countEgress wraps w to count the bytes written to it. The returned done
+// func adds them to key's total. Without an exporter, w is returned as is.
+func (handler *Handler) countEgress(w http.ResponseWriter, key string) (_ http.ResponseWriter, done func()) {
+	if handler.egressExporter == nil {
+		return w, func() {}
+	}
+	counter := &countingWriter{ResponseWriter: w}
+	return counter, func() { handler.egress.add(key, counter.written) }
+}
+
+// RunEgressExport periodically exports egress totals until ctx is canceled,
+// exporting what is left before returning. It returns right away when no
+// exporter is configured.
+func (handler *Handler) RunEgressExport(ctx context.Context) error {
+	if handler.egressExporter == nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(handler.egressExportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			handler.exportEgress(ctx)
+		case <-ctx.Done():
+			// the run context is gone, but the last totals still matter.
+			handler.exportEgress(context.Background())
+			return ctx.Err()
+		}
+	}
+}
+
+func (handler *Handler) exportEgress(ctx context.Context) {
+	start, totals := handler.egress.flush(time.Now())
+	if len(totals) == 0 {
+		return
+	}
+	if err := handler.egressExporter.ExportEgress(ctx, start, time.Now(), totals); err != nil {
+		handler.log.Error("unable to export egress", zap.Error(err), zap.Int("keys", len(totals)))
+	}
+}
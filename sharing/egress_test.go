@@ -0,0 +1,90 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/testcontext"
+)
+
+func TestEgressTally(t *testing.T) {
+	start := time.Now()
+	tally := newEgressTally(start)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tally.add("host:site.test", 100)
+			tally.add("access:abc", 1)
+			tally.add("access:empty", 0)
+		}()
+	}
+	wg.Wait()
+
+	flushedAt := start.Add(time.Minute)
+	gotStart, totals := tally.flush(flushedAt)
+	require.Equal(t, start, gotStart)
+	require.Equal(t, map[string]int64{"host:site.test": 1000, "access:abc": 10}, totals)
+
+	gotStart, totals = tally.flush(flushedAt.Add(time.Minute))
+	require.Equal(t, flushedAt, gotStart)
+	require.Empty(t, totals)
+}
+
+func TestCountEgress(t *testing.T) {
+	exporter := LogEgressExporter{Log: zap.NewNop()}
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:       []string{"http://test.test"},
+		Templates:      "../web",
+		EgressExporter: exporter,
+	})
+	require.NoError(t, err)
+
+	w, done := handler.countEgress(httptest.NewRecorder(), "host:site.test")
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	done()
+
+	_, totals := handler.egress.flush(time.Now())
+	require.Equal(t, map[string]int64{"host:site.test": 5}, totals)
+
+	require.Equal(t, egressAccessKey("grant"), egressAccessKey("grant"))
+	require.NotEqual(t, egressAccessKey("grant"), egressAccessKey("other"))
+}
+
+func TestHTTPEgressExporter(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	var received map[string]int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Egress map[string]int64 `json:"egress"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received = body.Egress
+	}))
+	defer server.Close()
+
+	totals := map[string]int64{"host:site.test": 42}
+	exporter := HTTPEgressExporter{URL: server.URL}
+	require.NoError(t, exporter.ExportEgress(ctx, time.Now(), time.Now(), totals))
+	require.Equal(t, totals, received)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	require.Error(t, HTTPEgressExporter{URL: failing.URL}.ExportEgress(ctx, time.Now(), time.Now(), totals))
+}
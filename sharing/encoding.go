@@ -0,0 +1,136 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/http/httpguts"
+
+	"storj.io/uplink"
+)
+
+// contentEncodingMetadataKey is the custom metadata key saying how an object
+// was stored encoded, like "gzip".
+const contentEncodingMetadataKey = "content-encoding"
+
+// storedHeaders are the response headers objects can set through custom
+// metadata under the lower case header name.
+var storedHeaders = []string{"Cache-Control", "Content-Encoding", "Content-Language"}
+
+// customMetadata returns the value of o's custom metadata key, matched case
+// insensitively, as uploaders differ in how they spell header-like keys.
+func customMetadata(o *uplink.Object, key string) string {
+	if value, ok := o.Custom[key]; ok {
+		return strings.TrimSpace(value)
+	}
+	for k, value := range o.Custom {
+		if strings.EqualFold(k, key) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// setStoredHeaders copies the headers o was uploaded with into the
+// response. Encoded objects are served as stored, ranges included, with the
+// Content-Encoding telling clients how to decode them, unless gzip
+// decompression is handling it.
+func (handler *Handler) setStoredHeaders(w http.ResponseWriter, o *uplink.Object) {
+	for _, header := range storedHeaders {
+		if header == "Content-Encoding" && handler.gzipDecompression && storedGzip(o) {
+			continue
+		}
+		value := customMetadata(o, strings.ToLower(header))
+		if value != "" && httpguts.ValidHeaderFieldValue(value) {
+			w.Header().Set(header, value)
+		}
+	}
+}
+
+// storedGzip returns whether o was stored gzip compressed.
+func storedGzip(o *uplink.Object) bool {
+	return strings.EqualFold(customMetadata(o, contentEncodingMetadataKey), "gzip")
+}
+
+// acceptsGzip returns whether an Accept-Encoding header value allows gzip,
+// either by name or through *, without a zero quality.
+func acceptsGzip(acceptEncoding string) bool {
+	return acceptsCoding(acceptEncoding, "gzip")
+}
+
+// acceptsCoding returns whether an Accept-Encoding header value allows
+// coding, either by name or through *, without a zero quality.
+func acceptsCoding(acceptEncoding, coding string) bool {
+	accepted := map[string]bool{}
+	for _, field := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(field, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		allowed := true
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				allowed = err == nil && q > 0
+			}
+		}
+		if _, ok := accepted[name]; !ok && name != "" {
+			accepted[name] = allowed
+		}
+	}
+	if allowed, ok := accepted[coding]; ok {
+		return allowed
+	}
+	return accepted["*"]
+}
+
+// serveGzipped handles objects stored gzip compressed. Clients accepting
+// gzip get the stored bytes with a Content-Encoding header and it returns
+// false, leaving the caller to serve them as usual. Other clients get the
+// object decompressed as it streams, without range support, as the
+// decompressed size isn't known up front.
+func (handler *Handler) serveGzipped(ctx context.Context, w http.ResponseWriter, r *http.Request, pr *parsedRequest, project *uplink.Project, o *uplink.Object) (served bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if acceptsGzip(requestHeader(w, r, "Accept-Encoding")) {
+		w.Header().Set("Content-Encoding", "gzip")
+		return false, nil
+	}
+
+	// the decompressed body is a different representation than the stored
+	// object the ETag describes.
+	w.Header().Del("ETag")
+	w.Header().Set("Accept-Ranges", "none")
+	if r.Method == http.MethodHead {
+		return true, nil
+	}
+
+	download, err := project.DownloadObject(ctx, pr.bucket, o.Key, nil)
+	if err != nil {
+		return true, WithAction(err, "download gzipped")
+	}
+	defer func() {
+		if err := download.Close(); err != nil {
+			handler.log.With(zap.Error(err)).Warn("unable to close gzipped download")
+		}
+	}()
+
+	reader, err := gzip.NewReader(download)
+	if err != nil {
+		return true, WithAction(err, "gzip header")
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, reader); err != nil {
+		// the status is already sent, so all we can do is log it.
+		handler.log.Warn("gzipped download failed", zap.Error(err))
+	}
+	return true, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, accepts := range map[string]bool{
+		"":                            false,
+		"gzip":                        true,
+		"GZIP":                        true,
+		"deflate, gzip;q=1.0, br":     true,
+		"gzip;q=0":                    false,
+		"gzip;q=0.0, *":               false,
+		"*":                           true,
+		"*;q=0":                       false,
+		"identity":                    false,
+		"br;q=1.0, gzip;q=0.5, *;q=0": true,
+	} {
+		assert.Equal(t, accepts, acceptsGzip(header), header)
+	}
+}
+
+func TestGzipHead(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:          []string{"http://test.test"},
+		Templates:         "../web",
+		GzipDecompression: true,
+	})
+	require.NoError(t, err)
+
+	ctx := testcontext.New(t)
+	object := &uplink.Object{Key: "data.json", Custom: uplink.CustomMetadata{
+		contentEncodingMetadataKey: "gzip",
+	}}
+	object.System.ContentLength = 100
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("HEAD", "http://test.test/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	require.NoError(t, handler.showObject(ctx, w, r, &parsedRequest{}, &uplink.Project{}, object))
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	require.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("HEAD", "http://test.test/", nil)
+	require.NoError(t, handler.showObject(ctx, w, r, &parsedRequest{}, &uplink.Project{}, object))
+	require.Empty(t, w.Header().Get("Content-Encoding"))
+	require.Equal(t, "none", w.Header().Get("Accept-Ranges"))
+	require.Empty(t, w.Header().Get("Content-Length"))
+}
+
+func TestStoredHeaders(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+
+	ctx := testcontext.New(t)
+	object := &uplink.Object{Key: "app.js", Custom: uplink.CustomMetadata{
+		"Cache-Control":    "public, max-age=31536000, immutable",
+		"content-encoding": "br",
+		"content-language": "de",
+		"x-other":          "ignored",
+	}}
+	object.System.ContentLength = 100
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("HEAD", "http://test.test/", nil)
+	require.NoError(t, handler.showObject(ctx, w, r, &parsedRequest{}, &uplink.Project{}, object))
+	require.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+	require.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	require.Equal(t, "de", w.Header().Get("Content-Language"))
+	// the stored bytes are served as is, so ranges still apply to them.
+	require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	require.Empty(t, w.Header().Get("X-Other"))
+
+	object.Custom = uplink.CustomMetadata{"cache-control": "no-cache\r\nSet-Cookie: x=y"}
+	w = httptest.NewRecorder()
+	require.NoError(t, handler.showObject(ctx, w, r, &parsedRequest{}, &uplink.Project{}, object))
+	require.Empty(t, w.Header().Get("Cache-Control"))
+}
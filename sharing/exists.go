@@ -0,0 +1,144 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/zeebo/errs"
+	"golang.org/x/sync/errgroup"
+
+	"storj.io/uplink"
+)
+
+// existsResult is the per-key result of an existence probe.
+type existsResult struct {
+	Exists bool  `json:"exists"`
+	Size   int64 `json:"size"`
+}
+
+// handleExists checks a batch of keys within a single bucket for existence,
+// using a single project for all of them. Requests look like
+// /exists/<access>/<bucket>?key=a&key=b and get back a JSON object mapping
+// each key to its result.
+func (handler *Handler) handleExists(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/exists/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return WithStatus(errs.New("expected /exists/<access>/<bucket>"), http.StatusBadRequest)
+	}
+	bucket := strings.TrimSuffix(parts[1], "/")
+
+	keys := r.URL.Query()["key"]
+	switch {
+	case len(keys) == 0:
+		return WithStatus(errs.New("missing keys"), http.StatusBadRequest)
+	case len(keys) > handler.existsMaxKeys:
+		return WithStatus(errs.New("too many keys, max %d", handler.existsMaxKeys), http.StatusBadRequest)
+	}
+
+	access, err := parseAccess(ctx, parts[0], handler.authConfig)
+	if err != nil {
+		return err
+	}
+	if err := handler.checkSatellite(access); err != nil {
+		return err
+	}
+
+	handler.auditAccessScope(access, bucket)
+
+	project, release, err := handler.openProject(ctx, access)
+	if err != nil {
+		return WithStatus(WithAction(err, "open project"), http.StatusBadRequest)
+	}
+	defer release()
+
+	if handler.passwordProtection {
+		if err := handler.checkKeyPasswords(ctx, w, r, project, access, bucket, keys); err != nil {
+			return err
+		}
+	}
+
+	results, err := handler.statKeys(ctx, project, bucket, keys)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(results)
+}
+
+// checkKeyPasswords requires the request to carry credentials for every
+// password object protecting any of the keys, like a request for each of them
+// would.
+func (handler *Handler) checkKeyPasswords(ctx context.Context, w http.ResponseWriter, r *http.Request, project *uplink.Project, access *uplink.Access, bucket string, keys []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	checked := map[string]bool{}
+	for _, key := range keys {
+		dir := key[:strings.LastIndexByte(key, '/')+1]
+		if checked[dir] {
+			continue
+		}
+		checked[dir] = true
+		if err := handler.checkPassword(ctx, w, r, project, &parsedRequest{access: access, bucket: bucket, realKey: key}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statKeys stats all of the keys concurrently, with at most
+// existsConcurrency stats in flight.
+func (handler *Handler) statKeys(ctx context.Context, project *uplink.Project, bucket string, keys []string) (_ map[string]existsResult, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	concurrency := handler.existsConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]existsResult, len(keys))
+	limiter := make(chan struct{}, concurrency)
+
+	group, ctx := errgroup.WithContext(ctx)
+	for _, key := range keys {
+		key := key
+		// password objects don't exist as far as shared links go.
+		if handler.hiddenKey(key) {
+			mu.Lock()
+			results[key] = existsResult{}
+			mu.Unlock()
+			continue
+		}
+		limiter <- struct{}{}
+		group.Go(func() error {
+			defer func() { <-limiter }()
+
+			var result existsResult
+			o, err := project.StatObject(ctx, bucket, key)
+			switch {
+			case err == nil:
+				result = existsResult{Exists: true, Size: o.System.ContentLength}
+			case errors.Is(err, uplink.ErrObjectNotFound):
+			default:
+				return WithAction(err, "stat object")
+			}
+
+			mu.Lock()
+			results[key] = result
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	return results, group.Wait()
+}
@@ -0,0 +1,89 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+
+	"github.com/zeebo/errs"
+)
+
+// HostingFallback is a static map of hosts to the access and root their TXT
+// records would give, for the hosting service to fall back on when a host's
+// records can't be looked up. Live TXT records always take precedence.
+//
+// The map is loaded from a JSON file of the form
+//
+//	{"www.example.com": {"access": "<access grant or key>", "root": "bucket/prefix"}}
+//
+// and can be reloaded at runtime. It is safe for concurrent use.
+type HostingFallback struct {
+	path string
+
+	mu    sync.RWMutex
+	hosts map[string]hostingFallbackEntry
+}
+
+type hostingFallbackEntry struct {
+	Access string `json:"access"`
+	Root   string `json:"root"`
+}
+
+// LoadHostingFallback loads the fallback map from the JSON file at path.
+func LoadHostingFallback(path string) (*HostingFallback, error) {
+	fallback := &HostingFallback{path: path}
+	if err := fallback.Reload(); err != nil {
+		return nil, err
+	}
+	return fallback, nil
+}
+
+// Reload reads the file again, replacing the map. The current map is kept if
+// the file can't be read or is invalid.
+func (fallback *HostingFallback) Reload() error {
+	data, err := ioutil.ReadFile(fallback.path)
+	if err != nil {
+		return errs.New("unable to read hosting fallback: %v", err)
+	}
+
+	var entries map[string]hostingFallbackEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return errs.New("invalid hosting fallback: %v", err)
+	}
+
+	hosts := make(map[string]hostingFallbackEntry, len(entries))
+	for host, entry := range entries {
+		normalized, err := normalizeHost(host)
+		if err != nil {
+			return errs.New("invalid hosting fallback: %v", err)
+		}
+		if entry.Access == "" {
+			return errs.New("invalid hosting fallback: no access for host %q", host)
+		}
+		if err := validateHostingRoot(entry.Root); err != nil {
+			return errs.New("invalid hosting fallback for host %q: %v", host, err)
+		}
+		hosts[normalized] = entry
+	}
+
+	fallback.mu.Lock()
+	fallback.hosts = hosts
+	fallback.mu.Unlock()
+	return nil
+}
+
+// lookup returns the serialized access and root for host, if it has any.
+// It is safe to call on a nil fallback.
+func (fallback *HostingFallback) lookup(host string) (access, root string, ok bool) {
+	if fallback == nil {
+		return "", "", false
+	}
+	fallback.mu.RLock()
+	defer fallback.mu.RUnlock()
+
+	entry, ok := fallback.hosts[host]
+	return entry.Access, entry.Root, ok
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+)
+
+func TestHostingFallback(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	serialized, err := newTestAccess(t).Serialize()
+	require.NoError(t, err)
+
+	path := filepath.Join(ctx.Dir(), "fallback.json")
+	write := func(content string) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	write(fmt.Sprintf(`{"WWW.Example.com": {"access": %q, "root": "site/www"}}`, serialized))
+	fallback, err := LoadHostingFallback(path)
+	require.NoError(t, err)
+
+	access, root, ok := fallback.lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, serialized, access)
+	require.Equal(t, "site/www", root)
+
+	_, _, ok = fallback.lookup("other.example.com")
+	require.False(t, ok)
+
+	// an invalid file keeps the current map.
+	write(`{"www.example.com": {"access": "x", "root": "sj://site"}}`)
+	require.Error(t, fallback.Reload())
+	_, _, ok = fallback.lookup("www.example.com")
+	require.True(t, ok)
+
+	write(fmt.Sprintf(`{"other.example.com": {"access": %q, "root": "other"}}`, serialized))
+	require.NoError(t, fallback.Reload())
+	_, _, ok = fallback.lookup("www.example.com")
+	require.False(t, ok)
+	_, root, ok = fallback.lookup("other.example.com")
+	require.True(t, ok)
+	require.Equal(t, "other", root)
+
+	_, _, ok = (*HostingFallback)(nil).lookup("other.example.com")
+	require.False(t, ok)
+}
+
+func TestFetchAccessForHostFallback(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	// a closed port, so DNS lookups fail right away.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dnsAddr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	client, err := NewDNSClient([]string{dnsAddr}, time.Second)
+	require.NoError(t, err)
+
+	serialized, err := newTestAccess(t).Serialize()
+	require.NoError(t, err)
+	path := filepath.Join(ctx.Dir(), "fallback.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(fmt.Sprintf(`{"www.example.com": {"access": %q, "root": "site"}}`, serialized)), 0644))
+	fallback, err := LoadHostingFallback(path)
+	require.NoError(t, err)
+
+	records := newTxtRecords(time.Hour, client, AuthServiceConfig{}, fallback)
+
+	access, root, options, err := records.fetchAccessForHost(ctx, "www.example.com")
+	require.NoError(t, err)
+	require.NotNil(t, access)
+	require.Equal(t, "site", root)
+	require.True(t, options.listing)
+
+	_, _, _, err = records.fetchAccessForHost(ctx, "other.example.com")
+	require.Error(t, err)
+
+	// a server failure is a failed lookup too.
+	client, err = NewDNSClient([]string{serveDNS(t, ctx, dns.RcodeServerFailure, "")}, time.Second)
+	require.NoError(t, err)
+	records = newTxtRecords(time.Hour, client, AuthServiceConfig{}, fallback)
+
+	_, root, _, err = records.fetchAccessForHost(ctx, "www.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "site", root)
+
+	// records that resolve, but are invalid, don't fall back.
+	for _, txt := range []string{"storj-root:bucket", "storj-root:sj://bucket"} {
+		client, err = NewDNSClient([]string{serveDNS(t, ctx, dns.RcodeSuccess, txt)}, time.Second)
+		require.NoError(t, err)
+		records = newTxtRecords(time.Hour, client, AuthServiceConfig{}, fallback)
+
+		_, _, _, err = records.fetchAccessForHost(ctx, "www.example.com")
+		require.Error(t, err, txt)
+	}
+}
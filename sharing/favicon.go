@@ -0,0 +1,40 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"storj.io/uplink"
+)
+
+// faviconPath is where browsers look for a site's icon on every visit.
+const faviconPath = "/favicon.ico"
+
+// serveFavicon serves a hosted site's favicon.ico. Browsers ask every site
+// for one, so a missing icon gets a bare 404 instead of the prefix checks,
+// fallbacks and error page other missing objects go through.
+func (handler *Handler) serveFavicon(ctx context.Context, w http.ResponseWriter, r *http.Request, pr *parsedRequest, project *uplink.Project) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := handler.transformKey(ctx, pr); err != nil {
+		return err
+	}
+
+	o, err := project.StatObject(ctx, pr.bucket, pr.realKey)
+	if err == nil {
+		return handler.showObject(ctx, w, r, pr, project, o)
+	}
+	if !errors.Is(err, uplink.ErrObjectNotFound) {
+		return WithAction(err, "stat object - favicon")
+	}
+
+	handler.setErrorCacheControl(w)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusNotFound)
+	return nil
+}
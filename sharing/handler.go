@@ -7,17 +7,21 @@ import (
 	"context"
 	"errors"
 	"html/template"
+	"image"
 	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/spacemonkeygo/monkit/v3"
 	"github.com/zeebo/errs"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
+	"storj.io/common/memory"
 	"storj.io/common/rpc/rpcpool"
 	"storj.io/linksharing/objectmap"
 	"storj.io/uplink"
@@ -36,6 +40,10 @@ type pageData struct {
 	// must use an absolute url. this is the base url they are all based off
 	// of. automatically filled in by renderTemplate.
 	Base string
+
+	// LinkBase, when set, is the relative <base href> of pages served at a
+	// URL other than their own, so their relative links still resolve.
+	LinkBase string
 }
 
 // Config specifies the handler configuration.
@@ -55,19 +63,46 @@ type Config struct {
 	// TxtRecordTTL is the duration for which an entry in the txtRecordCache is valid.
 	TxtRecordTTL time.Duration
 
+	// HostingFallback, when set, gives the access and root for hosts whose
+	// TXT records can't be looked up.
+	HostingFallback *HostingFallback
+
 	// AuthServiceConfig contains configuration required to use the auth service to resolve
 	// access key ids into access grants.
 	AuthServiceConfig AuthServiceConfig
 
-	// DNS Server address, for TXT record lookup
-	DNSServer string
+	// DNSServers are the DNS server addresses for TXT record lookup, tried
+	// in order until one answers.
+	DNSServers []string
+
+	// DNSTimeout is how long a TXT record lookup waits for each DNS server.
+	DNSTimeout time.Duration
+
+	// RequestTimeout bounds the metadata operations of a request, like
+	// opening the project, stat-ing and listing objects, which are answered
+	// with 504 when they take longer. Downloads may exceed it. Zero means no
+	// timeout.
+	RequestTimeout time.Duration
 
 	// RedirectHTTPS enables redirection to https://.
 	RedirectHTTPS bool
 
+	// UseRequestScheme builds absolute URLs, like those of static assets and
+	// short links, with the scheme requests were made with rather than that
+	// of the first URL base, for mixed environments where the base's https
+	// isn't reachable. X-Forwarded-Proto is honored from TrustedProxies.
+	UseRequestScheme bool
+
 	// LandingRedirectTarget is the url to redirect empty requests to.
 	LandingRedirectTarget string
 
+	// HealthPath and ReadyPath are the paths health and readiness probes are
+	// answered at, for any host. Empty paths aren't answered. Readiness also
+	// checks the auth service is reachable when ReadyCheckAuthService is set.
+	HealthPath            string
+	ReadyPath             string
+	ReadyCheckAuthService bool
+
 	// uplink Config settings
 	Uplink *uplink.Config
 
@@ -76,6 +111,376 @@ type Config struct {
 
 	// UseQOSAndCC indicates if congestion control and QOS settings from BackgroundDialer should be used.
 	UseQosAndCC bool
+
+	// LocationWorkers is the number of concurrent geolocation lookups done
+	// when rendering an object's map. Defaults to 1 if unset.
+	LocationWorkers int
+
+	// LocationTimeout bounds the total time spent geolocating an object's
+	// pieces. Pieces not located in time are left off the map. Zero means
+	// no limit.
+	LocationTimeout time.Duration
+
+	// ExistsMaxKeys caps the number of keys in a single existence probe.
+	// Defaults to 100 if unset.
+	ExistsMaxKeys int
+
+	// ExistsConcurrency is the number of keys an existence probe stats
+	// concurrently. Defaults to 1 if unset.
+	ExistsConcurrency int
+
+	// TextViewDefault makes a plain ?view on text objects render the
+	// enhanced text view instead of the raw object. ?view=text and ?view=raw
+	// pick one explicitly regardless.
+	TextViewDefault bool
+
+	// TextViewMaxSize is the most bytes of an object the text view will
+	// show. Defaults to 1 MiB if unset.
+	TextViewMaxSize int64
+
+	// PDFViewDefault makes a plain ?view on PDFs render them embedded in
+	// the site template with a download button, instead of the raw object.
+	// ?view=pdf and ?view=raw pick one explicitly regardless.
+	PDFViewDefault bool
+
+	// MarkdownViewDefault makes a plain ?view on Markdown objects render
+	// them as HTML within the site template, instead of the raw object.
+	// ?view=markdown and ?view=raw pick one explicitly regardless.
+	MarkdownViewDefault bool
+
+	// CORSAllowedOrigins are the origins allowed to fetch objects
+	// cross-origin. "*" allows any origin. Objects can override this with
+	// the cors-allowed-origins custom metadata key, except for OPTIONS
+	// preflights, which are answered without looking objects up.
+	CORSAllowedOrigins []string
+
+	// StripQueryParams drops query parameters that aren't in
+	// QueryParamAllowlist before handling a request, so that things like
+	// tracking parameters don't fragment caches.
+	StripQueryParams bool
+
+	// QueryParamAllowlist lists the query parameters kept when
+	// StripQueryParams is set. Defaults to the parameters the handler
+	// understands.
+	QueryParamAllowlist []string
+
+	// SecurityTXT is served at /.well-known/security.txt on our domains.
+	// If empty, that path 404s.
+	SecurityTXT string
+
+	// MaxKeyLength is the longest object key, in bytes, a request may ask
+	// for. Defaults to 4096 if unset.
+	MaxKeyLength int
+
+	// MaxKeyDepth is the most slash separated segments an object key a
+	// request asks for may have. Defaults to 256 if unset.
+	MaxKeyDepth int
+
+	// DownloadConfirmation shows an interstitial page with the object size
+	// before downloads of objects at least DownloadConfirmationMinSize
+	// bytes. Adding ?confirm=1 to the download skips it.
+	DownloadConfirmation bool
+
+	// DownloadConfirmationMinSize is the smallest object that needs download
+	// confirmation.
+	DownloadConfirmationMinSize int64
+
+	// CollapseSlashes collapses consecutive slashes in request paths before
+	// they are turned into object keys, so /a//b refers to the key a/b. By
+	// default paths are used exactly, which is the only way to reach keys
+	// that start with or contain consecutive slashes.
+	CollapseSlashes bool
+
+	// TrailingSlashRedirect is how prefixes requested without their
+	// trailing slash are redirected to it: "see-other", the default, with a
+	// 303, "permanent" with a 301, "temporary" with a 302, or "off" to list
+	// them in place.
+	TrailingSlashRedirect string
+
+	// ForceDownload lists file extensions (".svg") and media types
+	// ("image/svg+xml") that traditional link sharing always serves as
+	// attachments, even when viewed. Use it to keep active content like HTML
+	// from running on our own domains.
+	ForceDownload []string
+
+	// HostingForceDownload is like ForceDownload, but for the hosting
+	// service.
+	HostingForceDownload []string
+
+	// ListPageSize is the maximum number of entries in one page of a prefix
+	// listing, HTML or JSON. Defaults to 1000.
+	ListPageSize int
+
+	// MaxListSize is the maximum number of entries a page of an HTML prefix
+	// listing collects, bounding the memory of a request. Pages cut short by
+	// it end with a notice, and continue on the next page. Defaults to 1000.
+	MaxListSize int
+
+	// RelativeListingURLs makes the breadcrumbs of HTML prefix listings link
+	// relative to the listing instead of with absolute paths, for proxies
+	// that serve linksharing under a different path. Entries always link
+	// relatively.
+	RelativeListingURLs bool
+
+	// PresignSecretKey enables S3 style pre-signed URLs, validated against
+	// this secret key. Pre-signed URLs are disabled when empty.
+	PresignSecretKey string
+
+	// PasswordProtection lets shared links be protected with a password, by
+	// a .password object in the prefix they share, or one above it. Requests
+	// need HTTP basic auth credentials matching a line of it, a password or
+	// user:password, where passwords may be bcrypt hashes. Password objects
+	// are never served or listed. Only those in the bucket root and in the
+	// nearest 7 prefixes of a key protect it, and they are cached for 30
+	// seconds.
+	PasswordProtection bool
+
+	// TransformSigningKey and SignedTransforms make the listed expensive
+	// transformations, any of archive, preview and transform, require a
+	// ?sig signed with the key by SignTransform. Unsigned requests get 403.
+	TransformSigningKey string
+	SignedTransforms    []string
+
+	// Transforms are the content transformations objects can be served
+	// through with ?transform, by name. BuiltinTransforms has some to start
+	// with. None are enabled by default.
+	Transforms map[string]Transform
+
+	// HostingRootListing lists the root of hosted sites without an
+	// index.html or landing page, instead of serving a 404. Sites can still
+	// opt out with storj-listing:off.
+	HostingRootListing bool
+
+	// HostingNoindexListings sends X-Robots-Tag: noindex with the listings
+	// hosted sites generate, so search engines only index their content.
+	HostingNoindexListings bool
+
+	// SPARoutePattern is a regular expression matching request paths that
+	// sites with storj-spa:on treat as app routes even though they have a
+	// file extension, like ^/users/. Paths without an extension always are.
+	SPARoutePattern string
+
+	// SPAFallbackDocument is the document, like /index.html, every hosted
+	// site serves with a 200 for app routes that don't exist, instead of a
+	// listing or 404, as if it had storj-spa:on. Sites with storj-spa:on
+	// serve their /index.html regardless.
+	SPAFallbackDocument string
+
+	// EgressExporter, when set, receives egress totals per shared access
+	// and hosted domain every EgressExportInterval. Defaults to a minute.
+	EgressExporter       EgressExporter
+	EgressExportInterval time.Duration
+
+	// PageViewRecorder, when set, receives page views of hosted sites,
+	// sampled at PageViewSampleRate, between 0 and 1, which defaults to 1.
+	// Visitors are only identified by country unless PageViewClientIPs is
+	// set.
+	PageViewRecorder   PageViewRecorder
+	PageViewSampleRate float64
+	PageViewClientIPs  bool
+
+	// ChecksumVerification enables ?verify=sha256:<hex>, which hashes
+	// objects as they are served and reports whether they matched in an
+	// X-Verify-Result trailer. It is off by default for the CPU cost.
+	ChecksumVerification bool
+
+	// BodyCache, when set, caches the bodies of objects up to
+	// BodyCacheMaxObjectSize bytes for BodyCacheTTL. The size defaults to
+	// 1 MiB and the TTL to 5 minutes.
+	BodyCache              BodyCache
+	BodyCacheMaxObjectSize int64
+	BodyCacheTTL           time.Duration
+
+	// StaleIfError, when set, adds a stale-if-error directive for that long
+	// to object responses, so CDNs keep serving them through outages. With
+	// a BodyCache, bodies are also kept that much past their TTL, and served
+	// when looking the object up fails.
+	StaleIfError time.Duration
+
+	// RangeCoalesceWindow, when set, enables coalescing of small range
+	// requests: a range smaller than RangeCoalesceSize is served from a read
+	// of RangeCoalesceSize bytes starting at it, which later requests by the
+	// same client on the same connection within the window are served from
+	// too. Clients behind TrustedProxies are told apart by their forwarded
+	// addresses. The size defaults to 256 KiB.
+	RangeCoalesceWindow time.Duration
+	RangeCoalesceSize   int64
+
+	// ProjectCacheSize, when set, keeps up to that many opened projects,
+	// keyed by access, for reuse by later requests for the same share.
+	// Cached projects are reopened after ProjectCacheTTL, which defaults to
+	// 5 minutes.
+	ProjectCacheSize int
+	ProjectCacheTTL  time.Duration
+
+	// ShortLinks, when set, enables short links for sharing through QR
+	// codes. They are created by POSTing a JSON link to /q/ with
+	// ShortLinkToken as a bearer token, and served under /q/<id>. Links
+	// expire after the ttl they were created with, or ShortLinkTTL, and never
+	// later than ShortLinkMaxTTL. Zero durations mean no expiry.
+	ShortLinks      ShortLinkStore
+	ShortLinkToken  string
+	ShortLinkTTL    time.Duration
+	ShortLinkMaxTTL time.Duration
+
+	// DownloadBufferSize is the size of the buffer objects are copied to
+	// responses with. Larger buffers help throughput on high latency links,
+	// smaller ones save memory with many concurrent downloads. Defaults to
+	// 32 KiB, the size io.Copy uses.
+	DownloadBufferSize int
+
+	// LogRequests logs one line per request, with its method, host, path,
+	// status, size and duration, and the id from its X-Request-ID header or
+	// a generated one, which is echoed back either way. Accesses and short
+	// link ids in paths are never logged, whatever the host.
+	LogRequests bool
+
+	// Metrics, when set, collects HTTP level metrics of requests, which it
+	// serves in the Prometheus text format.
+	Metrics *Metrics
+
+	// MaxConcurrentDownloads, when set, limits how many objects are
+	// downloaded at once. Downloads over the limit wait, ordered by
+	// DownloadPriorityPolicy: "size", the default, lets downloads of up to
+	// SmallDownloadSize bytes (1 MiB by default) go before larger ones, and
+	// "fifo" keeps them in order. Larger downloads waiting for
+	// LargeDownloadMaxWait (5 seconds by default) go first regardless.
+	MaxConcurrentDownloads int
+	DownloadPriorityPolicy string
+	SmallDownloadSize      int64
+	LargeDownloadMaxWait   time.Duration
+
+	// BandwidthLimit, when set, throttles responses for shared accesses and
+	// hosted sites to that many bytes per second, whatever they serve:
+	// objects, archives, views or transformations. BandwidthLimitScope is
+	// what shares a limit: "access", the default, makes all responses for
+	// the same shared access or hosted site share one, and "request" gives
+	// each request its own. BandwidthBurst is how many bytes may go out at
+	// once after a pause, and defaults to a second's worth. Range requests
+	// are throttled like any other response.
+	BandwidthLimit      int64
+	BandwidthLimitScope string
+	BandwidthBurst      int64
+
+	// AbortShortReads aborts the connection when an object's download ends
+	// before its content length, rather than ending the response early.
+	// Responses without a Content-Length, like those of encoded objects,
+	// would otherwise look complete to clients. Short downloads are logged
+	// either way.
+	AbortShortReads bool
+
+	// FullRangeAsOK answers a single range covering the whole object, like
+	// bytes=0-, with a 200 rather than a 206, for clients that mishandle
+	// partial responses. Other ranges are always answered with a 206.
+	FullRangeAsOK bool
+
+	// TransformMemoryLimit caps how many bytes of an object a transformation
+	// like the text view may buffer per request. Objects over it are served
+	// as is, or rejected with 413 when TransformRejectOversize is set. Zero
+	// means no limit.
+	TransformMemoryLimit    int64
+	TransformRejectOversize bool
+
+	// HostingTraditionalPaths lets hosted domains also serve /s/ and /raw/
+	// link sharing URLs whose first segment is an access grant, instead of
+	// looking those paths up in the site's bucket.
+	HostingTraditionalPaths bool
+
+	// TrustedProxies are the CIDRs of proxies whose X-Forwarded-For headers
+	// are trusted when determining client IPs.
+	TrustedProxies []string
+
+	// ClientCountryHeader sets an X-Client-Country header on responses with
+	// the ISO code of the country the client IP geolocates to. It's off by
+	// default, as it exposes the geolocation to anything downstream.
+	ClientCountryHeader bool
+
+	// DebugHeaders exposes the metadata of served objects in X-Object-Created,
+	// X-Object-Modified, X-Object-Expires and X-Object-Meta-* headers, for
+	// developers and support. It's off by default, as the custom metadata
+	// may not be meant for everyone with the link.
+	DebugHeaders bool
+
+	// BotUserAgents are case insensitive User-Agent substrings identifying
+	// crawlers and link preview bots, which skip piece location lookups.
+	BotUserAgents []string
+
+	// ArchiveMaxObjects and ArchiveMaxBytes cap the number of objects and
+	// their total size in a ?archive= download. Prefixes over either cap are
+	// rejected with 413 before anything is streamed. Zero means no limit.
+	ArchiveMaxObjects int
+	ArchiveMaxBytes   int64
+
+	// ArchiveReproducible makes archiving an unchanged prefix produce the
+	// same bytes every time: entries are ordered by key, and their times are
+	// the objects' creation times in UTC, in whole seconds. Sorting holds the
+	// prefix' listing in memory, which ArchiveMaxObjects bounds, so it
+	// needs a positive ArchiveMaxObjects.
+	ArchiveReproducible bool
+
+	// CacheKeyHeader, when set, is a response header carrying a hash of
+	// CacheKeyParts for served objects, for CDNs that key their caches on a
+	// header. The parts are any of bucket, key, created and size, and default
+	// to all of them.
+	CacheKeyHeader string
+	CacheKeyParts  []string
+
+	// GzipDecompression serves objects stored gzip compressed, as marked by
+	// their content-encoding metadata, with Content-Encoding: gzip to clients
+	// accepting it and decompressed on the fly to others. It is off by
+	// default for the CPU cost.
+	GzipDecompression bool
+
+	// CompressResponses compresses HTML pages, listings and text objects
+	// with gzip, or deflate for clients accepting only that. Objects stored
+	// with a Content-Encoding and partial responses are never compressed.
+	CompressResponses bool
+
+	// KeyTransformer maps requested object keys to the keys fetched.
+	// Defaults to NoopKeyTransformer.
+	KeyTransformer KeyTransformer
+
+	// ScopeAuditLevel, when set, logs the buckets and prefixes each
+	// request's API key is limited to by its caveats at that level, like
+	// "debug". The access itself is never logged.
+	ScopeAuditLevel string
+
+	// ErrorCacheControl is the Cache-Control header of error responses,
+	// including the 404.html pages of hosted sites. Keep it short, so objects
+	// that were just uploaded show up soon, while repeated misses are still
+	// absorbed by caches. Empty leaves the header unset.
+	ErrorCacheControl string
+
+	// StrictDisplayFlags rejects requests combining ?view with ?download or
+	// ?wrap with 400, instead of resolving them by precedence.
+	StrictDisplayFlags bool
+
+	// Watermark, when set, is drawn over JPEG, PNG and GIF images served
+	// with ?preview, while downloads still get the original. Images over
+	// WatermarkMaxSize bytes are rejected with 413. The size defaults to
+	// 20 MiB. Watermarked images are cached in the BodyCache, if any.
+	Watermark        image.Image
+	WatermarkMaxSize int64
+
+	// AllowedSatellites limits which satellites accesses may be for, by node
+	// URL (id@host:port), host and port, or node id. Accesses for other
+	// satellites, including ones resolved through the auth service, are
+	// rejected. Any satellite is allowed when empty.
+	AllowedSatellites []string
+
+	// ArchiveCache, when set, caches generated ?archive= downloads of up to
+	// ArchiveCacheMaxSize bytes of objects for ArchiveCacheTTL, keyed by a
+	// hash of the prefix' listing so changes to it are never served stale.
+	// The size defaults to 10 MiB and the TTL to 5 minutes.
+	ArchiveCache        BodyCache
+	ArchiveCacheMaxSize int64
+	ArchiveCacheTTL     time.Duration
+
+	// MaxConcurrentArchives, when set, limits how many ?archive= downloads
+	// are generated at once. Requests over the limit are answered with 503
+	// and a Retry-After of ArchiveRetryAfter, which defaults to 30 seconds.
+	MaxConcurrentArchives int
+	ArchiveRetryAfter     time.Duration
 }
 
 // ConnectionPoolConfig is a config struct for configuring RPC connection pool options.
@@ -99,11 +504,143 @@ type Handler struct {
 	redirectHTTPS   bool
 	landingRedirect string
 	uplink          *uplink.Config
+	locationWorkers int
+	locationTimeout time.Duration
+	requestTimeout  time.Duration
+
+	healthPath            string
+	readyPath             string
+	readyCheckAuthService bool
+
+	existsMaxKeys     int
+	existsConcurrency int
+
+	textViewDefault bool
+	textViewMaxSize int64
+
+	pdfViewDefault      bool
+	markdownViewDefault bool
+
+	corsAllowedOrigins []string
+
+	queryAllowlist map[string]bool
+
+	securityTXT string
+
+	maxKeyLength int
+	maxKeyDepth  int
+
+	downloadConfirmation        bool
+	downloadConfirmationMinSize int64
+
+	collapseSlashes       bool
+	trailingSlashRedirect int
+
+	forceDownload        typeSet
+	hostingForceDownload typeSet
+
+	listPageSize        int
+	maxListSize         int
+	relativeListingURLs bool
+
+	presignSecretKey string
+
+	passwordProtection bool
+	passwordCache      *ttlCache
+
+	transformSigningKey string
+	signedTransforms    map[string]bool
+	transforms          map[string]Transform
+
+	hostingRootListing     bool
+	hostingNoindexListings bool
+	spaRoutePattern        *regexp.Regexp
+	spaFallbackDocument    string
+
+	egress               *egressTally
+	egressExporter       EgressExporter
+	egressExportInterval time.Duration
+
+	pageViewRecorder   PageViewRecorder
+	pageViewSampleRate float64
+	pageViewClientIPs  bool
+
+	checksumVerification bool
+
+	bodyCache              BodyCache
+	bodyCacheMaxObjectSize int64
+	bodyCacheTTL           time.Duration
+	staleIfError           time.Duration
+
+	rangeCoalescer  *rangeCoalescer
+	downloadBuffers *downloadBuffers
+	abortShortReads bool
+	fullRangeAsOK   bool
+
+	downloadLimiter        *downloadLimiter
+	downloadPriorityPolicy string
+	smallDownloadSize      int64
+
+	bandwidthLimiter *bandwidthLimiter
+
+	projectCache *projectCache
+
+	logRequests bool
+	metrics     *Metrics
+
+	shortLinks      ShortLinkStore
+	shortLinkToken  string
+	shortLinkTTL    time.Duration
+	shortLinkMaxTTL time.Duration
+
+	transformMemoryLimit    int64
+	transformRejectOversize bool
+
+	hostingTraditionalPaths bool
+
+	trustedProxies      []*net.IPNet
+	useRequestScheme    bool
+	clientCountryHeader bool
+	debugHeaders        bool
+
+	botUserAgents []string
+
+	archiveMaxObjects   int
+	archiveMaxBytes     int64
+	archiveReproducible bool
+
+	cacheKeyHeader string
+	cacheKeyParts  []string
+
+	gzipDecompression bool
+	compressResponses bool
+
+	keyTransformer KeyTransformer
+
+	scopeAudit      bool
+	scopeAuditLevel zapcore.Level
+
+	errorCacheControl string
+
+	strictDisplayFlags bool
+
+	watermark        image.Image
+	watermarkMaxSize int64
+
+	allowedSatellites []string
+
+	archiveCache        BodyCache
+	archiveCacheMaxSize int64
+	archiveCacheTTL     time.Duration
+	archiveLocks        MutexGroup
+
+	archiveLimiter    chan struct{}
+	archiveRetryAfter time.Duration
 }
 
 // NewHandler creates a new link sharing HTTP handler.
 func NewHandler(log *zap.Logger, mapper *objectmap.IPDB, config Config) (*Handler, error) {
-	dns, err := NewDNSClient(config.DNSServer)
+	dns, err := NewDNSClient(config.DNSServers, config.DNSTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -140,17 +677,318 @@ func NewHandler(log *zap.Logger, mapper *objectmap.IPDB, config Config) (*Handle
 		return nil, err
 	}
 
+	if config.ExistsMaxKeys <= 0 {
+		config.ExistsMaxKeys = 100
+	}
+	if config.MaxKeyLength <= 0 {
+		config.MaxKeyLength = 4096
+	}
+	if config.MaxKeyDepth <= 0 {
+		config.MaxKeyDepth = 256
+	}
+	if config.BodyCacheMaxObjectSize <= 0 {
+		config.BodyCacheMaxObjectSize = memory.MiB.Int64()
+	}
+	if config.BodyCacheTTL <= 0 {
+		config.BodyCacheTTL = 5 * time.Minute
+	}
+	if config.RangeCoalesceSize <= 0 {
+		config.RangeCoalesceSize = 256 * memory.KiB.Int64()
+	}
+	var rangeCoalescer *rangeCoalescer
+	if config.RangeCoalesceWindow > 0 {
+		rangeCoalescer = newRangeCoalescer(config.RangeCoalesceWindow, config.RangeCoalesceSize)
+	}
+	var downloadBuffers *downloadBuffers
+	if config.DownloadBufferSize > 0 {
+		downloadBuffers = newDownloadBuffers(config.DownloadBufferSize)
+	}
+	if config.TrailingSlashRedirect == "" {
+		config.TrailingSlashRedirect = "see-other"
+	}
+	trailingSlashRedirect, ok := trailingSlashRedirects[config.TrailingSlashRedirect]
+	if !ok {
+		return nil, errs.New("invalid trailing slash redirect %q", config.TrailingSlashRedirect)
+	}
+	if config.DownloadPriorityPolicy == "" {
+		config.DownloadPriorityPolicy = "size"
+	}
+	if !downloadPriorityPolicies[config.DownloadPriorityPolicy] {
+		return nil, errs.New("invalid download priority policy %q", config.DownloadPriorityPolicy)
+	}
+	if config.SmallDownloadSize <= 0 {
+		config.SmallDownloadSize = memory.MiB.Int64()
+	}
+	if config.LargeDownloadMaxWait <= 0 {
+		config.LargeDownloadMaxWait = 5 * time.Second
+	}
+	var downloadLimiter *downloadLimiter
+	if config.MaxConcurrentDownloads > 0 {
+		downloadLimiter = newDownloadLimiter(config.MaxConcurrentDownloads, config.LargeDownloadMaxWait)
+	}
+	if config.BandwidthLimitScope == "" {
+		config.BandwidthLimitScope = "access"
+	}
+	if !bandwidthLimitScopes[config.BandwidthLimitScope] {
+		return nil, errs.New("invalid bandwidth limit scope %q", config.BandwidthLimitScope)
+	}
+	var bandwidthLimiter *bandwidthLimiter
+	if config.BandwidthLimit > 0 {
+		if config.BandwidthBurst <= 0 {
+			config.BandwidthBurst = config.BandwidthLimit
+		}
+		bandwidthLimiter = newBandwidthLimiter(config.BandwidthLimit, config.BandwidthBurst, config.BandwidthLimitScope)
+	}
+	if config.ProjectCacheTTL <= 0 {
+		config.ProjectCacheTTL = 5 * time.Minute
+	}
+	var passwordCache *ttlCache
+	if config.PasswordProtection {
+		passwordCache = newTTLCache(passwordCacheSize)
+	}
+	var projectCache *projectCache
+	if config.ProjectCacheSize > 0 {
+		projectCache = newProjectCache(config.ProjectCacheSize, config.ProjectCacheTTL, func(project *uplink.Project) {
+			closeProject(log, project)
+		})
+	}
+	if config.EgressExportInterval <= 0 {
+		config.EgressExportInterval = time.Minute
+	}
+	if config.PageViewSampleRate <= 0 || config.PageViewSampleRate > 1 {
+		config.PageViewSampleRate = 1
+	}
+	if config.ListPageSize <= 0 {
+		config.ListPageSize = 1000
+	}
+	if config.MaxListSize <= 0 {
+		config.MaxListSize = 1000
+	}
+	if config.KeyTransformer == nil {
+		config.KeyTransformer = NoopKeyTransformer{}
+	}
+	if config.ArchiveReproducible && config.ArchiveMaxObjects <= 0 {
+		return nil, errs.New("reproducible archives need a maximum number of archive objects")
+	}
+	if config.ArchiveCacheMaxSize <= 0 {
+		config.ArchiveCacheMaxSize = 10 * memory.MiB.Int64()
+	}
+	if config.ArchiveCacheTTL <= 0 {
+		config.ArchiveCacheTTL = 5 * time.Minute
+	}
+	if config.ArchiveRetryAfter <= 0 {
+		config.ArchiveRetryAfter = 30 * time.Second
+	}
+	var archiveLimiter chan struct{}
+	if config.MaxConcurrentArchives > 0 {
+		archiveLimiter = make(chan struct{}, config.MaxConcurrentArchives)
+	}
+	if config.WatermarkMaxSize <= 0 {
+		config.WatermarkMaxSize = 20 * memory.MiB.Int64()
+	}
+	if config.TextViewMaxSize <= 0 {
+		config.TextViewMaxSize = memory.MiB.Int64()
+	}
+
+	var spaRoutePattern *regexp.Regexp
+	if config.SPARoutePattern != "" {
+		spaRoutePattern, err = regexp.Compile(config.SPARoutePattern)
+		if err != nil {
+			return nil, errs.New("invalid SPA route pattern: %v", err)
+		}
+	}
+
+	spaFallbackDocument := config.SPAFallbackDocument
+	if spaFallbackDocument != "" && !strings.HasPrefix(spaFallbackDocument, "/") {
+		spaFallbackDocument = "/" + spaFallbackDocument
+	}
+
+	signedTransforms, err := parseSignedTransforms(config.SignedTransforms)
+	if err != nil {
+		return nil, err
+	}
+	if len(signedTransforms) > 0 && config.TransformSigningKey == "" {
+		return nil, errs.New("signed transforms need a signing key")
+	}
+
+	trustedProxies, err := parseCIDRs(config.TrustedProxies)
+	if err != nil {
+		return nil, errs.New("invalid trusted proxies: %v", err)
+	}
+
+	botUserAgents := make([]string, 0, len(config.BotUserAgents))
+	for _, pattern := range config.BotUserAgents {
+		if pattern = strings.ToLower(strings.TrimSpace(pattern)); pattern != "" {
+			botUserAgents = append(botUserAgents, pattern)
+		}
+	}
+
+	cacheKeyParts, err := parseCacheKeyParts(config.CacheKeyParts)
+	if err != nil {
+		return nil, err
+	}
+
+	var scopeAuditLevel zapcore.Level
+	if config.ScopeAuditLevel != "" {
+		if err := scopeAuditLevel.UnmarshalText([]byte(config.ScopeAuditLevel)); err != nil {
+			return nil, errs.New("invalid scope audit level: %v", err)
+		}
+	}
+
+	var queryAllowlist map[string]bool
+	if config.StripQueryParams {
+		allowed := config.QueryParamAllowlist
+		if len(allowed) == 0 {
+			allowed = defaultQueryParams
+		}
+		queryAllowlist = make(map[string]bool, len(allowed))
+		for _, name := range allowed {
+			queryAllowlist[name] = true
+		}
+	}
+
 	return &Handler{
 		log:             log,
 		urlBases:        bases,
 		templates:       templates,
 		mapper:          mapper,
-		txtRecords:      newTxtRecords(config.TxtRecordTTL, dns, config.AuthServiceConfig),
+		txtRecords:      newTxtRecords(config.TxtRecordTTL, dns, config.AuthServiceConfig, config.HostingFallback),
 		authConfig:      config.AuthServiceConfig,
 		static:          http.StripPrefix("/static/", http.FileServer(http.Dir(config.StaticSourcesPath))),
 		landingRedirect: config.LandingRedirectTarget,
 		redirectHTTPS:   config.RedirectHTTPS,
 		uplink:          uplinkConfig,
+		locationWorkers: config.LocationWorkers,
+		locationTimeout: config.LocationTimeout,
+		requestTimeout:  config.RequestTimeout,
+
+		healthPath:            config.HealthPath,
+		readyPath:             config.ReadyPath,
+		readyCheckAuthService: config.ReadyCheckAuthService,
+
+		existsMaxKeys:     config.ExistsMaxKeys,
+		existsConcurrency: config.ExistsConcurrency,
+
+		textViewDefault: config.TextViewDefault,
+		textViewMaxSize: config.TextViewMaxSize,
+
+		pdfViewDefault:      config.PDFViewDefault,
+		markdownViewDefault: config.MarkdownViewDefault,
+
+		corsAllowedOrigins: config.CORSAllowedOrigins,
+
+		queryAllowlist: queryAllowlist,
+
+		securityTXT: config.SecurityTXT,
+
+		maxKeyLength: config.MaxKeyLength,
+		maxKeyDepth:  config.MaxKeyDepth,
+
+		downloadConfirmation:        config.DownloadConfirmation,
+		downloadConfirmationMinSize: config.DownloadConfirmationMinSize,
+
+		collapseSlashes:       config.CollapseSlashes,
+		trailingSlashRedirect: trailingSlashRedirect,
+
+		forceDownload:        newTypeSet(config.ForceDownload),
+		hostingForceDownload: newTypeSet(config.HostingForceDownload),
+
+		listPageSize:        config.ListPageSize,
+		maxListSize:         config.MaxListSize,
+		relativeListingURLs: config.RelativeListingURLs,
+
+		presignSecretKey: config.PresignSecretKey,
+
+		passwordProtection: config.PasswordProtection,
+		passwordCache:      passwordCache,
+
+		transformSigningKey: config.TransformSigningKey,
+		signedTransforms:    signedTransforms,
+		transforms:          config.Transforms,
+
+		hostingRootListing:     config.HostingRootListing,
+		hostingNoindexListings: config.HostingNoindexListings,
+		spaRoutePattern:        spaRoutePattern,
+		spaFallbackDocument:    spaFallbackDocument,
+
+		egress:               newEgressTally(time.Now()),
+		egressExporter:       config.EgressExporter,
+		egressExportInterval: config.EgressExportInterval,
+
+		pageViewRecorder:   config.PageViewRecorder,
+		pageViewSampleRate: config.PageViewSampleRate,
+		pageViewClientIPs:  config.PageViewClientIPs,
+
+		checksumVerification: config.ChecksumVerification,
+
+		bodyCache:              config.BodyCache,
+		bodyCacheMaxObjectSize: config.BodyCacheMaxObjectSize,
+		bodyCacheTTL:           config.BodyCacheTTL,
+		staleIfError:           config.StaleIfError,
+
+		rangeCoalescer:  rangeCoalescer,
+		downloadBuffers: downloadBuffers,
+		abortShortReads: config.AbortShortReads,
+		fullRangeAsOK:   config.FullRangeAsOK,
+
+		downloadLimiter:        downloadLimiter,
+		downloadPriorityPolicy: config.DownloadPriorityPolicy,
+		smallDownloadSize:      config.SmallDownloadSize,
+
+		bandwidthLimiter: bandwidthLimiter,
+
+		projectCache: projectCache,
+
+		logRequests: config.LogRequests,
+		metrics:     config.Metrics,
+
+		shortLinks:      config.ShortLinks,
+		shortLinkToken:  config.ShortLinkToken,
+		shortLinkTTL:    config.ShortLinkTTL,
+		shortLinkMaxTTL: config.ShortLinkMaxTTL,
+
+		transformMemoryLimit:    config.TransformMemoryLimit,
+		transformRejectOversize: config.TransformRejectOversize,
+
+		hostingTraditionalPaths: config.HostingTraditionalPaths,
+
+		trustedProxies:      trustedProxies,
+		useRequestScheme:    config.UseRequestScheme,
+		clientCountryHeader: config.ClientCountryHeader,
+		debugHeaders:        config.DebugHeaders,
+
+		botUserAgents: botUserAgents,
+
+		archiveMaxObjects:   config.ArchiveMaxObjects,
+		archiveMaxBytes:     config.ArchiveMaxBytes,
+		archiveReproducible: config.ArchiveReproducible,
+
+		cacheKeyHeader: http.CanonicalHeaderKey(config.CacheKeyHeader),
+		cacheKeyParts:  cacheKeyParts,
+
+		gzipDecompression: config.GzipDecompression,
+		compressResponses: config.CompressResponses,
+
+		keyTransformer: config.KeyTransformer,
+
+		scopeAudit:      config.ScopeAuditLevel != "",
+		scopeAuditLevel: scopeAuditLevel,
+
+		errorCacheControl: config.ErrorCacheControl,
+
+		strictDisplayFlags: config.StrictDisplayFlags,
+
+		watermark:        config.Watermark,
+		watermarkMaxSize: config.WatermarkMaxSize,
+
+		allowedSatellites: config.AllowedSatellites,
+
+		archiveCache:        config.ArchiveCache,
+		archiveCacheMaxSize: config.ArchiveCacheMaxSize,
+		archiveCacheTTL:     config.ArchiveCacheTTL,
+
+		archiveLimiter:    archiveLimiter,
+		archiveRetryAfter: config.ArchiveRetryAfter,
 	}, nil
 }
 
@@ -159,6 +997,25 @@ func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
 
+	id := requestID(r)
+	w.Header().Set(requestIDHeader, id)
+
+	// probes are frequent, so they aren't logged, measured or tracked.
+	if handler.serveProbe(w, r) {
+		return
+	}
+
+	log := handler.log.With(zap.String("request_id", id))
+
+	w, logged := handler.logRequest(log, w, r)
+	defer logged()
+
+	w, recorded := handler.recordMetrics(w, r)
+	defer recorded()
+
+	w, done := handler.trackPageView(ctx, w, r)
+	defer done()
+
 	handlerErr := handler.serveHTTP(ctx, w, r)
 	if handlerErr == nil {
 		return
@@ -197,6 +1054,14 @@ func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		status = http.StatusTooManyRequests
 		message = "Oops! Rate limited due too many request."
 		skipLog = true
+	case errors.Is(handlerErr, errInvalidHostingRoot):
+		status = http.StatusBadGateway
+		message = "Oops! This site's storj-root TXT record is misconfigured. It should be a bucket name, optionally followed by a prefix, like storj-root:bucket/prefix."
+		skipLog = true
+	case errors.Is(handlerErr, errUntrustedSatellite):
+		status = http.StatusForbidden
+		message = "Oops! This access is for a satellite this service doesn't serve."
+		skipLog = true
 	case errors.Is(handlerErr, context.Canceled) && errors.Is(ctx.Err(), context.Canceled):
 		status = httpStatusClientClosedRequest
 		message = "Client closed request."
@@ -210,20 +1075,31 @@ func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case http.StatusNotFound:
 			message = "Not found."
 			skipLog = true
+		case http.StatusUnauthorized:
+			message = "This link is protected. Please sign in with its password."
+			skipLog = true
 		case http.StatusBadRequest, http.StatusMethodNotAllowed:
 			message = "Malformed request. Please try again."
 			skipLog = true
+		case http.StatusRequestEntityTooLarge:
+			message = "Oops! Too large to serve."
+			skipLog = true
+		case http.StatusServiceUnavailable:
+			message = "Oops! Too busy right now. Please try again later."
+			skipLog = true
+		case http.StatusGatewayTimeout:
+			message = "Oops! The network took too long to respond. Please try again later."
 		}
 	}
 
 	if !skipLog {
-		handler.log.Error("unable to handle request",
+		log.Error("unable to handle request",
 			zap.Error(handlerErr),
 			zap.String("action", action),
 			zap.Int("status_code", status),
 		)
 	} else {
-		handler.log.Debug(
+		log.Debug(
 			"unable to handle request",
 			zap.Error(handlerErr),
 			zap.String("action", action),
@@ -231,12 +1107,28 @@ func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		)
 	}
 
+	handler.setErrorCacheControl(w)
+
+	if q := r.URL.Query(); wantsJSON(q) || wantsJSONLines(q) {
+		_ = writeJSON(w, status, struct {
+			Error string `json:"error"`
+		}{Error: message})
+		return
+	}
+
 	w.WriteHeader(status)
-	handler.renderTemplate(w, "error.html", pageData{Data: message, Title: "Error"})
+	handler.renderTemplate(w, r, "error.html", pageData{Data: message, Title: "Error"})
 }
 
-func (handler *Handler) renderTemplate(w http.ResponseWriter, template string, data pageData) {
-	data.Base = strings.TrimSuffix(handler.urlBases[0].String(), "/")
+// setErrorCacheControl sets the Cache-Control header of an error response.
+func (handler *Handler) setErrorCacheControl(w http.ResponseWriter) {
+	if handler.errorCacheControl != "" {
+		w.Header().Set("Cache-Control", handler.errorCacheControl)
+	}
+}
+
+func (handler *Handler) renderTemplate(w http.ResponseWriter, r *http.Request, template string, data pageData) {
+	data.Base = strings.TrimSuffix(handler.urlBase(r).String(), "/")
 	err := handler.templates.ExecuteTemplate(w, template, data)
 	if err != nil {
 		handler.log.Error("error while executing template", zap.Error(err))
@@ -246,8 +1138,37 @@ func (handler *Handler) renderTemplate(w http.ResponseWriter, template string, d
 func (handler *Handler) serveHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	if r.Method != http.MethodHead && r.Method != http.MethodGet {
-		return WithStatus(errs.New("method not allowed"), http.StatusMethodNotAllowed)
+	ctx, stop := handler.withRequestTimeout(ctx)
+	defer stop()
+	defer func() {
+		if err != nil && requestTimedOut(ctx) {
+			err = WithStatus(WithAction(err, "request timeout"), http.StatusGatewayTimeout)
+		}
+	}()
+
+	if r.Method != http.MethodHead && r.Method != http.MethodGet && !handler.isShortLinkCreate(r) {
+		if r.Method == http.MethodOptions && len(handler.corsAllowedOrigins) > 0 {
+			handler.servePreflight(w, r)
+			return nil
+		}
+		return handler.methodNotAllowed(w)
+	}
+
+	w, compressed := handler.compressResponse(w, r)
+	defer compressed()
+
+	if handler.clientCountryHeader {
+		if country := handler.clientCountry(ctx, r); country != "" {
+			w.Header().Set("X-Client-Country", country)
+		}
+	}
+
+	if handler.queryAllowlist != nil {
+		r.URL.RawQuery = filterQuery(r.URL.RawQuery, handler.queryAllowlist)
+	}
+	if handler.collapseSlashes {
+		r.URL.Path = collapseSlashes(r.URL.Path)
+		r.URL.RawPath = ""
 	}
 
 	ourDomain, err := isDomainOurs(r.Host, handler.urlBases)
@@ -256,9 +1177,24 @@ func (handler *Handler) serveHTTP(ctx context.Context, w http.ResponseWriter, r
 	}
 
 	if !ourDomain {
+		// short links are only created on our own domains.
+		if r.Method == http.MethodPost {
+			return handler.methodNotAllowed(w)
+		}
 		return handler.handleHostingService(ctx, w, r)
 	}
 
+	if handler.shortLinks != nil && strings.HasPrefix(r.URL.Path, shortLinkPrefix) {
+		if r.Method == http.MethodPost {
+			return handler.createShortLink(ctx, w, r)
+		}
+		ctx, err = handler.resolveShortLink(ctx, r)
+		if err != nil {
+			return err
+		}
+		r = r.WithContext(ctx)
+	}
+
 	switch {
 	case handler.redirectHTTPS && r.URL.Scheme == "http":
 		u, err := url.ParseRequestURI(r.RequestURI)
@@ -273,6 +1209,12 @@ func (handler *Handler) serveHTTP(ctx context.Context, w http.ResponseWriter, r
 		return nil
 	case strings.HasPrefix(r.URL.Path, "/health/process"):
 		return handler.healthProcess(ctx, w, r)
+	case r.URL.Path == "/.well-known/security.txt":
+		return handler.serveSecurityTXT(ctx, w, r)
+	case strings.HasPrefix(r.URL.Path, "/exists/"):
+		return handler.handleExists(ctx, w, r)
+	case handler.presignSecretKey != "" && isPresigned(r):
+		return handler.handlePresigned(ctx, w, r)
 	case handler.landingRedirect != "" && (r.URL.Path == "" || r.URL.Path == "/"):
 		http.Redirect(w, r, handler.landingRedirect, http.StatusSeeOther)
 		return nil
@@ -287,6 +1229,16 @@ func (handler *Handler) healthProcess(ctx context.Context, w http.ResponseWriter
 	return err
 }
 
+func (handler *Handler) serveSecurityTXT(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	if handler.securityTXT == "" {
+		return WithStatus(errs.New("security.txt not configured"), http.StatusNotFound)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = w.Write([]byte(handler.securityTXT))
+	return err
+}
+
 func isDomainOurs(host string, bases []*url.URL) (bool, error) {
 	for _, base := range bases {
 		ours, err := compareHosts(host, base.Host)
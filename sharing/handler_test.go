@@ -4,9 +4,14 @@
 package sharing
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func TestCompareHosts(t *testing.T) {
@@ -35,3 +40,77 @@ func TestCompareHosts(t *testing.T) {
 		assert.False(t, result)
 	}
 }
+
+func TestSecurityTXT(t *testing.T) {
+	for _, test := range []struct {
+		securityTXT string
+		status      int
+	}{
+		{securityTXT: "Contact: mailto:security@test.test\n", status: http.StatusOK},
+		{securityTXT: "", status: http.StatusNotFound},
+	} {
+		handler, err := NewHandler(zap.NewNop(), nil, Config{
+			URLBases:    []string{"http://test.test"},
+			Templates:   "../web",
+			SecurityTXT: test.securityTXT,
+		})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://test.test/.well-known/security.txt", nil)
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, test.status, w.Code)
+		if test.status == http.StatusOK {
+			require.Equal(t, test.securityTXT, w.Body.String())
+		}
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://test.test/s/?format=json", nil)
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"error": "Malformed request. Please try again."}`, w.Body.String())
+}
+
+func TestErrorCacheControl(t *testing.T) {
+	for _, cacheControl := range []string{"", "max-age=30"} {
+		handler, err := NewHandler(zap.NewNop(), nil, Config{
+			URLBases:          []string{"http://test.test"},
+			Templates:         "../web",
+			ErrorCacheControl: cacheControl,
+		})
+		require.NoError(t, err)
+
+		for _, target := range []string{"http://test.test/s/", "http://test.test/s/?format=json"} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Equal(t, cacheControl, w.Header().Get("Cache-Control"), target)
+		}
+	}
+}
+
+func TestListCursor(t *testing.T) {
+	for _, cursor := range []string{"", "photos/", "a b/c?d=e&f", "ключ"} {
+		token := encodeListCursor(cursor)
+		require.Equal(t, url.QueryEscape(token), token)
+
+		decoded, err := decodeListCursor(token)
+		require.NoError(t, err)
+		require.Equal(t, cursor, decoded)
+	}
+
+	_, err := decodeListCursor("not/base64")
+	require.Equal(t, http.StatusBadRequest, GetStatus(err, 0))
+}
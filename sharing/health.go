@@ -0,0 +1,84 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// readyTimeout bounds how long a readiness probe waits for the auth service.
+const readyTimeout = 5 * time.Second
+
+// serveProbe answers health and readiness probes, returning whether r was
+// one. Probes are answered for any host, so load balancers and Kubernetes
+// can probe instances by address.
+func (handler *Handler) serveProbe(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	switch {
+	case handler.healthPath != "" && r.URL.Path == handler.healthPath:
+		// the handler is initialized, since it's serving requests.
+		writeProbe(w, http.StatusOK, "okay")
+		return true
+	case handler.readyPath != "" && r.URL.Path == handler.readyPath:
+		if handler.readyCheckAuthService {
+			if err := handler.authConfig.checkReachable(r.Context()); err != nil {
+				handler.log.Debug("readiness check failed", zap.Error(err))
+				writeProbe(w, http.StatusServiceUnavailable, "auth service unreachable")
+				return true
+			}
+		}
+		writeProbe(w, http.StatusOK, "okay")
+		return true
+	}
+	return false
+}
+
+func writeProbe(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(message))
+}
+
+// checkReachable checks that the auth service answers requests, without
+// resolving an access key. Any response that isn't a server error counts.
+func (a AuthServiceConfig) checkReachable(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if a.BaseURL == "" {
+		return nil
+	}
+	reqURL, err := url.Parse(a.BaseURL)
+	if err != nil {
+		return AuthServiceError.Wrap(err)
+	}
+	reqURL.Path = path.Join(reqURL.Path, "/v1/health/live")
+
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return AuthServiceError.Wrap(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return AuthServiceError.Wrap(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 500 {
+		return AuthServiceError.New("invalid status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestProbes(t *testing.T) {
+	authStatus := http.StatusNotFound
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/health/live", r.URL.Path)
+		w.WriteHeader(authStatus)
+	}))
+	defer auth.Close()
+
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:              []string{"http://test.test"},
+		Templates:             "../web",
+		HealthPath:            "/healthz",
+		ReadyPath:             "/readyz",
+		ReadyCheckAuthService: true,
+		AuthServiceConfig:     AuthServiceConfig{BaseURL: auth.URL},
+	})
+	require.NoError(t, err)
+
+	probe := func(method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		return w
+	}
+
+	// probes work for any host, including hosted sites.
+	for _, url := range []string{"http://test.test/healthz", "http://10.0.0.1/healthz", "http://site.example/readyz"} {
+		w := probe("GET", url)
+		require.Equal(t, http.StatusOK, w.Code, url)
+		require.Equal(t, "okay", w.Body.String(), url)
+	}
+	require.Equal(t, http.StatusOK, probe("HEAD", "http://10.0.0.1/healthz").Code)
+
+	authStatus = http.StatusBadGateway
+	require.Equal(t, http.StatusServiceUnavailable, probe("GET", "http://10.0.0.1/readyz").Code)
+	require.Equal(t, http.StatusOK, probe("GET", "http://10.0.0.1/healthz").Code)
+
+	auth.Close()
+	require.Equal(t, http.StatusServiceUnavailable, probe("GET", "http://10.0.0.1/readyz").Code)
+
+	// other methods aren't probes.
+	require.Equal(t, http.StatusMethodNotAllowed, probe("POST", "http://test.test/healthz").Code)
+}
@@ -6,12 +6,18 @@ package sharing
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/zeebo/errs"
 	"go.uber.org/zap"
+	"golang.org/x/net/idna"
 
 	"storj.io/uplink"
 )
@@ -20,6 +26,10 @@ import (
 func (handler *Handler) handleHostingService(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	if handler.hostingTraditionalPaths && isTraditionalPath(r.URL.Path) {
+		return handler.handleStandard(ctx, w, r)
+	}
+
 	host, _, err := net.SplitHostPort(r.Host)
 	if err != nil {
 		var aerr *net.AddrError
@@ -30,23 +40,40 @@ func (handler *Handler) handleHostingService(ctx context.Context, w http.Respons
 		}
 	}
 
-	access, root, err := handler.txtRecords.fetchAccessForHost(ctx, host)
+	host, err = normalizeHost(host)
+	if err != nil {
+		return WithStatus(err, http.StatusBadRequest)
+	}
+
+	access, root, options, err := handler.txtRecords.fetchAccessForHost(ctx, host)
 	if err != nil {
 		return WithAction(err, "fetch access")
 	}
+	if err := handler.checkSatellite(access); err != nil {
+		return err
+	}
+
+	shareKey := "host:" + host
+	w, done := handler.countEgress(w, shareKey)
+	defer done()
+	w, metered := handler.meterShare(w, r, shareKey)
+	defer metered()
 
 	bucket, key := determineBucketAndObjectKey(root, r.URL.Path)
+	if err := checkKeyLimits(key, handler.maxKeyLength, handler.maxKeyDepth); err != nil {
+		return err
+	}
+
+	handler.auditAccessScope(access, bucket)
 
-	project, err := handler.uplink.OpenProject(ctx, access)
+	project, release, err := handler.openProject(ctx, access)
 	if err != nil {
 		return WithAction(err, "open project")
 	}
-	defer func() {
-		if err := project.Close(); err != nil {
-			handler.log.With(zap.Error(err)).Warn("unable to close project")
-		}
-	}()
+	defer release()
 
+	rootKey := key
+	_, rootPrefix := determineBucketAndObjectKey(root, "")
 	visibleKey := strings.TrimPrefix(r.URL.Path, "/")
 	if visibleKey == "" {
 		// special case: if someone is looking for http://sub.domain.tld/,
@@ -54,15 +81,28 @@ func (handler *Handler) handleHostingService(ctx context.Context, w http.Respons
 		key += "index.html"
 	}
 
-	err = handler.presentWithProject(ctx, w, r, &parsedRequest{
-		access:      access,
-		bucket:      bucket,
-		realKey:     key,
-		visibleKey:  visibleKey,
-		title:       host,
-		root:        breadcrumb{Prefix: host, URL: "/"},
-		wrapDefault: false,
-	}, project)
+	pr := &parsedRequest{
+		access:        access,
+		bucket:        bucket,
+		realKey:       key,
+		visibleKey:    visibleKey,
+		title:         host,
+		root:          breadcrumb{Prefix: host, URL: "/"},
+		wrapDefault:   false,
+		forceDownload: handler.hostingForceDownload,
+		htmlFallback:  options.prettyURLs,
+		noListing:     !options.listing || handler.spaFallbackDocument != "",
+		earlyHints:    options.preload,
+		keyPrefix:     rootPrefix,
+
+		noindexListings: handler.hostingNoindexListings,
+	}
+
+	if r.URL.Path == faviconPath {
+		return handler.serveFavicon(ctx, w, r, pr, project)
+	}
+
+	err = handler.presentWithProject(ctx, w, r, pr, project)
 
 	// if the error is anything other than ObjectNotFound, return to normal
 	// error handling. this includes the err == nil case
@@ -70,9 +110,58 @@ func (handler *Handler) handleHostingService(ctx context.Context, w http.Respons
 		return err
 	}
 
+	// single page apps handle their own routes, so they get the app instead
+	// of a 404. requests for missing assets still 404.
+	if document := handler.spaDocument(options); document != "" && handler.isSPARoute(r.URL.Path) {
+		bucket, key := determineBucketAndObjectKey(root, document)
+		o, err := project.StatObject(ctx, bucket, key)
+		if err == nil {
+			return handler.showObject(ctx, w, r, &parsedRequest{
+				access:        access,
+				bucket:        bucket,
+				realKey:       key,
+				visibleKey:    strings.TrimPrefix(document, "/"),
+				title:         host,
+				root:          breadcrumb{Prefix: host, URL: "/"},
+				wrapDefault:   false,
+				forceDownload: handler.hostingForceDownload,
+				earlyHints:    options.preload,
+			}, project, o)
+		}
+		if !errors.Is(err, uplink.ErrObjectNotFound) {
+			return WithAction(err, "stat object - spa document")
+		}
+	}
+
+	// the site root without an index.html can have a landing page or a
+	// listing instead of a 404.
+	if visibleKey == "" {
+		if handler.serveLanding(w, r, host, options.landing) {
+			return nil
+		}
+		if handler.hostingRootListing && options.listing {
+			err = handler.servePrefix(ctx, w, r, project, &parsedRequest{
+				access:  access,
+				bucket:  bucket,
+				realKey: rootKey,
+				title:   host,
+				root:    breadcrumb{Prefix: host, URL: "/"},
+
+				noindexListings: handler.hostingNoindexListings,
+			})
+			if !errors.Is(err, uplink.ErrObjectNotFound) {
+				return err
+			}
+		}
+	}
+
 	// in ObjectNotFound, let the user provide a custom 404 page
 
-	bucket, key = determineBucketAndObjectKey(root, "/404.html")
+	notFound := options.notFound
+	if notFound == "" {
+		notFound = "/404.html"
+	}
+	bucket, key = determineBucketAndObjectKey(root, notFound)
 	download, err := project.DownloadObject(ctx, bucket, key, nil)
 	if err != nil {
 		// if this returns uplink.ErrObjectNotFound, then, that's still
@@ -86,7 +175,13 @@ func (handler *Handler) handleHostingService(ctx context.Context, w http.Respons
 		}
 	}()
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.FormatInt(download.Info().System.ContentLength, 10))
+	handler.setErrorCacheControl(w)
 	w.WriteHeader(http.StatusNotFound)
+	if r.Method == http.MethodHead {
+		return nil
+	}
 	_, err = io.Copy(w, download)
 	if err != nil {
 		return WithAction(err, "serve 404")
@@ -94,13 +189,181 @@ func (handler *Handler) handleHostingService(ctx context.Context, w http.Respons
 	return nil
 }
 
+// hostingOptions are per-domain options for the hosting service, set
+// through additional TXT records next to storj-root and storj-access.
+type hostingOptions struct {
+	// prettyURLs makes /page serve page.html when there is no page object,
+	// before falling back to the directory style redirect to /page/. Set
+	// with storj-url-style:pretty. The default is storj-url-style:directory.
+	prettyURLs bool
+
+	// listing controls whether /page/ without an index.html lists the
+	// prefix or 404s. Set with storj-listing:off. Defaults to on.
+	listing bool
+
+	// landing names the landing-<name>.html template rendered for the site
+	// root when it has no index.html. Set with storj-landing:<name>.
+	landing string
+
+	// spa makes routes of a single page app that don't resolve to an object
+	// serve the site's /index.html. Set with storj-spa:on.
+	spa bool
+
+	// preload lists site assets to send as 103 Early Hints with HTML pages.
+	// Set with storj-preload:/css/site.css,/js/app.js.
+	preload []string
+
+	// notFound is the site path of the page served with missing paths' 404s,
+	// /404.html when empty. Set with storj-404:/errors/missing.html.
+	notFound string
+}
+
+// parseHostingOptions reads the hosting options out of a TXT record set.
+func parseHostingOptions(set *TXTRecordSet) hostingOptions {
+	return hostingOptions{
+		prettyURLs: strings.EqualFold(strings.TrimSpace(set.Lookup("storj-url-style")), "pretty"),
+		listing:    txtFlagLookup(set, "storj-listing", true),
+		landing:    strings.ToLower(strings.TrimSpace(set.Lookup("storj-landing"))),
+		spa:        txtFlagLookup(set, "storj-spa", false),
+		preload:    preloadLinks(set.Lookup("storj-preload")),
+		notFound:   notFoundPage(set.Lookup("storj-404")),
+	}
+}
+
+// notFoundPage returns the site path of a custom 404 page set with storj-404,
+// or "" to use the default one. Paths are relative to the site root whether
+// or not they start with a slash, and can't climb out of it.
+func notFoundPage(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	page := path.Clean("/" + value)
+	if page == "/" {
+		return ""
+	}
+	return page
+}
+
+// isTraditionalPath returns whether urlPath is a /s/ or /raw/ link sharing
+// path whose first segment is an access grant. Access keys aren't
+// considered, as they can't be told apart from a site's own paths.
+func isTraditionalPath(urlPath string) bool {
+	var rest string
+	switch {
+	case strings.HasPrefix(urlPath, "/s/"):
+		rest = urlPath[len("/s/"):]
+	case strings.HasPrefix(urlPath, "/raw/"):
+		rest = urlPath[len("/raw/"):]
+	default:
+		return false
+	}
+	access := strings.SplitN(rest, "/", 2)[0]
+	_, version, err := base58.CheckDecode(access)
+	return err == nil && version == 0
+}
+
+// normalizeHost returns the lower case ASCII (punycode) form of host, so
+// internationalized domain names find the same TXT records however the
+// client spelled them.
+func normalizeHost(host string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return "", errs.New("invalid host %q: %v", host, err)
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// spaDocument returns the document a site serves for its app routes, or
+// "" if it isn't a single page app.
+func (handler *Handler) spaDocument(options hostingOptions) string {
+	if options.spa {
+		return "/index.html"
+	}
+	return handler.spaFallbackDocument
+}
+
+// isSPARoute returns whether urlPath looks like a single page app route
+// rather than an asset: it has no file extension, or matches the configured
+// route pattern.
+func (handler *Handler) isSPARoute(urlPath string) bool {
+	if path.Ext(path.Base(urlPath)) == "" {
+		return true
+	}
+	return handler.spaRoutePattern != nil && handler.spaRoutePattern.MatchString(urlPath)
+}
+
+// serveLanding renders the landing-<name>.html template for host, if there
+// is one, and returns whether it did.
+func (handler *Handler) serveLanding(w http.ResponseWriter, r *http.Request, host, name string) bool {
+	if name == "" || strings.ContainsAny(name, "/\\.") {
+		return false
+	}
+	template := "landing-" + name + ".html"
+	if handler.templates.Lookup(template) == nil {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return true
+	}
+	handler.renderTemplate(w, r, template, pageData{
+		Data:  struct{ Host string }{Host: host},
+		Title: host,
+	})
+	return true
+}
+
+// txtFlagLookup finds a boolean value in a TXT record set, with the same
+// rules as queryFlagLookup.
+func txtFlagLookup(set *TXTRecordSet, field string, defValue bool) bool {
+	value := strings.TrimSpace(set.Lookup(field))
+	if value == "" {
+		return defValue
+	}
+	switch strings.ToLower(value) {
+	case "no", "false", "0", "off":
+		return false
+	}
+	return true
+}
+
+// errInvalidHostingRoot is returned for hosted domains whose storj-root TXT
+// record doesn't start with a bucket name.
+var errInvalidHostingRoot = errors.New("invalid storj-root TXT record")
+
+// validateHostingRoot checks a storj-root TXT record has the form
+// bucket[/prefix], so a misconfigured domain gets a clear error rather than
+// failing later with an empty or nonsensical bucket.
+func validateHostingRoot(root string) error {
+	if strings.HasPrefix(root, "sj://") {
+		return fmt.Errorf("%w %q: leave out the sj:// scheme", errInvalidHostingRoot, root)
+	}
+	bucket, _ := determineBucketAndObjectKey(root, "")
+	if bucket == "" {
+		return fmt.Errorf("%w %q: missing bucket name", errInvalidHostingRoot, root)
+	}
+	for _, c := range bucket {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.') {
+			return fmt.Errorf("%w %q: bucket name %q may only contain lower case letters, numbers, '-' and '.'",
+				errInvalidHostingRoot, root, bucket)
+		}
+	}
+	return nil
+}
+
 // determineBucketAndObjectKey is a helper function to parse storj_root and the url into the bucket and object key.
 // For example, we have http://mydomain.com/prefix2/index.html with storj_root:bucket1/prefix1/
 // The root path will be [bucket1, prefix1/]. Our bucket is named bucket1.
 // Since the url has a path of /prefix2/index.html and the second half of the root path is prefix1,
 // we get an object key of prefix1/prefix2/index.html. To make this work, the first (and only the
 // first) prefix slash from the URL is stripped. Additionally, to aid security, if there is a non-empty
-// prefix, it will have a suffix slash added to it if no trailing slash exists. See
+// prefix, it will have a suffix slash added to it if no trailing slash exists. Any further
+// leading slashes are preserved as part of the key, since object keys may start with a slash,
+// matching how traditional /s/<access>/<bucket>//key links behave. Dot
+// segments in the URL are resolved the way browsers resolve them, so a URL
+// like /images/../../secret can't reach keys outside of the prefix. See
 // TestDetermineBucketAndObjectKey for many examples.
 func determineBucketAndObjectKey(root, urlPath string) (bucket, key string) {
 	parts := strings.SplitN(root, "/", 2)
@@ -112,5 +375,37 @@ func determineBucketAndObjectKey(root, urlPath string) (bucket, key string) {
 	if prefix != "" && !strings.HasSuffix(prefix, "/") {
 		prefix += "/"
 	}
-	return bucket, prefix + strings.TrimPrefix(urlPath, "/")
+	return bucket, prefix + strings.TrimPrefix(removeDotSegments(urlPath), "/")
+}
+
+// removeDotSegments resolves the "." and ".." segments of urlPath like RFC
+// 3986 does, never going above its root. Unlike path.Clean, empty segments
+// are kept, since object keys may contain them.
+func removeDotSegments(urlPath string) string {
+	if !strings.Contains(urlPath, ".") {
+		return urlPath
+	}
+	segments := strings.Split(urlPath, "/")
+	root := 0
+	if strings.HasPrefix(urlPath, "/") {
+		root = 1
+	}
+	resolved := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		switch segment {
+		case ".":
+		case "..":
+			if len(resolved) > root {
+				resolved = resolved[:len(resolved)-1]
+			}
+		default:
+			resolved = append(resolved, segment)
+			continue
+		}
+		// a trailing dot segment still refers to a directory.
+		if i == len(segments)-1 {
+			resolved = append(resolved, "")
+		}
+	}
+	return strings.Join(resolved, "/")
 }
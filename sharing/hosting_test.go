@@ -4,10 +4,20 @@
 package sharing
 
 import (
+	"errors"
 	"fmt"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcutil/base58"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
 )
 
 func TestDetermineBucketAndObjectKey(t *testing.T) {
@@ -65,6 +75,13 @@ func TestDetermineBucketAndObjectKey(t *testing.T) {
 			bucket:  "bucket",
 			key:     "prefix/images/pic.jpg",
 		},
+		{
+			name:    "url with leading slash key",
+			root:    "bucket",
+			urlPath: "//images/pic.jpg",
+			bucket:  "bucket",
+			key:     "/images/pic.jpg",
+		},
 		{
 			name:    "url with two slashes",
 			root:    "bucket/prefix/",
@@ -72,9 +89,345 @@ func TestDetermineBucketAndObjectKey(t *testing.T) {
 			bucket:  "bucket",
 			key:     "prefix//images/pic.jpg",
 		},
+		{
+			name:    "url with parent segment at the root",
+			root:    "bucket/prefix/",
+			urlPath: "/../",
+			bucket:  "bucket",
+			key:     "prefix/",
+		},
+		{
+			name:    "url with parent segments above the root",
+			root:    "bucket/prefix/",
+			urlPath: "/foo/../../bar",
+			bucket:  "bucket",
+			key:     "prefix/bar",
+		},
+		{
+			name:    "url with trailing parent segment",
+			root:    "bucket/prefix/",
+			urlPath: "/images/2021/..",
+			bucket:  "bucket",
+			key:     "prefix/images/",
+		},
+		{
+			name:    "url with current segments",
+			root:    "bucket/prefix/",
+			urlPath: "/./images/./pic.jpg",
+			bucket:  "bucket",
+			key:     "prefix/images/pic.jpg",
+		},
+		{
+			name:    "url with parent segment after two slashes",
+			root:    "bucket/prefix/",
+			urlPath: "/images//../pic.jpg",
+			bucket:  "bucket",
+			key:     "prefix/images/pic.jpg",
+		},
+		{
+			name:    "url with dots that aren't segments",
+			root:    "bucket/prefix/",
+			urlPath: "/.well-known/..hidden/a..b/...",
+			bucket:  "bucket",
+			key:     "prefix/.well-known/..hidden/a..b/...",
+		},
+		{
+			name:    "url with double encoded parent segment",
+			root:    "bucket/prefix/",
+			urlPath: "/%2e%2e/secret",
+			bucket:  "bucket",
+			key:     "prefix/%2e%2e/secret",
+		},
 	} {
 		actualBucket, actualKey := determineBucketAndObjectKey(test.root, test.urlPath)
 		assert.Equal(t, actualBucket, test.bucket, fmt.Sprintf("%d: %s", idx, test.name))
 		assert.Equal(t, actualKey, test.key, fmt.Sprintf("%d: %s", idx, test.name))
 	}
 }
+
+func TestDetermineBucketAndObjectKeyEncodedTraversal(t *testing.T) {
+	for _, rawURL := range []string{
+		"http://site.test/%2e%2e/secret",
+		"http://site.test/%2E%2E/%2e%2E/secret",
+		"http://site.test/images/%2e%2e%2f%2e%2e%2fsecret",
+		"http://site.test/images/.%2e/../secret",
+	} {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+
+		bucket, key := determineBucketAndObjectKey("bucket/prefix/", u.Path)
+		assert.Equal(t, "bucket", bucket, rawURL)
+		assert.Equal(t, "prefix/secret", key, rawURL)
+	}
+}
+
+func TestValidateHostingRoot(t *testing.T) {
+	for root, valid := range map[string]bool{
+		"bucket":             true,
+		"bucket/prefix/":     true,
+		"my-site.example/a/": true,
+		"":                   false,
+		"/prefix":            false,
+		"sj://bucket/prefix": false,
+		"Bucket/prefix":      false,
+		"bucket name":        false,
+	} {
+		err := validateHostingRoot(root)
+		if valid {
+			assert.NoError(t, err, root)
+			continue
+		}
+		assert.True(t, errors.Is(err, errInvalidHostingRoot), root)
+		assert.True(t, errors.Is(WithAction(err, "fetch access"), errInvalidHostingRoot), root)
+	}
+}
+
+func TestParseHostingOptions(t *testing.T) {
+	for idx, test := range []struct {
+		name    string
+		records []string
+		options hostingOptions
+	}{
+		{
+			name:    "defaults",
+			records: nil,
+			options: hostingOptions{prettyURLs: false, listing: true},
+		},
+		{
+			name:    "directory style",
+			records: []string{"storj-url-style:directory"},
+			options: hostingOptions{prettyURLs: false, listing: true},
+		},
+		{
+			name:    "pretty urls",
+			records: []string{"storj-url-style:pretty"},
+			options: hostingOptions{prettyURLs: true, listing: true},
+		},
+		{
+			name:    "pretty urls mixed case",
+			records: []string{"storj_url_style:Pretty"},
+			options: hostingOptions{prettyURLs: true, listing: true},
+		},
+		{
+			name:    "unknown url style",
+			records: []string{"storj-url-style:fancy"},
+			options: hostingOptions{prettyURLs: false, listing: true},
+		},
+		{
+			name:    "listing off",
+			records: []string{"storj-listing:off"},
+			options: hostingOptions{prettyURLs: false, listing: false},
+		},
+		{
+			name:    "listing on",
+			records: []string{"storj-listing:on"},
+			options: hostingOptions{prettyURLs: false, listing: true},
+		},
+		{
+			name:    "pretty urls and listing off",
+			records: []string{"storj-url-style:pretty", "storj-listing:false"},
+			options: hostingOptions{prettyURLs: true, listing: false},
+		},
+		{
+			name:    "directory style and listing off",
+			records: []string{"storj-url-style:directory", "storj-listing:0"},
+			options: hostingOptions{prettyURLs: false, listing: false},
+		},
+		{
+			name:    "landing template",
+			records: []string{"storj-landing: Default"},
+			options: hostingOptions{prettyURLs: false, listing: true, landing: "default"},
+		},
+		{
+			name:    "single page app",
+			records: []string{"storj-spa:on"},
+			options: hostingOptions{prettyURLs: false, listing: true, spa: true},
+		},
+		{
+			name:    "custom 404 page",
+			records: []string{"storj-404:/errors/missing.html"},
+			options: hostingOptions{prettyURLs: false, listing: true, notFound: "/errors/missing.html"},
+		},
+		{
+			name:    "custom 404 page without a slash",
+			records: []string{"storj-404:../missing.html"},
+			options: hostingOptions{prettyURLs: false, listing: true, notFound: "/missing.html"},
+		},
+	} {
+		set := NewTXTRecordSet()
+		for _, record := range test.records {
+			set.Add(record, time.Hour)
+		}
+		set.Finalize()
+		assert.Equal(t, test.options, parseHostingOptions(set), fmt.Sprintf("%d: %s", idx, test.name))
+	}
+}
+
+func TestDetermineBucketAndObjectKeyCollapsed(t *testing.T) {
+	for idx, test := range []struct {
+		name          string
+		root, urlPath string
+		exact         string
+		collapsed     string
+	}{
+		{
+			name:      "simple",
+			root:      "bucket/prefix/",
+			urlPath:   "/images/pic.jpg",
+			exact:     "prefix/images/pic.jpg",
+			collapsed: "prefix/images/pic.jpg",
+		},
+		{
+			name:      "url with two leading slashes",
+			root:      "bucket/prefix/",
+			urlPath:   "//images/pic.jpg",
+			exact:     "prefix//images/pic.jpg",
+			collapsed: "prefix/images/pic.jpg",
+		},
+		{
+			name:      "url with inner slashes",
+			root:      "bucket",
+			urlPath:   "/images///2021//pic.jpg",
+			exact:     "images///2021//pic.jpg",
+			collapsed: "images/2021/pic.jpg",
+		},
+		{
+			name:      "url with trailing slashes",
+			root:      "bucket",
+			urlPath:   "/images//",
+			exact:     "images//",
+			collapsed: "images/",
+		},
+	} {
+		_, exact := determineBucketAndObjectKey(test.root, test.urlPath)
+		assert.Equal(t, test.exact, exact, fmt.Sprintf("%d: %s", idx, test.name))
+
+		_, collapsed := determineBucketAndObjectKey(test.root, collapseSlashes(test.urlPath))
+		assert.Equal(t, test.collapsed, collapsed, fmt.Sprintf("%d: %s", idx, test.name))
+	}
+}
+
+func TestServeLanding(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://site.test/", nil)
+	require.True(t, handler.serveLanding(w, r, "site.test", "default"))
+	require.Contains(t, w.Body.String(), "Welcome to site.test")
+
+	for _, name := range []string{"", "missing", "../default", "default.html"} {
+		w := httptest.NewRecorder()
+		require.False(t, handler.serveLanding(w, r, "site.test", name), name)
+		require.Zero(t, w.Body.Len())
+	}
+}
+
+func TestSPADocument(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "", handler.spaDocument(hostingOptions{}))
+	assert.Equal(t, "/index.html", handler.spaDocument(hostingOptions{spa: true}))
+
+	handler, err = NewHandler(zap.NewNop(), nil, Config{
+		URLBases:            []string{"http://test.test"},
+		Templates:           "../web",
+		SPAFallbackDocument: "app.html",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/app.html", handler.spaDocument(hostingOptions{}))
+	assert.Equal(t, "/index.html", handler.spaDocument(hostingOptions{spa: true}))
+}
+
+func TestHostingArchiveListingOff(t *testing.T) {
+	ctx := testcontext.New(t)
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+
+	// sites with listings off, or with a single page app fallback, don't
+	// hand out prefixes as archives either.
+	for _, query := range []string{"archive=zip", "archive=tar", "download=zip", "download=tar"} {
+		for _, key := range []string{"", "dir/"} {
+			r := httptest.NewRequest("GET", "http://site.test/"+key+"?"+query, nil)
+			pr := &parsedRequest{bucket: "bucket", realKey: key, visibleKey: key, noListing: true}
+			err := handler.presentWithProject(ctx, httptest.NewRecorder(), r, pr, nil)
+			require.True(t, errors.Is(err, uplink.ErrObjectNotFound), "%s %q: %v", query, key, err)
+		}
+	}
+}
+
+func TestIsSPARoute(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:        []string{"http://test.test"},
+		Templates:       "../web",
+		SPARoutePattern: "^/users/",
+	})
+	require.NoError(t, err)
+
+	for path, route := range map[string]bool{
+		"/users/42":         true,
+		"/app/settings":     true,
+		"/app/settings/":    true,
+		"/users/john.doe":   true,
+		"/static/app.js":    false,
+		"/style.css":        false,
+		"/images/photo.png": false,
+	} {
+		assert.Equal(t, route, handler.isSPARoute(path), path)
+	}
+
+	_, err = NewHandler(zap.NewNop(), nil, Config{
+		URLBases:        []string{"http://test.test"},
+		Templates:       "../web",
+		SPARoutePattern: "(",
+	})
+	require.Error(t, err)
+}
+
+func TestNormalizeHost(t *testing.T) {
+	for _, host := range []string{
+		"bücher.example",
+		"BÜCHER.example",
+		"xn--bcher-kva.example",
+		"XN--BCHER-KVA.EXAMPLE.",
+	} {
+		normalized, err := normalizeHost(host)
+		require.NoError(t, err, host)
+		assert.Equal(t, "xn--bcher-kva.example", normalized, host)
+	}
+
+	normalized, err := normalizeHost("WWW.Example.TEST")
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.test", normalized)
+
+	_, err = normalizeHost("exa mple.test")
+	require.Error(t, err)
+}
+
+func TestIsTraditionalPath(t *testing.T) {
+	grant := base58.CheckEncode([]byte("serialized grant"), 0)
+	otherVersion := base58.CheckEncode([]byte("serialized grant"), 1)
+
+	for path, traditional := range map[string]bool{
+		"/s/" + grant + "/bucket/key":                true,
+		"/raw/" + grant + "/bucket/key":              true,
+		"/s/" + grant:                                true,
+		"/s/" + otherVersion + "/b/k":                false,
+		"/s/jwaohtj3dhixxfpzhwj522x7z3pb/bucket/key": false,
+		"/s/about/index.html":                        false,
+		"/" + grant + "/bucket/key":                  false,
+		"/static/" + grant:                           false,
+		"/":                                          false,
+	} {
+		assert.Equal(t, traditional, isTraditionalPath(path), path)
+	}
+}
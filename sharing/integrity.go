@@ -0,0 +1,46 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+
+	"storj.io/uplink"
+)
+
+// sha384MetadataKey is the custom metadata key holding the hex sha384 of an
+// object, the hash Subresource Integrity recommends.
+const sha384MetadataKey = "sha384"
+
+// wantsIntegrity returns whether a listing should include Subresource
+// Integrity hashes. Listings only fetch the metadata they come from when
+// asked to.
+func wantsIntegrity(q url.Values) bool {
+	return queryFlagLookup(q, "integrity", false)
+}
+
+// objectIntegrity returns a Subresource Integrity value for o, like
+// "sha384-<base64>", from the hashes stored in its custom metadata. It is
+// empty when there are none, as hashing objects to list them would be far
+// too expensive.
+func objectIntegrity(o *uplink.Object) string {
+	for _, hash := range []struct {
+		key  string
+		name string
+		size int
+	}{
+		{key: sha384MetadataKey, name: "sha384", size: sha512.Size384},
+		{key: sha256MetadataKey, name: "sha256", size: sha256.Size},
+	} {
+		sum, err := hex.DecodeString(o.Custom[hash.key])
+		if err == nil && len(sum) == hash.size {
+			return hash.name + "-" + base64.StdEncoding.EncodeToString(sum)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/uplink"
+)
+
+func TestObjectIntegrity(t *testing.T) {
+	sum256 := sha256.Sum256([]byte("data"))
+	sum384 := sha512.Sum384([]byte("data"))
+
+	for _, test := range []struct {
+		custom    uplink.CustomMetadata
+		integrity string
+	}{
+		{custom: nil, integrity: ""},
+		{custom: uplink.CustomMetadata{"sha384": "nothex"}, integrity: ""},
+		{custom: uplink.CustomMetadata{"sha384": hex.EncodeToString(sum256[:])}, integrity: ""},
+		{
+			custom:    uplink.CustomMetadata{"sha256": hex.EncodeToString(sum256[:])},
+			integrity: "sha256-" + base64.StdEncoding.EncodeToString(sum256[:]),
+		},
+		{
+			custom: uplink.CustomMetadata{
+				"sha256": hex.EncodeToString(sum256[:]),
+				"sha384": hex.EncodeToString(sum384[:]),
+			},
+			integrity: "sha384-" + base64.StdEncoding.EncodeToString(sum384[:]),
+		},
+	} {
+		require.Equal(t, test.integrity, objectIntegrity(&uplink.Object{Custom: test.custom}), test.custom)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/memory"
+	"storj.io/uplink"
+)
+
+// wantsJSON returns whether the request asked for JSON output.
+func wantsJSON(q url.Values) bool {
+	return q.Get("format") == "json"
+}
+
+// wantsJSONListing returns whether a prefix listing should be served as
+// JSON: when asked for with ?format=json or, without a ?format, when the
+// Accept header prefers JSON to HTML.
+func wantsJSONListing(w http.ResponseWriter, r *http.Request) bool {
+	q := r.URL.Query()
+	if _, ok := q["format"]; ok {
+		return wantsJSON(q)
+	}
+	return prefersJSON(requestHeader(w, r, "Accept"))
+}
+
+// prefersJSON returns whether an Accept header value explicitly accepts
+// application/json with a higher quality than text/html. Wildcards only
+// count for HTML, so browsers and clients accepting anything get HTML.
+func prefersJSON(accept string) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, field := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(field)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json":
+			jsonQ = q
+		case "text/html", "text/*", "*/*":
+			if q > htmlQ {
+				htmlQ = q
+			}
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// wantsJSONLines returns whether the request asked for a listing streamed as
+// JSON Lines.
+func wantsJSONLines(q url.Values) bool {
+	return q.Get("format") == "jsonl"
+}
+
+// objectPreview is the JSON form of the data the single object preview page
+// is built from.
+type objectPreview struct {
+	Key                string     `json:"key"`
+	Size               int64      `json:"size"`
+	SizeHuman          string     `json:"sizeHuman"`
+	Created            time.Time  `json:"created"`
+	Pieces             int64      `json:"pieces"`
+	Locations          []location `json:"locations"`
+	LocationsAvailable bool       `json:"locationsAvailable"`
+}
+
+// serveObjectJSON serves the object preview data as JSON, so custom front
+// ends can build their own preview.
+func (handler *Handler) serveObjectJSON(ctx context.Context, w http.ResponseWriter, pr *parsedRequest, o *uplink.Object) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	locations, pieces, available, err := handler.getLocations(ctx, pr)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, objectPreview{
+		Key:                filepath.Base(o.Key),
+		Size:               o.System.ContentLength,
+		SizeHuman:          memory.Size(o.System.ContentLength).Base10String(),
+		Created:            o.System.Created,
+		Pieces:             pieces,
+		Locations:          locations,
+		LocationsAvailable: available,
+	})
+}
+
+// listingEntry is the JSON form of one entry in a prefix listing.
+type listingEntry struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	IsPrefix bool      `json:"isPrefix"`
+	Created  time.Time `json:"created"`
+
+	Integrity string `json:"integrity,omitempty"`
+}
+
+// newListingEntry returns the listing entry for item, which is under the
+// prefix being listed. Integrity is only known if item has custom metadata.
+func newListingEntry(pr *parsedRequest, item *uplink.Object) listingEntry {
+	return listingEntry{
+		Key:       item.Key[len(pr.realKey):],
+		Size:      item.System.ContentLength,
+		IsPrefix:  item.IsPrefix,
+		Created:   item.System.Created,
+		Integrity: objectIntegrity(item),
+	}
+}
+
+// listingPage is one page of a JSON prefix listing. When truncated, passing
+// nextToken back as ?cursor= continues the listing.
+type listingPage struct {
+	Objects   []listingEntry `json:"objects"`
+	Truncated bool           `json:"truncated"`
+	NextToken string         `json:"nextToken,omitempty"`
+}
+
+// encodeListCursor turns a listing cursor into an opaque, URL safe token.
+func encodeListCursor(cursor string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+// decodeListCursor reverses encodeListCursor.
+func decodeListCursor(token string) (string, error) {
+	cursor, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", WithStatus(errs.New("invalid cursor: %v", err), http.StatusBadRequest)
+	}
+	return string(cursor), nil
+}
+
+// serveListingJSON serves one page of the prefix listing as JSON.
+func (handler *Handler) serveListingJSON(ctx context.Context, w http.ResponseWriter, r *http.Request, project *uplink.Project, pr *parsedRequest) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	q := r.URL.Query()
+	cursor, err := decodeListCursor(q.Get("cursor"))
+	if err != nil {
+		return err
+	}
+	if r.Method == http.MethodHead {
+		objects := handler.listObjects(ctx, project, pr.bucket, &uplink.ListObjectsOptions{
+			Prefix: pr.realKey,
+			Cursor: cursor,
+		})
+		return serveListingHead(w, objects, cursor, "application/json")
+	}
+
+	// the cursor is relative to the prefix, the same as the keys we list.
+	objects := handler.listObjects(ctx, project, pr.bucket, &uplink.ListObjectsOptions{
+		Prefix: pr.realKey,
+		Cursor: cursor,
+		System: true,
+		Custom: wantsIntegrity(q),
+	})
+
+	page := listingPage{Objects: make([]listingEntry, 0)}
+	for objects.Next() {
+		if len(page.Objects) >= handler.listPageSize {
+			page.Truncated = true
+			break
+		}
+		page.Objects = append(page.Objects, newListingEntry(pr, objects.Item()))
+	}
+	if err := objects.Err(); err != nil {
+		return WithAction(err, "list objects")
+	}
+
+	// only the first page of an empty prefix is missing, later pages may
+	// just be past the end.
+	if len(page.Objects) == 0 && cursor == "" {
+		return WithAction(uplink.ErrObjectNotFound, "serve prefix - empty")
+	}
+
+	if page.Truncated {
+		page.NextToken = encodeListCursor(page.Objects[len(page.Objects)-1].Key)
+	}
+
+	return writeJSON(w, http.StatusOK, page)
+}
+
+// serveListingJSONLines streams the whole prefix listing as JSON Lines, one
+// listingEntry per line, as the listing is iterated. Memory use doesn't
+// depend on the size of the listing, and output starts right away. Once the
+// first entry is written the response is committed, so later failures are
+// logged and end the response early.
+func (handler *Handler) serveListingJSONLines(ctx context.Context, w http.ResponseWriter, r *http.Request, project *uplink.Project, pr *parsedRequest) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	objects := handler.listObjects(ctx, project, pr.bucket, &uplink.ListObjectsOptions{
+		Prefix: pr.realKey,
+		System: true,
+		Custom: wantsIntegrity(r.URL.Query()),
+	})
+
+	// find the first entry before committing to a response, so an empty
+	// prefix still gets a proper 404.
+	if !objects.Next() {
+		if err := objects.Err(); err != nil {
+			return WithAction(err, "list objects")
+		}
+		return WithAction(uplink.ErrObjectNotFound, "serve prefix - empty")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for count := 1; ; count++ {
+		if err := enc.Encode(newListingEntry(pr, objects.Item())); err != nil {
+			handler.log.Debug("listing stream interrupted", zap.Error(err))
+			return nil
+		}
+		// flush every so often so consumers can start on what they have.
+		if flusher != nil && count%handler.listPageSize == 0 {
+			flusher.Flush()
+		}
+		if !objects.Next() {
+			break
+		}
+	}
+	if err := objects.Err(); err != nil {
+		handler.log.Warn("listing stream interrupted", zap.Error(err))
+	}
+	return nil
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
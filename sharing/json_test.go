@@ -0,0 +1,50 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefersJSON(t *testing.T) {
+	for accept, expected := range map[string]bool{
+		"":                 false,
+		"*/*":              false,
+		"application/json": true,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": false,
+		"application/json, text/html;q=0.5":                               true,
+		"application/json;q=0.5, text/html":                               false,
+		"application/json;q=0.9, */*;q=0.1":                               true,
+		"application/json;q=0":                                            false,
+		"application/*":                                                   false,
+	} {
+		assert.Equal(t, expected, prefersJSON(accept), accept)
+	}
+}
+
+func TestWantsJSONListing(t *testing.T) {
+	for _, test := range []struct {
+		url      string
+		accept   string
+		expected bool
+		vary     string
+	}{
+		{url: "/", expected: false, vary: "Accept"},
+		{url: "/?format=json", expected: true},
+		{url: "/", accept: "application/json", expected: true, vary: "Accept"},
+		{url: "/?format=html", accept: "application/json", expected: false},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://test.test"+test.url, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		require.Equal(t, test.expected, wantsJSONListing(w, r), "%s %s", test.url, test.accept)
+		require.Equal(t, test.vary, w.Header().Get("Vary"), "%s %s", test.url, test.accept)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/zeebo/errs"
+)
+
+// KeyTransformer maps the object key a request asks for to the key actually
+// fetched, for embedders layering their own URL schemes on top of link
+// sharing, like friendly paths over content addressed keys.
+type KeyTransformer interface {
+	// TransformKey returns the key to fetch for key in bucket.
+	TransformKey(ctx context.Context, bucket, key string) (string, error)
+}
+
+// NoopKeyTransformer fetches keys as requested.
+type NoopKeyTransformer struct{}
+
+// TransformKey implements KeyTransformer.
+func (NoopKeyTransformer) TransformKey(ctx context.Context, bucket, key string) (string, error) {
+	return key, nil
+}
+
+// transformKey runs the key transformer on the object key of pr. Prefixes
+// are left alone so listings stay consistent. Transformed keys must stay
+// within the prefix the request is confined to, so a transformer can't be
+// used to reach outside of a hosted site's root or a link's scope.
+func (handler *Handler) transformKey(ctx context.Context, pr *parsedRequest) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if pr.realKey == "" || strings.HasSuffix(pr.realKey, "/") {
+		return nil
+	}
+
+	key, err := handler.keyTransformer.TransformKey(ctx, pr.bucket, pr.realKey)
+	if err != nil {
+		return WithAction(err, "transform key")
+	}
+	if key == "" || !strings.HasPrefix(key, pr.keyPrefix) {
+		return WithStatus(errs.New("transformed key outside of %q", pr.keyPrefix), http.StatusForbidden)
+	}
+	pr.realKey = key
+	return nil
+}
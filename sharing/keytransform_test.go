@@ -0,0 +1,52 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+)
+
+type prefixKeyTransformer string
+
+func (prefix prefixKeyTransformer) TransformKey(ctx context.Context, bucket, key string) (string, error) {
+	if strings.HasSuffix(key, ".fail") {
+		return "", errors.New("lookup failed")
+	}
+	return string(prefix) + key, nil
+}
+
+func TestTransformKey(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	noop := &Handler{keyTransformer: NoopKeyTransformer{}}
+	pr := &parsedRequest{bucket: "bucket", realKey: "site/index.html", keyPrefix: "site/"}
+	require.NoError(t, noop.transformKey(ctx, pr))
+	require.Equal(t, "site/index.html", pr.realKey)
+
+	within := &Handler{keyTransformer: prefixKeyTransformer("site/hashed/")}
+	pr = &parsedRequest{bucket: "bucket", realKey: "about.html", keyPrefix: "site/"}
+	require.NoError(t, within.transformKey(ctx, pr))
+	require.Equal(t, "site/hashed/about.html", pr.realKey)
+
+	// prefixes are listed as requested.
+	pr = &parsedRequest{bucket: "bucket", realKey: "docs/"}
+	require.NoError(t, within.transformKey(ctx, pr))
+	require.Equal(t, "docs/", pr.realKey)
+
+	pr = &parsedRequest{bucket: "bucket", realKey: "about.fail"}
+	require.Error(t, within.transformKey(ctx, pr))
+
+	escaping := &Handler{keyTransformer: prefixKeyTransformer("../other/")}
+	pr = &parsedRequest{bucket: "bucket", realKey: "about.html", keyPrefix: "site/"}
+	require.Equal(t, http.StatusForbidden, GetStatus(escaping.transformKey(ctx, pr), 0))
+	require.Equal(t, "about.html", pr.realKey)
+}
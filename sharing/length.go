@@ -0,0 +1,75 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"storj.io/common/ranger"
+	"storj.io/uplink"
+)
+
+// lengthCheckingRanger wraps the ranger downloading an object to notice
+// downloads ending before the length they were asked for, which means the
+// object's content length and data disagree. Such downloads fail with
+// io.ErrUnexpectedEOF instead of ending as if they were complete.
+type lengthCheckingRanger struct {
+	ranger.Ranger
+	log    *zap.Logger
+	bucket string
+	key    string
+
+	mismatched int32
+}
+
+func (handler *Handler) checkLength(rr ranger.Ranger, pr *parsedRequest, o *uplink.Object) *lengthCheckingRanger {
+	return &lengthCheckingRanger{Ranger: rr, log: handler.log, bucket: pr.bucket, key: o.Key}
+}
+
+func (rr *lengthCheckingRanger) Range(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	body, err := rr.Ranger.Range(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &lengthCheckingReader{ReadCloser: body, ranger: rr, offset: offset, expected: length}, nil
+}
+
+// short reports whether any download ended short.
+func (rr *lengthCheckingRanger) short() bool {
+	return atomic.LoadInt32(&rr.mismatched) != 0
+}
+
+func (rr *lengthCheckingRanger) mismatch(offset, expected, read int64) {
+	atomic.StoreInt32(&rr.mismatched, 1)
+	mon.Event("content_length_mismatch")
+	rr.log.Warn("object download ended before its content length",
+		zap.String("bucket", rr.bucket),
+		zap.String("key", rr.key),
+		zap.Int64("offset", offset),
+		zap.Int64("expected", expected),
+		zap.Int64("read", read))
+}
+
+type lengthCheckingReader struct {
+	io.ReadCloser
+	ranger   *lengthCheckingRanger
+	offset   int64
+	expected int64
+	read     int64
+}
+
+func (r *lengthCheckingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	if errors.Is(err, io.EOF) && r.read < r.expected {
+		r.ranger.mismatch(r.offset, r.expected, r.read)
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"storj.io/common/ranger"
+	"storj.io/common/ranger/httpranger"
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
+)
+
+// shortRanger claims a larger size than the data it has, like an object
+// whose content length disagrees with its segments.
+type shortRanger struct {
+	data []byte
+	size int64
+}
+
+func (rr shortRanger) Size() int64 { return rr.size }
+
+func (rr shortRanger) Range(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	end := offset + length
+	if end > int64(len(rr.data)) {
+		end = int64(len(rr.data))
+	}
+	if offset > end {
+		offset = end
+	}
+	return ioutil.NopCloser(bytes.NewReader(rr.data[offset:end])), nil
+}
+
+func TestLengthCheckingRanger(t *testing.T) {
+	ctx := testcontext.New(t)
+	core, logs := observer.New(zap.WarnLevel)
+	handler := &Handler{log: zap.New(core)}
+	pr := &parsedRequest{bucket: "bucket"}
+	o := &uplink.Object{Key: "video.mp4"}
+
+	// complete downloads pass through untouched.
+	complete := handler.checkLength(ranger.ByteRanger("0123456789"), pr, o)
+	body, err := complete.Range(ctx, 2, 5)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.Equal(t, "23456", string(data))
+	require.False(t, complete.short())
+	require.Zero(t, logs.Len())
+
+	short := handler.checkLength(shortRanger{data: []byte("0123456789"), size: 20}, pr, o)
+	body, err = short.Range(ctx, 0, 20)
+	require.NoError(t, err)
+	data, err = ioutil.ReadAll(body)
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	require.Equal(t, "0123456789", string(data))
+	require.True(t, short.short())
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	require.Equal(t, "video.mp4", entries[0].ContextMap()["key"])
+	require.EqualValues(t, 20, entries[0].ContextMap()["expected"])
+	require.EqualValues(t, 10, entries[0].ContextMap()["read"])
+
+	// served, the response declares the full length but ends early, which
+	// the server turns into a closed connection.
+	short = handler.checkLength(shortRanger{data: []byte("0123456789"), size: 20}, pr, o)
+	w := httptest.NewRecorder()
+	httpranger.ServeContent(ctx, w, httptest.NewRequest("GET", "/", nil), o.Key, o.System.Created, short)
+	require.Equal(t, "20", w.Header().Get("Content-Length"))
+	require.Equal(t, "0123456789", w.Body.String())
+	require.True(t, short.short())
+}
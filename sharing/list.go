@@ -8,53 +8,188 @@ import (
 	"html/template"
 	"net/http"
 	"net/url"
+	"path"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
 
 	"storj.io/common/memory"
 	"storj.io/uplink"
 )
 
+type listingObject struct {
+	Key       string
+	URL       template.URL
+	Size      string
+	Prefix    bool
+	Integrity string
+	// Category is the kind of entry, for its icon: "folder", "image",
+	// "video", "audio", "document", "archive" or "file".
+	Category string
+
+	size    int64
+	created time.Time
+}
+
 type breadcrumb struct {
 	Prefix string
 	URL    string
+	// Disabled breadcrumbs are above what the access can list, so they
+	// aren't rendered as links.
+	Disabled bool
 }
 
-func (handler *Handler) servePrefix(ctx context.Context, w http.ResponseWriter, project *uplink.Project, pr *parsedRequest) (err error) {
-	type Object struct {
-		Key    string
-		URL    template.URL
-		Size   string
-		Prefix bool
+func (handler *Handler) servePrefix(ctx context.Context, w http.ResponseWriter, r *http.Request, project *uplink.Project, pr *parsedRequest) (err error) {
+	if handler.metrics != nil {
+		start := time.Now()
+		defer func() { handler.metrics.observeListing(handler.serviceLabel(r), time.Since(start)) }()
+	}
+
+	if pr.noindexListings {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+
+	if wantsJSONListing(w, r) {
+		return handler.serveListingJSON(ctx, w, r, project, pr)
+	}
+	if wantsJSONLines(r.URL.Query()) {
+		return handler.serveListingJSONLines(ctx, w, r, project, pr)
+	}
+
+	q := r.URL.Query()
+	cursor, err := decodeListCursor(q.Get("cursor"))
+	if err != nil {
+		return err
+	}
+	sorting, err := parseListingSort(q)
+	if err != nil {
+		return err
+	}
+	if sorting.field != "" {
+		// sorted listings aren't paged, see listLimit.
+		cursor = ""
+	}
+	if r.Method == http.MethodHead {
+		objects := handler.listObjects(ctx, project, pr.bucket, &uplink.ListObjectsOptions{
+			Prefix: pr.realKey,
+			Cursor: cursor,
+		})
+		return serveListingHead(w, objects, cursor, "text/html; charset=utf-8")
 	}
 
 	var input struct {
 		Title       string
 		Breadcrumbs []breadcrumb
-		Objects     []Object
+		Back        bool
+		LinkQuery   template.URL
+		Objects     []listingObject
+
+		// Sort and Order are the active sort, empty when the listing is in
+		// key order. SortURLs link to the listing sorted by each field, in
+		// the opposite order for the active one.
+		Sort     string
+		Order    string
+		SortURLs map[string]template.URL
+
+		// NextCursor continues the listing after this page, if it is
+		// truncated. PrevURL and NextURL link to the neighbouring pages.
+		NextCursor string
+		PrevURL    template.URL
+		NextURL    template.URL
+
+		// Truncated is set when the page was cut short at MaxListSize
+		// entries. The rest follow on the next pages, or for sorted
+		// listings, aren't shown.
+		Truncated bool
+
+		// DownloadAllURL downloads the whole prefix as a ZIP archive, unless
+		// archives need a signature this page can't give out or listings are
+		// off, which turns archives off too.
+		DownloadAllURL template.URL
 	}
 	input.Title = pr.title
-	input.Breadcrumbs = append(input.Breadcrumbs, pr.root)
-	if pr.visibleKey != "" {
-		trimmed := strings.TrimRight(pr.visibleKey, "/")
-		for i, prefix := range strings.Split(trimmed, "/") {
-			input.Breadcrumbs = append(input.Breadcrumbs, breadcrumb{
-				Prefix: prefix,
-				URL:    input.Breadcrumbs[i].URL + prefix + "/",
-			})
-		}
+	input.Breadcrumbs = listingBreadcrumbs(pr, handler.relativeListingURLs)
+	input.Back = len(input.Breadcrumbs) > 1 && !input.Breadcrumbs[len(input.Breadcrumbs)-2].Disabled
+	input.LinkQuery = template.URL(pr.linkQuery)
+	if !handler.signedTransforms["archive"] && !pr.noListing {
+		input.DownloadAllURL = template.URL("?download=zip" + pr.linkQuery)
 	}
 
-	input.Objects = make([]Object, 0)
+	input.Objects = make([]listingObject, 0)
+	input.Sort, input.Order = sorting.field, sorting.order
+	input.SortURLs = sorting.urls(pr.linkQuery)
 
-	objects := project.ListObjects(ctx, pr.bucket, &uplink.ListObjectsOptions{
+	// the cursor is relative to the prefix, the same as the keys we list.
+	objects := handler.listObjects(ctx, project, pr.bucket, &uplink.ListObjectsOptions{
 		Prefix: pr.realKey,
+		Cursor: cursor,
 		System: true,
+		Custom: wantsIntegrity(q),
 	})
 
-	// TODO add paging
+	limit, capped := handler.listLimit(sorting)
+	entries, truncated, err := collectListing(objects, pr.realKey, limit)
+	if err != nil {
+		return err
+	}
+	input.Objects = append(input.Objects, entries...)
+
+	// only the first page of an empty prefix is missing, later pages may
+	// just be past the end.
+	if len(input.Objects) == 0 && cursor == "" {
+		return WithAction(uplink.ErrObjectNotFound, "serve prefix - empty")
+	}
+
+	if truncated {
+		input.Truncated = capped
+		if sorting.field == "" {
+			input.NextCursor = encodeListCursor(input.Objects[len(input.Objects)-1].Key)
+		}
+	}
+	sortListing(input.Objects, sorting)
+	if sorting.field == "" {
+		input.PrevURL, input.NextURL = listingPageURLs(q, pr.linkQuery, input.NextCursor)
+	}
+
+	handler.renderTemplate(w, r, "prefix-listing.html", pageData{
+		Data:     input,
+		Title:    pr.title,
+		LinkBase: pr.linkBase,
+	})
+	return nil
+}
+
+// serveListingHead answers a HEAD request for a listing with the status
+// and headers a GET would get, without listing past the first entry.
+func serveListingHead(w http.ResponseWriter, objects objectIterator, cursor, contentType string) error {
+	if !objects.Next() {
+		if err := objects.Err(); err != nil {
+			return WithAction(err, "list objects")
+		}
+		// only the first page of an empty prefix is missing, later pages
+		// may just be past the end.
+		if cursor == "" {
+			return WithAction(uplink.ErrObjectNotFound, "serve prefix - empty")
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// collectListing collects up to limit entries under prefix for an HTML
+// listing, and whether there were more.
+func collectListing(objects objectIterator, prefix string, limit int) (entries []listingObject, truncated bool, err error) {
 	for objects.Next() {
+		if len(entries) >= limit {
+			truncated = true
+			break
+		}
 		item := objects.Item()
-		key := item.Key[len(pr.realKey):]
+		key := item.Key[len(prefix):]
 		var keyURL string
 		if item.IsPrefix {
 			keyURL = url.PathEscape(strings.TrimSuffix(key, "/")) + "/"
@@ -62,25 +197,263 @@ func (handler *Handler) servePrefix(ctx context.Context, w http.ResponseWriter,
 			keyURL = url.PathEscape(key)
 		}
 
-		input.Objects = append(input.Objects, Object{
-			Key:    key,
-			URL:    template.URL(keyURL),
-			Size:   memory.Size(item.System.ContentLength).Base10String(),
-			Prefix: item.IsPrefix,
+		entries = append(entries, listingObject{
+			Key:       key,
+			URL:       template.URL(keyURL),
+			Size:      memory.Size(item.System.ContentLength).Base10String(),
+			Prefix:    item.IsPrefix,
+			Integrity: objectIntegrity(item),
+			Category:  listingCategory(key, item.IsPrefix),
+
+			size:    item.System.ContentLength,
+			created: item.System.Created,
 		})
 	}
-	err = objects.Err()
-	if err != nil {
-		return WithAction(err, "list objects")
+	if err := objects.Err(); err != nil {
+		return nil, false, WithAction(err, "list objects")
 	}
+	return entries, truncated, nil
+}
 
-	if len(input.Objects) == 0 {
-		return WithAction(uplink.ErrObjectNotFound, "serve prefix - empty")
+// listLimit returns how many entries a page of an HTML listing may show,
+// and whether that is less than a full page because of MaxListSize. The cap
+// is per page, as nothing a client sends back can be trusted to count the
+// pages before. Sorted listings aren't paged, since sorting a page on its
+// own would put entries from later pages out of order, so they show up to
+// MaxListSize entries on one page.
+func (handler *Handler) listLimit(sorting listingSort) (limit int, capped bool) {
+	if sorting.field != "" || handler.maxListSize < handler.listPageSize {
+		return handler.maxListSize, true
+	}
+	return handler.listPageSize, false
+}
+
+// listingPageURLs returns the links to the previous and next pages of a
+// listing, empty when there are none. Cursors only go forward, so the links
+// carry the cursors of the pages before this one in ?prev, comma separated
+// with the first page as the empty cursor.
+func listingPageURLs(q url.Values, linkQuery, nextCursor string) (prevURL, nextURL template.URL) {
+	var prev []string
+	if _, ok := q["prev"]; ok {
+		prev = strings.Split(q.Get("prev"), ",")
+	}
+
+	pageURL := func(cursor string, prev []string) template.URL {
+		v := url.Values{}
+		v.Set("wrap", "1")
+		if cursor != "" {
+			v.Set("cursor", cursor)
+			v.Set("prev", strings.Join(prev, ","))
+		}
+		return template.URL("?" + v.Encode() + linkQuery)
 	}
 
-	handler.renderTemplate(w, "prefix-listing.html", pageData{
-		Data:  input,
-		Title: pr.title,
+	if len(prev) > 0 {
+		prevURL = pageURL(prev[len(prev)-1], prev[:len(prev)-1])
+	}
+	if nextCursor != "" {
+		nextURL = pageURL(nextCursor, append(prev, q.Get("cursor")))
+	}
+	return prevURL, nextURL
+}
+
+// listingBreadcrumbs returns the breadcrumbs from the root down to the
+// requested prefix. Breadcrumbs above the access' scope are disabled. With
+// relative set, their URLs are relative to the listing instead of absolute
+// paths.
+func listingBreadcrumbs(pr *parsedRequest, relative bool) []breadcrumb {
+	crumbs := []breadcrumb{pr.root}
+	if pr.visibleKey == "" {
+		if relative {
+			crumbs[0].URL = relativeParent(0)
+		}
+		return crumbs
+	}
+
+	// the breadcrumbs follow the visible key, but the scope is in terms of
+	// the real key, which may have more in front of it.
+	base := strings.TrimSuffix(pr.realKey, pr.visibleKey)
+	key := base
+	trimmed := strings.TrimRight(pr.visibleKey, "/")
+	for i, prefix := range strings.Split(trimmed, "/") {
+		key += prefix + "/"
+		crumbs = append(crumbs, breadcrumb{
+			Prefix:   prefix,
+			URL:      crumbs[i].URL + prefix + "/",
+			Disabled: !strings.HasPrefix(key, pr.scope),
+		})
+	}
+
+	if relative {
+		for i := range crumbs {
+			crumbs[i].URL = relativeParent(len(crumbs) - 1 - i)
+		}
+	}
+
+	if pr.linkQuery != "" {
+		for i := range crumbs {
+			crumbs[i].URL += "?" + strings.TrimPrefix(pr.linkQuery, "&")
+		}
+	}
+	return crumbs
+}
+
+// listingCategories are the categories of listed objects, by extension.
+var listingCategories = map[string]string{
+	".apng": "image", ".avif": "image", ".bmp": "image", ".gif": "image",
+	".heic": "image", ".ico": "image", ".jpeg": "image", ".jpg": "image",
+	".png": "image", ".svg": "image", ".tif": "image", ".tiff": "image",
+	".webp": "image",
+
+	".avi": "video", ".m4v": "video", ".mkv": "video", ".mov": "video",
+	".mp4": "video", ".mpeg": "video", ".mpg": "video", ".ogv": "video",
+	".webm": "video", ".wmv": "video",
+
+	".aac": "audio", ".flac": "audio", ".m4a": "audio", ".mid": "audio",
+	".mp3": "audio", ".oga": "audio", ".ogg": "audio", ".opus": "audio",
+	".wav": "audio", ".weba": "audio",
+
+	".csv": "document", ".doc": "document", ".docx": "document",
+	".epub": "document", ".md": "document", ".odp": "document",
+	".ods": "document", ".odt": "document", ".pdf": "document",
+	".ppt": "document", ".pptx": "document", ".rtf": "document",
+	".txt": "document", ".xls": "document", ".xlsx": "document",
+
+	".7z": "archive", ".bz2": "archive", ".gz": "archive", ".jar": "archive",
+	".rar": "archive", ".tar": "archive", ".tgz": "archive", ".xz": "archive",
+	".zip": "archive", ".zst": "archive",
+}
+
+// listingCategory returns the category of a listed key, going by its
+// extension alone so listings don't need to stat every object.
+func listingCategory(key string, prefix bool) string {
+	if prefix {
+		return "folder"
+	}
+	if category, ok := listingCategories[strings.ToLower(path.Ext(key))]; ok {
+		return category
+	}
+	return "file"
+}
+
+// relativeParent returns the relative URL of the prefix levels up from the
+// current listing.
+func relativeParent(levels int) string {
+	if levels == 0 {
+		return "./"
+	}
+	return strings.Repeat("../", levels)
+}
+
+// listingSortFields are the fields HTML listings can be sorted by.
+var listingSortFields = []string{"name", "size", "modified"}
+
+// listingSort is how an HTML listing is sorted, from ?sort and ?order. An
+// empty field keeps the listing in key order.
+type listingSort struct {
+	field string
+	order string
+}
+
+func parseListingSort(q url.Values) (listingSort, error) {
+	sorting := listingSort{field: q.Get("sort"), order: q.Get("order")}
+	switch sorting.field {
+	case "", "name", "size", "modified":
+	default:
+		return listingSort{}, WithStatus(errs.New("invalid sort %q", sorting.field), http.StatusBadRequest)
+	}
+	switch sorting.order {
+	case "":
+		if sorting.field != "" {
+			sorting.order = "asc"
+		}
+	case "asc", "desc":
+		if sorting.field == "" {
+			sorting.field = "name"
+		}
+	default:
+		return listingSort{}, WithStatus(errs.New("invalid order %q", sorting.order), http.StatusBadRequest)
+	}
+	return sorting, nil
+}
+
+// query returns the sort as query parameters to keep on page links.
+func (sorting listingSort) query() string {
+	if sorting.field == "" {
+		return ""
+	}
+	return "&sort=" + sorting.field + "&order=" + sorting.order
+}
+
+// urls returns the links sorting the listing by each field. Sorting starts
+// over at the first page.
+func (sorting listingSort) urls(linkQuery string) map[string]template.URL {
+	urls := make(map[string]template.URL, len(listingSortFields))
+	for _, field := range listingSortFields {
+		order := "asc"
+		if field == sorting.field && sorting.order == "asc" {
+			order = "desc"
+		}
+		urls[field] = template.URL("?wrap=1&sort=" + field + "&order=" + order + linkQuery)
+	}
+	return urls
+}
+
+// sortListing sorts the objects of a listing. Prefixes always come
+// before objects, sorted by name since they have no size or date.
+func sortListing(objects []listingObject, sorting listingSort) {
+	if sorting.field == "" {
+		return
+	}
+	less := func(a, b listingObject) bool {
+		switch {
+		case sorting.field == "size" && !a.Prefix && a.size != b.size:
+			return a.size < b.size
+		case sorting.field == "modified" && !a.Prefix && !a.created.Equal(b.created):
+			return a.created.Before(b.created)
+		}
+		return a.Key < b.Key
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		a, b := objects[i], objects[j]
+		if a.Prefix != b.Prefix {
+			return a.Prefix
+		}
+		if sorting.order == "desc" {
+			return less(b, a)
+		}
+		return less(a, b)
 	})
-	return nil
+}
+
+// objectIterator iterates over listed objects.
+type objectIterator interface {
+	Next() bool
+	Item() *uplink.Object
+	Err() error
+}
+
+// listObjects lists objects in bucket like project.ListObjects, leaving out
+// hidden ones.
+func (handler *Handler) listObjects(ctx context.Context, project *uplink.Project, bucket string, options *uplink.ListObjectsOptions) objectIterator {
+	objects := project.ListObjects(ctx, bucket, options)
+	if !handler.passwordProtection {
+		return objects
+	}
+	return &hidingIterator{objectIterator: objects, hidden: handler.hiddenKey}
+}
+
+// hidingIterator leaves out the objects whose keys are hidden.
+type hidingIterator struct {
+	objectIterator
+	hidden func(key string) bool
+}
+
+func (it *hidingIterator) Next() bool {
+	for it.objectIterator.Next() {
+		if !it.hidden(it.Item().Key) {
+			return true
+		}
+	}
+	return false
 }
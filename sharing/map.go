@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
+	"sync"
 
 	"go.uber.org/zap"
 
@@ -20,43 +22,116 @@ import (
 )
 
 type location struct {
-	Latitude  float64
-	Longitude float64
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
 }
 
-func (handler *Handler) getLocations(ctx context.Context, pr *parsedRequest) (locs []location, pieceCount int64, err error) {
+// getLocations returns the known locations of the object's pieces. available
+// is false when location data could not be determined at all, which is
+// different from the object simply having no pieces.
+func (handler *Handler) getLocations(ctx context.Context, pr *parsedRequest) (locs []location, pieceCount int64, available bool, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	// looking up pieces is expensive, and bots have no use for the map.
+	if pr.bot {
+		mon.Event("bot_locations_skipped")
+		return make([]location, 0), 0, false, nil
+	}
+
 	ipSummary, err := object.GetObjectIPSummary(ctx, *handler.uplink, pr.access, pr.bucket, pr.realKey)
 	if err != nil {
-		return nil, 0, WithAction(err, "get locations")
+		return nil, 0, false, WithAction(err, "get locations")
 	}
 
 	// we explicitly don't want locations to be nil, so it doesn't
 	// render as null when we plop it into the output javascript.
 	locations := make([]location, 0, len(ipSummary.IPPorts))
-	if handler.mapper != nil {
-		for _, ip := range ipSummary.IPPorts {
-			info, err := handler.mapper.GetIPInfos(ctx, string(ip))
+	if !handler.mapper.Available() {
+		return locations, ipSummary.PieceCount, false, nil
+	}
+
+	locations = handler.lookupLocations(ctx, ipSummary.IPPorts)
+
+	// if we had addresses but could resolve none of them, the map would
+	// misleadingly look empty.
+	available = len(ipSummary.IPPorts) == 0 || len(locations) > 0
+
+	return locations, ipSummary.PieceCount, available, nil
+}
+
+// lookupLocations geolocates the given addresses using a bounded number of
+// workers. no new lookups are started once the location timeout passes, so
+// the result may be partial. the result is sorted so that it
+// doesn't depend on the order lookups finished in.
+func (handler *Handler) lookupLocations(ctx context.Context, ips [][]byte) []location {
+	if handler.locationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, handler.locationTimeout)
+		defer cancel()
+	}
+
+	workers := handler.locationWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		locations = make([]location, 0, len(ips))
+		limiter   = make(chan struct{}, workers)
+	)
+
+	for _, ip := range ips {
+		select {
+		case limiter <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			defer func() { <-limiter }()
+
+			info, err := handler.mapper.GetIPInfos(ctx, ip)
 			if err != nil {
-				handler.log.Error("failed to get IP info", zap.Error(err))
-				continue
+				// lookups still running at the location timeout are
+				// expected to fail, so only real failures are errors.
+				if ctx.Err() != nil {
+					handler.log.Debug("failed to get IP info", zap.Error(err))
+				} else {
+					handler.log.Error("failed to get IP info", zap.Error(err))
+				}
+				return
 			}
 
+			mu.Lock()
 			locations = append(locations, location{
 				Latitude:  info.Location.Latitude,
 				Longitude: info.Location.Longitude,
 			})
-		}
+			mu.Unlock()
+		}(string(ip))
 	}
+	wg.Wait()
 
-	return locations, ipSummary.PieceCount, nil
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].Latitude != locations[j].Latitude {
+			return locations[i].Latitude < locations[j].Latitude
+		}
+		return locations[i].Longitude < locations[j].Longitude
+	})
+
+	return locations
 }
 
 func (handler *Handler) serveMap(ctx context.Context, w http.ResponseWriter, pr *parsedRequest, o *uplink.Object, q url.Values) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	locations, pieces, err := handler.getLocations(ctx, pr)
+	locations, pieces, available, err := handler.getLocations(ctx, pr)
 	if err != nil {
 		return err
 	}
@@ -100,7 +175,8 @@ func (handler *Handler) serveMap(ctx context.Context, w http.ResponseWriter, pr
 				`<text x="3%" y="75%" width="100%" dominant-baseline="middle" text-anchor="left"
 	    style="font-family:Poppins,sans-serif;font-size:18px;fill:#6c757d;fill-opacity:1;">
 	    <tspan font-weight="bold">Pieces:</tspan> `+fmt.Sprint(pieces)+`
-	    <tspan x="3%" dy="1.4em"><tspan font-weight="bold">Size:</tspan> `+memory.Size(o.System.ContentLength).Base10String()+`</tspan>
+	    <tspan x="3%" dy="1.4em"><tspan font-weight="bold">Size:</tspan> `+memory.Size(o.System.ContentLength).Base10String()+`</tspan>`+
+					locationNote(available)+`
 	  </text>
 	</svg>`), 1)
 		}
@@ -110,3 +186,13 @@ func (handler *Handler) serveMap(ctx context.Context, w http.ResponseWriter, pr
 	_, err = w.Write(data)
 	return err
 }
+
+// locationNote returns an svg line explaining that the map is missing
+// location data, or nothing if location data is available.
+func locationNote(available bool) string {
+	if available {
+		return ""
+	}
+	return `
+	    <tspan x="3%" dy="1.4em" font-style="italic">Location data unavailable.</tspan>`
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"storj.io/common/testcontext"
+	"storj.io/linksharing/objectmap"
+)
+
+func TestLookupLocations(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	handler := &Handler{
+		log:             zap.NewNop(),
+		mapper:          objectmap.NewIPDB(&objectmap.MockReader{}),
+		locationWorkers: 4,
+		locationTimeout: time.Minute,
+	}
+
+	ips := [][]byte{
+		[]byte("172.146.10.2:4545"),
+		[]byte("172.146.10.1:4545"),
+		[]byte("1.1.1.1:4545"), // not found, left off the map
+		[]byte("172.146.10.3:4545"),
+		[]byte("172.146.10.1:4546"),
+	}
+
+	expected := []location{
+		{Latitude: -19.456, Longitude: 20.123},
+		{Latitude: -19.456, Longitude: 20.123},
+		{},
+		{},
+	}
+
+	for i := 0; i < 10; i++ {
+		require.Equal(t, expected, handler.lookupLocations(ctx, ips))
+	}
+}
+
+// slowReader is an objectmap.Reader whose lookups fail after a delay.
+type slowReader struct {
+	delay time.Duration
+}
+
+func (reader slowReader) Lookup(ip net.IP, result interface{}) error {
+	time.Sleep(reader.delay)
+	return errors.New("lookup failed")
+}
+
+func (reader slowReader) Close() error { return nil }
+
+func TestLookupLocationsTimeoutLogging(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	errorLogs := func() (n int) {
+		for _, entry := range logs.All() {
+			if entry.Level == zapcore.ErrorLevel {
+				n++
+			}
+		}
+		return n
+	}
+	handler := &Handler{
+		log:             zap.New(core),
+		mapper:          objectmap.NewIPDB(slowReader{delay: 100 * time.Millisecond}),
+		locationWorkers: 4,
+		locationTimeout: 10 * time.Millisecond,
+	}
+
+	// lookups cut short by the location timeout aren't errors.
+	ips := [][]byte{[]byte("172.146.10.1:4545"), []byte("172.146.10.2:4545")}
+	require.Empty(t, handler.lookupLocations(ctx, ips))
+	require.Equal(t, 2, logs.FilterMessage("failed to get IP info").Len())
+	require.Zero(t, errorLogs())
+
+	// failures before it are.
+	handler.mapper = objectmap.NewIPDB(slowReader{})
+	handler.locationTimeout = time.Minute
+	require.Empty(t, handler.lookupLocations(ctx, ips))
+	require.Equal(t, 2, errorLogs())
+}
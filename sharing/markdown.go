@@ -0,0 +1,643 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"html"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"storj.io/common/memory"
+	"storj.io/uplink"
+)
+
+// markdownExtensions are the extensions of Markdown objects.
+var markdownExtensions = map[string]bool{".md": true, ".markdown": true}
+
+// isMarkdownKey returns whether the key looks like it names a Markdown object.
+func isMarkdownKey(key string) bool {
+	return markdownExtensions[strings.ToLower(filepath.Ext(key))]
+}
+
+// wantsMarkdownView returns whether the object should be rendered as HTML
+// rather than served as is. ?view=markdown always asks for the rendering
+// and ?view=raw and ?view=text never do. A plain ?view on a Markdown object
+// gets whatever the configured default is.
+func (handler *Handler) wantsMarkdownView(q url.Values, key string) bool {
+	if vals := q["view"]; len(vals) > 0 {
+		switch strings.ToLower(vals[0]) {
+		case "markdown":
+			return true
+		case "raw", "text":
+			return false
+		}
+	}
+	return handler.markdownViewDefault && queryFlagLookup(q, "view", false) && isMarkdownKey(key)
+}
+
+// serveMarkdownView renders a Markdown object as HTML within the site
+// template. Raw HTML in the object is escaped rather than passed through.
+func (handler *Handler) serveMarkdownView(ctx context.Context, w http.ResponseWriter, r *http.Request, pr *parsedRequest, project *uplink.Project, o *uplink.Object) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	download, err := project.DownloadObject(ctx, pr.bucket, o.Key, nil)
+	if err != nil {
+		return WithAction(err, "download markdown")
+	}
+	defer func() {
+		if err := download.Close(); err != nil {
+			handler.log.With(zap.Error(err)).Warn("unable to close markdown download")
+		}
+	}()
+
+	data, err := ioutil.ReadAll(io.LimitReader(download, handler.textViewMaxSize))
+	if err != nil {
+		return WithAction(err, "read markdown")
+	}
+
+	var input struct {
+		Key       string
+		Size      string
+		HTML      template.HTML
+		Truncated bool
+		RawURL    template.URL
+		SourceURL template.URL
+	}
+	input.Key = filepath.Base(o.Key)
+	input.Size = memory.Size(o.System.ContentLength).Base10String()
+	input.HTML = renderMarkdown(string(data), "view=raw"+pr.linkQuery)
+	input.Truncated = int64(len(data)) < o.System.ContentLength
+	input.RawURL = template.URL("?view=raw" + pr.linkQuery)
+	input.SourceURL = template.URL("?view=text" + pr.linkQuery)
+
+	// the page is generated from the object, so its bytes can't be ranged.
+	w.Header().Set("Accept-Ranges", "none")
+	handler.renderTemplate(w, r, "markdown-view.html", pageData{
+		Data:  input,
+		Title: input.Key,
+	})
+	return nil
+}
+
+// renderMarkdown renders the common subset of Markdown READMEs use as HTML:
+// headings, paragraphs, emphasis, code, block quotes, lists, tables, rules,
+// links and images. Everything that isn't Markdown is escaped, so the output
+// is safe to embed. Relative image URLs get imageQuery, so they load the raw
+// object rather than its preview page.
+func renderMarkdown(src string, imageQuery string) template.HTML {
+	src = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\t", "    ").Replace(src)
+	renderer := &markdownRenderer{imageQuery: imageQuery}
+	renderer.blocks(strings.Split(src, "\n"))
+	return template.HTML(renderer.out.String()) //nolint:gosec // everything from src is escaped.
+}
+
+type markdownRenderer struct {
+	out        strings.Builder
+	imageQuery string
+}
+
+// markdownMaxSpan is the longest inline span, like a link or emphasis, that
+// will be rendered.
+const markdownMaxSpan = 1024
+
+var (
+	markdownHeading     = regexp.MustCompile(`^ {0,3}(#{1,6})(?:\s+(.*?))?(?:\s+#+)?\s*$`)
+	markdownRule        = regexp.MustCompile(`^ {0,3}(?:(?:\*\s*){3,}|(?:-\s*){3,}|(?:_\s*){3,})$`)
+	markdownFence       = regexp.MustCompile("^ {0,3}(```+|~~~+)\\s*([^`\\s]*)")
+	markdownListItem    = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])( +|$)`)
+	markdownTableDelim  = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	markdownFenceLang   = regexp.MustCompile(`^[A-Za-z0-9_+-]+$`)
+	markdownURLScheme   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:`)
+	markdownSafeSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+)
+
+// blocks renders lines as a sequence of blocks.
+func (renderer *markdownRenderer) blocks(lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case markdownFence.MatchString(line):
+			i = renderer.fencedCode(lines, i)
+		case markdownHeading.MatchString(line):
+			m := markdownHeading.FindStringSubmatch(line)
+			level := strconv.Itoa(len(m[1]))
+			renderer.out.WriteString("<h" + level + ">")
+			renderer.inline(m[2])
+			renderer.out.WriteString("</h" + level + ">\n")
+			i++
+		case markdownRule.MatchString(line):
+			renderer.out.WriteString("<hr>\n")
+			i++
+		case strings.HasPrefix(strings.TrimLeft(line, " "), ">"):
+			i = renderer.blockQuote(lines, i)
+		case markdownListItem.MatchString(line):
+			i = renderer.list(lines, i)
+		case strings.HasPrefix(line, "    "):
+			i = renderer.indentedCode(lines, i)
+		case i+1 < len(lines) && strings.Contains(line, "|") && markdownTableDelim.MatchString(lines[i+1]):
+			i = renderer.table(lines, i)
+		default:
+			i = renderer.paragraph(lines, i)
+		}
+	}
+}
+
+// startsBlock returns whether line interrupts a paragraph.
+func startsBlock(line string) bool {
+	return strings.TrimSpace(line) == "" ||
+		markdownFence.MatchString(line) ||
+		markdownHeading.MatchString(line) ||
+		markdownRule.MatchString(line) ||
+		strings.HasPrefix(strings.TrimLeft(line, " "), ">") ||
+		markdownListItem.MatchString(line)
+}
+
+func (renderer *markdownRenderer) fencedCode(lines []string, i int) int {
+	m := markdownFence.FindStringSubmatch(lines[i])
+	fence := m[1]
+	renderer.out.WriteString("<pre><code")
+	if markdownFenceLang.MatchString(m[2]) {
+		renderer.out.WriteString(` class="language-` + m[2] + `"`)
+	}
+	renderer.out.WriteString(">")
+	for i++; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+			i++
+			break
+		}
+		renderer.out.WriteString(html.EscapeString(lines[i]) + "\n")
+	}
+	renderer.out.WriteString("</code></pre>\n")
+	return i
+}
+
+func (renderer *markdownRenderer) indentedCode(lines []string, i int) int {
+	var code []string
+	for ; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "" && !strings.HasPrefix(lines[i], "    ") {
+			break
+		}
+		code = append(code, strings.TrimPrefix(lines[i], "    "))
+	}
+	// trailing blank lines belong to what follows.
+	for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+		code = code[:len(code)-1]
+	}
+	renderer.out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "\n</code></pre>\n")
+	return i
+}
+
+func (renderer *markdownRenderer) blockQuote(lines []string, i int) int {
+	var quoted []string
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimLeft(lines[i], " ")
+		if !strings.HasPrefix(trimmed, ">") {
+			// lazy continuation lines carry on the quoted paragraph.
+			if len(quoted) == 0 || startsBlock(lines[i]) || strings.TrimSpace(quoted[len(quoted)-1]) == "" {
+				break
+			}
+			quoted = append(quoted, lines[i])
+			continue
+		}
+		trimmed = strings.TrimPrefix(trimmed, ">")
+		quoted = append(quoted, strings.TrimPrefix(trimmed, " "))
+	}
+	renderer.out.WriteString("<blockquote>\n")
+	renderer.blocks(quoted)
+	renderer.out.WriteString("</blockquote>\n")
+	return i
+}
+
+// list renders a list and its items, whose contents are indented under
+// their markers and may hold any blocks, nested lists included.
+func (renderer *markdownRenderer) list(lines []string, i int) int {
+	m := markdownListItem.FindStringSubmatch(lines[i])
+	ordered := !strings.ContainsAny(m[2], "-*+")
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	renderer.out.WriteString("<" + tag + ">\n")
+
+	var items [][]string
+	loose := false
+	for i < len(lines) {
+		m := markdownListItem.FindStringSubmatch(lines[i])
+		if m == nil || ordered == strings.ContainsAny(m[2], "-*+") {
+			break
+		}
+		indent := len(m[0])
+		if m[3] == "" || len(m[3]) > 4 {
+			indent = len(m[1]) + len(m[2]) + 1
+		}
+		item := []string{strings.TrimPrefix(lines[i], m[0][:minInt(len(m[0]), indent)])}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			switch {
+			case strings.TrimSpace(line) == "":
+				item = append(item, "")
+				continue
+			case strings.HasPrefix(line, strings.Repeat(" ", indent)):
+				item = append(item, line[indent:])
+				continue
+			case strings.TrimSpace(item[len(item)-1]) != "" && !startsBlock(line):
+				// a lazy continuation of the item's paragraph.
+				item = append(item, strings.TrimLeft(line, " "))
+				continue
+			}
+			break
+		}
+		// blank lines between items make the whole list loose.
+		for len(item) > 1 && strings.TrimSpace(item[len(item)-1]) == "" {
+			item = item[:len(item)-1]
+			loose = loose || (i < len(lines) && markdownListItem.MatchString(lines[i]))
+		}
+		items = append(items, item)
+
+		if i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			i++
+		}
+	}
+
+	for _, item := range items {
+		renderer.out.WriteString("<li>")
+		renderer.listItem(item, loose)
+		renderer.out.WriteString("</li>\n")
+	}
+
+	renderer.out.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// listItem renders the contents of a list item. Items of a single paragraph
+// in tight lists are rendered without the paragraph.
+func (renderer *markdownRenderer) listItem(item []string, loose bool) {
+	end := 0
+	for end < len(item) && strings.TrimSpace(item[end]) != "" && (end == 0 || !startsBlock(item[end])) {
+		end++
+	}
+	if loose || markdownFence.MatchString(item[0]) || markdownHeading.MatchString(item[0]) || startsBlock(item[0]) {
+		renderer.blocks(item)
+		return
+	}
+	renderer.inline(strings.Join(item[:end], "\n"))
+	if end < len(item) {
+		renderer.out.WriteString("\n")
+		renderer.blocks(item[end:])
+	}
+}
+
+// table renders a pipe table, with a header row and alignment row.
+func (renderer *markdownRenderer) table(lines []string, i int) int {
+	header := splitTableRow(lines[i])
+	var aligns []string
+	for _, cell := range splitTableRow(lines[i+1]) {
+		switch left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":"); {
+		case left && right:
+			aligns = append(aligns, "center")
+		case right:
+			aligns = append(aligns, "right")
+		case left:
+			aligns = append(aligns, "left")
+		default:
+			aligns = append(aligns, "")
+		}
+	}
+
+	row := func(cells []string, tag string) {
+		renderer.out.WriteString("<tr>")
+		for k := range header {
+			renderer.out.WriteString("<" + tag)
+			if k < len(aligns) && aligns[k] != "" {
+				renderer.out.WriteString(` style="text-align: ` + aligns[k] + `"`)
+			}
+			renderer.out.WriteString(">")
+			if k < len(cells) {
+				renderer.inline(cells[k])
+			}
+			renderer.out.WriteString("</" + tag + ">")
+		}
+		renderer.out.WriteString("</tr>\n")
+	}
+
+	renderer.out.WriteString("<table class=\"table table-sm\">\n<thead>\n")
+	row(header, "th")
+	renderer.out.WriteString("</thead>\n<tbody>\n")
+	for i += 2; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i]); i++ {
+		row(splitTableRow(lines[i]), "td")
+	}
+	renderer.out.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+// splitTableRow splits a table row into its trimmed cells. Escaped pipes
+// don't split cells.
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for k := 0; k < len(line); k++ {
+		switch {
+		case line[k] == '\\' && k+1 < len(line) && line[k+1] == '|':
+			cell.WriteByte('|')
+			k++
+		case line[k] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[k])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func (renderer *markdownRenderer) paragraph(lines []string, i int) int {
+	start := i
+	for i++; i < len(lines) && !startsBlock(lines[i]); i++ {
+	}
+	renderer.out.WriteString("<p>")
+	renderer.inline(strings.Join(lines[start:i], "\n"))
+	renderer.out.WriteString("</p>\n")
+	return i
+}
+
+// inline renders the inline elements of text, escaping everything else.
+func (renderer *markdownRenderer) inline(text string) {
+	text = strings.TrimSpace(text)
+	for k := 0; k < len(text); {
+		c := text[k]
+		// spans are only looked for nearby, so that unclosed ones can't make
+		// rendering quadratic.
+		span := minInt(len(text), k+markdownMaxSpan)
+		switch {
+		case c == '\\' && k+1 < len(text) && text[k+1] == '\n':
+			renderer.out.WriteString("<br>\n")
+			k += 2
+			continue
+		case c == '\\' && k+1 < len(text) && strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", text[k+1]) >= 0:
+			renderer.out.WriteString(html.EscapeString(text[k+1 : k+2]))
+			k += 2
+			continue
+		case c == ' ':
+			// two or more spaces at the end of a line make a hard break.
+			spaces := len(text[k:]) - len(strings.TrimLeft(text[k:], " "))
+			if k+spaces < len(text) && text[k+spaces] == '\n' {
+				if spaces >= 2 {
+					renderer.out.WriteString("<br>")
+				}
+				k += spaces
+				continue
+			}
+		case c == '`':
+			if n, ok := renderer.codeSpan(text[k:span]); ok {
+				k += n
+				continue
+			}
+		case c == '!' && strings.HasPrefix(text[k:], "!["):
+			if n, ok := renderer.link(text[k+1:span], true); ok {
+				k += 1 + n
+				continue
+			}
+		case c == '[':
+			if n, ok := renderer.link(text[k:span], false); ok {
+				k += n
+				continue
+			}
+		case c == '<':
+			if n, ok := renderer.autolink(text[k:span]); ok {
+				k += n
+				continue
+			}
+		case c == '*' || c == '_' || c == '~':
+			if n, ok := renderer.emphasis(text[:span], k); ok {
+				k += n
+				continue
+			}
+		}
+		renderer.out.WriteString(html.EscapeString(text[k : k+1]))
+		k++
+	}
+}
+
+// codeSpan renders the code span text starts with, returning its length.
+func (renderer *markdownRenderer) codeSpan(text string) (int, bool) {
+	ticks := len(text) - len(strings.TrimLeft(text, "`"))
+	fence := text[:ticks]
+	for k := ticks; k < len(text); {
+		end := strings.Index(text[k:], fence)
+		if end < 0 {
+			return 0, false
+		}
+		end += k
+		after := end + ticks
+		if after < len(text) && text[after] == '`' {
+			// a longer run of backticks doesn't close the span.
+			k = after + len(text[after:]) - len(strings.TrimLeft(text[after:], "`"))
+			continue
+		}
+		code := strings.ReplaceAll(text[ticks:end], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+			code = code[1 : len(code)-1]
+		}
+		renderer.out.WriteString("<code>" + html.EscapeString(code) + "</code>")
+		return after, true
+	}
+	return 0, false
+}
+
+// link renders the link, or image, text starts with, returning its length.
+func (renderer *markdownRenderer) link(text string, image bool) (int, bool) {
+	label, n := matchBracket(text, '[', ']')
+	if n < 0 || n >= len(text) || text[n] != '(' {
+		return 0, false
+	}
+	dest, m := matchBracket(text[n:], '(', ')')
+	if m < 0 {
+		return 0, false
+	}
+	dest = strings.TrimSpace(dest)
+	var title string
+	if k := strings.IndexAny(dest, " \n"); k >= 0 {
+		title = strings.TrimSpace(dest[k:])
+		dest = dest[:k]
+		if len(title) < 2 || !(title[0] == '"' && title[len(title)-1] == '"' || title[0] == '\'' && title[len(title)-1] == '\'') {
+			return 0, false
+		}
+		title = title[1 : len(title)-1]
+	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+
+	href, ok := safeMarkdownURL(dest)
+	if image {
+		if ok && !markdownURLScheme.MatchString(href) && !strings.Contains(href, "?") && !strings.HasPrefix(href, "#") {
+			href += "?" + renderer.imageQuery
+		}
+		if !ok {
+			// an image that can't be shown is replaced by its description.
+			renderer.out.WriteString(html.EscapeString(label))
+			return n + m, true
+		}
+		renderer.out.WriteString(`<img src="` + html.EscapeString(href) + `" alt="` + html.EscapeString(label) + `"`)
+		if title != "" {
+			renderer.out.WriteString(` title="` + html.EscapeString(title) + `"`)
+		}
+		renderer.out.WriteString(` loading="lazy">`)
+		return n + m, true
+	}
+
+	if !ok {
+		renderer.inline(label)
+		return n + m, true
+	}
+	renderer.out.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener"`)
+	if title != "" {
+		renderer.out.WriteString(` title="` + html.EscapeString(title) + `"`)
+	}
+	renderer.out.WriteString(">")
+	renderer.inline(label)
+	renderer.out.WriteString("</a>")
+	return n + m, true
+}
+
+// autolink renders the <url> autolink text starts with, returning its
+// length.
+func (renderer *markdownRenderer) autolink(text string) (int, bool) {
+	end := strings.IndexAny(text, "> \n")
+	if end < 0 || text[end] != '>' {
+		return 0, false
+	}
+	dest := text[1:end]
+	href, ok := safeMarkdownURL(dest)
+	if !ok || !markdownURLScheme.MatchString(href) {
+		return 0, false
+	}
+	renderer.out.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener">` + html.EscapeString(dest) + "</a>")
+	return end + 1, true
+}
+
+// emphasis renders the emphasis, strong emphasis or strikethrough starting
+// at text[k], returning its length.
+func (renderer *markdownRenderer) emphasis(text string, k int) (int, bool) {
+	c := text[k]
+	run := len(text[k:]) - len(strings.TrimLeft(text[k:], string(c)))
+	if c == '~' && run != 2 {
+		return 0, false
+	}
+	if run > 3 {
+		return 0, false
+	}
+	// intraword underscores, like in snake_case, aren't emphasis.
+	if c == '_' && k > 0 && isWordByte(text[k-1]) {
+		return 0, false
+	}
+	start := k + run
+	if start >= len(text) || text[start] == ' ' || text[start] == '\n' {
+		return 0, false
+	}
+	delim := text[k:start]
+	for from := start; from < len(text); {
+		end := strings.Index(text[from:], delim)
+		if end < 0 {
+			return 0, false
+		}
+		end += from
+		after := end + run
+		switch {
+		case text[end-1] == ' ' || text[end-1] == '\n' || text[end-1] == '\\':
+		case after < len(text) && text[after] == c:
+		case c == '_' && after < len(text) && isWordByte(text[after]):
+		default:
+			inner := text[start:end]
+			open, close := "", ""
+			switch {
+			case c == '~':
+				open, close = "<del>", "</del>"
+			case run == 1:
+				open, close = "<em>", "</em>"
+			case run == 2:
+				open, close = "<strong>", "</strong>"
+			default:
+				open, close = "<em><strong>", "</strong></em>"
+			}
+			renderer.out.WriteString(open)
+			renderer.inline(inner)
+			renderer.out.WriteString(close)
+			return after - k, true
+		}
+		from = end + 1
+	}
+	return 0, false
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// matchBracket returns the text between the open bracket text starts with
+// and its matching close bracket, and the length up to and including it,
+// or -1 when it isn't closed.
+func matchBracket(text string, open, close byte) (string, int) {
+	if len(text) == 0 || text[0] != open {
+		return "", -1
+	}
+	depth := 0
+	for k := 0; k < len(text); k++ {
+		switch text[k] {
+		case '\\':
+			k++
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return text[1:k], k + 1
+			}
+		}
+	}
+	return "", -1
+}
+
+// safeMarkdownURL returns dest if it's safe to link to: relative, or with
+// an http, https or mailto scheme.
+func safeMarkdownURL(dest string) (string, bool) {
+	if dest == "" {
+		return "", false
+	}
+	// browsers drop control characters from URLs, which would otherwise
+	// hide a scheme from the check below.
+	for k := 0; k < len(dest); k++ {
+		if dest[k] < 0x20 || dest[k] == 0x7f {
+			return "", false
+		}
+	}
+	if scheme := markdownURLScheme.FindString(dest); scheme != "" {
+		if !markdownSafeSchemes[strings.ToLower(strings.TrimSuffix(scheme, ":"))] {
+			return "", false
+		}
+	}
+	return dest, true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
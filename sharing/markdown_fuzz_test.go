@@ -0,0 +1,29 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+//go:build go1.18
+// +build go1.18
+
+package sharing
+
+import (
+	"testing"
+)
+
+func FuzzRenderMarkdown(f *testing.F) {
+	for _, seed := range []string{
+		"# Title\n\n**bold** _em_ `code` ~~del~~",
+		"- a\n  - b\n\n> quote\n\n1. one",
+		"| a | b |\n|:--|--:|\n| [x](y) | ![z](w.png) |",
+		"```go\ncode\n```\n\n    indented",
+		"[x](javascript:alert(1)) <https://x> <img src=x>",
+		"[[[[``````****____",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		if err := checkMarkdownHTML(string(renderMarkdown(src, "view=raw"))); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+	})
+}
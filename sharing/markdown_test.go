@@ -0,0 +1,232 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+	"golang.org/x/net/html"
+)
+
+func TestWantsMarkdownView(t *testing.T) {
+	for _, test := range []struct {
+		query           string
+		key             string
+		markdownDefault bool
+		expected        bool
+	}{
+		{query: "", key: "README.md", markdownDefault: true, expected: false},
+		{query: "view", key: "README.md", markdownDefault: false, expected: false},
+		{query: "view", key: "README.md", markdownDefault: true, expected: true},
+		{query: "view", key: "notes.MARKDOWN", markdownDefault: true, expected: true},
+		{query: "view", key: "notes.txt", markdownDefault: true, expected: false},
+		{query: "view=0", key: "README.md", markdownDefault: true, expected: false},
+		{query: "view=raw", key: "README.md", markdownDefault: true, expected: false},
+		{query: "view=text", key: "README.md", markdownDefault: true, expected: false},
+		{query: "view=markdown", key: "notes.txt", markdownDefault: false, expected: true},
+	} {
+		q, err := url.ParseQuery(test.query)
+		assert.NoError(t, err)
+
+		handler := &Handler{markdownViewDefault: test.markdownDefault}
+		assert.Equal(t, test.expected, handler.wantsMarkdownView(q, test.key), "%q %q %v", test.query, test.key, test.markdownDefault)
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	for _, test := range []struct {
+		src      string
+		expected string
+	}{
+		{src: "# Title #", expected: "<h1>Title</h1>\n"},
+		{src: "### *Sub* title", expected: "<h3><em>Sub</em> title</h3>\n"},
+		{src: "one\ntwo\n\nthree", expected: "<p>one\ntwo</p>\n<p>three</p>\n"},
+		{src: "hard  \nbreak", expected: "<p>hard<br>\nbreak</p>\n"},
+		{src: "**bold** and _em_ and ~~gone~~", expected: "<p><strong>bold</strong> and <em>em</em> and <del>gone</del></p>\n"},
+		{src: "snake_case_name", expected: "<p>snake_case_name</p>\n"},
+		{src: "use `a < b` here", expected: "<p>use <code>a &lt; b</code> here</p>\n"},
+		{src: `\*not em\*`, expected: "<p>*not em*</p>\n"},
+		{src: "---", expected: "<hr>\n"},
+		{src: "```go\nif a < b {}\n```", expected: "<pre><code class=\"language-go\">if a &lt; b {}\n</code></pre>\n"},
+		{src: "```\"><script>\nx\n```", expected: "<pre><code>x\n</code></pre>\n"},
+		{src: "    indented\n    code", expected: "<pre><code>indented\ncode\n</code></pre>\n"},
+		{src: "> quoted\ncontinued", expected: "<blockquote>\n<p>quoted\ncontinued</p>\n</blockquote>\n"},
+		{src: "- one\n- two", expected: "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n"},
+		{src: "1. one\n2. two", expected: "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n"},
+		{src: "- one\n  - nested\n- two", expected: "<ul>\n<li>one\n<ul>\n<li>nested</li>\n</ul>\n</li>\n<li>two</li>\n</ul>\n"},
+		{src: "- one\n\n- two", expected: "<ul>\n<li><p>one</p>\n</li>\n<li><p>two</p>\n</li>\n</ul>\n"},
+		{
+			src:      "| a | b |\n|:--|--:|\n| 1 | 2 |",
+			expected: "<table class=\"table table-sm\">\n<thead>\n<tr><th style=\"text-align: left\">a</th><th style=\"text-align: right\">b</th></tr>\n</thead>\n<tbody>\n<tr><td style=\"text-align: left\">1</td><td style=\"text-align: right\">2</td></tr>\n</tbody>\n</table>\n",
+		},
+		{src: "[docs](https://storj.io \"Storj\")", expected: "<p><a href=\"https://storj.io\" rel=\"nofollow noopener\" title=\"Storj\">docs</a></p>\n"},
+		{src: "<https://storj.io>", expected: "<p><a href=\"https://storj.io\" rel=\"nofollow noopener\">https://storj.io</a></p>\n"},
+		{src: "![logo](img/logo.png)", expected: "<p><img src=\"img/logo.png?view=raw\" alt=\"logo\" loading=\"lazy\"></p>\n"},
+		{src: "![logo](https://storj.io/logo.png)", expected: "<p><img src=\"https://storj.io/logo.png\" alt=\"logo\" loading=\"lazy\"></p>\n"},
+
+		// anything that isn't Markdown is escaped, and unsafe links dropped.
+		{src: "<script>alert(1)</script>", expected: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{src: "<img src=x onerror=alert(1)>", expected: "<p>&lt;img src=x onerror=alert(1)&gt;</p>\n"},
+		{src: "[click](javascript:alert(1))", expected: "<p>click</p>\n"},
+		{src: "[click](JavaScript:alert(1))", expected: "<p>click</p>\n"},
+		{src: "[click](\x01javascript:alert(1))", expected: "<p>click</p>\n"},
+		{src: "![x](data:text/html,hi)", expected: "<p>x</p>\n"},
+		{src: "<javascript:alert(1)>", expected: "<p>&lt;javascript:alert(1)&gt;</p>\n"},
+		{src: "[x](\"onmouseover=\"alert(1))", expected: "<p><a href=\"&#34;onmouseover=&#34;alert(1)\" rel=\"nofollow noopener\">x</a></p>\n"},
+	} {
+		assert.Equal(t, test.expected, string(renderMarkdown(test.src, "view=raw")), "%q", test.src)
+	}
+}
+
+func TestRenderMarkdownAdversarial(t *testing.T) {
+	for _, src := range []string{
+		// nesting.
+		"**[a](https://x) _b_ `c`**",
+		"[[a](b)](c)",
+		"[a ![b](c.png)](d)",
+		"![a [b](c)](d.png)",
+		"> - > - > `x` **y**",
+		"- > ```\n  <script>\n  ```",
+		"| [a](javascript:x) | `<b>` |\n|---|---|\n| **<i>** | ![x](data:y) |",
+		strings.Repeat("> ", 500) + "deep",
+		strings.Repeat("- ", 500) + "deep",
+		strings.Repeat("**_", 300) + "x" + strings.Repeat("_**", 300),
+
+		// unclosed constructs.
+		"**a", "_a *b_ c*", "~~a", "`a", "``a`", "[a](b", "[a", "![a](", "<https://x",
+		"```\n<script>alert(1)</script>",
+		"| a |\n|---|\n| <b",
+		"[a](<javascript:alert(1)>",
+		"[a](https://x \"title)",
+
+		// pathological runs.
+		strings.Repeat("[", 50000),
+		strings.Repeat("]", 50000),
+		strings.Repeat("`", 50000),
+		strings.Repeat("a`", 25000),
+		strings.Repeat("[a](", 10000),
+		strings.Repeat("![", 20000),
+		strings.Repeat("*a", 20000),
+		strings.Repeat("_", 50000),
+		strings.Repeat("<", 50000),
+		strings.Repeat("\\", 50000),
+		strings.Repeat("|", 5000) + "\n" + strings.Repeat("|-", 5000),
+		strings.Repeat("[a](b)", 10000),
+		strings.Repeat("- a\n", 10000),
+
+		// urls with schemes hidden from a naive check.
+		"[x](javascript:alert(1))",
+		"[x](JAVASCRIPT:alert(1))",
+		"[x](  javascript:alert(1))",
+		"[x](\x00javascript:alert(1))",
+		"[x](\x7fjavascript:alert(1))",
+		"[x](\tjavascript:alert(1))",
+		"[x](java\nscript:alert(1))",
+		"[x](java\x0bscript:alert(1))",
+		"[x](java&#x09;script:alert(1))",
+		"[x](javascript&colon;alert(1))",
+		"[x](vbscript:msgbox(1))",
+		"[x](data:text/html;base64,PHNjcmlwdD4=)",
+		"![x](javascript:alert(1))",
+		"<javascript:alert(1)>",
+		"<JavaScript:alert(1)>",
+		"<java\x01script:alert(1)>",
+		"[x](https://x\" onmouseover=\"alert(1))",
+		"[x](https://x 'a\" onmouseover=\"alert(1)')",
+		"![x\" onerror=\"alert(1)](y.png)",
+	} {
+		start := time.Now()
+		out := string(renderMarkdown(src, "view=raw"))
+		require.NoError(t, checkMarkdownHTML(out), "%.80q", src)
+		// spans are bounded, so no input makes rendering quadratic.
+		require.Less(t, int64(time.Since(start)), int64(2*time.Second), "%.80q", src)
+	}
+}
+
+// markdownTags are the tags renderMarkdown may output, with the attributes
+// each may have.
+var markdownTags = map[string][]string{
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"p": nil, "br": nil, "hr": nil, "em": nil, "strong": nil, "del": nil,
+	"pre": nil, "code": {"class"}, "blockquote": nil,
+	"ul": nil, "ol": nil, "li": nil,
+	"table": {"class"}, "thead": nil, "tbody": nil, "tr": nil, "th": {"style"}, "td": {"style"},
+	"a":   {"href", "rel", "title"},
+	"img": {"src", "alt", "title", "loading"},
+}
+
+// markdownVoidTags are the markdownTags without an end tag.
+var markdownVoidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+// checkMarkdownHTML returns an error unless out is balanced HTML of only
+// markdownTags, whose URLs are safe.
+func checkMarkdownHTML(out string) error {
+	var open []string
+	z := html.NewTokenizer(strings.NewReader(out))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return z.Err()
+			}
+			if len(open) > 0 {
+				return errs.New("unclosed %v", open)
+			}
+			return nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			allowed, ok := markdownTags[tok.Data]
+			if !ok {
+				return errs.New("unexpected tag %q", tok.Data)
+			}
+			for _, attr := range tok.Attr {
+				if !containsString(allowed, attr.Key) {
+					return errs.New("unexpected attribute %q on %q", attr.Key, tok.Data)
+				}
+				if attr.Key == "href" || attr.Key == "src" {
+					// browsers drop whitespace and control characters
+					// from URLs before they look at the scheme.
+					stripped := strings.Map(func(r rune) rune {
+						if r <= ' ' || r == 0x7f {
+							return -1
+						}
+						return r
+					}, strings.ToLower(attr.Val))
+					for _, scheme := range []string{"javascript:", "vbscript:", "data:"} {
+						if strings.HasPrefix(stripped, scheme) {
+							return errs.New("unsafe url %q", attr.Val)
+						}
+					}
+				}
+			}
+			if !markdownVoidTags[tok.Data] {
+				open = append(open, tok.Data)
+			}
+		case html.EndTagToken:
+			tok := z.Token()
+			if len(open) == 0 || open[len(open)-1] != tok.Data {
+				return errs.New("unbalanced end tag %q in %v", tok.Data, open)
+			}
+			open = open[:len(open)-1]
+		case html.CommentToken, html.DoctypeToken:
+			return errs.New("unexpected %q", z.Token().String())
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
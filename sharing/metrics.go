@@ -0,0 +1,259 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsBuckets are the upper bounds, in seconds, of the duration
+// histograms. They are the Prometheus client's defaults.
+var metricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects HTTP level metrics of a Handler, and serves them in the
+// Prometheus text format as an http.Handler. Requests are labeled with the
+// service they are for: "standard" for shared links on the configured URL
+// bases, or "hosting" for hosted sites.
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[requestMetricKey]int64
+	bytes     map[string]int64
+	durations map[string]*histogram
+	listings  map[string]*histogram
+
+	shareBytes map[string]int64
+	throttled  map[string]int64
+}
+
+type requestMetricKey struct {
+	service string
+	method  string
+	status  int
+}
+
+type histogram struct {
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range metricsBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// NewMetrics returns Metrics with nothing recorded yet.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  map[requestMetricKey]int64{},
+		bytes:     map[string]int64{},
+		durations: map[string]*histogram{},
+		listings:  map[string]*histogram{},
+
+		shareBytes: map[string]int64{},
+		throttled:  map[string]int64{},
+	}
+}
+
+// observeRequest records a finished request.
+func (m *Metrics) observeRequest(service, method string, status int, bytes int64, duration time.Duration) {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodOptions:
+	default:
+		method = "other"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestMetricKey{service: service, method: method, status: status}]++
+	m.bytes[service] += bytes
+	observeHistogram(m.durations, service, duration)
+}
+
+// observeListing records how long generating a prefix listing took.
+func (m *Metrics) observeListing(service string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	observeHistogram(m.listings, service, duration)
+}
+
+// observeShare records the bytes served for a shared access or hosted site,
+// and how long the response was throttled by the bandwidth limit.
+func (m *Metrics) observeShare(service string, bytes int64, throttled time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.shareBytes[service] += bytes
+	m.throttled[service] += int64(throttled)
+}
+
+func observeHistogram(histograms map[string]*histogram, service string, duration time.Duration) {
+	h, ok := histograms[service]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(metricsBuckets))}
+		histograms[service] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.write(w)
+}
+
+// write writes the metrics in the Prometheus text format to w.
+func (m *Metrics) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP linksharing_requests_total Requests handled, by service, method and status.\n")
+	b.WriteString("# TYPE linksharing_requests_total counter\n")
+	keys := make([]requestMetricKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, k int) bool {
+		a, b := keys[i], keys[k]
+		if a.service != b.service {
+			return a.service < b.service
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&b, "linksharing_requests_total{service=%q,method=%q,status=\"%d\"} %d\n",
+			key.service, key.method, key.status, m.requests[key])
+	}
+
+	b.WriteString("# HELP linksharing_response_bytes_total Response body bytes served, by service.\n")
+	b.WriteString("# TYPE linksharing_response_bytes_total counter\n")
+	for _, service := range sortedServices(m.bytes) {
+		fmt.Fprintf(&b, "linksharing_response_bytes_total{service=%q} %d\n", service, m.bytes[service])
+	}
+
+	b.WriteString("# HELP linksharing_share_bytes_total Response body bytes served for shared accesses and hosted sites, by service.\n")
+	b.WriteString("# TYPE linksharing_share_bytes_total counter\n")
+	for _, service := range sortedServices(m.shareBytes) {
+		fmt.Fprintf(&b, "linksharing_share_bytes_total{service=%q} %d\n", service, m.shareBytes[service])
+	}
+
+	b.WriteString("# HELP linksharing_throttled_seconds_total Time responses waited for the bandwidth limit, by service.\n")
+	b.WriteString("# TYPE linksharing_throttled_seconds_total counter\n")
+	for _, service := range sortedServices(m.throttled) {
+		fmt.Fprintf(&b, "linksharing_throttled_seconds_total{service=%q} %s\n",
+			service, strconv.FormatFloat(time.Duration(m.throttled[service]).Seconds(), 'g', -1, 64))
+	}
+
+	writeHistograms(&b, "linksharing_request_duration_seconds", "How long requests took, by service.", m.durations)
+	writeHistograms(&b, "linksharing_listing_duration_seconds", "How long prefix listings took, by service.", m.listings)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHistograms(b *strings.Builder, name, help string, histograms map[string]*histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s histogram\n", name)
+
+	services := make([]string, 0, len(histograms))
+	for service := range histograms {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	for _, service := range services {
+		h := histograms[service]
+		for i, bound := range metricsBuckets {
+			fmt.Fprintf(b, "%s_bucket{service=%q,le=%q} %d\n", name, service, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{service=%q,le=\"+Inf\"} %d\n", name, service, h.count)
+		fmt.Fprintf(b, "%s_sum{service=%q} %s\n", name, service, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(b, "%s_count{service=%q} %d\n", name, service, h.count)
+	}
+}
+
+func sortedServices(values map[string]int64) []string {
+	services := make([]string, 0, len(values))
+	for service := range values {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// statusWriter remembers the status and body size of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	// informational responses like 103 Early Hints come before the real one.
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// serviceLabel returns the service r is for, as labeled in metrics.
+func (handler *Handler) serviceLabel(r *http.Request) string {
+	if ours, err := isDomainOurs(r.Host, handler.urlBases); err == nil && !ours {
+		return "hosting"
+	}
+	return "standard"
+}
+
+// recordMetrics wraps w to record the request in the metrics when the
+// returned done func is called. Without metrics w is returned as is.
+func (handler *Handler) recordMetrics(w http.ResponseWriter, r *http.Request) (_ http.ResponseWriter, done func()) {
+	if handler.metrics == nil {
+		return w, func() {}
+	}
+
+	start := time.Now()
+	service := handler.serviceLabel(r)
+	recorded := &statusWriter{ResponseWriter: w}
+	return recorded, func() {
+		status := recorded.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		handler.metrics.observeRequest(service, r.Method, status, recorded.bytes, time.Since(start))
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+		Metrics:   metrics,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.test/health/process", nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "http://test.test/health/process", nil))
+
+	// hosted sites are labeled apart from shared links.
+	w, done := handler.recordMetrics(httptest.NewRecorder(), httptest.NewRequest("GET", "http://site.test/", nil))
+	w.WriteHeader(http.StatusNotFound)
+	done()
+	metrics.observeListing("hosting", 30*time.Millisecond)
+	metrics.observeShare("hosting", 4096, 1500*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`linksharing_requests_total{service="hosting",method="GET",status="404"} 1`,
+		`linksharing_requests_total{service="standard",method="GET",status="200"} 2`,
+		`linksharing_requests_total{service="standard",method="other",status="405"} 1`,
+		`linksharing_response_bytes_total{service="hosting"} 0`,
+		`linksharing_request_duration_seconds_count{service="standard"} 3`,
+		`linksharing_listing_duration_seconds_bucket{service="hosting",le="0.025"} 0`,
+		`linksharing_listing_duration_seconds_bucket{service="hosting",le="0.05"} 1`,
+		`linksharing_listing_duration_seconds_bucket{service="hosting",le="+Inf"} 1`,
+		`linksharing_listing_duration_seconds_sum{service="hosting"} 0.03`,
+		`linksharing_share_bytes_total{service="hosting"} 4096`,
+		`linksharing_throttled_seconds_total{service="hosting"} 1.5`,
+		"# TYPE linksharing_request_duration_seconds histogram",
+	} {
+		require.Contains(t, body, line+"\n")
+	}
+	require.Regexp(t, `linksharing_response_bytes_total\{service="standard"\} [1-9]`, body)
+}
@@ -0,0 +1,243 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"storj.io/uplink"
+)
+
+// passwordObjectName is the name of the sidecar object protecting the
+// prefix it's in, and everything below, with a password.
+const passwordObjectName = ".password"
+
+// maxPasswordObjectSize caps how much of a password object is read.
+const maxPasswordObjectSize = 4 * 1024
+
+// passwordMaxCandidates bounds how many password objects are looked up for
+// a request, so deep keys can't make a request cost as many satellite round
+// trips as they have directories.
+const passwordMaxCandidates = 8
+
+// lookups of password objects are cached for passwordCacheTTL, so repeated
+// requests for the same prefixes don't look them up again.
+const (
+	passwordCacheSize = 10000
+	passwordCacheTTL  = 30 * time.Second
+)
+
+// passwordCredential is a line of a password object: a password, or a user
+// and password separated by a colon. Passwords starting with $2 are bcrypt
+// hashes.
+type passwordCredential struct {
+	user     string
+	password string
+}
+
+// parsePasswordObject returns the credentials in a password object, one per
+// non-empty line.
+func parsePasswordObject(data []byte) []passwordCredential {
+	var credentials []passwordCredential
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var credential passwordCredential
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			credential.user, credential.password = line[:i], line[i+1:]
+		} else {
+			credential.password = line
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials
+}
+
+// matches returns whether the given basic auth credentials match, in time
+// independent of how much of them does.
+func (credential passwordCredential) matches(user, password string) bool {
+	userOK := credential.user == "" || constantTimeEqual(credential.user, user)
+	var passwordOK bool
+	if strings.HasPrefix(credential.password, "$2") {
+		passwordOK = bcrypt.CompareHashAndPassword([]byte(credential.password), []byte(password)) == nil
+	} else {
+		passwordOK = constantTimeEqual(credential.password, password)
+	}
+	return userOK && passwordOK
+}
+
+// constantTimeEqual compares a and b in time independent of their contents
+// and lengths, by comparing their hashes.
+func constantTimeEqual(a, b string) bool {
+	hashA, hashB := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(hashA[:], hashB[:]) == 1
+}
+
+// passwordCandidates returns the keys of the password objects that may
+// protect key, nearest first. Those are the ones in the nearest
+// passwordMaxCandidates-1 directories of the key, and the bucket root's.
+func passwordCandidates(key string) []string {
+	dir := key[:strings.LastIndexByte(key, '/')+1]
+	var candidates []string
+	for dir != "" && len(candidates) < passwordMaxCandidates-1 {
+		candidates = append(candidates, dir+passwordObjectName)
+		dir = dir[:strings.LastIndexByte(strings.TrimSuffix(dir, "/"), '/')+1]
+	}
+	return append(candidates, passwordObjectName)
+}
+
+// checkPassword requires requests for shared links protected by a password
+// object to carry matching basic auth credentials. The nearest password
+// object in the prefixes of the requested key protects it. Those the access
+// can't read don't protect anything.
+func (handler *Handler) checkPassword(ctx context.Context, w http.ResponseWriter, r *http.Request, project *uplink.Project, pr *parsedRequest) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	credentials, err := handler.passwordCredentials(ctx, project, pr)
+	if err != nil {
+		return err
+	}
+	if credentials == nil {
+		return nil
+	}
+
+	if user, password, ok := r.BasicAuth(); ok {
+		for _, credential := range credentials {
+			if credential.matches(user, password) {
+				return nil
+			}
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="Shared link", charset="UTF-8"`)
+	return WithStatus(errs.New("password required"), http.StatusUnauthorized)
+}
+
+// passwordLookup is the cached result of looking up a password object.
+type passwordLookup struct {
+	found       bool
+	credentials []passwordCredential
+}
+
+// passwordCredentials returns the credentials of the nearest password
+// object protecting pr's key, or nil when there is none. Uncached password
+// objects are looked up concurrently.
+func (handler *Handler) passwordCredentials(ctx context.Context, project *uplink.Project, pr *parsedRequest) (_ []passwordCredential, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	accessKey, err := passwordCacheAccessKey(pr)
+	if err != nil {
+		return nil, WithAction(err, "password cache key")
+	}
+
+	candidates := passwordCandidates(pr.realKey)
+	type result struct {
+		lookup passwordLookup
+		err    error
+	}
+	results := make([]chan result, len(candidates))
+	for i, key := range candidates {
+		results[i] = make(chan result, 1)
+		cacheKey := accessKey + "/" + pr.bucket + "/" + key
+		if cached, ok := handler.passwordCache.get(cacheKey); ok {
+			mon.Event("password_cache_hit")
+			results[i] <- result{lookup: cached.(passwordLookup)}
+			continue
+		}
+		mon.Event("password_cache_miss")
+		go func(key, cacheKey string, results chan<- result) {
+			lookup, err := handler.lookupPasswordObject(ctx, project, pr.bucket, key)
+			if err == nil {
+				handler.passwordCache.set(cacheKey, lookup, passwordCacheTTL)
+			}
+			results <- result{lookup: lookup, err: err}
+		}(key, cacheKey, results[i])
+	}
+
+	var credentials []passwordCredential
+	for _, results := range results {
+		result := <-results
+		switch {
+		case credentials != nil:
+		case result.err != nil:
+			return nil, WithAction(result.err, "read password object")
+		case result.lookup.found:
+			credentials = result.lookup.credentials
+			if credentials == nil {
+				// an empty password object still protects its prefix.
+				credentials = []passwordCredential{}
+			}
+		}
+	}
+	return credentials, nil
+}
+
+// passwordCacheAccessKey identifies pr's access in password cache keys, as
+// bucket names are only unique per project and what an access can read
+// depends on it.
+func passwordCacheAccessKey(pr *parsedRequest) (string, error) {
+	serialized, err := pr.access.Serialize()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(serialized))
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// lookupPasswordObject reads the password object at key. Those that don't
+// exist or the access can't read aren't found.
+func (handler *Handler) lookupPasswordObject(ctx context.Context, project *uplink.Project, bucket, key string) (passwordLookup, error) {
+	data, err := handler.readPasswordObject(ctx, project, bucket, key)
+	switch {
+	case err == nil:
+		return passwordLookup{found: true, credentials: parsePasswordObject(data)}, nil
+	case errors.Is(err, uplink.ErrObjectNotFound), errors.Is(err, uplink.ErrPermissionDenied):
+		return passwordLookup{}, nil
+	default:
+		return passwordLookup{}, err
+	}
+}
+
+// readPasswordObject returns the contents of the password object at key.
+func (handler *Handler) readPasswordObject(ctx context.Context, project *uplink.Project, bucket, key string) (_ []byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	download, err := project.DownloadObject(ctx, bucket, key, &uplink.DownloadOptions{Offset: 0, Length: maxPasswordObjectSize})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := download.Close(); err != nil {
+			handler.log.With(zap.Error(err)).Warn("unable to close password object download")
+		}
+	}()
+	data := make([]byte, maxPasswordObjectSize)
+	n, err := io.ReadFull(download, data)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+// hiddenKey returns whether key is a password object, which is never served
+// or listed.
+func (handler *Handler) hiddenKey(key string) bool {
+	return handler.passwordProtection && path.Base(key) == passwordObjectName
+}
@@ -0,0 +1,213 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
+)
+
+func TestPasswordCredentials(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	credentials := parsePasswordObject([]byte("open sesame\n\n  alice:wonder:land  \nbob:" + string(hash) + "\n"))
+	require.Equal(t, []passwordCredential{
+		{password: "open sesame"},
+		{user: "alice", password: "wonder:land"},
+		{user: "bob", password: string(hash)},
+	}, credentials)
+
+	for _, test := range []struct {
+		credential     int
+		user, password string
+		matches        bool
+	}{
+		{credential: 0, user: "anyone", password: "open sesame", matches: true},
+		{credential: 0, user: "", password: "open sesame", matches: true},
+		{credential: 0, user: "anyone", password: "open sesame!", matches: false},
+		{credential: 1, user: "alice", password: "wonder:land", matches: true},
+		{credential: 1, user: "bob", password: "wonder:land", matches: false},
+		{credential: 2, user: "bob", password: "hashed secret", matches: true},
+		{credential: 2, user: "bob", password: string(hash), matches: false},
+	} {
+		require.Equal(t, test.matches, credentials[test.credential].matches(test.user, test.password), test)
+	}
+
+	require.Empty(t, parsePasswordObject([]byte("\n \n")))
+}
+
+func TestPasswordCandidates(t *testing.T) {
+	require.Equal(t, []string{".password"}, passwordCandidates(""))
+	require.Equal(t, []string{".password"}, passwordCandidates("file.txt"))
+	require.Equal(t, []string{"a/b/.password", "a/.password", ".password"}, passwordCandidates("a/b/"))
+	require.Equal(t, []string{"a/b/.password", "a/.password", ".password"}, passwordCandidates("a/b/c.jpg"))
+	require.Equal(t, []string{"/.password", ".password"}, passwordCandidates("/key"))
+
+	// deep keys only get the nearest prefixes and the bucket root checked.
+	require.Equal(t, []string{
+		"a/b/c/d/e/f/g/h/i/.password",
+		"a/b/c/d/e/f/g/h/.password",
+		"a/b/c/d/e/f/g/.password",
+		"a/b/c/d/e/f/.password",
+		"a/b/c/d/e/.password",
+		"a/b/c/d/.password",
+		"a/b/c/.password",
+		".password",
+	}, passwordCandidates("a/b/c/d/e/f/g/h/i/key"))
+}
+
+func TestTTLCache(t *testing.T) {
+	cache := newTTLCache(2)
+	cache.set("a", 1, time.Hour)
+	cache.set("b", 2, time.Hour)
+	value, ok := cache.get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, value)
+
+	// the least recently used entry is evicted.
+	cache.set("c", 3, time.Hour)
+	_, ok = cache.get("b")
+	require.False(t, ok)
+	_, ok = cache.get("a")
+	require.True(t, ok)
+
+	// expired entries are gone, and swept once in a while.
+	cache.set("d", 4, -time.Second)
+	_, ok = cache.get("d")
+	require.False(t, ok)
+	cache.set("e", 5, -time.Second)
+	cache.swept = time.Now().Add(-ttlCacheSweepInterval)
+	cache.set("a", 1, time.Hour)
+	require.Equal(t, 1, cache.order.Len())
+	require.NotContains(t, cache.entries, "e")
+}
+
+func TestPasswordCredentialsCached(t *testing.T) {
+	ctx := testcontext.New(t)
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:           []string{"http://test.test"},
+		Templates:          "../web",
+		PasswordProtection: true,
+	})
+	require.NoError(t, err)
+
+	pr := &parsedRequest{access: newTestAccess(t), bucket: "bucket", realKey: "private/photo.jpg"}
+	accessKey, err := passwordCacheAccessKey(pr)
+	require.NoError(t, err)
+
+	// cached lookups need no project.
+	handler.passwordCache.set(accessKey+"/bucket/private/.password", passwordLookup{found: true, credentials: []passwordCredential{{password: "secret"}}}, time.Hour)
+	handler.passwordCache.set(accessKey+"/bucket/.password", passwordLookup{}, time.Hour)
+	credentials, err := handler.passwordCredentials(ctx, nil, pr)
+	require.NoError(t, err)
+	require.Equal(t, []passwordCredential{{password: "secret"}}, credentials)
+
+	pr.realKey = "public/photo.jpg"
+	handler.passwordCache.set(accessKey+"/bucket/public/.password", passwordLookup{}, time.Hour)
+	credentials, err = handler.passwordCredentials(ctx, nil, pr)
+	require.NoError(t, err)
+	require.Nil(t, credentials)
+}
+
+// sliceIterator iterates over a slice of objects.
+type sliceIterator struct {
+	objects []*uplink.Object
+	next    int
+}
+
+func (it *sliceIterator) Next() bool {
+	it.next++
+	return it.next <= len(it.objects)
+}
+
+func (it *sliceIterator) Item() *uplink.Object { return it.objects[it.next-1] }
+
+func (it *sliceIterator) Err() error { return nil }
+
+func TestHiddenKeys(t *testing.T) {
+	handler := &Handler{passwordProtection: true}
+	require.True(t, handler.hiddenKey(".password"))
+	require.True(t, handler.hiddenKey("photos/.password"))
+	require.False(t, handler.hiddenKey("photos/.password.txt"))
+	require.False(t, (&Handler{}).hiddenKey(".password"))
+
+	it := &hidingIterator{hidden: handler.hiddenKey, objectIterator: &sliceIterator{objects: []*uplink.Object{
+		{Key: "photos/.password"},
+		{Key: "photos/a.jpg"},
+		{Key: "photos/.password"},
+		{Key: "photos/b.jpg"},
+	}}}
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Item().Key)
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, []string{"photos/a.jpg", "photos/b.jpg"}, keys)
+}
+
+func TestPasswordCheckedAfterKeyTransform(t *testing.T) {
+	ctx := testcontext.New(t)
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:           []string{"http://test.test"},
+		Templates:          "../web",
+		PasswordProtection: true,
+		KeyTransformer:     prefixKeyTransformer("private/"),
+	})
+	require.NoError(t, err)
+
+	pr := &parsedRequest{access: newTestAccess(t), bucket: "bucket", realKey: "photo.jpg", checkPassword: true}
+	accessKey, err := passwordCacheAccessKey(pr)
+	require.NoError(t, err)
+	handler.passwordCache.set(accessKey+"/bucket/private/.password", passwordLookup{found: true, credentials: []passwordCredential{{password: "secret"}}}, time.Hour)
+	handler.passwordCache.set(accessKey+"/bucket/.password", passwordLookup{}, time.Hour)
+
+	// photo.jpg isn't protected, but the private/photo.jpg it maps to is.
+	w := httptest.NewRecorder()
+	err = handler.presentWithProject(ctx, w, httptest.NewRequest("GET", "http://test.test/s/access/bucket/photo.jpg", nil), pr, nil)
+	require.Equal(t, http.StatusUnauthorized, GetStatus(err, 0))
+	require.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	require.True(t, pr.checkPassword)
+}
+
+func TestExistsPasswords(t *testing.T) {
+	ctx := testcontext.New(t)
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:           []string{"http://test.test"},
+		Templates:          "../web",
+		PasswordProtection: true,
+	})
+	require.NoError(t, err)
+
+	access := newTestAccess(t)
+	accessKey, err := passwordCacheAccessKey(&parsedRequest{access: access})
+	require.NoError(t, err)
+	handler.passwordCache.set(accessKey+"/bucket/private/.password", passwordLookup{found: true, credentials: []passwordCredential{{password: "secret"}}}, time.Hour)
+	handler.passwordCache.set(accessKey+"/bucket/public/.password", passwordLookup{}, time.Hour)
+	handler.passwordCache.set(accessKey+"/bucket/.password", passwordLookup{}, time.Hour)
+
+	keys := []string{"public/a.jpg", "public/b.jpg", "private/c.jpg"}
+	r := httptest.NewRequest("GET", "http://test.test/exists/access/bucket", nil)
+	w := httptest.NewRecorder()
+	err = handler.checkKeyPasswords(ctx, w, r, nil, access, "bucket", keys)
+	require.Equal(t, http.StatusUnauthorized, GetStatus(err, 0))
+	require.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+
+	r.SetBasicAuth("", "secret")
+	require.NoError(t, handler.checkKeyPasswords(ctx, httptest.NewRecorder(), r, nil, access, "bucket", keys))
+
+	// password objects themselves never exist.
+	results, err := handler.statKeys(ctx, nil, "bucket", []string{"private/.password", ".password"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]existsResult{"private/.password": {}, ".password": {}}, results)
+}
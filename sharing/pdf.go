@@ -0,0 +1,50 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"storj.io/common/memory"
+	"storj.io/uplink"
+)
+
+// wantsPDFView returns whether the object should be shown embedded in the
+// PDF viewer page rather than served as is. ?view=pdf always asks for the
+// viewer and ?view=raw never does. A plain ?view on a PDF gets whatever the
+// configured default is.
+func (handler *Handler) wantsPDFView(q url.Values, contentType string) bool {
+	if vals := q["view"]; len(vals) > 0 {
+		switch strings.ToLower(vals[0]) {
+		case "pdf":
+			return true
+		case "raw":
+			return false
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return handler.pdfViewDefault && queryFlagLookup(q, "view", false) && mediaType == "application/pdf"
+}
+
+// servePDFView renders the PDF viewer page, which embeds the raw object.
+func (handler *Handler) servePDFView(w http.ResponseWriter, r *http.Request, pr *parsedRequest, o *uplink.Object) {
+	var input struct {
+		Key    string
+		Size   string
+		RawURL template.URL
+	}
+	input.Key = filepath.Base(o.Key)
+	input.Size = memory.Size(o.System.ContentLength).Base10String()
+	input.RawURL = template.URL("?view=raw" + pr.linkQuery)
+
+	handler.renderTemplate(w, r, "pdf-view.html", pageData{
+		Data:  input,
+		Title: input.Key,
+	})
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
+)
+
+func TestWantsPDFView(t *testing.T) {
+	for _, test := range []struct {
+		query       string
+		contentType string
+		pdfDefault  bool
+		expected    bool
+	}{
+		{query: "", contentType: "application/pdf", pdfDefault: true, expected: false},
+		{query: "view", contentType: "application/pdf", pdfDefault: false, expected: false},
+		{query: "view", contentType: "application/pdf", pdfDefault: true, expected: true},
+		{query: "view", contentType: "image/png", pdfDefault: true, expected: false},
+		{query: "view=0", contentType: "application/pdf", pdfDefault: true, expected: false},
+		{query: "view=raw", contentType: "application/pdf", pdfDefault: true, expected: false},
+		{query: "view=pdf", contentType: "application/pdf", pdfDefault: false, expected: true},
+	} {
+		q, err := url.ParseQuery(test.query)
+		assert.NoError(t, err)
+
+		handler := &Handler{pdfViewDefault: test.pdfDefault}
+		assert.Equal(t, test.expected, handler.wantsPDFView(q, test.contentType), "%q %q %v", test.query, test.contentType, test.pdfDefault)
+	}
+}
+
+func TestPDFView(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:       []string{"http://test.test"},
+		Templates:      "../web",
+		PDFViewDefault: true,
+	})
+	require.NoError(t, err)
+
+	ctx := testcontext.New(t)
+	object := &uplink.Object{Key: "docs/report.pdf"}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://test.test/?view", nil)
+	require.NoError(t, handler.showObject(ctx, w, r, &parsedRequest{linkQuery: "&scope=docs%2F"}, &uplink.Project{}, object))
+	require.Contains(t, w.Body.String(), `data="?view=raw&amp;scope=docs%2F"`)
+	require.Contains(t, w.Body.String(), "report.pdf")
+}
@@ -0,0 +1,220 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/uplink"
+)
+
+// Transform is a content transformation objects can be served through,
+// when requested with ?transform=<name>. Several can be chained, like
+// ?transform=a,b, each transforming the output of the one before.
+type Transform interface {
+	// Accepts returns whether the transform applies to content of the given
+	// type. Transforms that don't are skipped.
+	Accepts(contentType string) bool
+	// Apply returns in transformed. header holds the response headers, with
+	// the Content-Type of in, which Apply adjusts to describe its output.
+	// It must adjust header before reading in, as HEAD requests pass an
+	// empty in and never read the output.
+	Apply(ctx context.Context, in io.Reader, header http.Header, query url.Values) (io.Reader, error)
+}
+
+// BuiltinTransforms returns the transforms that come with the handler, by
+// name, to register in Config.Transforms.
+func BuiltinTransforms() map[string]Transform {
+	return map[string]Transform{
+		"gzip":   GzipTransform{},
+		"base64": Base64Transform{},
+	}
+}
+
+// GzipTransform compresses content that isn't compressed already, serving
+// it with Content-Encoding: gzip.
+type GzipTransform struct{}
+
+// compressedTypes are media types whose content is compressed already.
+var compressedTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/zstd":             true,
+	"application/pdf":              true,
+}
+
+// Accepts implements Transform.
+func (GzipTransform) Accepts(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return !compressedTypes[mediaType]
+}
+
+// Apply implements Transform.
+func (GzipTransform) Apply(ctx context.Context, in io.Reader, header http.Header, query url.Values) (io.Reader, error) {
+	header.Set("Content-Encoding", "gzip")
+	return newEncodingReader(in, func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	}), nil
+}
+
+// Base64Transform encodes content as base64 text, for embedding or for
+// clients that can only handle text.
+type Base64Transform struct{}
+
+// Accepts implements Transform.
+func (Base64Transform) Accepts(contentType string) bool { return true }
+
+// Apply implements Transform.
+func (Base64Transform) Apply(ctx context.Context, in io.Reader, header http.Header, query url.Values) (io.Reader, error) {
+	header.Set("Content-Type", "text/plain; charset=us-ascii")
+	return newEncodingReader(in, func(w io.Writer) io.WriteCloser {
+		return base64.NewEncoder(base64.StdEncoding, w)
+	}), nil
+}
+
+// encodingReader reads the output of an encoder fed from in, encoding as
+// the output is read rather than in a goroutine.
+type encodingReader struct {
+	in      io.Reader
+	chunk   []byte
+	encoded bytes.Buffer
+	encoder io.WriteCloser
+	done    bool
+}
+
+func newEncodingReader(in io.Reader, encoder func(io.Writer) io.WriteCloser) *encodingReader {
+	reader := &encodingReader{in: in, chunk: make([]byte, 32*1024)}
+	reader.encoder = encoder(&reader.encoded)
+	return reader
+}
+
+func (reader *encodingReader) Read(p []byte) (int, error) {
+	for reader.encoded.Len() == 0 && !reader.done {
+		n, err := reader.in.Read(reader.chunk)
+		if n > 0 {
+			if _, err := reader.encoder.Write(reader.chunk[:n]); err != nil {
+				return 0, err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			reader.done = true
+			if err := reader.encoder.Close(); err != nil {
+				return 0, err
+			}
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if reader.encoded.Len() == 0 {
+		return 0, io.EOF
+	}
+	return reader.encoded.Read(p)
+}
+
+// transformNames returns the transforms requested with ?transform, in
+// order, rejecting unknown ones.
+func (handler *Handler) transformNames(q url.Values) ([]string, error) {
+	value := q.Get("transform")
+	if value == "" || len(handler.transforms) == 0 {
+		return nil, nil
+	}
+	names := strings.Split(value, ",")
+	for _, name := range names {
+		if _, ok := handler.transforms[name]; !ok {
+			return nil, WithStatus(errs.New("unknown transform %q", name), http.StatusBadRequest)
+		}
+	}
+	return names, nil
+}
+
+// serveTransformed serves o through the named transforms. The output's size
+// isn't known up front, so it's served whole, without ranges.
+func (handler *Handler) serveTransformed(ctx context.Context, w http.ResponseWriter, r *http.Request, pr *parsedRequest, project *uplink.Project, o *uplink.Object, names []string, download bool) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	disposition := "inline"
+	if download {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, filepath.Base(o.Key)))
+	handler.setCORSHeaders(w, r, o)
+	setLastModified(w, o)
+	w.Header().Set("Content-Type", objectContentType(o))
+
+	var in io.Reader = strings.NewReader("")
+	if r.Method != http.MethodHead {
+		release, err := handler.acquireDownload(ctx, r, o)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		objectDownload, err := project.DownloadObject(ctx, pr.bucket, o.Key, nil)
+		if err != nil {
+			return WithAction(err, "download object")
+		}
+		defer func() {
+			if err := objectDownload.Close(); err != nil {
+				handler.log.With(zap.Error(err)).Warn("unable to close object download")
+			}
+		}()
+		in = objectDownload
+	}
+
+	q := r.URL.Query()
+	var skipped bool
+	for _, name := range names {
+		transform := handler.transforms[name]
+		if !transform.Accepts(w.Header().Get("Content-Type")) {
+			skipped = true
+			continue
+		}
+		in, err = transform.Apply(ctx, in, w.Header(), q)
+		if err != nil {
+			return WithAction(err, "transform "+name)
+		}
+	}
+	if skipped {
+		w.Header().Set(transformSkippedHeader, "content-type")
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Accept-Ranges", "none")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	_, err = io.Copy(handler.downloadBuffers.wrap(w), in)
+	return err
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
+)
+
+func TestBuiltinTransforms(t *testing.T) {
+	ctx := testcontext.New(t)
+	content := strings.Repeat("hello, world\n", 10000)
+
+	header := http.Header{"Content-Type": {"text/plain"}}
+	out, err := GzipTransform{}.Apply(ctx, strings.NewReader(content), header, nil)
+	require.NoError(t, err)
+	require.Equal(t, "gzip", header.Get("Content-Encoding"))
+	zr, err := gzip.NewReader(out)
+	require.NoError(t, err)
+	decompressed, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, content, string(decompressed))
+
+	header = http.Header{"Content-Type": {"image/png"}}
+	out, err = Base64Transform{}.Apply(ctx, strings.NewReader("\x89PNG"), header, nil)
+	require.NoError(t, err)
+	encoded, err := ioutil.ReadAll(out)
+	require.NoError(t, err)
+	require.Equal(t, "iVBORw==", string(encoded))
+	require.Equal(t, "text/plain; charset=us-ascii", header.Get("Content-Type"))
+
+	for contentType, accepted := range map[string]bool{
+		"text/html; charset=utf-8": true,
+		"application/json":         true,
+		"image/svg+xml":            true,
+		"image/jpeg":               false,
+		"video/mp4":                false,
+		"application/zip":          false,
+		"invalid;;":                true,
+	} {
+		require.Equal(t, accepted, GzipTransform{}.Accepts(contentType), contentType)
+	}
+}
+
+// upperTransform uppercases text, as a transform registered from outside.
+type upperTransform struct{}
+
+func (upperTransform) Accepts(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/")
+}
+
+func (upperTransform) Apply(ctx context.Context, in io.Reader, header http.Header, query url.Values) (io.Reader, error) {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(strings.ToUpper(string(data))), nil
+}
+
+func TestTransformPipeline(t *testing.T) {
+	ctx := testcontext.New(t)
+	handler := &Handler{transforms: map[string]Transform{
+		"upper":  upperTransform{},
+		"base64": Base64Transform{},
+	}}
+
+	names, err := handler.transformNames(url.Values{"transform": {"upper,base64"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"upper", "base64"}, names)
+
+	_, err = handler.transformNames(url.Values{"transform": {"upper,resize"}})
+	require.Equal(t, http.StatusBadRequest, GetStatus(err, 0))
+
+	// without registered transforms, ?transform means nothing.
+	names, err = (&Handler{}).transformNames(url.Values{"transform": {"upper"}})
+	require.NoError(t, err)
+	require.Empty(t, names)
+
+	// HEAD requests get the headers of the transformed object, and
+	// transforms that don't accept the content type are skipped.
+	o := &uplink.Object{Key: "image.png"}
+	o.System.ContentLength = 100
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("HEAD", "http://test.test/raw/access/bucket/image.png?transform=upper,base64", nil)
+	require.NoError(t, handler.serveTransformed(ctx, w, r, &parsedRequest{bucket: "bucket"}, nil, o, []string{"upper", "base64"}, false))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/plain; charset=us-ascii", w.Header().Get("Content-Type"))
+	require.Equal(t, "content-type", w.Header().Get(transformSkippedHeader))
+	require.Equal(t, "none", w.Header().Get("Accept-Ranges"))
+	require.Empty(t, w.Header().Get("Content-Length"))
+}
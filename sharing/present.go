@@ -8,11 +8,12 @@ import (
 	"errors"
 	"mime"
 	"net/http"
+	"net/url"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/zeebo/errs"
-	"go.uber.org/zap"
 
 	"storj.io/common/memory"
 	"storj.io/common/ranger/httpranger"
@@ -20,6 +21,15 @@ import (
 	"storj.io/uplink"
 )
 
+// trailingSlashRedirects are the statuses prefixes requested without their
+// trailing slash are redirected with, by name. "off" lists them in place.
+var trailingSlashRedirects = map[string]int{
+	"see-other": http.StatusSeeOther,
+	"permanent": http.StatusMovedPermanently,
+	"temporary": http.StatusFound,
+	"off":       0,
+}
+
 type parsedRequest struct {
 	access          *uplink.Access
 	bucket          string
@@ -29,26 +39,108 @@ type parsedRequest struct {
 	root            breadcrumb
 	wrapDefault     bool
 	downloadDefault bool
+
+	// forceDownload lists the kinds of objects always served as
+	// attachments instead of inline.
+	forceDownload typeSet
+
+	// htmlFallback makes a missing key without a trailing slash try
+	// key+".html" before the directory style redirect.
+	htmlFallback bool
+	// noListing makes prefixes without an index.html 404 instead of listing.
+	noListing bool
+	// noIndex makes prefixes list their contents even when they have an
+	// index.html.
+	noIndex bool
+	// noindexListings asks search engines not to index listings.
+	noindexListings bool
+	// linkBase is the relative base URL of listings, when they are served
+	// at a URL without the trailing slash.
+	linkBase string
+
+	// scope is the key prefix the access is limited to. listings don't link
+	// to prefixes above it.
+	scope string
+	// linkQuery holds query parameters that links in listings carry along,
+	// starting with "&".
+	linkQuery string
+
+	// earlyHints are Link header values sent as 103 Early Hints before
+	// serving HTML objects.
+	earlyHints []string
+
+	// keyPrefix is the prefix object keys are confined to, like a hosted
+	// site's root. transformed keys may not leave it.
+	keyPrefix string
+
+	// bot is set for requests from crawlers and link preview bots, which
+	// don't get expensive extras like piece locations.
+	bot bool
+
+	// checkPassword makes password objects protect the request.
+	checkPassword bool
 }
 
 func (handler *Handler) present(ctx context.Context, w http.ResponseWriter, r *http.Request, pr *parsedRequest) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := handler.uplink.OpenProject(ctx, pr.access)
+	handler.auditAccessScope(pr.access, pr.bucket)
+
+	project, release, err := handler.openProject(ctx, pr.access)
 	if err != nil {
 		return WithStatus(WithAction(err, "open project"), http.StatusBadRequest)
 	}
-	defer func() {
-		if err := project.Close(); err != nil {
-			handler.log.With(zap.Error(err)).Warn("unable to close project")
-		}
-	}()
+	defer release()
 
 	return handler.presentWithProject(ctx, w, r, pr, project)
 }
 
 func (handler *Handler) presentWithProject(ctx context.Context, w http.ResponseWriter, r *http.Request, pr *parsedRequest, project *uplink.Project) (err error) {
 	defer mon.Task()(&ctx)(&err)
+	defer func() {
+		if err != nil && handler.serveStale(ctx, w, r, pr, err) {
+			err = nil
+		}
+	}()
+
+	if err := handler.transformKey(ctx, pr); err != nil {
+		return err
+	}
+	if handler.hiddenKey(pr.realKey) {
+		return WithAction(uplink.ErrObjectNotFound, "stat object - hidden")
+	}
+
+	// passwords protect the key actually served, so this comes after any
+	// key transformation. prefixes served in place come back here, and don't
+	// need checking again.
+	if pr.checkPassword {
+		if err := handler.checkPassword(ctx, w, r, project, pr); err != nil {
+			return err
+		}
+		pr.checkPassword = false
+	}
+
+	// uplink can't read object versions yet. serving the latest version, or
+	// a 404 for a latest version that's a delete marker, to a request for a
+	// specific one would be wrong either way.
+	if _, ok := r.URL.Query()["versionId"]; ok {
+		return WithStatus(errs.New("object versions aren't supported"), http.StatusNotImplemented)
+	}
+
+	format, err := archiveFormat(r)
+	if err != nil {
+		return err
+	}
+	if format != "" && (pr.realKey == "" || strings.HasSuffix(pr.realKey, "/")) {
+		// an archive holds everything a listing would show, and more.
+		if pr.noListing {
+			return WithAction(uplink.ErrObjectNotFound, "serve archive - listing disabled")
+		}
+		if err := handler.checkTransformSignature(r, "archive", format); err != nil {
+			return err
+		}
+		return handler.serveArchive(ctx, w, r, project, pr, format)
+	}
 
 	// first, kick off background index.html request, if appropriate. we do this
 	// to cut down on sequential round trips.
@@ -60,7 +152,9 @@ func (handler *Handler) presentWithProject(ctx context.Context, w http.ResponseW
 	// stat object result away entirely.
 	indexResultCh := make(chan statResult, 1)
 
-	if pr.realKey == "" || strings.HasSuffix(pr.realKey, "/") {
+	if pr.noIndex {
+		indexResultCh <- statResult{err: WithAction(uplink.ErrObjectNotFound, "index.html skipped")}
+	} else if pr.realKey == "" || strings.HasSuffix(pr.realKey, "/") {
 		go func() {
 			obj, err := project.StatObject(ctx, pr.bucket, pr.realKey+"index.html")
 			indexResultCh <- statResult{obj: obj, err: err}
@@ -82,6 +176,16 @@ func (handler *Handler) presentWithProject(ctx context.Context, w http.ResponseW
 		if !strings.HasSuffix(pr.realKey, "/") {
 			objNotFoundErr := WithAction(err, "stat object")
 
+			if pr.htmlFallback {
+				o, err := project.StatObject(ctx, pr.bucket, pr.realKey+".html")
+				if err == nil {
+					return handler.showObject(ctx, w, r, pr, project, o)
+				}
+				if !errors.Is(err, uplink.ErrObjectNotFound) {
+					return WithAction(err, "stat object - html fallback")
+				}
+			}
+
 			// s3 has interesting behavior, which is if the object doesn't exist
 			// but is a prefix, it will issue a redirect to have a trailing slash.
 			isPrefix, err := handler.isPrefix(ctx, project, pr)
@@ -90,8 +194,15 @@ func (handler *Handler) presentWithProject(ctx context.Context, w http.ResponseW
 			}
 
 			if isPrefix {
-				http.Redirect(w, r, r.URL.Path+"/", http.StatusSeeOther)
-				return nil
+				if handler.trailingSlashRedirect != 0 {
+					handler.redirectTrailingSlash(ctx, w, r)
+					return nil
+				}
+				pr.realKey += "/"
+				if pr.visibleKey != "" {
+					pr.visibleKey += "/"
+				}
+				return handler.presentWithProject(ctx, w, r, pr, project)
 			}
 
 			return objNotFoundErr
@@ -110,61 +221,338 @@ func (handler *Handler) presentWithProject(ctx context.Context, w http.ResponseW
 	}
 
 	// special case for if the user requested a bucket but there's no trailing slash
-	if !strings.HasSuffix(r.URL.Path, "/") {
-		http.Redirect(w, r, r.URL.Path+"/", http.StatusSeeOther)
-		return nil
+	if public := publicPath(ctx, r); !strings.HasSuffix(public, "/") {
+		if handler.trailingSlashRedirect != 0 {
+			handler.redirectTrailingSlash(ctx, w, r)
+			return nil
+		}
+		// the listing is served in place, so its relative links need to
+		// resolve as if the slash were there.
+		pr.linkBase = path.Base((&url.URL{Path: public}).EscapedPath()) + "/"
+	}
+
+	if pr.noListing {
+		return WithAction(uplink.ErrObjectNotFound, "serve prefix - listing disabled")
 	}
 
-	return handler.servePrefix(ctx, w, project, pr)
+	return handler.servePrefix(ctx, w, r, project, pr)
 }
 
 func (handler *Handler) showObject(ctx context.Context, w http.ResponseWriter, r *http.Request, pr *parsedRequest, project *uplink.Project, o *uplink.Object) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
 	q := r.URL.Query()
+	pr.bot = handler.isBot(r)
 
 	if queryFlagLookup(q, "map", false) {
 		return handler.serveMap(ctx, w, pr, o, q)
 	}
 
+	if wantsJSON(q) {
+		return handler.serveObjectJSON(ctx, w, pr, o)
+	}
+
+	// everything else reads the object, which takes as long as it takes.
+	liftRequestTimeout(ctx)
+
+	download, wrap, err := handler.displayMode(q, pr)
+	if err != nil {
+		return err
+	}
+
+	transforms, err := handler.transformNames(q)
+	if err != nil {
+		return err
+	}
+	if len(transforms) > 0 {
+		if err := handler.checkTransformSignature(r, "transform", q.Get("transform")); err != nil {
+			return err
+		}
+		// transforms work on content as uploaded, not on encoded objects.
+		if customMetadata(o, contentEncodingMetadataKey) == "" {
+			return handler.serveTransformed(ctx, w, r, pr, project, o, transforms, download)
+		}
+		w.Header().Set(transformSkippedHeader, "content-encoding")
+	}
+
+	if !download && handler.wantsMarkdownView(q, o.Key) {
+		fits, err := handler.transformFits(w, minInt64(o.System.ContentLength, handler.textViewMaxSize))
+		if err != nil {
+			return err
+		}
+		if fits {
+			return handler.serveMarkdownView(ctx, w, r, pr, project, o)
+		}
+	}
+
+	if !download && handler.wantsTextView(q, o.Key) {
+		fits, err := handler.transformFits(w, minInt64(o.System.ContentLength, handler.textViewMaxSize))
+		if err != nil {
+			return err
+		}
+		if fits {
+			return handler.serveTextView(ctx, w, r, q, pr, project, o)
+		}
+	}
+
+	contentType := objectContentType(o)
+
+	if !download && handler.wantsWatermark(q, contentType) {
+		if err := handler.checkTransformSignature(r, "preview", q.Get("preview")); err != nil {
+			return err
+		}
+		return handler.serveWatermarked(ctx, w, r, pr, project, o)
+	}
+
+	if !download && handler.wantsPDFView(q, contentType) {
+		handler.servePDFView(w, r, pr, o)
+		return nil
+	}
+
+	// active content is only ever served inline where configured to.
+	if !download && !wrap && pr.forceDownload.matches(o.Key, contentType) {
+		download = true
+	}
+
+	if download && handler.needsDownloadConfirmation(q, o) {
+		return handler.serveDownloadConfirmation(ctx, w, r, q, o)
+	}
+
+	if download || !wrap {
+		// the filename is used when saving, whether downloaded or viewed.
+		disposition := "inline"
+		if download {
+			disposition = "attachment"
+		}
+		w.Header().Set("Content-Disposition", contentDisposition(disposition, filepath.Base(o.Key)))
+
+		handler.setCORSHeaders(w, r, o)
+
+		w.Header().Set("Content-Type", contentType)
+		handler.setStoredHeaders(w, o)
+		handler.setDebugHeaders(w, o)
+		handler.setStaleIfError(w)
+		handler.setCacheKeyHeader(w, pr, o)
+		setLastModified(w, o)
+		setETag(w, o)
+
+		if notModified(r, o) {
+			writeNotModified(w)
+			return nil
+		}
+
+		if value := q.Get("verify"); value != "" && handler.checksumVerification {
+			expected, err := parseVerify(value)
+			if err != nil {
+				return err
+			}
+			return handler.serveVerified(ctx, w, r, pr, project, o, expected)
+		}
+
+		if handler.gzipDecompression && storedGzip(o) {
+			served, err := handler.serveGzipped(ctx, w, r, pr, project, o)
+			if served || err != nil {
+				return err
+			}
+		}
+
+		// ServeContent only sets these for non-empty objects, but HEAD
+		// requests should see the same headers a GET would.
+		w.Header().Set("Accept-Ranges", "bytes")
+		if o.System.ContentLength <= 0 {
+			w.Header().Set("Content-Length", "0")
+		}
+
+		if !handler.checkRange(w, r, o.System.ContentLength) {
+			return nil
+		}
+
+		if !download && strings.HasPrefix(contentType, "text/html") {
+			writeEarlyHints(w, r, pr.earlyHints)
+		}
+
+		release, err := handler.acquireDownload(ctx, r, o)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		download := handler.checkLength(objectranger.New(project, o, pr.bucket), pr, o)
+		httpranger.ServeContent(ctx, handler.downloadBuffers.wrap(w), r, o.Key, o.System.Created, handler.objectRanger(r, pr, o, download))
+		if download.short() && handler.abortShortReads {
+			// responses without a Content-Length, like encoded ones, would
+			// otherwise look complete.
+			panic(http.ErrAbortHandler)
+		}
+		return nil
+	}
+
+	var input struct {
+		Key          string
+		Size         string
+		MapAvailable bool
+	}
+	input.Key = filepath.Base(o.Key)
+	input.Size = memory.Size(o.System.ContentLength).Base10String()
+	input.MapAvailable = handler.mapper.Available() && !pr.bot
+
+	handler.renderTemplate(w, r, "single-object.html", pageData{
+		Data:  input,
+		Title: input.Key,
+	})
+	return nil
+}
+
+// displayMode resolves how an object is shown from the download, wrap and
+// view flags. download on always downloads, whatever the other flags say.
+// Otherwise wrap on or off decides whether the object is shown in the
+// preview page, then view on means unwrapped, and without any of them the
+// URL's defaults apply.
+//
+// With strict display flags, contradictory combinations are rejected with
+// 400 instead: download with view, and wrap with view.
+func (handler *Handler) displayMode(q url.Values, pr *parsedRequest) (download, wrap bool, err error) {
 	// if someone provides the 'download' flag on or off, we do that, otherwise
 	// we do what the downloadDefault was (based on the URL scope).
-	download := queryFlagLookup(q, "download", pr.downloadDefault)
+	download = queryFlagLookup(q, "download", pr.downloadDefault)
 	// if we're not downloading, and someone provides the 'wrap' flag on or off,
 	// we do that. otherwise, we *don't* wrap if someone provided the view flag
 	// on, otherwise we fall back to what wrapDefault was.
-	wrap := queryFlagLookup(q, "wrap",
+	wrap = queryFlagLookup(q, "wrap",
 		!queryFlagLookup(q, "view", !pr.wrapDefault))
 
-	if download {
-		w.Header().Set("Content-Disposition", "attachment")
+	if handler.strictDisplayFlags && queryFlagLookup(q, "view", false) {
+		switch {
+		case queryFlagLookup(q, "download", false):
+			return false, false, WithStatus(errs.New("conflicting download and view flags"), http.StatusBadRequest)
+		case queryFlagLookup(q, "wrap", false):
+			return false, false, WithStatus(errs.New("conflicting wrap and view flags"), http.StatusBadRequest)
+		}
 	}
-	if download || !wrap {
-		contentType := mime.TypeByExtension(filepath.Ext(o.Key))
-		if contentType != "" {
-			w.Header().Set("Content-Type", contentType)
-		} else {
-			w.Header().Set("Content-Type", "application/octet-stream")
+	return download, wrap, nil
+}
+
+// contentTypeMetadataKey is the custom metadata key holding the content type
+// an object was uploaded with, as set by the S3 gateway and uplink CLI.
+const contentTypeMetadataKey = "content-type"
+
+// objectContentType returns the content type to serve o with: the one it was
+// uploaded with, if it's valid, and otherwise the one for its extension.
+func objectContentType(o *uplink.Object) string {
+	if value := customMetadata(o, contentTypeMetadataKey); value != "" {
+		if _, _, err := mime.ParseMediaType(value); err == nil {
+			return value
 		}
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(o.Key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
 
-		httpranger.ServeContent(ctx, w, r, o.Key, o.System.Created, objectranger.New(project, o, pr.bucket))
-		return nil
+// contentDisposition returns a Content-Disposition header value with a
+// sanitized filename. Non-ASCII names are encoded as RFC 5987 filename*.
+func contentDisposition(disposition, filename string) string {
+	filename = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); value != "" {
+		return value
+	}
+	return disposition
+}
+
+// typeSet is a set of file extensions (".svg") and media types
+// ("image/svg+xml") used to match objects.
+type typeSet map[string]bool
+
+// newTypeSet builds a typeSet from extensions and media types. Entries are
+// case insensitive.
+func newTypeSet(entries []string) typeSet {
+	set := make(typeSet, len(entries))
+	for _, entry := range entries {
+		set[strings.ToLower(strings.TrimSpace(entry))] = true
+	}
+	return set
+}
+
+// matches returns whether the key's extension or the content type's media
+// type is in the set.
+func (set typeSet) matches(key, contentType string) bool {
+	if len(set) == 0 {
+		return false
+	}
+	if ext := strings.ToLower(filepath.Ext(key)); ext != "" && set[ext] {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && set[mediaType]
+}
+
+// needsDownloadConfirmation returns whether downloading o should first show
+// the confirmation interstitial.
+func (handler *Handler) needsDownloadConfirmation(q url.Values, o *uplink.Object) bool {
+	return handler.downloadConfirmation &&
+		o.System.ContentLength >= handler.downloadConfirmationMinSize &&
+		!queryFlagLookup(q, "confirm", false)
+}
+
+// serveDownloadConfirmation renders a page warning about the size of the
+// download, linking to the same download with ?confirm=1.
+func (handler *Handler) serveDownloadConfirmation(ctx context.Context, w http.ResponseWriter, r *http.Request, q url.Values, o *uplink.Object) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	confirmed := url.Values{}
+	for name, vals := range q {
+		confirmed[name] = vals
 	}
+	confirmed.Set("confirm", "1")
 
 	var input struct {
-		Key  string
-		Size string
+		Key        string
+		Size       string
+		ConfirmURL string
 	}
 	input.Key = filepath.Base(o.Key)
 	input.Size = memory.Size(o.System.ContentLength).Base10String()
+	input.ConfirmURL = "?" + confirmed.Encode()
 
-	handler.renderTemplate(w, "single-object.html", pageData{
+	handler.renderTemplate(w, r, "download-confirm.html", pageData{
 		Data:  input,
 		Title: input.Key,
 	})
 	return nil
 }
 
+// localRedirectPath makes sure a redirect to the given path stays on the
+// current host. Object keys may start with a slash, which can make a path
+// start with "//", and browsers read that as a protocol relative URL
+// redirectTrailingSlash redirects to the path r was requested with, with a
+// trailing slash added.
+func (handler *Handler) redirectTrailingSlash(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, withRawQuery(localRedirectPath(publicPath(ctx, r)+"/"), r.URL.RawQuery), handler.trailingSlashRedirect)
+}
+
+// pointing at another host.
+func localRedirectPath(p string) string {
+	if strings.HasPrefix(p, "//") {
+		return "/." + p
+	}
+	return p
+}
+
+// withRawQuery appends rawQuery to a redirect path, so parameters like
+// listing cursors survive the redirect.
+func withRawQuery(p, rawQuery string) string {
+	if rawQuery == "" {
+		return p
+	}
+	return p + "?" + rawQuery
+}
+
 func (handler *Handler) isPrefix(ctx context.Context, project *uplink.Project, pr *parsedRequest) (bool, error) {
 	// we might not having listing permission. if this is the case,
 	// guess that we're looking for an index.html and look for that.
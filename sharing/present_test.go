@@ -4,9 +4,19 @@
 package sharing
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -53,3 +63,793 @@ func TestDownloadContentTypeHeader(t *testing.T) {
 	require.True(t, haveType)
 	require.Equal(t, "application/octet-stream", ctypes[0])
 }
+
+func TestObjectContentType(t *testing.T) {
+	for _, test := range []struct {
+		key      string
+		custom   uplink.CustomMetadata
+		expected string
+	}{
+		{key: "photo.png", expected: "image/png"},
+		{key: "data", expected: "application/octet-stream"},
+		{key: "data", custom: uplink.CustomMetadata{"content-type": "image/webp"}, expected: "image/webp"},
+		{key: "photo.png", custom: uplink.CustomMetadata{"Content-Type": " text/plain; charset=utf-8 "}, expected: "text/plain; charset=utf-8"},
+		{key: "photo.png", custom: uplink.CustomMetadata{"content-type": "not a type"}, expected: "image/png"},
+		{key: "photo.png", custom: uplink.CustomMetadata{"content-type": ""}, expected: "image/png"},
+	} {
+		o := &uplink.Object{Key: test.key, Custom: test.custom}
+		require.Equal(t, test.expected, objectContentType(o), "%s %v", test.key, test.custom)
+	}
+}
+
+func TestLocalRedirectPath(t *testing.T) {
+	require.Equal(t, "/s/access/bucket/prefix/", localRedirectPath("/s/access/bucket/prefix/"))
+	require.Equal(t, "/prefix/", localRedirectPath("/prefix/"))
+	require.Equal(t, "/.//evil.test/", localRedirectPath("//evil.test/"))
+
+	require.Equal(t, "/prefix/", withRawQuery("/prefix/", ""))
+	require.Equal(t, "/prefix/?cursor=abc", withRawQuery("/prefix/", "cursor=abc"))
+}
+
+func TestDownloadConfirmation(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:                    []string{"http://test.test"},
+		Templates:                   "../web",
+		DownloadConfirmation:        true,
+		DownloadConfirmationMinSize: 10,
+	})
+	require.NoError(t, err)
+
+	ctx := testcontext.New(t)
+	pr := &parsedRequest{}
+	project := &uplink.Project{}
+
+	object := &uplink.Object{Key: "big.bin"}
+	object.System.ContentLength = 1e9
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://test.test/?download", nil)
+	require.NoError(t, handler.showObject(ctx, w, r, pr, project, object))
+	require.Empty(t, w.Header().Get("Content-Disposition"))
+	require.Contains(t, w.Body.String(), "1.00 GB")
+	require.Contains(t, w.Body.String(), "?confirm=1&amp;download=")
+
+	// small objects download right away.
+	object = &uplink.Object{Key: "small.bin"}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "http://test.test/?download", nil)
+	require.NoError(t, handler.showObject(ctx, w, r, pr, project, object))
+	require.Equal(t, "attachment; filename=small.bin", w.Header().Get("Content-Disposition"))
+}
+
+func TestArchiveFormat(t *testing.T) {
+	for _, test := range []struct {
+		query  string
+		format string
+		status int
+	}{
+		{query: "", format: ""},
+		{query: "archive=zip", format: "zip"},
+		{query: "archive=ZIP", format: "zip"},
+		{query: "archive=tar", format: "tar"},
+		{query: "archive=rar", status: http.StatusBadRequest},
+		{query: "download=zip", format: "zip"},
+		{query: "download=TAR", format: "tar"},
+		{query: "download=1", format: ""},
+		{query: "archive=tar&download=zip", format: "tar"},
+	} {
+		r := httptest.NewRequest("GET", "http://test.test/?"+test.query, nil)
+		format, err := archiveFormat(r)
+		if test.status != 0 {
+			require.Equal(t, test.status, GetStatus(err, 0), test.query)
+			continue
+		}
+		require.NoError(t, err, test.query)
+		require.Equal(t, test.format, format, test.query)
+	}
+}
+
+func TestDisplayMode(t *testing.T) {
+	for _, test := range []struct {
+		query       string
+		wrapDefault bool
+		download    bool
+		wrap        bool
+		strictError bool
+	}{
+		{query: "", wrapDefault: true, wrap: true},
+		{query: "", wrapDefault: false, wrap: false},
+		{query: "download", wrapDefault: true, download: true, wrap: true},
+		{query: "download=0", wrapDefault: true, wrap: true},
+		{query: "view", wrapDefault: true, wrap: false},
+		{query: "view=0", wrapDefault: false, wrap: true},
+		{query: "wrap=1", wrapDefault: false, wrap: true},
+		{query: "wrap=0", wrapDefault: true, wrap: false},
+		{query: "wrap=0&view", wrapDefault: true, wrap: false},
+		{query: "download&view", wrapDefault: true, download: true, wrap: false, strictError: true},
+		{query: "download&view=0", wrapDefault: true, download: true, wrap: true},
+		{query: "download=0&view", wrapDefault: true, wrap: false},
+		{query: "wrap=1&view", wrapDefault: false, wrap: true, strictError: true},
+		{query: "download&wrap=1", wrapDefault: false, download: true, wrap: true},
+	} {
+		q, err := url.ParseQuery(test.query)
+		require.NoError(t, err)
+		pr := &parsedRequest{wrapDefault: test.wrapDefault}
+
+		download, wrap, err := (&Handler{}).displayMode(q, pr)
+		require.NoError(t, err, test.query)
+		require.Equal(t, test.download, download, test.query)
+		require.Equal(t, test.wrap, wrap, test.query)
+
+		download, wrap, err = (&Handler{strictDisplayFlags: true}).displayMode(q, pr)
+		if test.strictError {
+			require.Equal(t, http.StatusBadRequest, GetStatus(err, 0), test.query)
+			continue
+		}
+		require.NoError(t, err, test.query)
+		require.Equal(t, test.download, download, test.query)
+		require.Equal(t, test.wrap, wrap, test.query)
+	}
+}
+
+func TestVersionIDNotSupported(t *testing.T) {
+	ctx := testcontext.New(t)
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+
+	for _, key := range []string{"photo.jpg", "", "dir/"} {
+		r := httptest.NewRequest("GET", "http://test.test/s/access/bucket/"+key+"?versionId=1", nil)
+		pr := &parsedRequest{bucket: "bucket", realKey: key, visibleKey: key}
+		err := handler.presentWithProject(ctx, httptest.NewRecorder(), r, pr, nil)
+		require.Error(t, err, key)
+		require.Equal(t, http.StatusNotImplemented, GetStatus(err, 0), key)
+	}
+}
+
+func TestArchiveFits(t *testing.T) {
+	handler := &Handler{archiveMaxObjects: 2, archiveMaxBytes: 100}
+
+	require.NoError(t, handler.archiveFits(2, 100))
+	require.Equal(t, http.StatusRequestEntityTooLarge, GetStatus(handler.archiveFits(3, 10), 0))
+	require.Equal(t, http.StatusRequestEntityTooLarge, GetStatus(handler.archiveFits(1, 101), 0))
+
+	unlimited := &Handler{}
+	require.NoError(t, unlimited.archiveFits(1<<20, 1<<40))
+}
+
+func TestArchiveEntryName(t *testing.T) {
+	for _, test := range []struct {
+		key, prefix string
+		name        string
+		ok          bool
+	}{
+		{key: "dir/a.txt", prefix: "dir/", name: "a.txt", ok: true},
+		{key: "dir/sub/a.txt", prefix: "dir/", name: "sub/a.txt", ok: true},
+		{key: "dir/sub/", prefix: "dir/", name: "sub/", ok: true},
+		{key: "/etc/passwd", prefix: "", name: "etc/passwd", ok: true},
+		{key: "dir//etc/passwd", prefix: "dir/", name: "etc/passwd", ok: true},
+		{key: "dir/a/../b.txt", prefix: "dir/", name: "b.txt", ok: true},
+		{key: "dir/./a.txt", prefix: "dir/", name: "a.txt", ok: true},
+		{key: "dir/a/../../x", prefix: "dir/", ok: false},
+		{key: "dir/../x", prefix: "dir/", ok: false},
+		{key: "../../etc/passwd", prefix: "", ok: false},
+		{key: "/../x", prefix: "", ok: false},
+		{key: "..", prefix: "", ok: false},
+		{key: "dir/", prefix: "dir/", ok: false},
+	} {
+		name, ok := archiveEntryName(test.key, test.prefix)
+		require.Equal(t, test.ok, ok, test.key)
+		require.Equal(t, test.name, name, test.key)
+	}
+}
+
+func TestArchiveProtectedPrefixes(t *testing.T) {
+	scan := archiveScan{protected: []string{"dir/private/", "dir/a/b/"}}
+	it := &hidingIterator{hidden: scan.protects, objectIterator: &sliceIterator{objects: []*uplink.Object{
+		{Key: "dir/a.txt"},
+		{Key: "dir/private/secret.txt"},
+		{Key: "dir/private/deeper/secret.txt"},
+		{Key: "dir/privateer.txt"},
+		{Key: "dir/a/b.txt"},
+		{Key: "dir/a/b/c.txt"},
+	}}}
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Item().Key)
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, []string{"dir/a.txt", "dir/privateer.txt", "dir/a/b.txt"}, keys)
+}
+
+func TestReproducibleArchive(t *testing.T) {
+	created := time.Date(2021, 6, 1, 12, 0, 0, 123456789, time.UTC)
+
+	write := func(format string, loc *time.Location) []byte {
+		item := &uplink.Object{Key: "a.txt"}
+		item.System.Created = created.In(loc)
+		item.System.ContentLength = 5
+
+		var buf bytes.Buffer
+		var archive archiveWriter
+		if format == "tar" {
+			archive = tarArchive{tar.NewWriter(&buf), true}
+		} else {
+			archive = zipArchive{zip.NewWriter(&buf), true}
+		}
+		entry, err := archive.Next(item, item.Key)
+		require.NoError(t, err)
+		_, err = entry.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, archive.Close())
+		return buf.Bytes()
+	}
+
+	elsewhere := time.FixedZone("elsewhere", 5*60*60)
+	for _, format := range []string{"zip", "tar"} {
+		require.Equal(t, write(format, time.UTC), write(format, elsewhere), format)
+	}
+
+	item := &uplink.Object{}
+	item.System.Created = created.In(elsewhere)
+	require.Equal(t, created.In(elsewhere), archiveModTime(item, false))
+	require.Equal(t, time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), archiveModTime(item, true))
+
+	// sorting is bounded only by the object cap.
+	_, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:            []string{"http://test.test"},
+		Templates:           "../web",
+		ArchiveReproducible: true,
+	})
+	require.Error(t, err)
+	_, err = NewHandler(zap.NewNop(), nil, Config{
+		URLBases:            []string{"http://test.test"},
+		Templates:           "../web",
+		ArchiveReproducible: true,
+		ArchiveMaxObjects:   100,
+	})
+	require.NoError(t, err)
+}
+
+func TestArchiveLimit(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:              []string{"http://test.test"},
+		Templates:             "../web",
+		MaxConcurrentArchives: 3,
+		ArchiveRetryAfter:     1500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	// ten archive requests come in at once, and only three get a slot.
+	var (
+		mu       sync.Mutex
+		releases []func()
+		rejected []*httptest.ResponseRecorder
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			release, err := handler.acquireArchive(w)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				require.Equal(t, http.StatusServiceUnavailable, GetStatus(err, 0))
+				rejected = append(rejected, w)
+				return
+			}
+			releases = append(releases, release)
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, releases, 3)
+	require.Len(t, rejected, 7)
+	for _, w := range rejected {
+		require.Equal(t, "2", w.Header().Get("Retry-After"))
+	}
+
+	// finished archives free their slots for the next ones.
+	releases[0]()
+	release, err := handler.acquireArchive(httptest.NewRecorder())
+	require.NoError(t, err)
+	release()
+}
+
+func TestArchiveCacheKey(t *testing.T) {
+	pr := &parsedRequest{access: newTestAccess(t), bucket: "bucket", realKey: "photos/"}
+
+	key, err := archiveCacheKey(pr, "zip", "etag")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(key, "archive:"))
+	require.True(t, strings.HasSuffix(key, "/bucket/photos/.zip@etag"))
+
+	tarKey, err := archiveCacheKey(pr, "tar", "etag")
+	require.NoError(t, err)
+	require.NotEqual(t, key, tarKey)
+
+	changed, err := archiveCacheKey(pr, "zip", "other")
+	require.NoError(t, err)
+	require.NotEqual(t, key, changed)
+}
+
+func TestHeadObjectHeaders(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+
+	ctx := testcontext.New(t)
+	created := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// nothing is downloaded for HEAD requests, however large the object.
+	for _, size := range []int64{0, 1234, 5 << 30} {
+		object := &uplink.Object{Key: "index.html"}
+		object.System.ContentLength = size
+		object.System.Created = created
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("HEAD", "http://test.test/", nil)
+		require.NoError(t, handler.showObject(ctx, w, r, &parsedRequest{}, &uplink.Project{}, object))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, fmt.Sprint(size), w.Header().Get("Content-Length"))
+		require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		require.Equal(t, created.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+		require.Zero(t, w.Body.Len())
+	}
+}
+
+func TestRangeRequest(t *testing.T) {
+	ctx := testcontext.New(t)
+	access := newTestAccess(t)
+
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+		BodyCache: NewMemoryBodyCache(1024),
+	})
+	require.NoError(t, err)
+
+	body := "0123456789abcdefghijklmnopqrstuvwxyz"
+	object := &uplink.Object{Key: "video.mp4"}
+	object.System.ContentLength = int64(len(body))
+	object.System.Created = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// serve the body from the cache, as there is no project to download from.
+	pr := &parsedRequest{access: access, bucket: "bucket"}
+	key, err := bodyCacheKey(access, pr.bucket, object)
+	require.NoError(t, err)
+	handler.bodyCache.Set(ctx, key, []byte(body), time.Hour)
+
+	for _, test := range []struct {
+		rangeHeader  string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{rangeHeader: "bytes=10-19", status: http.StatusPartialContent, contentRange: "bytes 10-19/36", body: "abcdefghij"},
+		{rangeHeader: "bytes=-6", status: http.StatusPartialContent, contentRange: "bytes 30-35/36", body: "uvwxyz"},
+		{rangeHeader: "bytes=30-", status: http.StatusPartialContent, contentRange: "bytes 30-35/36", body: "uvwxyz"},
+		{rangeHeader: "bytes=40-50", status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */36"},
+		{rangeHeader: "", status: http.StatusOK, body: body},
+		{rangeHeader: "bytes=0-", status: http.StatusPartialContent, contentRange: "bytes 0-35/36", body: body},
+		{rangeHeader: "bytes=-0", status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */36"},
+		{rangeHeader: "bytes=0-1,-0", status: http.StatusPartialContent, contentRange: "bytes 0-1/36", body: "01"},
+		{rangeHeader: "bytes=abc", status: http.StatusOK, body: body},
+		{rangeHeader: "bytes=5-2", status: http.StatusOK, body: body},
+		{rangeHeader: "bytes=-", status: http.StatusOK, body: body},
+		{rangeHeader: "items=0-5", status: http.StatusOK, body: body},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://test.test/?view", nil)
+		if test.rangeHeader != "" {
+			r.Header.Set("Range", test.rangeHeader)
+		}
+		require.NoError(t, handler.showObject(ctx, w, r, pr, &uplink.Project{}, object))
+
+		require.Equal(t, test.status, w.Code, test.rangeHeader)
+		require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"), test.rangeHeader)
+		require.Equal(t, test.contentRange, w.Header().Get("Content-Range"), test.rangeHeader)
+		if test.status != http.StatusRequestedRangeNotSatisfiable {
+			require.Equal(t, test.body, w.Body.String(), test.rangeHeader)
+			require.Equal(t, fmt.Sprint(len(test.body)), w.Header().Get("Content-Length"), test.rangeHeader)
+		}
+	}
+}
+
+func TestNormalizeRange(t *testing.T) {
+	for _, test := range []struct {
+		header        string
+		fullAsOK      bool
+		value         string
+		unsatisfiable bool
+	}{
+		{header: "bytes=0-", value: "bytes=0-"},
+		{header: "bytes=0-", fullAsOK: true, value: ""},
+		{header: "bytes=-36", fullAsOK: true, value: ""},
+		{header: "bytes=0-99", fullAsOK: true, value: ""},
+		{header: "bytes=0-34", fullAsOK: true, value: "bytes=0-34"},
+		{header: "bytes=0-,5-9", fullAsOK: true, value: "bytes=0-,5-9"},
+		{header: "bytes= 1-2, ,4-5", value: "bytes=1-2,4-5"},
+		{header: "bytes=1-2,40-", value: "bytes=1-2"},
+		{header: "bytes=-0", unsatisfiable: true},
+		{header: "bytes=36-", unsatisfiable: true},
+		{header: "bytes=", value: ""},
+		{header: "bytes=+1-2", value: ""},
+		{header: "bytes=1", value: ""},
+		{header: "bytes=1-2,x", value: ""},
+		{header: "Bytes=1-2", value: ""},
+	} {
+		value, unsatisfiable := normalizeRange(test.header, 36, test.fullAsOK)
+		require.Equal(t, test.value, value, test.header)
+		require.Equal(t, test.unsatisfiable, unsatisfiable, test.header)
+	}
+}
+
+func TestForceDownload(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+
+	ctx := testcontext.New(t)
+	pr := &parsedRequest{forceDownload: newTypeSet([]string{"image/svg+xml", ".HTML"})}
+
+	for _, test := range []struct {
+		key         string
+		disposition string
+	}{
+		{key: "logo.svg", disposition: "attachment; filename=logo.svg"},
+		{key: "index.html", disposition: "attachment; filename=index.html"},
+		{key: "INDEX.HTM", disposition: "inline; filename=INDEX.HTM"},
+		{key: "photo.png", disposition: "inline; filename=photo.png"},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("HEAD", "http://test.test/?view", nil)
+		require.NoError(t, handler.showObject(ctx, w, r, pr, &uplink.Project{}, &uplink.Object{Key: test.key}))
+		require.Equal(t, test.disposition, w.Header().Get("Content-Disposition"), test.key)
+	}
+}
+
+func TestListingPageURLs(t *testing.T) {
+	page := func(query string) url.Values {
+		q, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		return q
+	}
+
+	// first page
+	prev, next := listingPageURLs(page(""), "", "b")
+	require.Empty(t, prev)
+	require.Equal(t, "?cursor=b&prev=&wrap=1", string(next))
+
+	// second page, back to the first
+	prev, next = listingPageURLs(page("cursor=b&prev="), "", "c")
+	require.Equal(t, "?wrap=1", string(prev))
+	require.Equal(t, "?cursor=c&prev=%2Cb&wrap=1", string(next))
+
+	// third and last page
+	prev, next = listingPageURLs(page("cursor=c&prev=,b"), "&scope=docs%2F", "")
+	require.Equal(t, "?cursor=b&prev=&wrap=1&scope=docs%2F", string(prev))
+	require.Empty(t, next)
+}
+
+func TestListLimit(t *testing.T) {
+	for _, test := range []struct {
+		pageSize, maxSize int
+		limit             int
+		capped            bool
+	}{
+		{pageSize: 10, maxSize: 25, limit: 10, capped: false},
+		{pageSize: 10, maxSize: 10, limit: 10, capped: false},
+		{pageSize: 100, maxSize: 25, limit: 25, capped: true},
+	} {
+		handler := &Handler{listPageSize: test.pageSize, maxListSize: test.maxSize}
+		limit, capped := handler.listLimit(listingSort{})
+		require.Equal(t, test.limit, limit, test)
+		require.Equal(t, test.capped, capped, test)
+	}
+
+	// sorted listings aren't paged, only capped.
+	limit, capped := (&Handler{listPageSize: 10, maxListSize: 25}).listLimit(listingSort{field: "size", order: "asc"})
+	require.Equal(t, 25, limit)
+	require.True(t, capped)
+
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1000, handler.maxListSize)
+}
+
+func TestListingBreadcrumbs(t *testing.T) {
+	pr := &parsedRequest{
+		bucket:     "bucket",
+		realKey:    "a/b/c/d/",
+		visibleKey: "a/b/c/d/",
+		root:       breadcrumb{Prefix: "bucket", URL: "/s/access/bucket/", Disabled: true},
+		scope:      "a/b/",
+		linkQuery:  "&scope=a%2Fb%2F",
+	}
+
+	require.Equal(t, []breadcrumb{
+		{Prefix: "bucket", URL: "/s/access/bucket/?scope=a%2Fb%2F", Disabled: true},
+		{Prefix: "a", URL: "/s/access/bucket/a/?scope=a%2Fb%2F", Disabled: true},
+		{Prefix: "b", URL: "/s/access/bucket/a/b/?scope=a%2Fb%2F"},
+		{Prefix: "c", URL: "/s/access/bucket/a/b/c/?scope=a%2Fb%2F"},
+		{Prefix: "d", URL: "/s/access/bucket/a/b/c/d/?scope=a%2Fb%2F"},
+	}, listingBreadcrumbs(pr, false))
+
+	// hosted sites have their root in front of the visible key.
+	pr = &parsedRequest{
+		bucket:     "bucket",
+		realKey:    "site/docs/",
+		visibleKey: "docs/",
+		root:       breadcrumb{Prefix: "site.test", URL: "/"},
+	}
+	require.Equal(t, []breadcrumb{
+		{Prefix: "site.test", URL: "/"},
+		{Prefix: "docs", URL: "/docs/"},
+	}, listingBreadcrumbs(pr, false))
+}
+
+func TestRelativeListingBreadcrumbs(t *testing.T) {
+	for _, test := range []struct {
+		listing string
+		pr      *parsedRequest
+	}{
+		{
+			listing: "http://test.test/s/access/bucket/a/b/c/",
+			pr: &parsedRequest{
+				bucket:     "bucket",
+				realKey:    "a/b/c/",
+				visibleKey: "a/b/c/",
+				root:       breadcrumb{Prefix: "bucket", URL: "/s/access/bucket/"},
+				linkQuery:  "&restrict=token",
+			},
+		},
+		{
+			listing: "http://site.test/docs/guides/",
+			pr: &parsedRequest{
+				bucket:     "bucket",
+				realKey:    "site/docs/guides/",
+				visibleKey: "docs/guides/",
+				root:       breadcrumb{Prefix: "site.test", URL: "/"},
+			},
+		},
+		{
+			listing: "http://test.test/s/access/bucket/",
+			pr: &parsedRequest{
+				bucket: "bucket",
+				root:   breadcrumb{Prefix: "bucket", URL: "/s/access/bucket/"},
+			},
+		},
+	} {
+		listing, err := url.Parse(test.listing)
+		require.NoError(t, err)
+
+		absolute := listingBreadcrumbs(test.pr, false)
+		relative := listingBreadcrumbs(test.pr, true)
+		require.Len(t, relative, len(absolute), test.listing)
+
+		// both modes must lead to the same places from the listing.
+		for i := range absolute {
+			require.False(t, strings.HasPrefix(relative[i].URL, "/"), relative[i].URL)
+			target, err := listing.Parse(relative[i].URL)
+			require.NoError(t, err)
+			require.Equal(t, absolute[i].URL, target.RequestURI(), test.listing)
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	for _, test := range []struct {
+		disposition string
+		filename    string
+		expected    string
+	}{
+		{disposition: "inline", filename: "photo.png", expected: "inline; filename=photo.png"},
+		{disposition: "attachment", filename: "my report.pdf", expected: `attachment; filename="my report.pdf"`},
+		{disposition: "inline", filename: `say "hi".txt`, expected: `inline; filename="say \"hi\".txt"`},
+		{disposition: "inline", filename: "résumé.pdf", expected: "inline; filename*=utf-8''r%C3%A9sum%C3%A9.pdf"},
+		{disposition: "inline", filename: "evil\r\nX-Injected: 1", expected: `inline; filename="evil__X-Injected: 1"`},
+	} {
+		require.Equal(t, test.expected, contentDisposition(test.disposition, test.filename), test.filename)
+	}
+}
+
+func TestSortListing(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2021, 6, d, 0, 0, 0, 0, time.UTC) }
+	objects := func() []listingObject {
+		return []listingObject{
+			{Key: "b.txt", size: 30, created: day(1)},
+			{Key: "z/", Prefix: true},
+			{Key: "a.txt", size: 20, created: day(3)},
+			{Key: "c.txt", size: 10, created: day(2)},
+			{Key: "m/", Prefix: true},
+		}
+	}
+	keys := func(objects []listingObject) string {
+		var keys []string
+		for _, o := range objects {
+			keys = append(keys, o.Key)
+		}
+		return strings.Join(keys, " ")
+	}
+
+	for _, test := range []struct {
+		query string
+		keys  string
+	}{
+		{query: "", keys: "b.txt z/ a.txt c.txt m/"},
+		{query: "sort=name", keys: "m/ z/ a.txt b.txt c.txt"},
+		{query: "order=desc", keys: "z/ m/ c.txt b.txt a.txt"},
+		{query: "sort=size", keys: "m/ z/ c.txt a.txt b.txt"},
+		{query: "sort=size&order=desc", keys: "z/ m/ b.txt a.txt c.txt"},
+		{query: "sort=modified", keys: "m/ z/ b.txt c.txt a.txt"},
+		{query: "sort=modified&order=desc", keys: "z/ m/ a.txt c.txt b.txt"},
+	} {
+		q, err := url.ParseQuery(test.query)
+		require.NoError(t, err)
+		sorting, err := parseListingSort(q)
+		require.NoError(t, err, test.query)
+
+		sorted := objects()
+		sortListing(sorted, sorting)
+		require.Equal(t, test.keys, keys(sorted), test.query)
+	}
+
+	for _, query := range []string{"sort=owner", "sort=name&order=up"} {
+		q, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		_, err = parseListingSort(q)
+		require.Equal(t, http.StatusBadRequest, GetStatus(err, 0), query)
+	}
+
+	sorting := listingSort{field: "size", order: "asc"}
+	require.Equal(t, "&sort=size&order=asc", sorting.query())
+	require.Equal(t, map[string]template.URL{
+		"name":     "?wrap=1&sort=name&order=asc&scope=a%2F",
+		"size":     "?wrap=1&sort=size&order=desc&scope=a%2F",
+		"modified": "?wrap=1&sort=modified&order=asc&scope=a%2F",
+	}, sorting.urls("&scope=a%2F"))
+}
+
+func TestSortedListingAcrossPages(t *testing.T) {
+	handler := &Handler{listPageSize: 2, maxListSize: 4}
+	objects := func() objectIterator {
+		return &sliceIterator{objects: []*uplink.Object{
+			{Key: "p/a.txt", System: uplink.SystemMetadata{ContentLength: 3}},
+			{Key: "p/b.txt", System: uplink.SystemMetadata{ContentLength: 1}},
+			{Key: "p/c.txt", System: uplink.SystemMetadata{ContentLength: 5}},
+			{Key: "p/d.txt", System: uplink.SystemMetadata{ContentLength: 2}},
+			{Key: "p/e.txt", System: uplink.SystemMetadata{ContentLength: 4}},
+		}}
+	}
+	keys := func(objects []listingObject) string {
+		var keys []string
+		for _, o := range objects {
+			keys = append(keys, o.Key)
+		}
+		return strings.Join(keys, " ")
+	}
+
+	// in key order, pages of two follow each other.
+	limit, capped := handler.listLimit(listingSort{})
+	entries, truncated, err := collectListing(objects(), "p/", limit)
+	require.NoError(t, err)
+	require.Equal(t, "a.txt b.txt", keys(entries))
+	require.True(t, truncated)
+	require.False(t, capped)
+
+	// sorted, the listing spans what would be several pages, and is sorted
+	// as a whole rather than page by page.
+	sorting := listingSort{field: "size", order: "asc"}
+	limit, capped = handler.listLimit(sorting)
+	entries, truncated, err = collectListing(objects(), "p/", limit)
+	require.NoError(t, err)
+	sortListing(entries, sorting)
+	require.Equal(t, "b.txt d.txt a.txt c.txt", keys(entries))
+	require.True(t, truncated)
+	require.True(t, capped)
+
+	handler.maxListSize = 10
+	limit, _ = handler.listLimit(sorting)
+	entries, truncated, err = collectListing(objects(), "p/", limit)
+	require.NoError(t, err)
+	sortListing(entries, sorting)
+	require.Equal(t, "b.txt d.txt a.txt e.txt c.txt", keys(entries))
+	require.False(t, truncated)
+}
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	for mode, status := range map[string]int{
+		"":          http.StatusSeeOther,
+		"see-other": http.StatusSeeOther,
+		"permanent": http.StatusMovedPermanently,
+		"temporary": http.StatusFound,
+		"off":       0,
+	} {
+		handler, err := NewHandler(zap.NewNop(), nil, Config{
+			URLBases:              []string{"http://test.test"},
+			Templates:             "../web",
+			TrailingSlashRedirect: mode,
+		})
+		require.NoError(t, err, mode)
+		require.Equal(t, status, handler.trailingSlashRedirect, mode)
+	}
+
+	_, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:              []string{"http://test.test"},
+		Templates:             "../web",
+		TrailingSlashRedirect: "sometimes",
+	})
+	require.Error(t, err)
+
+	// pages served in place resolve their relative links from the prefix.
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "http://test.test/s/access/bucket/photos", nil)
+	w := httptest.NewRecorder()
+	handler.renderTemplate(w, r, "error.html", pageData{Data: "listing", LinkBase: "photos/"})
+	require.Contains(t, w.Body.String(), `<base href="photos/">`)
+
+	w = httptest.NewRecorder()
+	handler.renderTemplate(w, r, "error.html", pageData{Data: "listing"})
+	require.NotContains(t, w.Body.String(), "<base")
+}
+
+func TestListingCategory(t *testing.T) {
+	for _, test := range []struct {
+		key      string
+		prefix   bool
+		category string
+	}{
+		{key: "photos/", prefix: true, category: "folder"},
+		{key: "photo.jpg", category: "image"},
+		{key: "PHOTO.JPG", category: "image"},
+		{key: "clip.mp4", category: "video"},
+		{key: "song.mp3", category: "audio"},
+		{key: "report.pdf", category: "document"},
+		{key: "backup.tar.gz", category: "archive"},
+		{key: "binary", category: "file"},
+		{key: "script.sh", category: "file"},
+		{key: "notes.zip/", prefix: true, category: "folder"},
+	} {
+		require.Equal(t, test.category, listingCategory(test.key, test.prefix), test.key)
+	}
+}
+
+func TestServeListingHead(t *testing.T) {
+	w := httptest.NewRecorder()
+	objects := &sliceIterator{objects: []*uplink.Object{{Key: "photos/a.jpg"}, {Key: "photos/b.jpg"}}}
+	require.NoError(t, serveListingHead(w, objects, "", "text/html; charset=utf-8"))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Zero(t, w.Body.Len())
+	require.Equal(t, 1, objects.next)
+
+	// an empty prefix is missing, but pages past the end aren't.
+	err := serveListingHead(httptest.NewRecorder(), &sliceIterator{}, "", "application/json")
+	require.True(t, errors.Is(err, uplink.ErrObjectNotFound))
+
+	w = httptest.NewRecorder()
+	require.NoError(t, serveListingHead(w, &sliceIterator{}, "photos/b.jpg", "application/json"))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+}
@@ -0,0 +1,224 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+const (
+	presignAlgorithm  = "AWS4-HMAC-SHA256"
+	presignTimeFormat = "20060102T150405Z"
+	presignMaxExpires = 7 * 24 * 60 * 60
+)
+
+// handlerRoutes are the first path segments of the handler's own routes.
+// Requests for them are never pre-signed URLs, whatever their query.
+var handlerRoutes = map[string]bool{
+	"s": true, "raw": true, "q": true, "exists": true, "static": true, "health": true,
+}
+
+// isPresigned returns whether the request carries a pre-signed URL signature
+// and isn't for one of the handler's own routes.
+func isPresigned(r *http.Request) bool {
+	route := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	return !handlerRoutes[route] && r.URL.Query().Get("X-Amz-Signature") != ""
+}
+
+// handlePresigned serves path style S3 pre-signed GET and HEAD URLs, of the
+// form /<bucket>/<key>?X-Amz-.... The access key in the credential is
+// resolved like any other shared access key. URLs signed for GET may be
+// requested with HEAD too, as S3 allows.
+func (handler *Handler) handlePresigned(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	accessKeyID, err := verifyPresigned(r, handler.presignSecretKey, time.Now())
+	if err != nil {
+		return err
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return WithStatus(errs.New("pre-signed URLs must name an object"), http.StatusBadRequest)
+	}
+	bucket, key := parts[0], parts[1]
+
+	if err := checkKeyLimits(key, handler.maxKeyLength, handler.maxKeyDepth); err != nil {
+		return err
+	}
+
+	access, err := parseAccess(ctx, accessKeyID, handler.authConfig)
+	if err != nil {
+		return err
+	}
+	if err := handler.checkSatellite(access); err != nil {
+		return err
+	}
+
+	shareKey := egressAccessKey(accessKeyID)
+	w, done := handler.countEgress(w, shareKey)
+	defer done()
+	w, metered := handler.meterShare(w, r, shareKey)
+	defer metered()
+
+	return handler.present(ctx, w, r, &parsedRequest{
+		access:        access,
+		bucket:        bucket,
+		realKey:       key,
+		visibleKey:    key,
+		title:         bucket,
+		root:          breadcrumb{Prefix: bucket, URL: "/" + bucket + "/"},
+		wrapDefault:   false,
+		forceDownload: handler.forceDownload,
+		checkPassword: handler.passwordProtection,
+	})
+}
+
+// verifyPresigned checks the AWS signature version 4 query signature of r
+// against secretKey, returning the access key id it was signed for.
+func verifyPresigned(r *http.Request, secretKey string, now time.Time) (accessKeyID string, err error) {
+	q := r.URL.Query()
+	forbidden := func(format string, args ...interface{}) error {
+		return WithStatus(errs.New("pre-signed URL: "+format, args...), http.StatusForbidden)
+	}
+
+	if q.Get("X-Amz-Algorithm") != presignAlgorithm {
+		return "", forbidden("unsupported algorithm %q", q.Get("X-Amz-Algorithm"))
+	}
+
+	// the credential is <access key id>/<date>/<region>/<service>/aws4_request.
+	credential := strings.Split(q.Get("X-Amz-Credential"), "/")
+	if len(credential) != 5 || credential[0] == "" || credential[4] != "aws4_request" {
+		return "", forbidden("malformed credential")
+	}
+	accessKeyID = credential[0]
+	scope := strings.Join(credential[1:], "/")
+
+	signedAt, err := time.Parse(presignTimeFormat, q.Get("X-Amz-Date"))
+	if err != nil {
+		return "", forbidden("malformed date")
+	}
+	if signedAt.Format("20060102") != credential[1] {
+		return "", forbidden("credential date does not match")
+	}
+
+	expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	if err != nil || expires <= 0 || expires > presignMaxExpires {
+		return "", forbidden("malformed expiration")
+	}
+	if now.Before(signedAt.Add(-time.Minute)) {
+		return "", forbidden("not yet valid")
+	}
+	if now.After(signedAt.Add(time.Duration(expires) * time.Second)) {
+		return "", forbidden("expired")
+	}
+
+	signedHeaders := q.Get("X-Amz-SignedHeaders")
+	if signedHeaders == "" {
+		return "", forbidden("no signed headers")
+	}
+
+	signature, err := hex.DecodeString(q.Get("X-Amz-Signature"))
+	if err != nil {
+		return "", forbidden("malformed signature")
+	}
+
+	expected := presignSignature(r, r.Method, secretKey, scope, signedHeaders)
+	if !hmac.Equal(signature, expected) && (r.Method != http.MethodHead ||
+		!hmac.Equal(signature, presignSignature(r, http.MethodGet, secretKey, scope, signedHeaders))) {
+		return "", forbidden("signature does not match")
+	}
+	return accessKeyID, nil
+}
+
+// presignSignature computes the signature version 4 query signature of r,
+// as if it were made with method.
+func presignSignature(r *http.Request, method, secretKey, scope, signedHeaders string) []byte {
+	q := r.URL.Query()
+
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if strings.EqualFold(name, "host") {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(strings.ToLower(name) + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		presignEscape(r.URL.Path, false),
+		presignCanonicalQuery(q),
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		presignAlgorithm,
+		q.Get("X-Amz-Date"),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hmacSHA256(key, stringToSign)
+}
+
+// presignCanonicalQuery returns the sorted, escaped query string of every
+// parameter but the signature itself.
+func presignCanonicalQuery(q url.Values) string {
+	pairs := make([]string, 0, len(q))
+	for name, values := range q {
+		if name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, presignEscape(name, true)+"="+presignEscape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// presignEscape escapes s the way signature version 4 expects: everything
+// but unreserved characters, and slashes unless escapeSlash is set.
+func presignEscape(s string, escapeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
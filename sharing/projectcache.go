@@ -0,0 +1,155 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"storj.io/uplink"
+)
+
+// projectCache keeps opened projects around, keyed by their access, so
+// repeated requests for the same share don't set up new satellite
+// connections each time. It holds up to size projects, evicting the least
+// recently used, and reopens projects older than ttl. Evicted projects are
+// only closed once every request using them has released them.
+type projectCache struct {
+	size  int
+	ttl   time.Duration
+	close func(*uplink.Project)
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cachedProject struct {
+	key     string
+	project *uplink.Project
+	expires time.Time
+	refs    int
+	evicted bool
+}
+
+func newProjectCache(size int, ttl time.Duration, close func(*uplink.Project)) *projectCache {
+	return &projectCache{
+		size:    size,
+		ttl:     ttl,
+		close:   close,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// get returns the project cached under key, opening and caching it with
+// open when there is none. The returned release func must be called once
+// the project is no longer used.
+func (cache *projectCache) get(key string, open func() (*uplink.Project, error)) (_ *uplink.Project, release func(), err error) {
+	cache.mu.Lock()
+	if elem, ok := cache.entries[key]; ok {
+		entry := elem.Value.(*cachedProject)
+		if time.Now().Before(entry.expires) {
+			mon.Event("project_cache_hit")
+			entry.refs++
+			cache.order.MoveToFront(elem)
+			cache.mu.Unlock()
+			return entry.project, cache.releaser(entry), nil
+		}
+	}
+	cache.mu.Unlock()
+	mon.Event("project_cache_miss")
+
+	project, err := open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cache.mu.Lock()
+	var closing []*uplink.Project
+	if elem, ok := cache.entries[key]; ok {
+		closing = cache.evict(elem, closing)
+	}
+	entry := &cachedProject{
+		key:     key,
+		project: project,
+		expires: time.Now().Add(cache.ttl),
+		refs:    1,
+	}
+	cache.entries[key] = cache.order.PushFront(entry)
+	for cache.order.Len() > cache.size {
+		closing = cache.evict(cache.order.Back(), closing)
+	}
+	cache.mu.Unlock()
+
+	for _, project := range closing {
+		cache.close(project)
+	}
+	return project, cache.releaser(entry), nil
+}
+
+// evict removes elem from the cache, adding its project to closing when no
+// request is using it anymore. Otherwise the last release closes it.
+func (cache *projectCache) evict(elem *list.Element, closing []*uplink.Project) []*uplink.Project {
+	entry := cache.order.Remove(elem).(*cachedProject)
+	delete(cache.entries, entry.key)
+	entry.evicted = true
+	if entry.refs == 0 {
+		closing = append(closing, entry.project)
+	}
+	return closing
+}
+
+func (cache *projectCache) releaser(entry *cachedProject) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cache.mu.Lock()
+			entry.refs--
+			closeNow := entry.evicted && entry.refs == 0
+			cache.mu.Unlock()
+
+			if closeNow {
+				cache.close(entry.project)
+			}
+		})
+	}
+}
+
+// openProject opens the project of access, going through the project cache
+// when enabled. The returned release func must be called once the project
+// is no longer used, instead of closing it.
+func (handler *Handler) openProject(ctx context.Context, access *uplink.Access) (_ *uplink.Project, release func(), err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	open := func() (*uplink.Project, error) {
+		return handler.uplink.OpenProject(ctx, access)
+	}
+	if handler.projectCache == nil {
+		project, err := open()
+		if err != nil {
+			return nil, nil, err
+		}
+		return project, func() { closeProject(handler.log, project) }, nil
+	}
+
+	serialized, err := access.Serialize()
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha256.Sum256([]byte(serialized))
+	return handler.projectCache.get(hex.EncodeToString(sum[:]), open)
+}
+
+func closeProject(log *zap.Logger, project *uplink.Project) {
+	if err := project.Close(); err != nil {
+		log.With(zap.Error(err)).Warn("unable to close project")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/uplink"
+)
+
+func TestProjectCache(t *testing.T) {
+	var closed []*uplink.Project
+	cache := newProjectCache(2, time.Hour, func(project *uplink.Project) {
+		closed = append(closed, project)
+	})
+
+	opened := 0
+	open := func() (*uplink.Project, error) {
+		opened++
+		return &uplink.Project{}, nil
+	}
+
+	a, releaseA, err := cache.get("a", open)
+	require.NoError(t, err)
+	again, releaseAgain, err := cache.get("a", open)
+	require.NoError(t, err)
+	require.Same(t, a, again)
+	require.Equal(t, 1, opened)
+	releaseAgain()
+
+	b, releaseB, err := cache.get("b", open)
+	require.NoError(t, err)
+	releaseB()
+
+	// a is still in use, so evicting it must not close it yet.
+	_, releaseC, err := cache.get("c", open)
+	require.NoError(t, err)
+	releaseC()
+	require.Empty(t, closed)
+
+	releaseA()
+	releaseA()
+	require.Equal(t, []*uplink.Project{a}, closed)
+
+	// evicting b, which isn't in use, closes it right away.
+	_, releaseD, err := cache.get("d", open)
+	require.NoError(t, err)
+	releaseD()
+	require.Equal(t, []*uplink.Project{a, b}, closed)
+	require.Equal(t, 4, opened)
+}
+
+func TestProjectCacheExpiry(t *testing.T) {
+	var closed []*uplink.Project
+	cache := newProjectCache(2, time.Nanosecond, func(project *uplink.Project) {
+		closed = append(closed, project)
+	})
+	open := func() (*uplink.Project, error) { return &uplink.Project{}, nil }
+
+	first, release, err := cache.get("a", open)
+	require.NoError(t, err)
+	release()
+	time.Sleep(time.Millisecond)
+
+	second, release, err := cache.get("a", open)
+	require.NoError(t, err)
+	release()
+	require.NotSame(t, first, second)
+	require.Equal(t, []*uplink.Project{first}, closed)
+}
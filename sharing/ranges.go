@@ -0,0 +1,123 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// normalizeRange checks a Range header value against an object of the given
+// size before it reaches ServeContent, following RFC 7233. Headers with
+// another unit or invalid syntax are ignored, returning "", so the whole
+// object is served. Ranges that can't be satisfied, like bytes=-0, are
+// dropped, and when none are left unsatisfiable is set. Otherwise the
+// satisfiable ranges are returned. With fullAsOK, a single range covering
+// the whole object is ignored too, for clients that can't handle a 206.
+func normalizeRange(header string, size int64, fullAsOK bool) (value string, unsatisfiable bool) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return "", false
+	}
+
+	var specs []string
+	parsed, whole := 0, false
+	for _, spec := range strings.Split(header[len("bytes="):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		dash := strings.IndexByte(spec, '-')
+		if dash < 0 {
+			return "", false
+		}
+		first, last := spec[:dash], spec[dash+1:]
+		parsed++
+
+		if first == "" {
+			suffix, ok := parseRangePos(last)
+			if !ok {
+				return "", false
+			}
+			if suffix > 0 {
+				specs = append(specs, spec)
+				whole = suffix >= size
+			}
+			continue
+		}
+
+		start, ok := parseRangePos(first)
+		if !ok {
+			return "", false
+		}
+		end := size - 1
+		if last != "" {
+			if end, ok = parseRangePos(last); !ok || end < start {
+				return "", false
+			}
+		}
+		if start < size {
+			specs = append(specs, spec)
+			whole = start == 0 && end >= size-1
+		}
+	}
+
+	switch {
+	case parsed == 0:
+		return "", false
+	case len(specs) == 0:
+		return "", true
+	case fullAsOK && len(specs) == 1 && whole:
+		return "", false
+	}
+	return "bytes=" + strings.Join(specs, ","), false
+}
+
+// parseRangePos parses a byte position, which is only ever digits.
+func parseRangePos(s string) (int64, bool) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, false
+	}
+	pos, err := strconv.ParseInt(s, 10, 64)
+	return pos, err == nil
+}
+
+// checkRange normalizes the request's Range header for serving an object of
+// the given size. It answers unsatisfiable ranges with 416 itself, returning
+// false.
+func (handler *Handler) checkRange(w http.ResponseWriter, r *http.Request, size int64) bool {
+	header := r.Header.Get("Range")
+	if header == "" || size <= 0 {
+		return true
+	}
+
+	value, unsatisfiable := normalizeRange(header, size, handler.fullRangeAsOK)
+	if unsatisfiable && !hasPreconditions(r) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, "invalid range: failed to overlap", http.StatusRequestedRangeNotSatisfiable)
+		return false
+	}
+	if unsatisfiable {
+		// leave it to ServeContent, which checks preconditions first.
+		return true
+	}
+	if value == "" {
+		r.Header.Del("Range")
+	} else {
+		r.Header.Set("Range", value)
+	}
+	return true
+}
+
+// hasPreconditions reports whether the request has conditions that decide
+// whether its Range applies at all.
+func hasPreconditions(r *http.Request) bool {
+	for _, name := range []string{"If-Range", "If-Match", "If-Unmodified-Since"} {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// requestIDHeader carries the id of a request, taken from the client or a
+// proxy in front when it sends one, and echoed back in the response.
+const requestIDHeader = "X-Request-ID"
+
+// requestID returns the id r came with, if it is a sane one, and otherwise
+// generates a new one.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// validRequestID reports whether id is short and only uses characters that
+// are safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("-_.:+/=", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// logRequest wraps w to log one line for the request to log when the
+// returned done func is called, if request logging is enabled. Otherwise w
+// is returned as is.
+func (handler *Handler) logRequest(log *zap.Logger, w http.ResponseWriter, r *http.Request) (_ http.ResponseWriter, done func()) {
+	if !handler.logRequests {
+		return w, func() {}
+	}
+
+	start := time.Now()
+	method, host, path := r.Method, r.Host, handler.loggedPath(r)
+	recorded := &statusWriter{ResponseWriter: w}
+	return recorded, func() {
+		status := recorded.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		log.Info("request",
+			zap.String("method", method),
+			zap.String("host", host),
+			zap.String("path", path),
+			zap.Int("status", status),
+			zap.Int64("bytes", recorded.bytes),
+			zap.Duration("duration", time.Since(start)))
+	}
+}
+
+// loggedPath returns the path of r to log. Paths of shared links hold the
+// access, and those of short links an id that works just as well, which
+// must never be logged, so they are replaced with "-". Hosted domains can
+// serve shared links too, so theirs are redacted the same.
+func (handler *Handler) loggedPath(r *http.Request) string {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	switch parts[0] {
+	case "s", "raw", "exists", "q":
+		if len(parts) > 1 && parts[1] != "" {
+			parts[1] = "-"
+		}
+		return "/" + strings.Join(parts, "/")
+	}
+	if ours, err := isDomainOurs(r.Host, handler.urlBases); err != nil || !ours {
+		return r.URL.Path
+	}
+
+	switch parts[0] {
+	case "", "static", "health", ".well-known":
+		return r.URL.Path
+	default:
+		// presigned paths start with the bucket, and old style links with
+		// the access.
+		if !isPresigned(r) {
+			parts[0] = "-"
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestLogging(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	handler, err := NewHandler(zap.New(core), nil, Config{
+		URLBases:    []string{"http://test.test"},
+		Templates:   "../web",
+		LogRequests: true,
+	})
+	require.NoError(t, err)
+
+	// a sane id from the client is kept.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://test.test/health/process", nil)
+	r.Header.Set("X-Request-ID", "abc-123")
+	handler.ServeHTTP(w, r)
+	require.Equal(t, "abc-123", w.Header().Get("X-Request-ID"))
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	require.Equal(t, "request", entries[0].Message)
+	require.Equal(t, "abc-123", fields["request_id"])
+	require.Equal(t, "GET", fields["method"])
+	require.Equal(t, "test.test", fields["host"])
+	require.Equal(t, "/health/process", fields["path"])
+	require.EqualValues(t, http.StatusOK, fields["status"])
+	require.EqualValues(t, len("okay"), fields["bytes"])
+
+	// anything else gets a generated id, also on errors.
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("DELETE", "http://test.test/health/process", nil)
+	r.Header.Set("X-Request-ID", "bad\tid")
+	handler.ServeHTTP(w, r)
+	id := w.Header().Get("X-Request-ID")
+	require.Regexp(t, "^[0-9a-f]{32}$", id)
+
+	entries = logs.TakeAll()
+	require.Len(t, entries, 1)
+	require.Equal(t, id, entries[0].ContextMap()["request_id"])
+	require.EqualValues(t, http.StatusMethodNotAllowed, entries[0].ContextMap()["status"])
+}
+
+func TestLoggedPath(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../web",
+	})
+	require.NoError(t, err)
+
+	for target, expected := range map[string]string{
+		"http://test.test/s/1secretaccess/bucket/key.txt":     "/s/-/bucket/key.txt",
+		"http://test.test/raw/1secretaccess/bucket/a/b.txt":   "/raw/-/bucket/a/b.txt",
+		"http://test.test/exists/1secretaccess/bucket":        "/exists/-/bucket",
+		"http://test.test/1secretaccess/bucket/key.txt":       "/-/bucket/key.txt",
+		"http://test.test/bucket/key.txt?X-Amz-Signature=abc": "/bucket/key.txt",
+		"http://test.test/static/img/file.svg":                "/static/img/file.svg",
+		"http://test.test/q/SECRETSHORTID":                    "/q/-",
+		"http://test.test/q/SECRETSHORTID/a.txt":              "/q/-/a.txt",
+		"http://test.test/q/":                                 "/q/",
+		"http://site.test/docs/index.html":                    "/docs/index.html",
+		"http://site.test/s/1secretaccess/bucket/key.txt":     "/s/-/bucket/key.txt",
+		"http://site.test/raw/1secretaccess/bucket/key.txt":   "/raw/-/bucket/key.txt",
+		"http://test.test/":                                   "/",
+	} {
+		r := httptest.NewRequest("GET", target, nil)
+		path := handler.loggedPath(r)
+		require.Equal(t, expected, path, target)
+		require.False(t, strings.Contains(path, "secret"), target)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// restriction narrows a shared access into a new link. It is passed as the
+// restrict query parameter, encoded as unpadded URL-safe base64 JSON.
+//
+// Restrictions are applied with uplink.Access.Share, which adds a caveat to
+// the access' macaroon, so they can only ever narrow what the access allows.
+// The narrowed access replaces the shared one in the link, as a parameter
+// next to it could just be left out again.
+type restriction struct {
+	// Prefixes are "bucket/prefix" paths the request is limited to.
+	Prefixes []string `json:"prefixes"`
+	// NotBefore and NotAfter optionally limit when the access is valid.
+	NotBefore time.Time `json:"notBefore,omitempty"`
+	NotAfter  time.Time `json:"notAfter,omitempty"`
+}
+
+// parseRestriction decodes a restrict token.
+func parseRestriction(token string) (*restriction, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(token, "="))
+	if err != nil {
+		return nil, WithStatus(errs.New("invalid restriction: %v", err), http.StatusBadRequest)
+	}
+	var res restriction
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, WithStatus(errs.New("invalid restriction: %v", err), http.StatusBadRequest)
+	}
+	if len(res.Prefixes) == 0 {
+		return nil, WithStatus(errs.New("invalid restriction: no prefixes"), http.StatusBadRequest)
+	}
+	for _, prefix := range res.Prefixes {
+		if bucket, _ := splitRestrictionPrefix(prefix); bucket == "" {
+			return nil, WithStatus(errs.New("invalid restriction: missing bucket in %q", prefix), http.StatusBadRequest)
+		}
+	}
+	return &res, nil
+}
+
+// splitRestrictionPrefix splits a "bucket/prefix" path.
+func splitRestrictionPrefix(path string) (bucket, prefix string) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// allows returns whether the restriction permits requests for key in bucket.
+func (res *restriction) allows(bucket, key string) bool {
+	for _, path := range res.Prefixes {
+		prefixBucket, prefix := splitRestrictionPrefix(path)
+		if prefixBucket == bucket && strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns access narrowed to the restriction, read only.
+func (res *restriction) apply(access *uplink.Access) (*uplink.Access, error) {
+	permission := uplink.ReadOnlyPermission()
+	permission.NotBefore = res.NotBefore
+	permission.NotAfter = res.NotAfter
+
+	prefixes := make([]uplink.SharePrefix, 0, len(res.Prefixes))
+	for _, path := range res.Prefixes {
+		bucket, prefix := splitRestrictionPrefix(path)
+		prefixes = append(prefixes, uplink.SharePrefix{Bucket: bucket, Prefix: prefix})
+	}
+
+	restricted, err := access.Share(permission, prefixes...)
+	if err != nil {
+		return nil, WithStatus(errs.New("unable to restrict access: %v", err), http.StatusBadRequest)
+	}
+	return restricted, nil
+}
+
+// scope returns the key prefix the restriction limits bucket to, if it
+// allows exactly one.
+func (res *restriction) scope(bucket string) (prefix string, ok bool) {
+	for _, path := range res.Prefixes {
+		prefixBucket, candidate := splitRestrictionPrefix(path)
+		if prefixBucket != bucket {
+			continue
+		}
+		if ok {
+			return "", false
+		}
+		prefix, ok = candidate, true
+	}
+	return prefix, ok
+}
+
+// restrictAccess applies the restrict token, if any, to access. It fails with
+// forbidden if the requested bucket and key fall outside of it. The parsed
+// restriction is nil without a token.
+func restrictAccess(access *uplink.Access, token, bucket, key string) (*uplink.Access, *restriction, error) {
+	if token == "" {
+		return access, nil, nil
+	}
+	res, err := parseRestriction(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !res.allows(bucket, key) {
+		return nil, nil, WithStatus(errs.New("restriction does not allow %q", bucket+"/"+key), http.StatusForbidden)
+	}
+	restricted, err := res.apply(access)
+	return restricted, res, err
+}
+
+// redirectRestricted redirects to the link for the requested bucket and key
+// with access narrowed by the restrict token, serialized in place of the
+// shared access. linkPrefix is the part of the path before the access, like
+// "/s/". Listings of the new link don't link above the prefix the
+// restriction allows, if it allows exactly one.
+func redirectRestricted(w http.ResponseWriter, r *http.Request, access *uplink.Access, token, linkPrefix, bucket, key string) error {
+	restricted, res, err := restrictAccess(access, token, bucket, key)
+	if err != nil {
+		return err
+	}
+	serialized, err := restricted.Serialize()
+	if err != nil {
+		return WithAction(err, "serialize restricted access")
+	}
+
+	q := r.URL.Query()
+	q.Del("restrict")
+	if scope, ok := res.scope(bucket); ok && scope != "" && q.Get("scope") == "" {
+		q.Set("scope", scope)
+	}
+	target := &url.URL{Path: linkPrefix + serialized + "/" + bucket + "/" + key, RawQuery: q.Encode()}
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+	return nil
+}
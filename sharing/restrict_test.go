@@ -0,0 +1,80 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/uplink"
+)
+
+func TestParseRestriction(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	res, err := parseRestriction(encode(`{"prefixes":["bucket/photos/","other"]}`))
+	require.NoError(t, err)
+	require.True(t, res.allows("bucket", "photos/cat.jpg"))
+	require.True(t, res.allows("other", "anything"))
+	require.False(t, res.allows("bucket", "docs/secret.txt"))
+	require.False(t, res.allows("photos", "cat.jpg"))
+
+	for _, token := range []string{
+		"!!!",
+		encode(`not json`),
+		encode(`{"prefixes":[]}`),
+		encode(`{"prefixes":["/key"]}`),
+	} {
+		_, err := parseRestriction(token)
+		require.Error(t, err, token)
+		require.Equal(t, http.StatusBadRequest, GetStatus(err, 0), token)
+	}
+
+	scope, ok := res.scope("bucket")
+	require.True(t, ok)
+	require.Equal(t, "photos/", scope)
+	_, ok = res.scope("missing")
+	require.False(t, ok)
+
+	_, _, err = restrictAccess(nil, encode(`{"prefixes":["bucket/photos/"]}`), "bucket", "docs/")
+	require.Equal(t, http.StatusForbidden, GetStatus(err, 0))
+}
+
+func TestRedirectRestricted(t *testing.T) {
+	access := newTestAccess(t)
+	serialized, err := access.Serialize()
+	require.NoError(t, err)
+	token := base64.RawURLEncoding.EncodeToString([]byte(`{"prefixes":["photos/2021/"]}`))
+
+	r := httptest.NewRequest("GET", "http://test.test/s/"+serialized+"/photos/2021/cat.jpg?download=1&restrict="+token, nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, redirectRestricted(w, r, access, token, "/s/", "photos", "2021/cat.jpg"))
+	require.Equal(t, http.StatusSeeOther, w.Code)
+
+	// the narrowed access is all the new link has.
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	require.NotContains(t, location.String(), serialized)
+	require.Equal(t, url.Values{"download": {"1"}, "scope": {"2021/"}}, location.Query())
+
+	parts := strings.SplitN(strings.TrimPrefix(location.Path, "/s/"), "/", 2)
+	require.Equal(t, "photos/2021/cat.jpg", parts[1])
+	restricted, err := uplink.ParseAccess(parts[0])
+	require.NoError(t, err)
+	scope, _, err := accessScope(restricted)
+	require.NoError(t, err)
+	require.Equal(t, []string{"photos/2021"}, scope)
+
+	// keys outside of the restriction aren't redirected to.
+	err = redirectRestricted(httptest.NewRecorder(), r, access, token, "/s/", "photos", "2020/dog.jpg")
+	require.Equal(t, http.StatusForbidden, GetStatus(err, 0))
+}
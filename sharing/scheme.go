@@ -0,0 +1,48 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// requestScheme returns the scheme r was made with. Requests from trusted
+// proxies are taken to be made with the scheme in their X-Forwarded-Proto
+// header, as the proxy may have terminated TLS.
+func (handler *Handler) requestScheme(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !handler.trustedProxy(ip) {
+		return scheme
+	}
+	// the client's proxy comes first when several proxies set the header.
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]
+	switch forwarded = strings.ToLower(strings.TrimSpace(forwarded)); forwarded {
+	case "http", "https":
+		return forwarded
+	}
+	return scheme
+}
+
+// urlBase returns the URL base absolute URLs in responses to r are built
+// with. It has the scheme of the configured URL base, unless configured to
+// use the scheme r was made with, for environments where the configured
+// scheme isn't reachable, like https in development.
+func (handler *Handler) urlBase(r *http.Request) *url.URL {
+	base := *handler.urlBases[0]
+	if handler.useRequestScheme {
+		base.Scheme = handler.requestScheme(r)
+	}
+	return &base
+}
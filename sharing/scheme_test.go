@@ -0,0 +1,54 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestURLBaseScheme(t *testing.T) {
+	newHandler := func(useRequestScheme bool) *Handler {
+		handler, err := NewHandler(zap.NewNop(), nil, Config{
+			URLBases:         []string{"https://test.test"},
+			Templates:        "../web",
+			TrustedProxies:   []string{"10.0.0.0/8"},
+			UseRequestScheme: useRequestScheme,
+		})
+		require.NoError(t, err)
+		return handler
+	}
+
+	for _, test := range []struct {
+		remote    string
+		tls       bool
+		forwarded string
+		base      string
+	}{
+		{remote: "192.0.2.1:1234", base: "http://test.test"},
+		{remote: "192.0.2.1:1234", tls: true, base: "https://test.test"},
+		// only trusted proxies may say what the scheme was.
+		{remote: "192.0.2.1:1234", forwarded: "https", base: "http://test.test"},
+		{remote: "10.0.0.1:1234", forwarded: "https", base: "https://test.test"},
+		{remote: "10.0.0.1:1234", tls: true, forwarded: "HTTP, https", base: "http://test.test"},
+		{remote: "10.0.0.1:1234", forwarded: "gopher", base: "http://test.test"},
+	} {
+		r := httptest.NewRequest("GET", "http://test.test/", nil)
+		r.RemoteAddr = test.remote
+		if test.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-Proto", test.forwarded)
+		}
+
+		require.Equal(t, test.base, newHandler(true).urlBase(r).String(), test)
+		// by default, the configured https is always used.
+		require.Equal(t, "https://test.test", newHandler(false).urlBase(r).String(), test)
+	}
+}
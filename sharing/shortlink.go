@@ -0,0 +1,252 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// shortLinkPrefix is the path short links are created at and served under.
+const shortLinkPrefix = "/q/"
+
+// shortLinkEncoding encodes short link ids with uppercase letters and digits
+// only, which QR codes store in their compact alphanumeric mode.
+var shortLinkEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ShortLink is what a short link resolves to: a shared link to an object or
+// prefix, wrapped in the preview page unless Raw is set.
+type ShortLink struct {
+	Access string `json:"access"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key,omitempty"`
+	Raw    bool   `json:"raw,omitempty"`
+}
+
+// ShortLinkStore stores short links by id. Implementations must be safe for
+// concurrent use.
+type ShortLinkStore interface {
+	// Get returns the link stored under id, if there is one that hasn't
+	// expired.
+	Get(ctx context.Context, id string) (ShortLink, bool, error)
+	// Put stores link under id, expiring after ttl unless it is zero.
+	Put(ctx context.Context, id string, link ShortLink, ttl time.Duration) error
+}
+
+// MemoryShortLinkStore is an in-memory ShortLinkStore. Its links are lost on
+// restart, so it is mostly useful for testing and single instances.
+type MemoryShortLinkStore struct {
+	mu    sync.Mutex
+	links map[string]memoryShortLink
+}
+
+type memoryShortLink struct {
+	link    ShortLink
+	expires time.Time
+}
+
+// NewMemoryShortLinkStore returns an empty MemoryShortLinkStore.
+func NewMemoryShortLinkStore() *MemoryShortLinkStore {
+	return &MemoryShortLinkStore{links: map[string]memoryShortLink{}}
+}
+
+// Get implements ShortLinkStore.
+func (store *MemoryShortLinkStore) Get(ctx context.Context, id string) (ShortLink, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stored, ok := store.links[id]
+	if !ok {
+		return ShortLink{}, false, nil
+	}
+	if !stored.expires.IsZero() && time.Now().After(stored.expires) {
+		delete(store.links, id)
+		return ShortLink{}, false, nil
+	}
+	return stored.link, true, nil
+}
+
+// Put implements ShortLinkStore.
+func (store *MemoryShortLinkStore) Put(ctx context.Context, id string, link ShortLink, ttl time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stored := memoryShortLink{link: link}
+	if ttl > 0 {
+		stored.expires = time.Now().Add(ttl)
+	}
+	store.links[id] = stored
+	return nil
+}
+
+// newShortLinkID returns a random id of 16 characters.
+func newShortLinkID() (string, error) {
+	var id [10]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return shortLinkEncoding.EncodeToString(id[:]), nil
+}
+
+// isShortLinkCreate reports whether r asks to create a short link.
+func (handler *Handler) isShortLinkCreate(r *http.Request) bool {
+	return handler.shortLinks != nil && r.Method == http.MethodPost && r.URL.Path == shortLinkPrefix
+}
+
+// createShortLink stores the link described by the request's JSON body
+// under a new id and answers with its URL. Requests must carry the
+// configured token as a bearer token. The body may ask for a ttl in
+// seconds, which is capped by the configured maximum.
+func (handler *Handler) createShortLink(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if handler.shortLinkToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(handler.shortLinkToken)) != 1 {
+		return WithStatus(errs.New("invalid short link token"), http.StatusUnauthorized)
+	}
+
+	var req struct {
+		ShortLink
+		TTL int64 `json:"ttl"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		return WithStatus(errs.New("invalid short link: %v", err), http.StatusBadRequest)
+	}
+	if req.Access == "" || req.Bucket == "" {
+		return WithStatus(errs.New("invalid short link: missing access or bucket"), http.StatusBadRequest)
+	}
+	if err := checkKeyLimits(req.Key, handler.maxKeyLength, handler.maxKeyDepth); err != nil {
+		return err
+	}
+	access, err := parseAccess(ctx, req.Access, handler.authConfig)
+	if err != nil {
+		return err
+	}
+	if err := handler.checkSatellite(access); err != nil {
+		return err
+	}
+
+	ttl := time.Duration(req.TTL) * time.Second
+	if ttl <= 0 {
+		ttl = handler.shortLinkTTL
+	}
+	if handler.shortLinkMaxTTL > 0 && (ttl <= 0 || ttl > handler.shortLinkMaxTTL) {
+		ttl = handler.shortLinkMaxTTL
+	}
+
+	id, err := newShortLinkID()
+	if err != nil {
+		return err
+	}
+	if err := handler.shortLinks.Put(ctx, id, req.ShortLink, ttl); err != nil {
+		return WithAction(err, "store short link")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(struct {
+		URL string `json:"url"`
+	}{URL: shortLinkURL(handler.urlBase(r), id)})
+}
+
+// resolvedShortLinkKey is the context key of the resolvedShortLink a
+// request was rewritten from.
+type resolvedShortLinkKey struct{}
+
+// resolvedShortLink is the short link a request was rewritten from. Links
+// and redirects in the response are made from it, as the rewritten path
+// holds the access the short link hides.
+type resolvedShortLink struct {
+	// path is the path that was requested, and root the short link's own
+	// path, ending in a slash.
+	path string
+	root string
+	// key is the key root stands for.
+	key string
+}
+
+// resolveShortLink rewrites a request for a short link's path into one for
+// the shared link it stands for, returning a context that remembers the
+// short link. Anything after the id is appended to the link's key, so short
+// links to prefixes can be browsed.
+func (handler *Handler) resolveShortLink(ctx context.Context, r *http.Request) (_ context.Context, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	id := strings.TrimPrefix(r.URL.Path, shortLinkPrefix)
+	var rest string
+	slash := strings.IndexByte(id, '/')
+	if slash >= 0 {
+		id, rest = id[:slash], id[slash+1:]
+	}
+
+	link, ok, err := handler.shortLinks.Get(ctx, strings.ToUpper(id))
+	if err != nil {
+		return ctx, WithAction(err, "resolve short link")
+	}
+	if !ok {
+		return ctx, WithStatus(errs.New("short link not found"), http.StatusNotFound)
+	}
+	key := link.Key
+	if slash >= 0 && key != "" && !strings.HasSuffix(key, "/") {
+		// a short link to a key that turns out to be a prefix is
+		// redirected to with a trailing slash.
+		if rest != "" {
+			return ctx, WithStatus(errs.New("short link not found"), http.StatusNotFound)
+		}
+		key += "/"
+	}
+
+	mode := "/s/"
+	if link.Raw {
+		mode = "/raw/"
+	}
+	resolved := &resolvedShortLink{
+		path: r.URL.Path,
+		root: shortLinkPrefix + id + "/",
+		key:  key,
+	}
+	r.URL.Path = mode + link.Access + "/" + link.Bucket + "/" + key + rest
+	r.URL.RawPath = ""
+	return context.WithValue(ctx, resolvedShortLinkKey{}, resolved), nil
+}
+
+// rootShortLink makes listings of a request for a short link start at the
+// prefix the short link stands for, so none of their links give away the
+// access.
+func rootShortLink(ctx context.Context, pr *parsedRequest) {
+	link, ok := ctx.Value(resolvedShortLinkKey{}).(*resolvedShortLink)
+	if !ok {
+		return
+	}
+	root := pr.bucket
+	if trimmed := strings.TrimSuffix(link.key, "/"); trimmed != "" {
+		root = trimmed[strings.LastIndex(trimmed, "/")+1:]
+	}
+	pr.visibleKey = strings.TrimPrefix(pr.realKey, link.key)
+	pr.root = breadcrumb{Prefix: root, URL: link.root}
+}
+
+// publicPath returns the path r was requested with: for short links, the
+// short link's rather than the shared link's it was rewritten to.
+func publicPath(ctx context.Context, r *http.Request) string {
+	if link, ok := ctx.Value(resolvedShortLinkKey{}).(*resolvedShortLink); ok {
+		return link.path
+	}
+	return r.URL.Path
+}
+
+// shortLinkURL returns the URL of the short link with the given id.
+func shortLinkURL(base *url.URL, id string) string {
+	return strings.TrimSuffix(base.String(), "/") + shortLinkPrefix + id
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/testcontext"
+)
+
+func TestShortLinks(t *testing.T) {
+	ctx := testcontext.New(t)
+	store := NewMemoryShortLinkStore()
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:        []string{"http://test.test"},
+		Templates:       "../web",
+		ShortLinks:      store,
+		ShortLinkToken:  "token",
+		ShortLinkMaxTTL: time.Hour,
+	})
+	require.NoError(t, err)
+
+	serialized, err := newTestAccess(t).Serialize()
+	require.NoError(t, err)
+
+	create := func(token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "http://test.test/q/", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	link := `{"access":"` + serialized + `","bucket":"photos","key":"album/"}`
+	require.Equal(t, http.StatusUnauthorized, create("", link).Code)
+	require.Equal(t, http.StatusUnauthorized, create("wrong", link).Code)
+	require.Equal(t, http.StatusBadRequest, create("token", `{"bucket":"photos"}`).Code)
+
+	w := create("token", link)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		URL string `json:"url"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	require.Regexp(t, `^http://test\.test/q/[A-Z2-7]{16}$`, created.URL)
+	id := strings.TrimPrefix(created.URL, "http://test.test/q/")
+
+	// the requested ttl is capped by the maximum.
+	stored, ok := store.links[id]
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(time.Hour), stored.expires, time.Minute)
+
+	for _, test := range []struct {
+		path     string
+		resolved string
+		status   int
+	}{
+		{path: "/q/" + id, resolved: "/s/" + serialized + "/photos/album/"},
+		{path: "/q/" + strings.ToLower(id) + "/2021/beach.jpg", resolved: "/s/" + serialized + "/photos/album/2021/beach.jpg"},
+		{path: "/q/UNKNOWN", status: http.StatusNotFound},
+	} {
+		r := httptest.NewRequest("GET", "http://test.test"+test.path, nil)
+		_, err := handler.resolveShortLink(ctx, r)
+		if test.status != 0 {
+			require.Equal(t, test.status, GetStatus(err, 0), test.path)
+			continue
+		}
+		require.NoError(t, err, test.path)
+		require.Equal(t, test.resolved, r.URL.Path, test.path)
+	}
+
+	// redirects and listings of short links keep to the short link's path,
+	// and never give away the access.
+	r := httptest.NewRequest("GET", "http://test.test/q/"+id+"/2021?scope=x", nil)
+	resolved, err := handler.resolveShortLink(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, "/s/"+serialized+"/photos/album/2021", r.URL.Path)
+	w = httptest.NewRecorder()
+	handler.redirectTrailingSlash(resolved, w, r)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	require.Equal(t, "/q/"+id+"/2021/?scope=x", w.Header().Get("Location"))
+	require.NotContains(t, w.Header().Get("Location"), serialized)
+
+	pr := &parsedRequest{bucket: "photos", realKey: "album/2021/", visibleKey: "album/2021/"}
+	rootShortLink(resolved, pr)
+	require.Equal(t, []breadcrumb{
+		{Prefix: "album", URL: "/q/" + id + "/"},
+		{Prefix: "2021", URL: "/q/" + id + "/2021/"},
+	}, listingBreadcrumbs(pr, false))
+
+	// only the short link endpoint accepts POST.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "http://test.test/q/"+id, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestMemoryShortLinkStoreExpiry(t *testing.T) {
+	ctx := testcontext.New(t)
+	store := NewMemoryShortLinkStore()
+
+	require.NoError(t, store.Put(ctx, "forever", ShortLink{Bucket: "a"}, 0))
+	require.NoError(t, store.Put(ctx, "expired", ShortLink{Bucket: "b"}, time.Nanosecond))
+	time.Sleep(time.Millisecond)
+
+	link, ok, err := store.Get(ctx, "forever")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "a", link.Bucket)
+
+	_, ok, err = store.Get(ctx, "expired")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/zeebo/errs"
+)
+
+// signedTransformTypes are the transformations that can be configured to
+// require a signature, named after the query parameter requesting them.
+var signedTransformTypes = map[string]bool{
+	"archive":   true,
+	"preview":   true,
+	"transform": true,
+}
+
+// parseSignedTransforms validates the transformations configured to require
+// a signature.
+func parseSignedTransforms(names []string) (map[string]bool, error) {
+	signed := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !signedTransformTypes[name] {
+			return nil, errs.New("unknown transform %q: only archive, preview and transform can be signed", name)
+		}
+		signed[name] = true
+	}
+	return signed, nil
+}
+
+// SignTransform returns the ?sig value allowing the transformation requested
+// with ?transform=value on the link with the given path, signed with key.
+// Archives are signed as archive with their format, however requested.
+func SignTransform(key, path, transform, value string) string {
+	return hex.EncodeToString(hmacSHA256([]byte(key), transform+"\n"+value+"\n"+path))
+}
+
+// checkTransformSignature rejects a request for transform with the given
+// value without a valid ?sig when the transform is configured to require
+// one, so only those holding the signing key can have links trigger it.
+func (handler *Handler) checkTransformSignature(r *http.Request, transform, value string) error {
+	if !handler.signedTransforms[transform] {
+		return nil
+	}
+	signature, err := hex.DecodeString(r.URL.Query().Get("sig"))
+	if err != nil || len(signature) == 0 {
+		return WithStatus(errs.New("%s requires a signature", transform), http.StatusForbidden)
+	}
+	expected, _ := hex.DecodeString(SignTransform(handler.transformSigningKey, r.URL.Path, transform, value))
+	if !hmac.Equal(signature, expected) {
+		return WithStatus(errs.New("%s signature does not match", transform), http.StatusForbidden)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCheckTransformSignature(t *testing.T) {
+	signed, err := parseSignedTransforms([]string{"archive"})
+	require.NoError(t, err)
+	handler := &Handler{transformSigningKey: "secret", signedTransforms: signed}
+
+	const path = "/s/access/bucket/photos/"
+	sig := SignTransform("secret", path, "archive", "zip")
+
+	for _, test := range []struct {
+		url    string
+		status int
+	}{
+		{url: path + "?archive=zip&sig=" + sig, status: 0},
+		{url: path + "?download=zip&sig=" + sig, status: 0},
+		{url: path + "?archive=zip", status: http.StatusForbidden},
+		{url: path + "?archive=zip&sig=zz", status: http.StatusForbidden},
+		{url: path + "?archive=tar&sig=" + sig, status: http.StatusForbidden},
+		{url: "/s/access/bucket/other/?archive=zip&sig=" + sig, status: http.StatusForbidden},
+		{url: path + "?archive=zip&sig=" + SignTransform("other", path, "archive", "zip"), status: http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("GET", "http://test.test"+test.url, nil)
+		format, err := archiveFormat(r)
+		require.NoError(t, err)
+		err = handler.checkTransformSignature(r, "archive", format)
+		require.Equal(t, test.status, GetStatus(err, 0), test.url)
+	}
+
+	// transforms not configured to be signed don't need a signature.
+	r := httptest.NewRequest("GET", "http://test.test"+path+"photo.jpg?preview", nil)
+	require.NoError(t, handler.checkTransformSignature(r, "preview", ""))
+
+	_, err = parseSignedTransforms([]string{"thumbnail"})
+	require.Error(t, err)
+
+	_, err = NewHandler(zap.NewNop(), nil, Config{
+		URLBases:         []string{"http://test.test"},
+		Templates:        "../web",
+		SignedTransforms: []string{"archive"},
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"storj.io/uplink"
+)
+
+// staleBodyCacheKey identifies the latest cached body of the key, whatever
+// its version, for serving when the object can't be looked up.
+func staleBodyCacheKey(access *uplink.Access, bucket, key string) (string, error) {
+	serialized, err := access.Serialize()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(serialized))
+	return fmt.Sprintf("stale:%s/%s/%s", hex.EncodeToString(sum[:16]), bucket, key), nil
+}
+
+// setStaleIfError adds a stale-if-error directive to the Cache-Control of an
+// object response, so caches in front of us keep serving it through
+// outages, unless the object's own Cache-Control already has one.
+func (handler *Handler) setStaleIfError(w http.ResponseWriter) {
+	if handler.staleIfError <= 0 {
+		return
+	}
+	directive := "stale-if-error=" + strconv.FormatInt(int64(handler.staleIfError/time.Second), 10)
+
+	cacheControl := w.Header().Get("Cache-Control")
+	switch {
+	case cacheControl == "":
+		w.Header().Set("Cache-Control", directive)
+	case !strings.Contains(strings.ToLower(cacheControl), "stale-if-error"):
+		w.Header().Set("Cache-Control", cacheControl+", "+directive)
+	}
+}
+
+// staleEligible returns whether err is a backend failure, rather than
+// something wrong with the request, that a stale body may be served for.
+func staleEligible(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, uplink.ErrBucketNotFound),
+		errors.Is(err, uplink.ErrObjectNotFound),
+		errors.Is(err, uplink.ErrBucketNameInvalid),
+		errors.Is(err, uplink.ErrObjectKeyInvalid),
+		errors.Is(err, uplink.ErrPermissionDenied),
+		errors.Is(err, uplink.ErrBandwidthLimitExceeded),
+		errors.Is(err, uplink.ErrTooManyRequests):
+		return false
+	}
+	status := GetStatus(err, http.StatusInternalServerError)
+	return status >= http.StatusInternalServerError
+}
+
+// serveStale serves the last body cached for the requested object when
+// looking it up failed with a backend error, returning whether it did.
+// Only objects that would be served as is are, as there is nothing to
+// build a preview page from.
+func (handler *Handler) serveStale(ctx context.Context, w http.ResponseWriter, r *http.Request, pr *parsedRequest, err error) bool {
+	if handler.staleIfError <= 0 || handler.bodyCache == nil || !staleEligible(err) {
+		return false
+	}
+	// requests still waiting on their password check aren't served anything.
+	if pr.checkPassword {
+		return false
+	}
+	download, wrap, modeErr := handler.displayMode(r.URL.Query(), pr)
+	if modeErr != nil || (wrap && !download) {
+		return false
+	}
+
+	key, keyErr := staleBodyCacheKey(pr.access, pr.bucket, pr.realKey)
+	if keyErr != nil {
+		return false
+	}
+	body, ok := handler.bodyCache.Get(ctx, key)
+	if !ok {
+		return false
+	}
+
+	mon.Event("stale_body_served")
+	handler.log.Warn("serving stale body", zap.String("bucket", pr.bucket), zap.Error(err))
+
+	w.Header().Set("Content-Type", objectContentType(&uplink.Object{Key: pr.realKey}))
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	return true
+}
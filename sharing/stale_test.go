@@ -0,0 +1,78 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
+)
+
+func TestSetStaleIfError(t *testing.T) {
+	handler := &Handler{staleIfError: time.Hour}
+
+	w := httptest.NewRecorder()
+	handler.setStaleIfError(w)
+	require.Equal(t, "stale-if-error=3600", w.Header().Get("Cache-Control"))
+
+	w = httptest.NewRecorder()
+	w.Header().Set("Cache-Control", "max-age=60")
+	handler.setStaleIfError(w)
+	require.Equal(t, "max-age=60, stale-if-error=3600", w.Header().Get("Cache-Control"))
+
+	w = httptest.NewRecorder()
+	w.Header().Set("Cache-Control", "max-age=60, stale-if-error=10")
+	handler.setStaleIfError(w)
+	require.Equal(t, "max-age=60, stale-if-error=10", w.Header().Get("Cache-Control"))
+
+	w = httptest.NewRecorder()
+	(&Handler{}).setStaleIfError(w)
+	require.Empty(t, w.Header().Get("Cache-Control"))
+}
+
+func TestStaleEligible(t *testing.T) {
+	require.True(t, staleEligible(errs.New("dial tcp: connection refused")))
+	require.True(t, staleEligible(WithStatus(errs.New("bad gateway"), http.StatusBadGateway)))
+	require.False(t, staleEligible(WithStatus(errs.New("forbidden"), http.StatusForbidden)))
+	require.False(t, staleEligible(uplink.ErrObjectNotFound))
+	require.False(t, staleEligible(context.Canceled))
+}
+
+func TestServeStale(t *testing.T) {
+	ctx := testcontext.New(t)
+	handler := &Handler{
+		log:          zap.NewNop(),
+		bodyCache:    NewMemoryBodyCache(100),
+		staleIfError: time.Hour,
+	}
+	pr := &parsedRequest{access: newTestAccess(t), bucket: "site", realKey: "index.html"}
+	backendErr := errs.New("satellite unavailable")
+
+	r := httptest.NewRequest("GET", "http://test.test/", nil)
+	require.False(t, handler.serveStale(ctx, httptest.NewRecorder(), r, pr, backendErr))
+
+	key, err := staleBodyCacheKey(pr.access, pr.bucket, pr.realKey)
+	require.NoError(t, err)
+	handler.bodyCache.Set(ctx, key, []byte("<h1>hi</h1>"), time.Hour)
+
+	w := httptest.NewRecorder()
+	require.True(t, handler.serveStale(ctx, w, r, pr, backendErr))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "<h1>hi</h1>", w.Body.String())
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+	// only backend errors are covered, and only when serving as is.
+	require.False(t, handler.serveStale(ctx, httptest.NewRecorder(), r, pr, uplink.ErrObjectNotFound))
+	wrapped := &parsedRequest{access: pr.access, bucket: pr.bucket, realKey: pr.realKey, wrapDefault: true}
+	require.False(t, handler.serveStale(ctx, httptest.NewRecorder(), r, wrapped, backendErr))
+}
@@ -31,6 +31,10 @@ func (handler *Handler) handleStandard(ctx context.Context, w http.ResponseWrite
 		return nil
 	}
 
+	// everything after the bucket is the key, as is. object keys may start
+	// with a slash, so /s/<access>/<bucket>//key refers to the key "/key",
+	// the same as the hosting service does for a root of "<bucket>/" and a
+	// path of "//key".
 	var serializedAccess string
 	parts := strings.SplitN(path, "/", 3)
 	switch len(parts) {
@@ -50,16 +54,52 @@ func (handler *Handler) handleStandard(ctx context.Context, w http.ResponseWrite
 		pr.realKey = parts[2]
 	}
 
+	if err := checkKeyLimits(pr.realKey, handler.maxKeyLength, handler.maxKeyDepth); err != nil {
+		return err
+	}
+
+	shareKey := egressAccessKey(serializedAccess)
+	w, done := handler.countEgress(w, shareKey)
+	defer done()
+	w, metered := handler.meterShare(w, r, shareKey)
+	defer metered()
+
 	access, err := parseAccess(ctx, serializedAccess, handler.authConfig)
 	if err != nil {
 		return err
 	}
+	if err := handler.checkSatellite(access); err != nil {
+		return err
+	}
+
+	// a restrict token mints a link for a narrower access, in place of this
+	// one.
+	q := r.URL.Query()
+	if token := q.Get("restrict"); token != "" {
+		linkPrefix := r.URL.Path[:len(r.URL.Path)-len(path)]
+		return redirectRestricted(w, r, access, token, linkPrefix, pr.bucket, pr.realKey)
+	}
+
+	// listings only link down to the prefix the access is scoped to.
+	pr.scope = q.Get("scope")
+	pr.linkQuery = preservedQuery(q, "scope", "index")
+
+	// shared prefixes serve their index.html like hosted sites do, unless
+	// the listing is asked for with ?index=0.
+	pr.noIndex = !queryFlagLookup(q, "index", true)
 
 	pr.access = access
+	pr.forceDownload = handler.forceDownload
+	pr.checkPassword = handler.passwordProtection
 
 	pr.visibleKey = pr.realKey
 	pr.title = pr.bucket
-	pr.root = breadcrumb{Prefix: pr.bucket, URL: "/s/" + serializedAccess + "/" + pr.bucket + "/"}
+	pr.root = breadcrumb{
+		Prefix:   pr.bucket,
+		URL:      "/s/" + serializedAccess + "/" + pr.bucket + "/",
+		Disabled: pr.scope != "",
+	}
+	rootShortLink(ctx, &pr)
 
 	return handler.present(ctx, w, r, &pr)
 }
@@ -0,0 +1,141 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"storj.io/common/memory"
+	"storj.io/uplink"
+)
+
+// textExtensions are extensions we consider text even though the mime
+// package doesn't know them as such.
+var textExtensions = map[string]bool{
+	".log":  true,
+	".md":   true,
+	".yaml": true,
+	".yml":  true,
+	".toml": true,
+	".ini":  true,
+	".conf": true,
+	".go":   true,
+	".sh":   true,
+}
+
+// isTextKey returns whether the key looks like it names a text object.
+func isTextKey(key string) bool {
+	ext := strings.ToLower(filepath.Ext(key))
+	if textExtensions[ext] {
+		return true
+	}
+	contentType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	switch {
+	case strings.HasPrefix(contentType, "text/"):
+		return true
+	case contentType == "application/json",
+		contentType == "application/xml",
+		contentType == "application/javascript":
+		return true
+	}
+	return false
+}
+
+// wantsTextView returns whether the object should be rendered in the
+// enhanced text view rather than served as is. ?view=text always asks for
+// the enhanced view and ?view=raw never does. A plain ?view on a text
+// object gets whatever the configured default is.
+func (handler *Handler) wantsTextView(q url.Values, key string) bool {
+	if vals := q["view"]; len(vals) > 0 {
+		switch strings.ToLower(vals[0]) {
+		case "text":
+			return true
+		case "raw":
+			return false
+		}
+	}
+	return handler.textViewDefault && queryFlagLookup(q, "view", false) && isTextKey(key)
+}
+
+type textLine struct {
+	Number int
+	Text   string
+}
+
+// serveTextView renders a text object within the site template, with
+// optional line numbers and soft wrapping.
+func (handler *Handler) serveTextView(ctx context.Context, w http.ResponseWriter, r *http.Request, q url.Values, pr *parsedRequest, project *uplink.Project, o *uplink.Object) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	download, err := project.DownloadObject(ctx, pr.bucket, o.Key, nil)
+	if err != nil {
+		return WithAction(err, "download text")
+	}
+	defer func() {
+		if err := download.Close(); err != nil {
+			handler.log.With(zap.Error(err)).Warn("unable to close text download")
+		}
+	}()
+
+	data, err := ioutil.ReadAll(io.LimitReader(download, handler.textViewMaxSize))
+	if err != nil {
+		return WithAction(err, "read text")
+	}
+
+	lineNumbers := queryFlagLookup(q, "lines", true)
+	softWrap := queryFlagLookup(q, "softwrap", true)
+
+	toggle := func(lines, wrap bool) string {
+		v := url.Values{}
+		v.Set("view", "text")
+		v.Set("lines", flagValue(lines))
+		v.Set("softwrap", flagValue(wrap))
+		return "?" + v.Encode()
+	}
+
+	var input struct {
+		Key         string
+		Size        string
+		Lines       []textLine
+		LineNumbers bool
+		SoftWrap    bool
+		Truncated   bool
+		LinesToggle string
+		WrapToggle  string
+	}
+	input.Key = filepath.Base(o.Key)
+	input.Size = memory.Size(o.System.ContentLength).Base10String()
+	for i, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		input.Lines = append(input.Lines, textLine{Number: i + 1, Text: line})
+	}
+	input.LineNumbers = lineNumbers
+	input.SoftWrap = softWrap
+	input.Truncated = int64(len(data)) < o.System.ContentLength
+	input.LinesToggle = toggle(!lineNumbers, softWrap)
+	input.WrapToggle = toggle(lineNumbers, !softWrap)
+
+	// the page is generated from the object, so its bytes can't be ranged.
+	w.Header().Set("Accept-Ranges", "none")
+	handler.renderTemplate(w, r, "text-view.html", pageData{
+		Data:  input,
+		Title: input.Key,
+	})
+	return nil
+}
+
+func flagValue(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
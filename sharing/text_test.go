@@ -0,0 +1,36 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWantsTextView(t *testing.T) {
+	for _, test := range []struct {
+		query       string
+		key         string
+		textDefault bool
+		expected    bool
+	}{
+		{query: "", key: "log.txt", textDefault: true, expected: false},
+		{query: "view", key: "log.txt", textDefault: false, expected: false},
+		{query: "view", key: "log.txt", textDefault: true, expected: true},
+		{query: "view", key: "server.log", textDefault: true, expected: true},
+		{query: "view", key: "photo.jpg", textDefault: true, expected: false},
+		{query: "view=0", key: "log.txt", textDefault: true, expected: false},
+		{query: "view=raw", key: "log.txt", textDefault: true, expected: false},
+		{query: "view=text", key: "log.txt", textDefault: false, expected: true},
+		{query: "view=text", key: "binary", textDefault: false, expected: true},
+	} {
+		q, err := url.ParseQuery(test.query)
+		assert.NoError(t, err)
+
+		handler := &Handler{textViewDefault: test.textDefault}
+		assert.Equal(t, test.expected, handler.wantsTextView(q, test.key), "%q %q %v", test.query, test.key, test.textDefault)
+	}
+}
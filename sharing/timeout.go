@@ -0,0 +1,65 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// requestDeadline cancels a request's context when its metadata operations
+// take too long. Unlike a context deadline it can be lifted, so downloads
+// started within it may take as long as they need.
+type requestDeadline struct {
+	timer *time.Timer
+
+	mu    sync.Mutex
+	fired bool
+}
+
+type requestDeadlineKey struct{}
+
+// withRequestTimeout returns ctx canceled after the configured request
+// timeout, unless lifted before by liftRequestTimeout. The returned func
+// releases its resources.
+func (handler *Handler) withRequestTimeout(ctx context.Context) (_ context.Context, stop func()) {
+	if handler.requestTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	deadline := &requestDeadline{}
+	deadline.timer = time.AfterFunc(handler.requestTimeout, func() {
+		deadline.mu.Lock()
+		deadline.fired = true
+		deadline.mu.Unlock()
+		cancel()
+	})
+	return context.WithValue(ctx, requestDeadlineKey{}, deadline), func() {
+		deadline.timer.Stop()
+		cancel()
+	}
+}
+
+// liftRequestTimeout lets the request ctx is for run past the request
+// timeout, for downloads and other long-running responses. A timeout that
+// already fired stays fired.
+func liftRequestTimeout(ctx context.Context) {
+	if deadline, ok := ctx.Value(requestDeadlineKey{}).(*requestDeadline); ok {
+		deadline.timer.Stop()
+	}
+}
+
+// requestTimedOut returns whether the request ctx is for was canceled by
+// the request timeout.
+func requestTimedOut(ctx context.Context) bool {
+	deadline, ok := ctx.Value(requestDeadlineKey{}).(*requestDeadline)
+	if !ok {
+		return false
+	}
+	deadline.mu.Lock()
+	defer deadline.mu.Unlock()
+	return deadline.fired
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/testcontext"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	ctx := testcontext.New(t)
+	handler := &Handler{requestTimeout: 10 * time.Millisecond}
+
+	timed, stop := handler.withRequestTimeout(ctx)
+	<-timed.Done()
+	require.True(t, requestTimedOut(timed))
+	stop()
+
+	// a lifted timeout never fires.
+	lifted, stop := handler.withRequestTimeout(ctx)
+	liftRequestTimeout(lifted)
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, lifted.Err())
+	require.False(t, requestTimedOut(lifted))
+	stop()
+
+	// nor does one that isn't configured.
+	untimed, stop := (&Handler{}).withRequestTimeout(ctx)
+	defer stop()
+	require.False(t, requestTimedOut(untimed))
+	liftRequestTimeout(untimed)
+}
+
+// stalledShortLinkStore is a ShortLinkStore that never answers.
+type stalledShortLinkStore struct{}
+
+func (stalledShortLinkStore) Get(ctx context.Context, id string) (ShortLink, bool, error) {
+	<-ctx.Done()
+	return ShortLink{}, false, ctx.Err()
+}
+
+func (stalledShortLinkStore) Put(ctx context.Context, id string, link ShortLink, ttl time.Duration) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRequestTimeoutStatus(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:       []string{"http://test.test"},
+		Templates:      "../web",
+		ShortLinks:     stalledShortLinkStore{},
+		RequestTimeout: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.test/q/ABCDEFGH", nil))
+	require.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
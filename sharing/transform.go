@@ -0,0 +1,36 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+
+	"github.com/zeebo/errs"
+)
+
+// transformSkippedHeader tells clients a transformation they asked for was
+// skipped and the object is served as is.
+const transformSkippedHeader = "X-Transform-Skipped"
+
+// transformFits returns whether a transformation buffering need bytes fits
+// in the per-request memory limit. If it doesn't, the transformation is
+// either skipped, with a header saying so, or rejected with 413.
+func (handler *Handler) transformFits(w http.ResponseWriter, need int64) (bool, error) {
+	if handler.transformMemoryLimit <= 0 || need <= handler.transformMemoryLimit {
+		return true, nil
+	}
+	if handler.transformRejectOversize {
+		return false, WithStatus(errs.New("object too large to transform: %d > %d bytes",
+			need, handler.transformMemoryLimit), http.StatusRequestEntityTooLarge)
+	}
+	w.Header().Set(transformSkippedHeader, "memory-limit")
+	return false, nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,38 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformFits(t *testing.T) {
+	handler := &Handler{}
+	w := httptest.NewRecorder()
+	fits, err := handler.transformFits(w, 1<<40)
+	require.NoError(t, err)
+	require.True(t, fits, "no limit")
+
+	handler = &Handler{transformMemoryLimit: 100}
+	w = httptest.NewRecorder()
+	fits, err = handler.transformFits(w, 100)
+	require.NoError(t, err)
+	require.True(t, fits)
+	require.Empty(t, w.Header().Get(transformSkippedHeader))
+
+	fits, err = handler.transformFits(w, 101)
+	require.NoError(t, err)
+	require.False(t, fits)
+	require.Equal(t, "memory-limit", w.Header().Get(transformSkippedHeader))
+
+	handler = &Handler{transformMemoryLimit: 100, transformRejectOversize: true}
+	w = httptest.NewRecorder()
+	fits, err = handler.transformFits(w, 101)
+	require.False(t, fits)
+	require.Equal(t, http.StatusRequestEntityTooLarge, GetStatus(err, 0))
+}
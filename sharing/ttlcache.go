@@ -0,0 +1,91 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ttlCacheSweepInterval is how often a ttlCache drops all of its expired
+// entries, rather than only those it comes across.
+const ttlCacheSweepInterval = time.Minute
+
+// ttlCache is an in-memory cache of values that expire. It holds up to size
+// entries, evicting the least recently used, so keys made up by clients
+// can't grow it without bound.
+type ttlCache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	swept   time.Time
+}
+
+type ttlCacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newTTLCache(size int) *ttlCache {
+	return &ttlCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+		swept:   time.Now(),
+	}
+}
+
+// get returns the unexpired value cached under key.
+func (cache *ttlCache) get(key string) (interface{}, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	elem, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*ttlCacheEntry)
+	if !time.Now().Before(entry.expires) {
+		cache.remove(elem)
+		return nil, false
+	}
+	cache.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// set caches value under key for ttl.
+func (cache *ttlCache) set(key string, value interface{}, ttl time.Duration) {
+	now := time.Now()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if now.Sub(cache.swept) >= ttlCacheSweepInterval {
+		cache.swept = now
+		for elem := cache.order.Front(); elem != nil; {
+			next := elem.Next()
+			if !now.Before(elem.Value.(*ttlCacheEntry).expires) {
+				cache.remove(elem)
+			}
+			elem = next
+		}
+	}
+
+	if elem, ok := cache.entries[key]; ok {
+		cache.remove(elem)
+	}
+	cache.entries[key] = cache.order.PushFront(&ttlCacheEntry{key: key, value: value, expires: now.Add(ttl)})
+	for cache.order.Len() > cache.size {
+		cache.remove(cache.order.Back())
+	}
+}
+
+func (cache *ttlCache) remove(elem *list.Element) {
+	cache.order.Remove(elem)
+	delete(cache.entries, elem.Value.(*ttlCacheEntry).key)
+}
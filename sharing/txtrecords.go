@@ -19,6 +19,8 @@ type txtRecords struct {
 	dns    *DNSClient
 	auth   AuthServiceConfig
 
+	fallback *HostingFallback
+
 	cache       sync.Map
 	updateLocks MutexGroup
 }
@@ -33,19 +35,21 @@ type txtRecord struct {
 	// revoking access keys due to this confusion.
 	access     *uplink.Access
 	root       string
+	options    hostingOptions
 	expiration time.Time
 }
 
-func newTxtRecords(maxTTL time.Duration, dns *DNSClient, auth AuthServiceConfig) *txtRecords {
+func newTxtRecords(maxTTL time.Duration, dns *DNSClient, auth AuthServiceConfig, fallback *HostingFallback) *txtRecords {
 	return &txtRecords{
-		maxTTL: maxTTL,
-		dns:    dns,
-		auth:   auth,
+		maxTTL:   maxTTL,
+		dns:      dns,
+		auth:     auth,
+		fallback: fallback,
 	}
 }
 
-// fetchAccessForHost fetches the root and access grant from the cache or dns server when applicable.
-func (records *txtRecords) fetchAccessForHost(ctx context.Context, hostname string) (access *uplink.Access, root string, err error) {
+// fetchAccessForHost fetches the root, access grant and hosting options from the cache or dns server when applicable.
+func (records *txtRecords) fetchAccessForHost(ctx context.Context, hostname string) (access *uplink.Access, root string, options hostingOptions, err error) {
 	defer mon.Task()(&ctx)(&err)
 
 	val, ok := records.cache.Load(hostname)
@@ -54,9 +58,16 @@ func (records *txtRecords) fetchAccessForHost(ctx context.Context, hostname stri
 		// we can return.
 		record, err := records.updateCache(ctx, hostname, time.Time{})
 		if err != nil {
-			return nil, "", err
+			// only fall back when the dns servers couldn't be asked, and not
+			// when they answered with records we refuse to serve.
+			if errDNS.Has(err) {
+				if fallback, ok := records.fallbackRecord(ctx, hostname); ok {
+					return fallback.access, fallback.root, fallback.options, nil
+				}
+			}
+			return nil, "", hostingOptions{}, err
 		}
-		return record.access, record.root, nil
+		return record.access, record.root, record.options, nil
 	}
 
 	// there's something in the cache!
@@ -77,7 +88,30 @@ func (records *txtRecords) fetchAccessForHost(ctx context.Context, hostname stri
 		}(ctx, hostname, record)
 	}
 
-	return record.access, record.root, nil
+	return record.access, record.root, record.options, nil
+}
+
+// fallbackRecord returns the record from the static fallback map for a
+// hostname whose TXT records couldn't be looked up because of a timeout,
+// network error or server failure. It isn't cached, so the
+// TXT records are tried again on the next request.
+func (records *txtRecords) fallbackRecord(ctx context.Context, hostname string) (record *txtRecord, ok bool) {
+	serializedAccess, root, ok := records.fallback.lookup(hostname)
+	if !ok {
+		return nil, false
+	}
+
+	access, err := parseAccess(ctx, serializedAccess, records.auth)
+	if err != nil {
+		return nil, false
+	}
+
+	mon.Event("hosting_fallback")
+	return &txtRecord{
+		access:  access,
+		root:    root,
+		options: parseHostingOptions(NewTXTRecordSet()),
+	}, true
 }
 
 // updateCache will attempt to fetch and update the dns record for the given hostname.
@@ -113,7 +147,10 @@ func (records *txtRecords) queryAccessFromDNS(ctx context.Context, hostname stri
 
 	r, err := records.dns.Lookup(ctx, "txt-"+hostname, dns.TypeTXT)
 	if err != nil {
-		return nil, errs.New("failure with hostname %q: %w", hostname, err)
+		return nil, errDNS.New("failure with hostname %q: %w", hostname, err)
+	}
+	if r.Rcode == dns.RcodeServerFailure || r.Rcode == dns.RcodeRefused {
+		return nil, errDNS.New("failure with hostname %q: %s", hostname, dns.RcodeToString[r.Rcode])
 	}
 	set := ResponseToTXTRecordSet(r)
 
@@ -127,6 +164,9 @@ func (records *txtRecords) queryAccessFromDNS(ctx context.Context, hostname stri
 		// backcompat
 		root = set.Lookup("storj-path")
 	}
+	if err := validateHostingRoot(root); err != nil {
+		return nil, errs.New("failure with hostname %q: %w", hostname, err)
+	}
 
 	access, err := parseAccess(ctx, serializedAccess, records.auth)
 	if err != nil {
@@ -138,5 +178,10 @@ func (records *txtRecords) queryAccessFromDNS(ctx context.Context, hostname stri
 		ttl = records.maxTTL
 	}
 
-	return &txtRecord{access: access, root: root, expiration: time.Now().Add(ttl)}, nil
+	return &txtRecord{
+		access:     access,
+		root:       root,
+		options:    parseHostingOptions(set),
+		expiration: time.Now().Add(ttl),
+	}, nil
 }
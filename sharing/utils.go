@@ -5,11 +5,14 @@ package sharing
 
 import (
 	"context"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/zeebo/errs"
 )
 
 // queryFlagLookup finds a boolean value in a url.Values struct, returning
@@ -49,6 +52,82 @@ func queryIntLookup(q url.Values, name string, defValue int) int {
 	return defValue
 }
 
+// preservedQuery returns the named parameters of q that are set, encoded
+// as "&name=value" pairs to append to an existing query.
+func preservedQuery(q url.Values, names ...string) string {
+	var b strings.Builder
+	for _, name := range names {
+		if value := q.Get(name); value != "" {
+			b.WriteString("&" + url.QueryEscape(name) + "=" + url.QueryEscape(value))
+		}
+	}
+	return b.String()
+}
+
+// defaultQueryParams are the query parameters the handler understands. When
+// query stripping is enabled without an explicit allowlist, these are kept
+// and everything else is dropped.
+var defaultQueryParams = []string{
+	"download", "view", "wrap", "map", "width", "include-stats",
+	"key", "lines", "softwrap", "confirm", "format", "archive",
+	"restrict", "cursor", "prev", "scope", "verify", "integrity", "preview", "sig",
+	"transform", "index",
+	"sort", "order",
+	"X-Amz-Algorithm", "X-Amz-Credential", "X-Amz-Date",
+	"X-Amz-Expires", "X-Amz-SignedHeaders", "X-Amz-Signature",
+}
+
+// filterQuery returns the raw query with only the allowed parameters kept,
+// preserving their original order and encoding.
+func filterQuery(rawQuery string, allowed map[string]bool) string {
+	if rawQuery == "" {
+		return ""
+	}
+	kept := make([]string, 0, strings.Count(rawQuery, "&")+1)
+	for _, param := range strings.Split(rawQuery, "&") {
+		name := param
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if allowed[name] {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+// collapseSlashes replaces every run of consecutive slashes in p with a
+// single slash.
+func collapseSlashes(p string) string {
+	if !strings.Contains(p, "//") {
+		return p
+	}
+	var b strings.Builder
+	b.Grow(len(p))
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' && i > 0 && p[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(p[i])
+	}
+	return b.String()
+}
+
+// checkKeyLimits returns a bad request error if the key is longer than
+// maxLength bytes or has more than maxDepth path segments.
+func checkKeyLimits(key string, maxLength, maxDepth int) error {
+	if len(key) > maxLength {
+		return WithStatus(errs.New("object key too long: %d > %d bytes", len(key), maxLength), http.StatusBadRequest)
+	}
+	if depth := strings.Count(key, "/") + 1; depth > maxDepth {
+		return WithStatus(errs.New("object key too deep: %d > %d segments", depth, maxDepth), http.StatusBadRequest)
+	}
+	return nil
+}
+
 // MutexGroup is a group of mutexes by name that attempts to only keep track of
 // live mutexes. The zero value is okay to use.
 type MutexGroup struct {
@@ -145,3 +224,15 @@ func (e *ExponentialBackoff) Maxed() bool {
 	e.init()
 	return e.delay == e.Max
 }
+
+// SplitList splits a comma separated list, trimming whitespace and dropping
+// empty entries.
+func SplitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
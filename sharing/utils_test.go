@@ -6,6 +6,9 @@ package sharing
 import (
 	"fmt"
 	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -92,3 +95,50 @@ func TestMutexGroup(t *testing.T) {
 		require.Equal(t, int32(0), *counters[lockNo])
 	}
 }
+
+func TestFilterQuery(t *testing.T) {
+	allowed := map[string]bool{"download": true, "view": true, "width": true}
+
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{query: "", expected: ""},
+		{query: "download", expected: "download"},
+		{query: "utm_source=mail&download", expected: "download"},
+		{query: "view=1&utm_source=mail&utm_medium=email&width=400", expected: "view=1&width=400"},
+		{query: "fbclid=abc", expected: ""},
+		{query: "wid%74h=400", expected: "wid%74h=400"},
+	} {
+		require.Equal(t, test.expected, filterQuery(test.query, allowed), test.query)
+	}
+}
+
+func TestCheckKeyLimits(t *testing.T) {
+	for _, test := range []struct {
+		key string
+		ok  bool
+	}{
+		{key: "", ok: true},
+		{key: strings.Repeat("a", 16), ok: true},
+		{key: strings.Repeat("a", 17), ok: false},
+		{key: "a/b/c/d", ok: true},
+		{key: "a/b/c/d/", ok: false},
+		{key: "a/b/c/d/e", ok: false},
+		{key: "////", ok: false},
+	} {
+		err := checkKeyLimits(test.key, 16, 4)
+		if test.ok {
+			require.NoError(t, err, test.key)
+			continue
+		}
+		require.Error(t, err, test.key)
+		require.Equal(t, http.StatusBadRequest, GetStatus(err, 0), test.key)
+	}
+}
+
+func TestPreservedQuery(t *testing.T) {
+	q := url.Values{"scope": {"a/b/"}, "wrap": {"1"}, "restrict": {""}}
+	require.Equal(t, "&scope=a%2Fb%2F", preservedQuery(q, "scope", "restrict"))
+	require.Equal(t, "", preservedQuery(q, "missing"))
+}
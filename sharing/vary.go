@@ -0,0 +1,45 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// requestHeader returns the named request header and records on the response
+// that it varies by that header. Any request header that changes what we
+// serve (content negotiation, encodings, languages) must be read through
+// here, so that caches in front of us never hand out the wrong variant.
+func requestHeader(w http.ResponseWriter, r *http.Request, name string) string {
+	addVary(w.Header(), name)
+	return r.Header.Get(name)
+}
+
+// addVary adds the given header names to the Vary header, skipping names
+// that are already present.
+func addVary(h http.Header, names ...string) {
+	existing := map[string]bool{}
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(field))
+			if field != "" {
+				existing[field] = true
+			}
+		}
+	}
+	if existing["*"] {
+		return
+	}
+
+	for _, name := range names {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if existing[name] {
+			continue
+		}
+		existing[name] = true
+		h.Add("Vary", name)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddVary(t *testing.T) {
+	h := http.Header{}
+	addVary(h, "Accept-Encoding")
+	addVary(h, "accept-encoding", "Accept")
+	require.Equal(t, []string{"Accept-Encoding", "Accept"}, h.Values("Vary"))
+
+	h = http.Header{"Vary": {"Accept-Language, accept"}}
+	addVary(h, "Accept", "Accept-Language", "Accept-Encoding")
+	require.Equal(t, []string{"Accept-Language, accept", "Accept-Encoding"}, h.Values("Vary"))
+
+	h = http.Header{"Vary": {"*"}}
+	addVary(h, "Accept")
+	require.Equal(t, []string{"*"}, h.Values("Vary"))
+}
+
+func TestRequestHeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://test.test/", nil)
+	r.Header.Set("Accept-Language", "en")
+
+	require.Equal(t, "en", requestHeader(w, r, "Accept-Language"))
+	require.Equal(t, "", requestHeader(w, r, "Accept"))
+	require.Equal(t, []string{"Accept-Language", "Accept"}, w.Header().Values("Vary"))
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/uplink"
+)
+
+const (
+	// verifyHeader reports the result of ?verify. For GET requests it is an
+	// HTTP trailer, as the result is only known once the body is sent.
+	verifyHeader = "X-Verify-Result"
+
+	// sha256MetadataKey is the custom metadata key HEAD requests compare
+	// ?verify hashes against.
+	sha256MetadataKey = "sha256"
+)
+
+// parseVerify parses a ?verify=sha256:<hex> value.
+func parseVerify(value string) ([]byte, error) {
+	hexHash := strings.TrimPrefix(value, "sha256:")
+	if hexHash == value {
+		return nil, WithStatus(errs.New("unsupported verify algorithm"), http.StatusBadRequest)
+	}
+	hash, err := hex.DecodeString(hexHash)
+	if err != nil || len(hash) != sha256.Size {
+		return nil, WithStatus(errs.New("malformed verify hash"), http.StatusBadRequest)
+	}
+	return hash, nil
+}
+
+// serveVerified serves o in full while hashing it, reporting whether it
+// matched the expected hash in the X-Verify-Result trailer: "match" or
+// "mismatch". HEAD requests compare against the sha256 custom metadata
+// instead, reporting "unknown" if there is none.
+//
+// Range requests are ignored, as only the whole object can be verified.
+func (handler *Handler) serveVerified(ctx context.Context, w http.ResponseWriter, r *http.Request, pr *parsedRequest, project *uplink.Project, o *uplink.Object, expected []byte) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if r.Method == http.MethodHead {
+		result := "unknown"
+		if stored, err := hex.DecodeString(o.Custom[sha256MetadataKey]); err == nil && len(stored) == sha256.Size {
+			result = verifyResult(bytes.Equal(stored, expected))
+		}
+		w.Header().Set(verifyHeader, result)
+		w.Header().Set("Content-Length", strconv.FormatInt(o.System.ContentLength, 10))
+		return nil
+	}
+
+	download, err := project.DownloadObject(ctx, pr.bucket, o.Key, nil)
+	if err != nil {
+		return WithAction(err, "download verified")
+	}
+	defer func() {
+		if err := download.Close(); err != nil {
+			handler.log.With(zap.Error(err)).Warn("unable to close verified download")
+		}
+	}()
+
+	// trailers need a chunked response, so there is no Content-Length.
+	w.Header().Set("Trailer", verifyHeader)
+	w.Header().Del("Content-Length")
+	w.Header().Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hasher), download); err != nil {
+		// the status is already sent, so all we can do is log it.
+		handler.log.Error("verified download failed", zap.Error(err))
+		return nil
+	}
+
+	matched := bytes.Equal(hasher.Sum(nil), expected)
+	if !matched {
+		handler.log.Warn("verified download hash mismatch",
+			zap.String("bucket", pr.bucket),
+			zap.Int64("size", o.System.ContentLength))
+	}
+	w.Header().Set(verifyHeader, verifyResult(matched))
+	return nil
+}
+
+func verifyResult(matched bool) string {
+	if matched {
+		return "match"
+	}
+	return "mismatch"
+}
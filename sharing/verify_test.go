@@ -0,0 +1,68 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/testcontext"
+	"storj.io/uplink"
+)
+
+func TestParseVerify(t *testing.T) {
+	sum := sha256.Sum256([]byte("data"))
+
+	hash, err := parseVerify("sha256:" + hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	require.Equal(t, sum[:], hash)
+
+	for _, value := range []string{
+		hex.EncodeToString(sum[:]),
+		"md5:" + hex.EncodeToString(sum[:16]),
+		"sha256:nothex",
+		"sha256:" + hex.EncodeToString(sum[:16]),
+	} {
+		_, err := parseVerify(value)
+		require.Equal(t, http.StatusBadRequest, GetStatus(err, 0), value)
+	}
+}
+
+func TestVerifyHead(t *testing.T) {
+	handler, err := NewHandler(zap.NewNop(), nil, Config{
+		URLBases:             []string{"http://test.test"},
+		Templates:            "../web",
+		ChecksumVerification: true,
+	})
+	require.NoError(t, err)
+
+	ctx := testcontext.New(t)
+	sum := sha256.Sum256([]byte("data"))
+	other := sha256.Sum256([]byte("other"))
+
+	for _, test := range []struct {
+		stored string
+		result string
+	}{
+		{stored: hex.EncodeToString(sum[:]), result: "match"},
+		{stored: hex.EncodeToString(other[:]), result: "mismatch"},
+		{stored: "", result: "unknown"},
+	} {
+		object := &uplink.Object{Key: "data.bin", Custom: uplink.CustomMetadata{}}
+		if test.stored != "" {
+			object.Custom[sha256MetadataKey] = test.stored
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("HEAD", "http://test.test/?verify=sha256:"+hex.EncodeToString(sum[:]), nil)
+		require.NoError(t, handler.showObject(ctx, w, r, &parsedRequest{}, &uplink.Project{}, object))
+		require.Equal(t, test.result, w.Header().Get(verifyHeader))
+	}
+}
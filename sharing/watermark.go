@@ -0,0 +1,154 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/draw"
+	_ "image/gif" // register GIF decoding
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/uplink"
+)
+
+// watermarkMaxPixels caps the dimensions of images we decode to watermark,
+// as decoded images take 4 bytes or more per pixel.
+const watermarkMaxPixels = 50 * 1000 * 1000
+
+// LoadWatermark loads a PNG watermark image, whose alpha channel sets how
+// opaque it is when drawn over previews.
+func LoadWatermark(path string) (_ image.Image, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errs.Combine(err, f.Close()) }()
+
+	return png.Decode(f)
+}
+
+// wantsWatermark returns whether the object should be served as a
+// watermarked preview.
+func (handler *Handler) wantsWatermark(q url.Values, contentType string) bool {
+	if handler.watermark == nil || !queryFlagLookup(q, "preview", false) {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// serveWatermarked serves o with the watermark drawn in its bottom right
+// corner, re-encoded as JPEG for JPEGs and PNG otherwise. Results go through
+// the body cache, if there is one.
+func (handler *Handler) serveWatermarked(ctx context.Context, w http.ResponseWriter, r *http.Request, pr *parsedRequest, project *uplink.Project, o *uplink.Object) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if o.System.ContentLength > handler.watermarkMaxSize {
+		return WithStatus(errs.New("image too large to watermark: %d > %d bytes",
+			o.System.ContentLength, handler.watermarkMaxSize), http.StatusRequestEntityTooLarge)
+	}
+
+	var cacheKey string
+	if handler.bodyCache != nil {
+		if key, err := bodyCacheKey(pr.access, pr.bucket, o); err == nil {
+			cacheKey = "watermark:" + key
+		}
+	}
+
+	body, ok := []byte(nil), false
+	if cacheKey != "" {
+		body, ok = handler.bodyCache.Get(ctx, cacheKey)
+	}
+	if !ok {
+		body, err = handler.watermarkObject(ctx, project, pr, o)
+		if err != nil {
+			return err
+		}
+		if cacheKey != "" {
+			handler.bodyCache.Set(ctx, cacheKey, body, handler.bodyCacheTTL)
+		}
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(body))
+	w.Header().Del("Content-Disposition")
+	http.ServeContent(w, r, "", o.System.Created, bytes.NewReader(body))
+	return nil
+}
+
+// watermarkObject downloads, decodes, watermarks and re-encodes o.
+func (handler *Handler) watermarkObject(ctx context.Context, project *uplink.Project, pr *parsedRequest, o *uplink.Object) (_ []byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	download, err := project.DownloadObject(ctx, pr.bucket, o.Key, nil)
+	if err != nil {
+		return nil, WithAction(err, "download image")
+	}
+	defer func() {
+		if err := download.Close(); err != nil {
+			handler.log.With(zap.Error(err)).Warn("unable to close image download")
+		}
+	}()
+
+	data, err := ioutil.ReadAll(io.LimitReader(download, handler.watermarkMaxSize))
+	if err != nil {
+		return nil, WithAction(err, "read image")
+	}
+	return watermarkImage(data, handler.watermark)
+}
+
+// watermarkImage draws mark over the bottom right corner of the encoded
+// image data, returning it re-encoded.
+func watermarkImage(data []byte, mark image.Image) ([]byte, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, WithStatus(errs.New("unable to decode image: %v", err), http.StatusUnprocessableEntity)
+	}
+	if int64(config.Width)*int64(config.Height) > watermarkMaxPixels {
+		return nil, WithStatus(errs.New("image too large to watermark: %dx%d",
+			config.Width, config.Height), http.StatusRequestEntityTooLarge)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, WithStatus(errs.New("unable to decode image: %v", err), http.StatusUnprocessableEntity)
+	}
+
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+
+	markBounds := mark.Bounds()
+	at := image.Pt(bounds.Max.X-markBounds.Dx(), bounds.Max.Y-markBounds.Dy())
+	if at.X < bounds.Min.X {
+		at.X = bounds.Min.X
+	}
+	if at.Y < bounds.Min.Y {
+		at.Y = bounds.Min.Y
+	}
+	draw.Draw(dst, markBounds.Sub(markBounds.Min).Add(at), mark, markBounds.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	return buf.Bytes(), err
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWantsWatermark(t *testing.T) {
+	mark := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	for _, test := range []struct {
+		query       string
+		contentType string
+		watermark   image.Image
+		expected    bool
+	}{
+		{query: "preview", contentType: "image/png", watermark: mark, expected: true},
+		{query: "preview", contentType: "image/jpeg", watermark: mark, expected: true},
+		{query: "preview", contentType: "image/png", watermark: nil, expected: false},
+		{query: "", contentType: "image/png", watermark: mark, expected: false},
+		{query: "preview=0", contentType: "image/png", watermark: mark, expected: false},
+		{query: "preview", contentType: "image/svg+xml", watermark: mark, expected: false},
+	} {
+		q, err := url.ParseQuery(test.query)
+		require.NoError(t, err)
+
+		handler := &Handler{watermark: test.watermark}
+		require.Equal(t, test.expected, handler.wantsWatermark(q, test.contentType), "%q %q", test.query, test.contentType)
+	}
+}
+
+func TestWatermarkImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	mark := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for x := 0; x < 2; x++ {
+		for y := 0; y < 2; y++ {
+			mark.Set(x, y, color.RGBA{A: 0xff})
+		}
+	}
+
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, src))
+
+	out, err := watermarkImage(encoded.Bytes(), mark)
+	require.NoError(t, err)
+	result, err := png.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+
+	// the watermark is in the bottom right corner only.
+	require.Equal(t, color.RGBA{A: 0xff}, color.RGBAModel.Convert(result.At(7, 7)))
+	require.Equal(t, color.RGBA{A: 0xff}, color.RGBAModel.Convert(result.At(6, 6)))
+	require.Equal(t, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.RGBAModel.Convert(result.At(5, 5)))
+
+	// JPEGs stay JPEGs.
+	encoded.Reset()
+	require.NoError(t, jpeg.Encode(&encoded, src, nil))
+	out, err = watermarkImage(encoded.Bytes(), mark)
+	require.NoError(t, err)
+	require.Equal(t, "image/jpeg", http.DetectContentType(out))
+
+	_, err = watermarkImage([]byte("not an image"), mark)
+	require.Equal(t, http.StatusUnprocessableEntity, GetStatus(err, 0))
+}
@@ -6,12 +6,15 @@ package testsuite
 import (
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"path"
 	"strings"
 	"testing"
 
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
@@ -115,6 +118,9 @@ func testHandlerRequests(t *testing.T, ctx *testcontext.Context, planet *testpla
 	err := planet.Uplinks[0].Upload(ctx, planet.Satellites[0], "testbucket", "test/foo", []byte("FOO"))
 	require.NoError(t, err)
 
+	err = planet.Uplinks[0].Upload(ctx, planet.Satellites[0], "testbucket", "/leading/slash", []byte("SLASH"))
+	require.NoError(t, err)
+
 	access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
 	serializedAccess, err := access.Serialize()
 	require.NoError(t, err)
@@ -201,6 +207,28 @@ func testHandlerRequests(t *testing.T, ctx *testcontext.Context, planet *testpla
 			status: http.StatusOK,
 			body:   "foo",
 		},
+		{
+			name:   "GET raw advertises ranges",
+			method: "GET",
+			path:   path.Join("raw", serializedAccess, "testbucket", "test/foo"),
+			status: http.StatusOK,
+			header: http.Header{"Accept-Ranges": {"bytes"}},
+			body:   "FOO",
+		},
+		{
+			name:   "GET leading slash key",
+			method: "GET",
+			path:   path.Join("s", serializedAccess, "testbucket") + "//leading/slash",
+			status: http.StatusOK,
+			body:   "slash",
+		},
+		{
+			name:   "GET leading slash key raw",
+			method: "GET",
+			path:   path.Join("raw", serializedAccess, "testbucket") + "//leading/slash",
+			status: http.StatusOK,
+			body:   "SLASH",
+		},
 		{
 			name:   "GET bucket listing success",
 			method: "GET",
@@ -215,6 +243,56 @@ func testHandlerRequests(t *testing.T, ctx *testcontext.Context, planet *testpla
 			status: http.StatusOK,
 			body:   "foo",
 		},
+		{
+			name:   "GET prefix listing JSON",
+			method: "GET",
+			path:   path.Join("s", serializedAccess, "testbucket", "test") + "/?format=json",
+			status: http.StatusOK,
+			header: http.Header{"Content-Type": {"application/json"}},
+			body:   `"truncated":false`,
+		},
+		{
+			name:   "GET prefix listing JSON invalid cursor",
+			method: "GET",
+			path:   path.Join("s", serializedAccess, "testbucket", "test") + "/?format=json&cursor=!",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "GET prefix listing JSON Lines",
+			method: "GET",
+			path:   path.Join("s", serializedAccess, "testbucket", "test") + "/?format=jsonl",
+			status: http.StatusOK,
+			header: http.Header{"Content-Type": {"application/x-ndjson"}},
+			body:   `{"key":"foo","size":3,"isPrefix":false,`,
+		},
+		{
+			name:   "GET prefix listing JSON Lines empty",
+			method: "GET",
+			path:   path.Join("s", serializedAccess, "testbucket", "test-empty") + "/?format=jsonl",
+			status: http.StatusNotFound,
+			header: http.Header{"Content-Type": {"application/json"}},
+		},
+		{
+			name:   "GET prefix archive",
+			method: "GET",
+			path:   path.Join("s", serializedAccess, "testbucket", "test") + "/?archive=tar",
+			status: http.StatusOK,
+			header: http.Header{"Content-Type": {"application/x-tar"}, "Accept-Ranges": {"none"}},
+			body:   "FOO",
+		},
+		{
+			name:   "GET prefix download zip",
+			method: "GET",
+			path:   path.Join("s", serializedAccess, "testbucket", "test") + "/?download=zip",
+			status: http.StatusOK,
+			header: http.Header{"Content-Type": {"application/zip"}, "Content-Disposition": {"attachment; filename=test.zip"}},
+		},
+		{
+			name:   "GET prefix archive empty",
+			method: "GET",
+			path:   path.Join("s", serializedAccess, "testbucket", "test-empty") + "/?archive=zip",
+			status: http.StatusNotFound,
+		},
 		{
 			name:   "GET prefix listing empty",
 			method: "GET",
@@ -319,6 +397,170 @@ func testHandlerRequests(t *testing.T, ctx *testcontext.Context, planet *testpla
 	}
 }
 
+func TestHostingURLStyles(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount:   1,
+		StorageNodeCount: 1,
+		UplinkCount:      1,
+	}, testHostingURLStyles)
+}
+
+func testHostingURLStyles(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+	for key, data := range map[string]string{
+		"site/page.html":       "PAGE",
+		"site/dir/index.html":  "DIR",
+		"site/docs/readme.txt": "DOCS",
+	} {
+		err := planet.Uplinks[0].Upload(ctx, planet.Satellites[0], "testbucket", key, []byte(data))
+		require.NoError(t, err)
+	}
+
+	access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
+	serializedAccess, err := access.Serialize()
+	require.NoError(t, err)
+
+	// every combination of url style and listing is a site of its own,
+	// named after them, so its TXT records set them.
+	records := []string{"storj-root:testbucket/site"}
+	for i := 0; len(serializedAccess) > 0; i++ {
+		n := 200
+		if n > len(serializedAccess) {
+			n = len(serializedAccess)
+		}
+		records = append(records, fmt.Sprintf("storj-access-%d:%s", i+1, serializedAccess[:n]))
+		serializedAccess = serializedAccess[n:]
+	}
+	dnsServer := serveHostingDNS(t, ctx, func(host string) []string {
+		style, listing := strings.Split(host, ".")[0], strings.Split(host, ".")[1]
+		return append(append([]string{}, records...), "storj-url-style:"+style, "storj-listing:"+listing)
+	})
+
+	handler, err := sharing.NewHandler(zaptest.NewLogger(t), objectmap.NewIPDB(&objectmap.MockReader{}), sharing.Config{
+		URLBases:   []string{"http://localhost"},
+		Templates:  "./../web/",
+		DNSServers: []string{dnsServer},
+	})
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		site     string
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		// /page only finds page.html with pretty urls.
+		{site: "directory.on", path: "/page", status: http.StatusNotFound},
+		{site: "directory.off", path: "/page", status: http.StatusNotFound},
+		{site: "pretty.on", path: "/page", status: http.StatusOK, body: "PAGE"},
+		{site: "pretty.off", path: "/page", status: http.StatusOK, body: "PAGE"},
+
+		{site: "directory.on", path: "/page.html", status: http.StatusOK, body: "PAGE"},
+		{site: "pretty.off", path: "/page.html", status: http.StatusOK, body: "PAGE"},
+
+		// prefixes get their trailing slash either way, and serve their
+		// index.html regardless of listings.
+		{site: "directory.on", path: "/dir", status: http.StatusSeeOther, location: "/dir/"},
+		{site: "pretty.on", path: "/dir", status: http.StatusSeeOther, location: "/dir/"},
+		{site: "directory.off", path: "/dir/", status: http.StatusOK, body: "DIR"},
+		{site: "pretty.off", path: "/dir/", status: http.StatusOK, body: "DIR"},
+		{site: "pretty.off", path: "/docs", status: http.StatusSeeOther, location: "/docs/"},
+
+		// /docs/ has no index.html, so it is listed only with listings on.
+		{site: "directory.on", path: "/docs/", status: http.StatusOK, body: "readme.txt"},
+		{site: "pretty.on", path: "/docs/", status: http.StatusOK, body: "readme.txt"},
+		{site: "directory.off", path: "/docs/", status: http.StatusNotFound},
+		{site: "pretty.off", path: "/docs/", status: http.StatusNotFound},
+	} {
+		name := test.site + test.path
+		w := httptest.NewRecorder()
+		r, err := http.NewRequestWithContext(ctx, "GET", "http://"+test.site+".example.test"+test.path, nil)
+		require.NoError(t, err)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, test.status, w.Code, name)
+		assert.Equal(t, test.location, w.Header().Get("Location"), name)
+		if test.status == http.StatusNotFound {
+			assert.NotContains(t, w.Body.String(), "readme.txt", name)
+		}
+		assert.Contains(t, w.Body.String(), test.body, name)
+	}
+}
+
+func TestShortLinkRedirects(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount:   1,
+		StorageNodeCount: 1,
+		UplinkCount:      1,
+	}, testShortLinkRedirects)
+}
+
+func testShortLinkRedirects(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+	err := planet.Uplinks[0].Upload(ctx, planet.Satellites[0], "testbucket", "album/dir/a.txt", []byte("A"))
+	require.NoError(t, err)
+
+	access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
+	serializedAccess, err := access.Serialize()
+	require.NoError(t, err)
+
+	store := sharing.NewMemoryShortLinkStore()
+	require.NoError(t, store.Put(ctx, "SHORTLINKID23456", sharing.ShortLink{
+		Access: serializedAccess,
+		Bucket: "testbucket",
+		Key:    "album/",
+	}, 0))
+
+	handler, err := sharing.NewHandler(zaptest.NewLogger(t), objectmap.NewIPDB(&objectmap.MockReader{}), sharing.Config{
+		URLBases:   []string{"http://localhost"},
+		Templates:  "./../web/",
+		ShortLinks: store,
+	})
+	require.NoError(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequestWithContext(ctx, "GET", "http://localhost"+path, nil)
+		require.NoError(t, err)
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// the prefix is redirected to under the short link, not the access.
+	w := get("/q/SHORTLINKID23456/dir")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/q/SHORTLINKID23456/dir/", w.Header().Get("Location"))
+	assert.NotContains(t, w.Header().Get("Location"), serializedAccess)
+
+	w = get("/q/SHORTLINKID23456/dir/")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "a.txt")
+	assert.NotContains(t, w.Body.String(), serializedAccess)
+}
+
+// serveHostingDNS serves TXT lookups for txt-<host> over TCP, answering with
+// the records for host, and returns its address.
+func serveHostingDNS(t *testing.T, ctx *testcontext.Context, records func(host string) []string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &dns.Server{Listener: listener, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		name := r.Question[0].Name
+		host := strings.TrimSuffix(strings.TrimPrefix(name, "txt-"), ".")
+		for _, txt := range records(host) {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{txt},
+			})
+		}
+		_ = w.WriteMsg(m)
+	})}
+	ctx.Go(server.ActivateAndServe)
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return listener.Addr().String()
+}
+
 func makeAuthHandler(t *testing.T, accessKeys map[string]authHandlerEntry, token string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		require.True(t, strings.HasPrefix(r.URL.Path, "/v1/access/"))